@@ -0,0 +1,95 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+)
+
+func bucketIDDecode(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("bucketid decode", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	encoded, err := readArg(fs.Args(), stdin, "BucketID")
+	if err != nil {
+		return err
+	}
+	id, err := types.DecodeToBucketID(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid BucketID: %v", err)
+	}
+	account, bucket, unitType, err := azureutils.DescribeBucketID(id)
+	if err != nil {
+		return err
+	}
+	printField(stdout, "subscriptionID", id.SubID)
+	printField(stdout, "resourceGroup", id.ResourceGroup)
+	printField(stdout, "url", id.URL)
+	printField(stdout, "account", account)
+	printField(stdout, "bucket", bucket)
+	printField(stdout, "unitType", unitType.String())
+	printField(stdout, "directory", id.Directory)
+	printField(stdout, "protocol", id.Protocol)
+	printField(stdout, "corsRule", id.CORSRule)
+	if id.PrivateLink {
+		printField(stdout, "privateLink", strconv.FormatBool(id.PrivateLink))
+	}
+	return nil
+}
+
+func bucketIDEncode(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("bucketid encode", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	id := &types.BucketID{}
+	fs.StringVar(&id.SubID, "subscription-id", "", "subscription of the storage account")
+	fs.StringVar(&id.ResourceGroup, "resource-group", "", "resource group of the storage account")
+	fs.StringVar(&id.URL, "url", "", "URL of the storage account, container, filesystem or fileshare")
+	fs.StringVar(&id.UnitType, "unit-type", "", "filesystem or fileshare, the other unit types are told apart by the URL")
+	fs.StringVar(&id.Directory, "directory", "", "root directory of a filesystem bucket")
+	fs.StringVar(&id.Protocol, "protocol", "", "enabled protocol of a fileshare bucket")
+	fs.StringVar(&id.CORSRule, "cors-rule", "", "key of the CORS rule added for the bucket")
+	fs.BoolVar(&id.PrivateLink, "private-link", false, "the account is reached through a blob private endpoint")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if id.URL == "" {
+		return fmt.Errorf("--url is required")
+	}
+	switch id.UnitType {
+	case "", constant.Filesystem.String(), constant.FileShare.String():
+	default:
+		return fmt.Errorf("--unit-type must be %s or %s, got %s", constant.Filesystem, constant.FileShare, id.UnitType)
+	}
+	if _, _, _, err := azureutils.DescribeBucketID(id); err != nil {
+		return err
+	}
+	encoded, err := id.Encode()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, encoded)
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBucketID(t *testing.T) {
+	tests := []struct {
+		testName       string
+		args           []string
+		expectedCode   int
+		expectedOutput []string
+	}{
+		{
+			testName:       "Container",
+			args:           []string{"--url", "https://account.blob.core.windows.net/container", "--subscription-id", "sub"},
+			expectedOutput: []string{"subscriptionID: sub", "account:        account", "bucket:         container", "unitType:       container"},
+		},
+		{
+			testName:       "Storage account",
+			args:           []string{"--url", "https://account.blob.core.windows.net/"},
+			expectedOutput: []string{"unitType:       storageaccount"},
+		},
+		{
+			testName:       "Filesystem",
+			args:           []string{"--url", "https://account.blob.core.windows.net/fs", "--unit-type", "filesystem", "--directory", "data"},
+			expectedOutput: []string{"bucket:         fs", "unitType:       filesystem", "directory:      data"},
+		},
+		{
+			testName:     "Missing URL",
+			args:         []string{"--unit-type", "fileshare"},
+			expectedCode: 1,
+		},
+		{
+			testName:     "Invalid unit type",
+			args:         []string{"--url", "https://account.blob.core.windows.net/container", "--unit-type", "container"},
+			expectedCode: 1,
+		},
+	}
+	for _, test := range tests {
+		encoded, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(append([]string{"bucketid", "encode"}, test.args...), nil, encoded, stderr)
+		if code != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %d\nActual Code: %d\nStderr: %s", test.testName, test.expectedCode, code, stderr)
+		}
+		if code != 0 {
+			continue
+		}
+		decoded := &bytes.Buffer{}
+		if code := run([]string{"bucketid", "decode", "-"}, encoded, decoded, stderr); code != 0 {
+			t.Errorf("\nTestCase: %s\nunexpected decode failure: %s", test.testName, stderr)
+		}
+		for _, line := range test.expectedOutput {
+			if !strings.Contains(decoded.String(), line+"\n") {
+				t.Errorf("\nTestCase: %s\nExpected Line: %s\nActual Output: %s", test.testName, line, decoded)
+			}
+		}
+	}
+}
+
+func TestBucketIDDecodeInvalid(t *testing.T) {
+	for _, id := range []string{"not base64!", "bm90IGpzb24="} {
+		if code := run([]string{"bucketid", "decode", id}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != 1 {
+			t.Errorf("expected decoding %s to fail, got code %d", id, code)
+		}
+	}
+}
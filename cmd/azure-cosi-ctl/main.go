@@ -0,0 +1,90 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// azure-cosi-ctl inspects the values the driver exchanges with COSI without calling Azure:
+// it decodes and encodes BucketIDs, validates class parameters and decodes SAS URLs.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: azure-cosi-ctl <command> <subcommand> [flags] [args]
+
+Commands:
+  bucketid decode <id>           print the fields of a BucketID, - reads it from stdin
+  bucketid encode --url <url>    build a BucketID
+  params validate -f <manifest>  check the BucketClasses and BucketAccessClasses of a manifest
+  sas inspect <url>              print the permissions and expiry of a SAS URL
+`
+
+// errInvalid is returned once the reasons something is invalid were printed
+var errInvalid = errors.New("invalid")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes one command and returns the exit code, 2 for a usage error and 1 for a failure
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) < 2 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] + " " + args[1] {
+	case "bucketid decode":
+		err = bucketIDDecode(args[2:], stdin, stdout, stderr)
+	case "bucketid encode":
+		err = bucketIDEncode(args[2:], stdout, stderr)
+	case "params validate":
+		err = paramsValidate(args[2:], stdin, stdout, stderr)
+	case "sas inspect":
+		err = sasInspect(args[2:], stdout, stderr)
+	default:
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if err != nil {
+		if err != errInvalid {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// readArg returns the only positional argument, or the trimmed stdin when it is -
+func readArg(args []string, stdin io.Reader, name string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected one %s argument, got %d", name, len(args))
+	}
+	if args[0] != "-" {
+		return args[0], nil
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func printField(w io.Writer, name, value string) {
+	if value != "" {
+		fmt.Fprintf(w, "%-16s%s\n", name+":", value)
+	}
+}
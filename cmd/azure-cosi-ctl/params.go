@@ -0,0 +1,138 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/config"
+	"github.com/Azure/azure-cosi-driver/pkg/driver"
+
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// classManifest holds the fields of a BucketClass or BucketAccessClass the driver reads
+type classManifest struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	DriverName         string            `json:"driverName"`
+	AuthenticationType string            `json:"authenticationType"`
+	Parameters         map[string]string `json:"parameters"`
+}
+
+func paramsValidate(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("params validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	manifest := fs.String("f", "", "YAML or JSON manifest with BucketClasses and BucketAccessClasses, - reads stdin")
+	configFile := fs.String("config", "", "driver config file whose defaults and restrictions apply")
+	policyFile := fs.String("policy", "", "policy file whose rules apply")
+	location := fs.String("location", "", "region of the cluster, which buckets without a region are created in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *manifest == "" || fs.NArg() != 0 {
+		return fmt.Errorf("expected only -f <manifest>")
+	}
+	if *configFile != "" {
+		c, err := config.Load(*configFile)
+		if err != nil {
+			return err
+		}
+		if err := azureutils.SetDriverConfig(c); err != nil {
+			return fmt.Errorf("invalid driver config: %v", err)
+		}
+	}
+	if *policyFile != "" {
+		p, err := config.LoadPolicy(*policyFile)
+		if err != nil {
+			return err
+		}
+		if err := azureutils.SetPolicy(p); err != nil {
+			return fmt.Errorf("invalid policy: %v", err)
+		}
+	}
+
+	r := stdin
+	if *manifest != "-" {
+		f, err := os.Open(*manifest)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	classes, err := readClasses(r)
+	if err != nil {
+		return err
+	}
+	if len(classes) == 0 {
+		return fmt.Errorf("no BucketClass or BucketAccessClass in %s", *manifest)
+	}
+
+	invalid := false
+	for _, class := range classes {
+		name := class.Kind + " " + class.Metadata.Name
+		if class.DriverName != driver.DriverName {
+			fmt.Fprintf(stdout, "%s: skipped, driverName is %q\n", name, class.DriverName)
+			continue
+		}
+		var errs []error
+		if class.Kind == "BucketClass" {
+			errs = azureutils.ValidateBucketClassParameters(class.Parameters, *location)
+		} else {
+			errs = azureutils.ValidateBucketAccessClassParameters(class.Parameters, !strings.EqualFold(class.AuthenticationType, "IAM"))
+		}
+		if len(errs) == 0 {
+			fmt.Fprintf(stdout, "%s: valid\n", name)
+			continue
+		}
+		invalid = true
+		fmt.Fprintf(stdout, "%s: invalid\n", name)
+		for _, err := range errs {
+			fmt.Fprintf(stdout, "  - %s\n", status.Convert(err).Message())
+		}
+	}
+	if invalid {
+		return errInvalid
+	}
+	return nil
+}
+
+// readClasses returns the BucketClasses and BucketAccessClasses of a multi document manifest
+func readClasses(r io.Reader) ([]*classManifest, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	classes := []*classManifest{}
+	for {
+		class := &classManifest{}
+		err := decoder.Decode(class)
+		if err == io.EOF {
+			return classes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing manifest: %v", err)
+		}
+		if class.Kind == "BucketClass" || class.Kind == "BucketAccessClass" {
+			classes = append(classes, class)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+)
+
+const testManifest = `apiVersion: objectstorage.k8s.io/v1alpha1
+kind: BucketClass
+metadata:
+  name: valid
+driverName: blob.cosi.azure.com
+deletionPolicy: Delete
+parameters:
+  bucketunittype: container
+  region: eastus
+---
+kind: BucketClass
+metadata:
+  name: invalid
+driverName: blob.cosi.azure.com
+parameters:
+  bucketunittype: bucket
+  accesstier: lukewarm
+---
+kind: BucketAccessClass
+metadata:
+  name: insecure
+driverName: blob.cosi.azure.com
+authenticationType: Key
+parameters:
+  signedprotocol: https,http
+---
+kind: BucketClass
+metadata:
+  name: other
+driverName: s3.example.com
+---
+kind: ConfigMap
+metadata:
+  name: ignored
+`
+
+func TestParamsValidate(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "classes.yaml")
+	policy := filepath.Join(dir, "policy.yaml")
+	if err := os.WriteFile(manifest, []byte(testManifest), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(policy, []byte("requireHTTPS: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = azureutils.SetPolicy(nil) })
+
+	tests := []struct {
+		testName       string
+		args           []string
+		expectedCode   int
+		expectedOutput []string
+	}{
+		{
+			testName:     "Every class is reported",
+			args:         []string{"-f", manifest},
+			expectedCode: 1,
+			expectedOutput: []string{
+				"BucketClass valid: valid",
+				"BucketClass invalid: invalid\n  - Access Tier lukewarm is unsupported\n  - Invalid BucketUnitType bucket\n",
+				"BucketAccessClass insecure: valid",
+				`BucketClass other: skipped, driverName is "s3.example.com"`,
+			},
+		},
+		{
+			testName:     "Policy",
+			args:         []string{"-f", manifest, "--policy", policy},
+			expectedCode: 1,
+			expectedOutput: []string{
+				"BucketAccessClass insecure: invalid\n  - Denied by policy rule requireHTTPS",
+			},
+		},
+		{
+			testName:     "Missing manifest",
+			args:         []string{"-f", filepath.Join(dir, "missing.yaml")},
+			expectedCode: 1,
+		},
+		{
+			testName:     "No manifest",
+			args:         []string{},
+			expectedCode: 1,
+		},
+	}
+	for _, test := range tests {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(append([]string{"params", "validate"}, test.args...), nil, stdout, stderr)
+		if code != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %d\nActual Code: %d\nStderr: %s", test.testName, test.expectedCode, code, stderr)
+		}
+		for _, expected := range test.expectedOutput {
+			if !strings.Contains(stdout.String(), expected) {
+				t.Errorf("\nTestCase: %s\nExpected Output: %s\nActual Output: %s", test.testName, expected, stdout)
+			}
+		}
+	}
+}
+
+func TestParamsValidateStdin(t *testing.T) {
+	manifest := strings.NewReader(`{"kind": "BucketClass", "metadata": {"name": "json"}, "driverName": "blob.cosi.azure.com"}`)
+	stdout := &bytes.Buffer{}
+	if code := run([]string{"params", "validate", "-f", "-"}, manifest, stdout, &bytes.Buffer{}); code != 0 {
+		t.Errorf("expected a valid JSON manifest, got code %d: %s", code, stdout)
+	}
+	if code := run([]string{"params", "validate", "-f", "-"}, strings.NewReader("kind: ConfigMap\n"), stdout, &bytes.Buffer{}); code != 1 {
+		t.Errorf("expected a manifest without classes to fail, got code %d", code)
+	}
+}
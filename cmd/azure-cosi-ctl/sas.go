@@ -0,0 +1,151 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// now is replaced in tests
+var now = time.Now
+
+var (
+	sasPermissions = map[rune]string{
+		'r': "read",
+		'a': "add",
+		'c': "create",
+		'w': "write",
+		'd': "delete",
+		'x': "delete version",
+		'y': "permanent delete",
+		'l': "list",
+		't': "tags",
+		'f': "filter",
+		'm': "move",
+		'e': "execute",
+		'o': "ownership",
+		'p': "permissions",
+		'i': "set immutability policy",
+		'u': "update",
+	}
+	sasServices = map[rune]string{
+		'b': "blob",
+		'f': "file",
+		'q': "queue",
+		't': "table",
+	}
+	sasResourceTypes = map[rune]string{
+		's': "service",
+		'c': "container",
+		'o': "object",
+	}
+	sasSignedResources = map[string]string{
+		"b":  "blob",
+		"bv": "blob version",
+		"bs": "blob snapshot",
+		"c":  "container",
+		"d":  "directory",
+		"s":  "share",
+		"f":  "file",
+	}
+)
+
+func sasInspect(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("sas inspect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected one SAS URL argument, got %d", fs.NArg())
+	}
+	u, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid SAS URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("sig") == "" {
+		return fmt.Errorf("not a SAS URL, there is no signature")
+	}
+	expiry, err := describeSASTime(q.Get("se"), true)
+	if err != nil {
+		return err
+	}
+	start, err := describeSASTime(q.Get("st"), false)
+	if err != nil {
+		return err
+	}
+
+	// the signature is a credential and is never printed
+	u.RawQuery = ""
+	printField(stdout, "resource", u.String())
+	printField(stdout, "version", q.Get("sv"))
+	printField(stdout, "services", describeLetters(q.Get("ss"), sasServices))
+	printField(stdout, "resourceTypes", describeLetters(q.Get("srt"), sasResourceTypes))
+	if sr := q.Get("sr"); sr != "" {
+		if name, ok := sasSignedResources[sr]; ok {
+			printField(stdout, "signedResource", name)
+		} else {
+			printField(stdout, "signedResource", "unknown ("+sr+")")
+		}
+	}
+	printField(stdout, "permissions", describeLetters(q.Get("sp"), sasPermissions))
+	printField(stdout, "start", start)
+	printField(stdout, "expiry", expiry)
+	printField(stdout, "protocol", q.Get("spr"))
+	printField(stdout, "ip", q.Get("sip"))
+	printField(stdout, "identifier", q.Get("si"))
+	return nil
+}
+
+// describeLetters names every letter of a SAS field, keeping unknown letters visible
+func describeLetters(letters string, names map[rune]string) string {
+	described := []string{}
+	for _, l := range letters {
+		if name, ok := names[l]; ok {
+			described = append(described, name)
+		} else {
+			described = append(described, fmt.Sprintf("unknown (%c)", l))
+		}
+	}
+	return strings.Join(described, ", ")
+}
+
+// describeSASTime parses a SAS start or expiry time and tells how far it is from now
+func describeSASTime(value string, isExpiry bool) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", value); err != nil {
+			return "", fmt.Errorf("invalid SAS time %s", value)
+		}
+	}
+	d := t.Sub(now()).Round(time.Second)
+	switch {
+	case isExpiry && d <= 0:
+		return fmt.Sprintf("%s (expired %v ago)", value, -d), nil
+	case isExpiry:
+		return fmt.Sprintf("%s (expires in %v)", value, d), nil
+	case d > 0:
+		return fmt.Sprintf("%s (valid in %v)", value, d), nil
+	}
+	return value, nil
+}
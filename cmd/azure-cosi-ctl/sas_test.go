@@ -0,0 +1,81 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSASInspect(t *testing.T) {
+	now = func() time.Time { return time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { now = time.Now })
+
+	tests := []struct {
+		testName       string
+		url            string
+		expectedCode   int
+		expectedOutput []string
+	}{
+		{
+			testName: "Service SAS",
+			url:      "https://account.blob.core.windows.net/container?sv=2021-08-06&sr=c&sp=rwdlx&st=2022-06-01T11:00:00Z&se=2022-06-01T14:00:00Z&spr=https&sig=c2VjcmV0",
+			expectedOutput: []string{
+				"resource:       https://account.blob.core.windows.net/container\n",
+				"signedResource: container\n",
+				"permissions:    read, write, delete, list, delete version\n",
+				"start:          2022-06-01T11:00:00Z\n",
+				"expiry:         2022-06-01T14:00:00Z (expires in 2h0m0s)\n",
+				"protocol:       https\n",
+			},
+		},
+		{
+			testName: "Expired account SAS",
+			url:      "https://account.blob.core.windows.net/?sv=2021-08-06&ss=bf&srt=sco&sp=rlq&se=2022-05-31&sig=c2VjcmV0",
+			expectedOutput: []string{
+				"services:       blob, file\n",
+				"resourceTypes:  service, container, object\n",
+				"permissions:    read, list, unknown (q)\n",
+				"expiry:         2022-05-31 (expired 36h0m0s ago)\n",
+			},
+		},
+		{
+			testName:     "No signature",
+			url:          "https://account.blob.core.windows.net/container?sv=2021-08-06&se=2022-06-01T14:00:00Z",
+			expectedCode: 1,
+		},
+		{
+			testName:     "Invalid expiry",
+			url:          "https://account.blob.core.windows.net/container?se=tomorrow&sig=c2VjcmV0",
+			expectedCode: 1,
+		},
+	}
+	for _, test := range tests {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run([]string{"sas", "inspect", test.url}, nil, stdout, stderr)
+		if code != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %d\nActual Code: %d\nStderr: %s", test.testName, test.expectedCode, code, stderr)
+		}
+		for _, expected := range test.expectedOutput {
+			if !strings.Contains(stdout.String(), expected) {
+				t.Errorf("\nTestCase: %s\nExpected Output: %s\nActual Output: %s", test.testName, expected, stdout)
+			}
+		}
+		if strings.Contains(stdout.String(), "c2VjcmV0") {
+			t.Errorf("\nTestCase: %s\nthe signature must not be printed: %s", test.testName, stdout)
+		}
+	}
+}
@@ -14,10 +14,21 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"github.com/Azure/azure-cosi-driver/pkg/audit"
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/config"
 	"github.com/Azure/azure-cosi-driver/pkg/driver"
+	"github.com/Azure/azure-cosi-driver/pkg/leaderelection"
+	"github.com/Azure/azure-cosi-driver/pkg/metrics"
 	identityserver "github.com/Azure/azure-cosi-driver/pkg/server/identity"
 	provisionerserver "github.com/Azure/azure-cosi-driver/pkg/server/provisioner"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"k8s.io/klog"
 )
@@ -27,6 +38,33 @@ var (
 	kubeconfig                 = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Required only when running out of cluster.")
 	cloudConfigSecretName      = flag.String("cloud-config-secret-name", "azure-cloud-provider", "cloud config secret name")
 	cloudConfigSecretNamespace = flag.String("cloud-config-secret-namespace", "kube-system", "cloud config secret namespace")
+	azureMaxRetries            = flag.Int("azure-max-retries", azureutils.DefaultRetryPolicy.MaxRetries, "number of retries of a throttled or failed Azure call, 0 disables retries")
+	azureRetryBaseDelay        = flag.Duration("azure-retry-base-delay", azureutils.DefaultRetryPolicy.BaseDelay, "delay before the first retry of an Azure call, doubled on every further retry")
+	azureRetryMaxDelay         = flag.Duration("azure-retry-max-delay", azureutils.DefaultRetryPolicy.MaxDelay, "maximum delay between retries of an Azure call, unless Azure asks for a longer Retry-After")
+	armQPS                     = flag.Float64("arm-qps", float64(azureutils.DefaultRetryPolicy.QPS), "sustained rate of ARM calls per subscription, 0 disables client side rate limiting")
+	armBurst                   = flag.Int("arm-burst", azureutils.DefaultRetryPolicy.Burst, "burst of ARM calls per subscription above arm-qps")
+	auditSink                  = flag.String("audit-sink", "", "where to write the audit log of granted and revoked credentials: stdout, file:<path> or an http(s) webhook URL, empty disables auditing")
+	auditFileMaxSize           = flag.Int64("audit-file-max-size", audit.DefaultMaxFileSize, "size in bytes at which the audit file is rotated")
+	auditFileMaxBackups        = flag.Int("audit-file-max-backups", audit.DefaultMaxBackups, "number of rotated audit files to keep")
+	leaderElection             = flag.Bool("leader-election", false, "run several replicas, only the holder of the lease serves Provisioner calls")
+	leaderElectionNamespace    = flag.String("leader-election-namespace", "", "namespace of the leader election lease, defaults to the namespace of the pod")
+	leaderElectionLease        = flag.Duration("leader-election-lease-duration", leaderelection.DefaultLeaseDuration, "time a follower waits before taking over a lease that was not renewed")
+	leaderElectionRenew        = flag.Duration("leader-election-renew-deadline", leaderelection.DefaultRenewDeadline, "time the leader keeps trying to renew the lease before giving it up")
+	leaderElectionRetry        = flag.Duration("leader-election-retry-period", leaderelection.DefaultRetryPeriod, "interval between attempts to acquire or renew the lease")
+	driverConfig               = flag.String("config", "", "path of the YAML or JSON driver config file, reloaded when it changes")
+	configReloadInterval       = flag.Duration("config-reload-interval", config.DefaultReloadInterval, "how often the driver config file is checked for changes")
+	policyFile                 = flag.String("policy", "", "path of the YAML or JSON policy file whose rules every BucketClass and BucketAccessClass must satisfy, read at startup")
+	healthAddress              = flag.String("health-address", "", "address serving /readyz and /healthz, for example :29642, empty disables the health endpoints")
+	keyRotationInterval        = flag.Duration("key-rotation-interval", 0, "how often the keys of the storage accounts of known buckets are rotated and the SAS signed with them reissued, 0 disables key rotation")
+	sasRenewalInterval         = flag.Duration("sas-renewal-interval", 0, "how often the expiry of the SAS granted to BucketAccesses is checked, 0 disables SAS renewal")
+	sasRenewBefore             = flag.Duration("sas-renew-before", provisionerserver.DefaultSASRenewBefore, "how long before its expiry a SAS is reissued and written to the credentials Secret of its BucketAccess")
+	usageCollectionInterval    = flag.Duration("usage-collection-interval", 0, "how often the blob count and bytes of the known buckets are collected and published as metrics, 0 disables usage collection")
+	usageAnnotations           = flag.Bool("usage-annotations", false, "also record the collected usage as annotations on each Bucket")
+	metricsAddress             = flag.String("metrics-address", "", "address serving Prometheus metrics on /metrics, for example :29643, empty disables metrics")
+	doctor                     = flag.Bool("doctor", false, "check the Azure permissions of the driver's identity with a temporary storage account, print a report and exit")
+	doctorResourceGroup        = flag.String("doctor-resource-group", "", "resource group of the temporary storage account of --doctor, defaults to the one of the cloud config")
+	doctorLocation             = flag.String("doctor-location", "", "location of the temporary storage account of --doctor, defaults to the one of the cloud config")
+	doctorTimeout              = flag.Duration("doctor-timeout", 5*time.Minute, "time allowed for all the checks of --doctor")
 )
 
 func init() {
@@ -37,17 +75,139 @@ func main() {
 	flag.Parse()
 	defer klog.Flush()
 
-	provServer, err := provisionerserver.NewProvisionerServer(*kubeconfig, *cloudConfigSecretName, *cloudConfigSecretNamespace)
+	err := azureutils.SetRetryPolicy(azureutils.RetryPolicy{
+		MaxRetries: *azureMaxRetries,
+		BaseDelay:  *azureRetryBaseDelay,
+		MaxDelay:   *azureRetryMaxDelay,
+		QPS:        float32(*armQPS),
+		Burst:      *armBurst,
+	})
+	if err != nil {
+		klog.Exitf("Invalid retry policy: %v", err)
+	}
+
+	if *driverConfig != "" {
+		c, err := config.Load(*driverConfig)
+		if err != nil {
+			klog.Exitf("Invalid driver config: %v", err)
+		}
+		if err := azureutils.SetDriverConfig(c); err != nil {
+			klog.Exitf("Invalid driver config %s: %v", *driverConfig, err)
+		}
+		go config.Watch(context.Background(), *driverConfig, *configReloadInterval, azureutils.SetDriverConfig)
+	}
+
+	if *policyFile != "" {
+		p, err := config.LoadPolicy(*policyFile)
+		if err != nil {
+			klog.Exitf("Invalid policy: %v", err)
+		}
+		if err := azureutils.SetPolicy(p); err != nil {
+			klog.Exitf("Invalid policy %s: %v", *policyFile, err)
+		}
+	}
+
+	if *doctor {
+		os.Exit(runDoctor())
+	}
+
+	sink, err := audit.NewSink(*auditSink, audit.SinkOptions{MaxFileSize: *auditFileMaxSize, MaxBackups: *auditFileMaxBackups})
+	if err != nil {
+		klog.Exitf("Error creating audit sink: %v", err)
+	}
+	auditLogger := audit.NewLogger(sink)
+	defer auditLogger.Close()
+
+	var elector *leaderelection.Elector
+	if *leaderElection {
+		kubeClient, err := azureutils.GetKubeClient(*kubeconfig)
+		if err != nil {
+			klog.Exitf("Error creating kubeclient for leader election: %v", err)
+		}
+		elector, err = leaderelection.NewElector(kubeClient, driver.DriverName, leaderelection.Config{
+			Namespace:     *leaderElectionNamespace,
+			LeaseDuration: *leaderElectionLease,
+			RenewDeadline: *leaderElectionRenew,
+			RetryPeriod:   *leaderElectionRetry,
+		})
+		if err != nil {
+			klog.Exitf("Error creating leader elector: %v", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			elector.Run(ctx)
+			if ctx.Err() == nil {
+				// restart as a follower, the buckets known to this replica may have changed
+				klog.Exitf("Lost the leader election lease")
+			}
+		}()
+	}
+
+	// only the leader rotates keys, renews SAS and collects usage
+	provServer, err := provisionerserver.NewProvisionerServer(provisionerserver.Options{
+		Kubeconfig:                 *kubeconfig,
+		CloudConfigSecretName:      *cloudConfigSecretName,
+		CloudConfigSecretNamespace: *cloudConfigSecretNamespace,
+		AuditLogger:                auditLogger,
+		KeyRotationInterval:        *keyRotationInterval,
+		SASRenewalInterval:         *sasRenewalInterval,
+		SASRenewBefore:             *sasRenewBefore,
+		UsageCollectionInterval:    *usageCollectionInterval,
+		UsageAnnotations:           *usageAnnotations,
+		Leading:                    elector.Leading(),
+	})
 	if err != nil {
 		klog.Exitf("Error creating ProvisionerServer: %v", err)
 	}
+
+	if *healthAddress != "" {
+		go func() {
+			klog.Exitf("Error serving health endpoints: %v", http.ListenAndServe(*healthAddress, leaderelection.HealthHandler(elector)))
+		}()
+	}
+
+	if *metricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			klog.Exitf("Error serving metrics: %v", http.ListenAndServe(*metricsAddress, mux))
+		}()
+	}
+
 	identityServer, err := identityserver.NewIdentityServer(driver.DriverName)
 	if err != nil {
 		klog.Exitf("Error creating IdentityServer: %v", err)
 	}
 
-	err = driver.RunServerWithSignalHandler(*endpoint, identityServer, provServer)
+	err = driver.RunServerWithSignalHandler(*endpoint, identityServer, leaderelection.Guard(elector, provServer))
 	if err != nil {
 		klog.Exitf("Error when running driver: %v", err)
 	}
 }
+
+// runDoctor checks every Azure permission the driver needs and returns the exit code
+func runDoctor() int {
+	defer klog.Flush()
+	kubeClient, err := azureutils.GetKubeClient(*kubeconfig)
+	if err != nil {
+		klog.Exitf("Error creating kubeclient: %v", err)
+	}
+	cloud, err := azureutils.GetAzureCloudProvider(kubeClient, *cloudConfigSecretName, *cloudConfigSecretNamespace)
+	if err != nil {
+		klog.Exitf("Error loading the cloud config: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *doctorTimeout)
+	defer cancel()
+	checks := azureutils.GetDoctorChecks(cloud, azureutils.DoctorOptions{
+		ResourceGroup: *doctorResourceGroup,
+		Location:      *doctorLocation,
+	})
+	if !azureutils.RunDoctorChecks(ctx, checks, os.Stdout) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+type Action string
+
+const (
+	ActionGrant  Action = "grant"
+	ActionRevoke Action = "revoke"
+)
+
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// Record is one entry of the audit log.
+// It describes the access that was granted or revoked and never holds the credential itself.
+type Record struct {
+	Time               time.Time `json:"time"`
+	Action             Action    `json:"action"`
+	Outcome            Outcome   `json:"outcome"`
+	Error              string    `json:"error,omitempty"`
+	AuthenticationType string    `json:"authenticationType,omitempty"`
+	AccessName         string    `json:"accessName,omitempty"`
+	AccountID          string    `json:"accountId,omitempty"`
+	StorageAccount     string    `json:"storageAccount,omitempty"`
+	Container          string    `json:"container,omitempty"`
+	Directory          string    `json:"directory,omitempty"`
+	// SignedResource is the sr of a SAS, e.g. c for a container or d for a directory
+	SignedResource string `json:"signedResource,omitempty"`
+	Permissions    string `json:"permissions,omitempty"`
+	IPRange        string `json:"ipRange,omitempty"`
+	Protocol       string `json:"protocol,omitempty"`
+	Start          string `json:"start,omitempty"`
+	Expiry         string `json:"expiry,omitempty"`
+	Detail         string `json:"detail,omitempty"`
+}
+
+// SetSASToken copies the scope of a SAS into the record. The signature is not copied.
+func (r *Record) SetSASToken(token string) {
+	query, err := url.ParseQuery(token)
+	if err != nil {
+		return
+	}
+	r.SignedResource = query.Get("sr")
+	r.Permissions = query.Get("sp")
+	r.IPRange = query.Get("sip")
+	r.Protocol = query.Get("spr")
+	r.Start = query.Get("st")
+	r.Expiry = query.Get("se")
+}
+
+// Sink stores audit records, one JSON document per record
+type Sink interface {
+	Write(record []byte) error
+	Close() error
+}
+
+// Logger appends records to a sink. A nil Logger discards records.
+type Logger struct {
+	mux  sync.Mutex
+	sink Sink
+}
+
+func NewLogger(sink Sink) *Logger {
+	if sink == nil {
+		return nil
+	}
+	return &Logger{sink: sink}
+}
+
+// Log writes the record to the sink. Failures are logged, an unavailable sink does not fail the grant.
+func (l *Logger) Log(r Record) {
+	if l == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		klog.Errorf("Could not marshal audit record: %v", err)
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if err := l.sink.Write(data); err != nil {
+		klog.Errorf("Could not write audit record of %s %s: %v", r.Action, r.AccountID, err)
+	}
+}
+
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.sink.Close()
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSASToken = "sv=2020-02-10&st=2022-01-01T00%3A00%3A00Z&se=2022-01-02T00%3A00%3A00Z&sr=c&sp=rl&sip=10.0.0.1-10.0.0.9&spr=https&sig=c2lnbmF0dXJl"
+
+func TestSetSASToken(t *testing.T) {
+	r := Record{}
+	r.SetSASToken(testSASToken)
+	expected := Record{
+		SignedResource: "c",
+		Permissions:    "rl",
+		IPRange:        "10.0.0.1-10.0.0.9",
+		Protocol:       "https",
+		Start:          "2022-01-01T00:00:00Z",
+		Expiry:         "2022-01-02T00:00:00Z",
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("\nExpected Record: %+v\nActual Record: %+v", expected, r)
+	}
+}
+
+func TestLoggerLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(NewWriterSink(buf))
+
+	r := Record{Action: ActionGrant, Outcome: OutcomeSuccess, AccountID: "sas:validaccount/validcontainer/access"}
+	r.SetSASToken(testSASToken)
+	l.Log(r)
+	l.Log(Record{Action: ActionRevoke, Outcome: OutcomeSuccess, AccountID: "sas:validaccount/validcontainer/access"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d: %s", len(lines), buf.String())
+	}
+	if strings.Contains(buf.String(), "c2lnbmF0dXJl") {
+		t.Errorf("expected the signature to be absent from the audit log: %s", buf.String())
+	}
+
+	var logged Record
+	if err := json.Unmarshal([]byte(lines[0]), &logged); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if logged.Time.IsZero() || time.Since(logged.Time) > time.Minute {
+		t.Errorf("expected the record to be timestamped, got %v", logged.Time)
+	}
+	logged.Time = time.Time{}
+	if !reflect.DeepEqual(logged, r) {
+		t.Errorf("\nExpected Record: %+v\nActual Record: %+v", r, logged)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	l := NewLogger(nil)
+	if l != nil {
+		t.Fatalf("expected a nil logger without a sink")
+	}
+	l.Log(Record{Action: ActionGrant})
+	if err := l.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
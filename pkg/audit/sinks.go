@@ -0,0 +1,206 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxFileSize is the size in bytes at which an audit file is rotated
+	DefaultMaxFileSize = 100 * 1024 * 1024
+	// DefaultMaxBackups is the number of rotated audit files kept
+	DefaultMaxBackups = 5
+	// DefaultWebhookTimeout bounds each request to a webhook sink
+	DefaultWebhookTimeout = 10 * time.Second
+
+	filePrefix = "file:"
+)
+
+// SinkOptions configures the sinks created by NewSink
+type SinkOptions struct {
+	// MaxFileSize defaults to DefaultMaxFileSize when not positive
+	MaxFileSize int64
+	// MaxBackups of 0 truncates the file on rotation, a negative value uses DefaultMaxBackups
+	MaxBackups int
+	// WebhookTimeout defaults to DefaultWebhookTimeout when not positive
+	WebhookTimeout time.Duration
+}
+
+// NewSink creates the sink named by target:
+// "stdout", "file:<path>" or an http(s) webhook URL. An empty target or "none" disables auditing.
+func NewSink(target string, opts SinkOptions) (Sink, error) {
+	switch {
+	case target == "" || target == "none":
+		return nil, nil
+	case target == "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case strings.HasPrefix(target, filePrefix):
+		sink, err := newFileSink(strings.TrimPrefix(target, filePrefix), opts.MaxFileSize, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		return sink, nil
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		return newWebhookSink(target, opts.WebhookTimeout), nil
+	}
+	return nil, fmt.Errorf("audit sink %s is unsupported, expected stdout, file:<path> or an http(s) URL", target)
+}
+
+// writerSink writes records as JSON lines
+type writerSink struct {
+	w io.Writer
+}
+
+// NewWriterSink writes records as JSON lines to w
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Write(record []byte) error {
+	_, err := s.w.Write(append(record, '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// fileSink appends JSON lines to a file, rotating it to <path>.1 ... <path>.<maxBackups> once it reaches maxSize
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("audit file path is empty")
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	if maxBackups < 0 {
+		maxBackups = DefaultMaxBackups
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("could not create audit directory: %v", err)
+	}
+	s := &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open audit file %s: %v", s.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not stat audit file %s: %v", s.path, err)
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", s.path, i)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Write(record []byte) error {
+	line := append(record, '\n')
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("could not rotate audit file %s: %v", s.path, err)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+// webhookSink posts every record as a JSON document
+type webhookSink struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+func newWebhookSink(url string, timeout time.Duration) *webhookSink {
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	return &webhookSink{url: url, timeout: timeout, client: http.DefaultClient}
+}
+
+func (s *webhookSink) Write(record []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(record))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
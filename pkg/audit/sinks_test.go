@@ -0,0 +1,129 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSink(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		testName    string
+		target      string
+		expectNil   bool
+		expectedErr bool
+	}{
+		{testName: "Disabled", target: "", expectNil: true},
+		{testName: "None", target: "none", expectNil: true},
+		{testName: "Stdout", target: "stdout"},
+		{testName: "File", target: "file:" + filepath.Join(dir, "audit", "audit.log")},
+		{testName: "Webhook", target: "https://audit.example.com/records"},
+		{testName: "Empty file path", target: "file:", expectNil: true, expectedErr: true},
+		{testName: "Unsupported", target: "syslog", expectNil: true, expectedErr: true},
+	}
+	for _, test := range tests {
+		sink, err := NewSink(test.target, SinkOptions{})
+		if (err != nil) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if (sink == nil) != test.expectNil {
+			t.Errorf("\nTestCase: %s\nExpected nil sink: %v\nActual sink: %v", test.testName, test.expectNil, sink)
+		}
+		if sink != nil {
+			sink.Close()
+		}
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	record := []byte(`{"action":"grant"}`)
+	// two records fit in a file
+	sink, err := newFileSink(path, int64(2*(len(record)+1)), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := sink.Write(record); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedLines := map[string]int{path: 1, path + ".1": 2, path + ".2": 2}
+	for file, lines := range expectedLines {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(data) != lines*(len(record)+1) {
+			t.Errorf("expected %d records in %s, got %q", lines, file, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups to be kept")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the audit file to be private, got %v", info.Mode().Perm())
+	}
+
+	// reopening appends to the existing file
+	sink, err = newFileSink(path, DefaultMaxFileSize, DefaultMaxBackups)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sink.size != int64(len(record)+1) {
+		t.Errorf("expected the size of the existing file, got %d", sink.size)
+	}
+	sink.Close()
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received []byte
+	var contentType string
+	statusCode := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+
+	sink := newWebhookSink(server.URL, 0)
+	record := []byte(`{"action":"revoke"}`)
+	if err := sink.Write(record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(received) != string(record) || contentType != "application/json" {
+		t.Errorf("expected %s as application/json, got %s as %s", record, received, contentType)
+	}
+
+	statusCode = http.StatusInternalServerError
+	if err := sink.Write(record); err == nil {
+		t.Errorf("expected an error when the webhook fails")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	"sigs.k8s.io/cloud-provider-azure/pkg/auth"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	// DefaultStorageAccountNamePrefix starts the names the driver generates for storage accounts
+	DefaultStorageAccountNamePrefix = "cosi"
+	// maxStorageAccountNameLength is the Azure limit on storage account names
+	maxStorageAccountNameLength = 24
+	// maxStorageAccountNamePrefixLength leaves room in generated names for part of the bucket name and the hash
+	maxStorageAccountNamePrefixLength = 11
+	// accountNameHashLength is the number of hex digits of the hash that make generated names unique
+	accountNameHashLength = 8
+	// maxAccountNameAttempts is how many names are tried before giving up on collisions
+	maxAccountNameAttempts = 5
+)
+
+var (
+	storageAccountNamePrefixRE = regexp.MustCompile(`^[a-z0-9]+$`)
+	accountNameInvalidCharRE   = regexp.MustCompile(`[^a-z0-9]`)
+)
+
+// newAccountsClient returns the ARM client used to check storage account name availability, tests replace it
+var newAccountsClient = func(cloud *azure.Cloud, subsID string) (*storage.AccountsClient, error) {
+	token, err := auth.GetServicePrincipalToken(&cloud.Config.AzureAuthConfig, &cloud.Environment, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not get service principal token: %v", err)
+	}
+	client := storage.NewAccountsClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	client.Authorizer = autorest.NewBearerAuthorizer(token)
+	return &client, nil
+}
+
+func validateStorageAccountNamePrefix(prefix string) error {
+	if len(prefix) > maxStorageAccountNamePrefixLength || !storageAccountNamePrefixRE.MatchString(prefix) {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid %s %q, it must be 1 to %d lowercase letters and digits", constant.StorageAccountNamePrefixField, prefix, maxStorageAccountNamePrefixLength))
+	}
+	return nil
+}
+
+// getStorageAccountName derives a storage account name from the prefix, the bucket name and a hash of
+// the subscription, resource group and bucket name. The same inputs always give the same name so a
+// retried CreateBucket finds the account it created before; attempt changes the hash after a collision.
+func getStorageAccountName(prefix, subsID, resourceGroup, bucketName string, attempt int) string {
+	seed := strings.ToLower(fmt.Sprintf("%s/%s/%s", subsID, resourceGroup, bucketName))
+	if attempt > 0 {
+		seed = fmt.Sprintf("%s/%d", seed, attempt)
+	}
+	sum := sha256.Sum256([]byte(seed))
+	hash := hex.EncodeToString(sum[:])[:accountNameHashLength]
+
+	middle := accountNameInvalidCharRE.ReplaceAllString(strings.ToLower(bucketName), "")
+	if room := maxStorageAccountNameLength - len(prefix) - accountNameHashLength; len(middle) > room {
+		middle = middle[:room]
+	}
+	return prefix + middle + hash
+}
+
+// generateStorageAccountName picks the name of the storage account of a bucket whose BucketClass has no
+// storageaccountname. A name already used by an account of the resource group is reused, other names are
+// checked for global availability and the next candidate is tried when another account holds the name.
+func generateStorageAccountName(ctx context.Context, bucketName string, params *BucketClassParameters, cloud *azure.Cloud) (string, error) {
+	subsID := cloud.SubscriptionID
+	if params.subscriptionID != "" {
+		subsID = params.subscriptionID
+	}
+	resourceGroup := params.resourceGroup
+	if resourceGroup == "" {
+		resourceGroup = cloud.ResourceGroup
+	}
+	prefix := params.storageAccountNamePrefix
+	if prefix == "" {
+		prefix = DefaultStorageAccountNamePrefix
+	}
+
+	var client *storage.AccountsClient
+	for attempt := 0; attempt < maxAccountNameAttempts; attempt++ {
+		name := getStorageAccountName(prefix, subsID, resourceGroup, bucketName, attempt)
+
+		// an earlier attempt to create this bucket may already have created the account
+		rerr := withRetryError(ctx, subsID, "GetStorageAccountProperties", func() *retry.Error {
+			_, rerr := cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, name)
+			return rerr
+		})
+		if rerr == nil {
+			klog.Infof("Reusing storage account %s for bucket %s", name, bucketName)
+			return name, nil
+		}
+		if rerr.HTTPStatusCode != http.StatusNotFound {
+			return "", newAzureError(rerr.Error(), "Could not get storage account %s: %v", name, rerr.Error())
+		}
+
+		if client == nil {
+			var err error
+			if client, err = newAccountsClient(cloud, subsID); err != nil {
+				return "", status.Error(codes.Internal, fmt.Sprintf("Could not create storage accounts client: %v", err))
+			}
+		}
+		var result storage.CheckNameAvailabilityResult
+		err := withRetry(ctx, subsID, "CheckNameAvailability", func() (err error) {
+			result, err = client.CheckNameAvailability(ctx, storage.AccountCheckNameAvailabilityParameters{
+				Name: to.StringPtr(name),
+				Type: to.StringPtr("Microsoft.Storage/storageAccounts"),
+			})
+			return err
+		})
+		if err != nil {
+			return "", newAzureError(err, "Could not check availability of storage account name %s: %v", name, err)
+		}
+		if to.Bool(result.NameAvailable) {
+			klog.Infof("Generated storage account name %s for bucket %s", name, bucketName)
+			return name, nil
+		}
+		if result.Reason == storage.ReasonAccountNameInvalid {
+			return "", status.Error(codes.InvalidArgument, fmt.Sprintf("Generated storage account name %s is invalid: %s", name, to.String(result.Message)))
+		}
+		klog.Infof("Storage account name %s is not available: %s", name, to.String(result.Message))
+	}
+	return "", status.Error(codes.Aborted, fmt.Sprintf("Could not find an available storage account name for bucket %s after %d attempts", bucketName, maxAccountNameAttempts))
+}
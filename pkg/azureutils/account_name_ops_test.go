@@ -0,0 +1,191 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetStorageAccountName(t *testing.T) {
+	validName := regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+	tests := []struct {
+		testName     string
+		prefix       string
+		bucketName   string
+		expectedBase string
+	}{
+		{
+			testName:     "short bucket name",
+			prefix:       "cosi",
+			bucketName:   "logs",
+			expectedBase: "cosilogs",
+		},
+		{
+			testName:     "bucket name with invalid characters",
+			prefix:       "cosi",
+			bucketName:   "Team-A.Logs",
+			expectedBase: "cositeamalogs",
+		},
+		{
+			testName:     "long bucket name is truncated",
+			prefix:       "cosi",
+			bucketName:   "bucket-e2c4a3b1-7f0d-4e2a-9d8c-5b6a7c8d9e0f",
+			expectedBase: "cosibuckete2c4a3",
+		},
+		{
+			testName:     "longest prefix",
+			prefix:       "abcdefghijk",
+			bucketName:   "logs",
+			expectedBase: "abcdefghijklogs",
+		},
+		{
+			testName:     "bucket name without valid characters",
+			prefix:       "cosi",
+			bucketName:   "---",
+			expectedBase: "cosi",
+		},
+	}
+	for _, test := range tests {
+		name := getStorageAccountName(test.prefix, "sub", "rg", test.bucketName, 0)
+		if !validName.MatchString(name) {
+			t.Errorf("\nTestCase: %s\nGenerated invalid name %q", test.testName, name)
+		}
+		if base := strings.TrimSuffix(name, name[len(name)-accountNameHashLength:]); base != test.expectedBase {
+			t.Errorf("\nTestCase: %s\nExpected name to start with %q, got %q", test.testName, test.expectedBase, name)
+		}
+		if again := getStorageAccountName(test.prefix, "sub", "rg", test.bucketName, 0); again != name {
+			t.Errorf("\nTestCase: %s\nExpected the same name for the same inputs, got %q and %q", test.testName, name, again)
+		}
+		if retried := getStorageAccountName(test.prefix, "sub", "rg", test.bucketName, 1); retried == name {
+			t.Errorf("\nTestCase: %s\nExpected a different name for the next attempt, got %q", test.testName, retried)
+		}
+		if other := getStorageAccountName(test.prefix, "sub", "otherrg", test.bucketName, 0); other == name {
+			t.Errorf("\nTestCase: %s\nExpected a different name in another resource group, got %q", test.testName, other)
+		}
+	}
+}
+
+func TestValidateStorageAccountNamePrefix(t *testing.T) {
+	for prefix, valid := range map[string]bool{
+		"cosi":         true,
+		"team1":        true,
+		"abcdefghijk":  true,
+		"":             false,
+		"abcdefghijkl": false,
+		"Team":         false,
+		"team-a":       false,
+	} {
+		err := validateStorageAccountNamePrefix(prefix)
+		if valid && err != nil {
+			t.Errorf("expected prefix %q to be valid, got %v", prefix, err)
+		}
+		if !valid && status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected prefix %q to be invalid, got %v", prefix, err)
+		}
+	}
+}
+
+func TestGenerateStorageAccountName(t *testing.T) {
+	ctx := context.Background()
+	params := &BucketClassParameters{resourceGroup: fakeazure.ResourceGroup}
+	first := getStorageAccountName(DefaultStorageAccountNamePrefix, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "bucket", 0)
+	second := getStorageAccountName(DefaultStorageAccountNamePrefix, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "bucket", 1)
+
+	tests := []struct {
+		testName     string
+		existing     func(s *fakeazure.Server)
+		expectedName string
+		expectedCode codes.Code
+	}{
+		{
+			testName:     "name is available",
+			existing:     func(s *fakeazure.Server) {},
+			expectedName: first,
+		},
+		{
+			testName: "account of the resource group is reused",
+			existing: func(s *fakeazure.Server) {
+				s.CreateAccount(fakeazure.SubscriptionID, fakeazure.ResourceGroup, first)
+			},
+			expectedName: first,
+		},
+		{
+			testName: "name taken by another subscription",
+			existing: func(s *fakeazure.Server) {
+				s.CreateAccount("other-subscription", "other-rg", first)
+			},
+			expectedName: second,
+		},
+		{
+			testName: "all names taken",
+			existing: func(s *fakeazure.Server) {
+				for attempt := 0; attempt < maxAccountNameAttempts; attempt++ {
+					s.CreateAccount("other-subscription", "other-rg", getStorageAccountName(DefaultStorageAccountNamePrefix, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "bucket", attempt))
+				}
+			},
+			expectedCode: codes.Aborted,
+		},
+	}
+	for _, test := range tests {
+		s := fakeazure.NewServer()
+		test.existing(s)
+
+		name, err := generateStorageAccountName(ctx, "bucket", params, s.Cloud())
+		if status.Code(err) != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %v\nActual Error: %v", test.testName, test.expectedCode, err)
+		}
+		if name != test.expectedName {
+			t.Errorf("\nTestCase: %s\nExpected Name: %s\nActual Name: %s", test.testName, test.expectedName, name)
+		}
+		s.Close()
+	}
+}
+
+func TestCreateBucketGeneratesStorageAccountName(t *testing.T) {
+	s := fakeazure.NewServer()
+	defer s.Close()
+	SetHTTPClient(s.HTTPClient())
+	defer SetHTTPClient(nil)
+
+	base64ID, err := CreateBucket(context.Background(), "bucket", map[string]string{
+		constant.BucketUnitTypeField:           constant.StorageAccount.String(),
+		constant.ResourceGroupField:            fakeazure.ResourceGroup,
+		constant.StorageAccountNamePrefixField: "team1",
+	}, s.Cloud())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedName := getStorageAccountName("team1", fakeazure.SubscriptionID, fakeazure.ResourceGroup, "bucket", 0)
+	if accounts := s.Accounts(); len(accounts) != 1 || accounts[0] != expectedName {
+		t.Errorf("expected storage account %s, got %v", expectedName, accounts)
+	}
+	id, err := types.DecodeToBucketID(base64ID)
+	if err != nil {
+		t.Fatalf("unexpected error decoding bucket ID: %v", err)
+	}
+	if account := getStorageAccountNameFromContainerURL(id.URL); account != expectedName {
+		t.Errorf("expected the BucketID to record storage account %s, got %s", expectedName, account)
+	}
+}
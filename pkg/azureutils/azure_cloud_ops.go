@@ -20,6 +20,7 @@ import (
 	"runtime"
 	"strings"
 
+	"k8s.io/client-go/dynamic"
 	clientSet "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -70,6 +71,16 @@ func GetKubeClient(kubeconfig string) (*clientSet.Clientset, error) {
 	return clientSet.NewForConfig(config)
 }
 
+// GetDynamicClient returns a client for the COSI custom resources
+func GetDynamicClient(kubeconfig string) (dynamic.Interface, error) {
+	config, err := getKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamic.NewForConfig(config)
+}
+
 // GetAzureCloudProvider get Azure Cloud Provider
 func GetAzureCloudProvider(
 	kubeClient clientSet.Interface,
@@ -87,6 +98,8 @@ func GetAzureCloudProvider(
 		az.KubeClient = kubeClient
 		if err := az.InitializeCloudFromSecret(); err != nil {
 			klog.Infof("InitializeCloudFromSecret failed with error: %v", err)
+		} else if err := disableClientBackoff(az, true, true); err != nil {
+			return az, err
 		}
 	}
 
@@ -116,6 +129,9 @@ func GetAzureCloudProvider(
 		if az, err = azure.NewCloudWithoutFeatureGates(f, false); err != nil {
 			return az, err
 		}
+		if err := disableClientBackoff(az, false, false); err != nil {
+			return az, err
+		}
 	}
 
 	// reassign kubeClient
@@ -124,3 +140,16 @@ func GetAzureCloudProvider(
 	}
 	return az, nil
 }
+
+// disableClientBackoff initializes the clients of the cloud provider again without their own retries
+// when the cloud config enables cloudProviderBackoff. The driver retries ARM calls itself (see withRetry),
+// retrying in the clients as well would multiply the attempts and the delays under throttling.
+func disableClientBackoff(az *azure.Cloud, fromSecret, callFromCCM bool) error {
+	if !az.Config.CloudProviderBackoff {
+		return nil
+	}
+	klog.Infof("ignoring cloudProviderBackoff of the cloud config, the driver retries Azure calls with its own retry policy")
+	config := az.Config
+	config.CloudProviderBackoff = false
+	return az.InitializeCloudFromConfig(&config, fromSecret, callFromCCM)
+}
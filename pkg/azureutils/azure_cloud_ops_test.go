@@ -2,6 +2,7 @@ package azureutils
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -54,3 +55,34 @@ func TestGetAzureCloudProvider(t *testing.T) {
 		}*/
 	}
 }
+
+func TestGetAzureCloudProviderDisablesClientBackoff(t *testing.T) {
+	credFile := filepath.Join(t.TempDir(), "azure.json")
+	config := `{
+		"cloud": "AzurePublicCloud",
+		"tenantId": "tenant",
+		"subscriptionId": "subscription",
+		"resourceGroup": "rg",
+		"location": "eastus",
+		"aadClientId": "client",
+		"aadClientSecret": "secret",
+		"cloudProviderBackoff": true,
+		"cloudProviderBackoffRetries": 6
+	}`
+	if err := os.WriteFile(credFile, []byte(config), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv(DefaultAzureCredentialFileEnv, credFile)
+
+	cloud, err := GetAzureCloudProvider(nil, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cloud.Config.CloudProviderBackoff || cloud.Config.CloudProviderBackoffRetries != 1 {
+		t.Errorf("expected the client backoff to be disabled, got cloudProviderBackoff %v with %d retries",
+			cloud.Config.CloudProviderBackoff, cloud.Config.CloudProviderBackoffRetries)
+	}
+	if cloud.StorageAccountClient == nil || cloud.SubscriptionID != "subscription" {
+		t.Errorf("expected the cloud to be initialized from the file, got %+v", cloud.Config)
+	}
+}
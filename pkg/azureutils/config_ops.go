@@ -0,0 +1,204 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-cosi-driver/pkg/config"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	driverConfigLock sync.RWMutex
+	driverConfig     = &config.Config{}
+)
+
+// SetDriverConfig replaces the driver configuration used by all later calls.
+// The defaults are parsed like class parameters and must satisfy the allowed regions, SKUs and
+// maximum SAS lifetime, so a configuration that would make every claim fail is rejected.
+func SetDriverConfig(c *config.Config) error {
+	if c == nil {
+		c = &config.Config{}
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	defaults, err := parseBucketClassParameters(c.BucketClassDefaults)
+	if err != nil {
+		return fmt.Errorf("invalid bucketClassDefaults: %v", err)
+	}
+	if defaults.region != "" && len(c.AllowedRegions) > 0 && !containsNormalized(c.AllowedRegions, defaults.region) {
+		return fmt.Errorf("default %s %s is not in allowedRegions", constant.RegionField, defaults.region)
+	}
+	if defaults.storageAccountType != "" && len(c.AllowedSKUs) > 0 && !containsNormalized(c.AllowedSKUs, defaults.storageAccountType) {
+		return fmt.Errorf("default %s %s is not in allowedSKUs", StorageAccountTypeField, defaults.storageAccountType)
+	}
+	if _, err := resolveBucketAccessClassParameters(c, nil); err != nil {
+		return fmt.Errorf("invalid bucketAccessClassDefaults: %v", err)
+	}
+	driverConfigLock.Lock()
+	defer driverConfigLock.Unlock()
+	driverConfig = c
+	return nil
+}
+
+func getDriverConfig() *config.Config {
+	driverConfigLock.RLock()
+	defer driverConfigLock.RUnlock()
+	return driverConfig
+}
+
+// withDefaults returns the parameters with every default they do not set, keys are case insensitive
+func withDefaults(defaults, parameters map[string]string) map[string]string {
+	merged := make(map[string]string, len(defaults)+len(parameters))
+	set := make(map[string]bool, len(parameters))
+	for k, v := range parameters {
+		merged[k] = v
+		set[strings.ToLower(k)] = true
+	}
+	for k, v := range defaults {
+		if !set[strings.ToLower(k)] {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// getBucketClassParameters parses the BucketClass parameters on top of the configured defaults
+// and checks them against the admin policy. Buckets without a region are created in location.
+func getBucketClassParameters(parameters map[string]string, location string) (*BucketClassParameters, error) {
+	params, err := resolveBucketClassParameters(getDriverConfig(), parameters, location)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBucketClassPolicy(getPolicy(), params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func resolveBucketClassParameters(c *config.Config, parameters map[string]string, location string) (*BucketClassParameters, error) {
+	params, err := parseBucketClassParameters(withDefaults(c.BucketClassDefaults, parameters))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBucketClassRestrictions(c, params, location); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// checkBucketClassRestrictions checks the parsed BucketClass parameters against the allowed regions
+// and SKUs of the driver config
+func checkBucketClassRestrictions(c *config.Config, params *BucketClassParameters, location string) error {
+	if len(c.AllowedRegions) > 0 {
+		// cloud-provider-azure creates storage accounts without a region in the location of the cluster
+		region := params.region
+		if region == "" {
+			region = location
+		}
+		if region == "" {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("%s is required, allowed regions are %s", constant.RegionField, strings.Join(c.AllowedRegions, ", ")))
+		}
+		if !containsNormalized(c.AllowedRegions, region) {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s is not allowed, allowed regions are %s", constant.RegionField, region, strings.Join(c.AllowedRegions, ", ")))
+		}
+	}
+	if sku := getSKU(params); len(c.AllowedSKUs) > 0 && !containsNormalized(c.AllowedSKUs, sku) {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s is not allowed, allowed SKUs are %s", StorageAccountTypeField, sku, strings.Join(c.AllowedSKUs, ", ")))
+	}
+	return nil
+}
+
+// getBucketAccessClassParameters parses the BucketAccessClass parameters on top of the configured defaults
+func getBucketAccessClassParameters(parameters map[string]string) (*BucketAccessClassParameters, error) {
+	return resolveBucketAccessClassParameters(getDriverConfig(), parameters)
+}
+
+func resolveBucketAccessClassParameters(c *config.Config, parameters map[string]string) (*BucketAccessClassParameters, error) {
+	merged := withDefaults(c.BucketAccessClassDefaults, parameters)
+	params, err := parseBucketAccessClassParameters(merged)
+	if err != nil {
+		return nil, err
+	}
+	maxMsec := uint64(c.MaxSASLifetime.Milliseconds())
+	if maxMsec > 0 && params.validationPeriod > maxMsec {
+		if hasParameter(merged, constant.ValidationPeriodField) {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("%s %d exceeds the maximum SAS lifetime of %v", constant.ValidationPeriodField, params.validationPeriod, c.MaxSASLifetime.Duration))
+		}
+		// the built in default of a week is shortened rather than rejected
+		params.validationPeriod = maxMsec
+	}
+	return params, nil
+}
+
+// getSKU returns the storage account type a new storage account is created with
+func getSKU(params *BucketClassParameters) string {
+	if params.storageAccountType != "" {
+		return params.storageAccountType
+	}
+	if params.bucketUnitType == constant.FileShare && params.shareProtocol == storage.EnabledProtocolsNFS {
+		return string(storage.SkuNamePremiumLRS)
+	}
+	return constant.StandardLRS.String()
+}
+
+func hasParameter(parameters map[string]string, field string) bool {
+	for k := range parameters {
+		if strings.EqualFold(k, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsNormalized compares Azure names ignoring case and spaces, "East US" is eastus
+func containsNormalized(list []string, value string) bool {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(s, " ", ""))
+	}
+	for _, item := range list {
+		if normalize(item) == normalize(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// getBlobDomain returns the domain of blob endpoints, blob.core.windows.net in the public cloud
+func getBlobDomain() string {
+	return "blob." + getDriverConfig().GetStorageEndpointSuffix()
+}
+
+// getBlobPrivateLinkDomain returns the domain of blob endpoints reached through a private link
+func getBlobPrivateLinkDomain() string {
+	return "privatelink." + getBlobDomain()
+}
+
+// getDFSDomain returns the domain of Data Lake Storage Gen2 endpoints
+func getDFSDomain() string {
+	return "dfs." + getDriverConfig().GetStorageEndpointSuffix()
+}
+
+// getFileDomain returns the domain of Azure Files endpoints
+func getFileDomain() string {
+	return "file." + getDriverConfig().GetStorageEndpointSuffix()
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/config"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func setTestDriverConfig(t *testing.T, c *config.Config) {
+	if err := SetDriverConfig(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = SetDriverConfig(nil) })
+}
+
+func TestWithDefaults(t *testing.T) {
+	defaults := map[string]string{"resourcegroup": "cosi", "region": "eastus"}
+	parameters := map[string]string{"Region": "westus2", "bucketunittype": "container"}
+	expected := map[string]string{"resourcegroup": "cosi", "Region": "westus2", "bucketunittype": "container"}
+	if merged := withDefaults(defaults, parameters); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("\nExpected Parameters: %v\nActual Parameters: %v", expected, merged)
+	}
+	if merged := withDefaults(nil, nil); len(merged) != 0 {
+		t.Errorf("expected no parameters, got %v", merged)
+	}
+}
+
+func TestSetDriverConfig(t *testing.T) {
+	tests := []struct {
+		testName    string
+		config      *config.Config
+		expectedErr bool
+	}{
+		{testName: "Empty", config: &config.Config{}},
+		{
+			testName: "Valid defaults",
+			config: &config.Config{
+				BucketClassDefaults:       map[string]string{"region": "East US", "storageaccounttype": "Standard_GRS"},
+				BucketAccessClassDefaults: map[string]string{"validationperiod": "3600000"},
+				AllowedRegions:            []string{"eastus"},
+				AllowedSKUs:               []string{"Standard_GRS"},
+				MaxSASLifetime:            metav1.Duration{Duration: time.Hour},
+			},
+		},
+		{
+			testName: "Allowed regions without a default region",
+			config:   &config.Config{AllowedRegions: []string{"eastus"}},
+		},
+		{
+			testName:    "Unparsable bucket class default",
+			config:      &config.Config{BucketClassDefaults: map[string]string{"accesstier": "lukewarm"}},
+			expectedErr: true,
+		},
+		{
+			testName:    "Unparsable bucket access class default",
+			config:      &config.Config{BucketAccessClassDefaults: map[string]string{"validationperiod": "a week"}},
+			expectedErr: true,
+		},
+		{
+			testName: "Default region not allowed",
+			config: &config.Config{
+				BucketClassDefaults: map[string]string{"region": "westus2"},
+				AllowedRegions:      []string{"eastus"},
+			},
+			expectedErr: true,
+		},
+		{
+			testName: "Default SKU not allowed",
+			config: &config.Config{
+				BucketClassDefaults: map[string]string{"storageaccounttype": "Premium_LRS"},
+				AllowedSKUs:         []string{"Standard_LRS"},
+			},
+			expectedErr: true,
+		},
+		{
+			testName: "Default validation period above the maximum",
+			config: &config.Config{
+				BucketAccessClassDefaults: map[string]string{"validationperiod": "7200000"},
+				MaxSASLifetime:            metav1.Duration{Duration: time.Hour},
+			},
+			expectedErr: true,
+		},
+	}
+	t.Cleanup(func() { _ = SetDriverConfig(nil) })
+	for _, test := range tests {
+		previous := getDriverConfig()
+		err := SetDriverConfig(test.config)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err != nil && getDriverConfig() != previous {
+			t.Errorf("\nTestCase: %s\nexpected a rejected config to leave the previous one in effect", test.testName)
+		}
+	}
+}
+
+func TestGetBucketClassParameters(t *testing.T) {
+	setTestDriverConfig(t, &config.Config{
+		BucketClassDefaults: map[string]string{"resourcegroup": "cosi", "region": "eastus"},
+		AllowedRegions:      []string{"East US", "westus2"},
+		AllowedSKUs:         []string{"Standard_LRS", "Premium_LRS"},
+	})
+	tests := []struct {
+		testName              string
+		parameters            map[string]string
+		expectedRegion        string
+		expectedResourceGroup string
+		expectedErr           codes.Code
+	}{
+		{
+			testName:              "Defaults",
+			parameters:            map[string]string{},
+			expectedRegion:        "eastus",
+			expectedResourceGroup: "cosi",
+		},
+		{
+			testName:              "Class overrides a default",
+			parameters:            map[string]string{"Region": "westus2"},
+			expectedRegion:        "westus2",
+			expectedResourceGroup: "cosi",
+		},
+		{
+			testName:    "Region not allowed",
+			parameters:  map[string]string{"region": "northeurope"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			testName:    "SKU not allowed",
+			parameters:  map[string]string{"storageaccounttype": "Standard_GRS"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			testName:              "NFS share defaults to an allowed premium SKU",
+			parameters:            map[string]string{"bucketunittype": "fileshare", "shareprotocol": "nfs"},
+			expectedRegion:        "eastus",
+			expectedResourceGroup: "cosi",
+		},
+	}
+	for _, test := range tests {
+		params, err := getBucketClassParameters(test.parameters, "")
+		if status.Code(err) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && (params.region != test.expectedRegion || params.resourceGroup != test.expectedResourceGroup) {
+			t.Errorf("\nTestCase: %s\nExpected: %s %s\nActual: %s %s", test.testName, test.expectedRegion, test.expectedResourceGroup, params.region, params.resourceGroup)
+		}
+	}
+
+	setTestDriverConfig(t, &config.Config{AllowedRegions: []string{"eastus"}})
+	if _, err := getBucketClassParameters(map[string]string{}, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected a region to be required when regions are restricted, got %v", err)
+	}
+	// without a region the bucket is created in the location of the cluster
+	if _, err := getBucketClassParameters(map[string]string{}, "eastus"); err != nil {
+		t.Errorf("expected the location of the cluster to be allowed, got %v", err)
+	}
+	if _, err := getBucketClassParameters(map[string]string{}, "westus2"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected the location of the cluster to be checked, got %v", err)
+	}
+}
+
+func TestGetBucketAccessClassParameters(t *testing.T) {
+	setTestDriverConfig(t, &config.Config{
+		BucketAccessClassDefaults: map[string]string{"enablewrite": "true"},
+		MaxSASLifetime:            metav1.Duration{Duration: time.Hour},
+	})
+	tests := []struct {
+		testName                 string
+		parameters               map[string]string
+		expectedValidationPeriod uint64
+		expectedWrite            bool
+		expectedErr              codes.Code
+	}{
+		{
+			testName:                 "Default validation period is capped",
+			parameters:               map[string]string{},
+			expectedValidationPeriod: 3600000,
+			expectedWrite:            true,
+		},
+		{
+			testName:                 "Shorter validation period",
+			parameters:               map[string]string{constant.ValidationPeriodField: "60000", "enablewrite": "false"},
+			expectedValidationPeriod: 60000,
+			expectedWrite:            false,
+		},
+		{
+			testName:    "Validation period above the maximum",
+			parameters:  map[string]string{constant.ValidationPeriodField: "7200000"},
+			expectedErr: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		params, err := getBucketAccessClassParameters(test.parameters)
+		if status.Code(err) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && (params.validationPeriod != test.expectedValidationPeriod || params.enableWrite != test.expectedWrite) {
+			t.Errorf("\nTestCase: %s\nExpected: %d %v\nActual: %d %v", test.testName, test.expectedValidationPeriod, test.expectedWrite, params.validationPeriod, params.enableWrite)
+		}
+	}
+}
+
+func TestStorageEndpointSuffix(t *testing.T) {
+	if getBlobDomain() != BlobPublicDomain || getBlobPrivateLinkDomain() != BlobPrivateLinkDomain ||
+		getDFSDomain() != DFSPublicDomain || getFileDomain() != FilePublicDomain {
+		t.Errorf("expected the public cloud domains by default")
+	}
+
+	setTestDriverConfig(t, &config.Config{Endpoints: config.Endpoints{StorageEndpointSuffix: "core.chinacloudapi.cn"}})
+	if url := getFileShareURL("account", "share"); url != "https://account.file.core.chinacloudapi.cn/share" {
+		t.Errorf("unexpected file share URL %s", url)
+	}
+	if url := toPrivateLinkURL("https://account.blob.core.chinacloudapi.cn/container"); url != "https://account.privatelink.blob.core.chinacloudapi.cn/container" {
+		t.Errorf("unexpected private link URL %s", url)
+	}
+	// buckets created before the suffix changed still parse
+	for _, url := range []string{"https://account.blob.core.chinacloudapi.cn/container", "https://account.blob.core.windows.net/container"} {
+		account, container, _, err := parseContainerURL(url)
+		if err != nil || account != "account" || container != "container" {
+			t.Errorf("unexpected result parsing %s: %s %s %v", url, account, container, err)
+		}
+	}
+}
@@ -15,6 +15,7 @@ package azureutils
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -34,11 +35,20 @@ const (
 	HNSEnabledField            = "hnsenabled"
 	EnableNFSV3Field           = "enablenfsv3"
 	EnableLargeFileSharesField = "enablelargefileshares"
+	KeyVaultURIField           = "keyvaulturi"
+	KeyNameField               = "keyname"
+	KeyVersionField            = "keyversion"
+
+	NetworkDefaultActionField    = "networkdefaultaction"
+	AllowedIPRangesField         = "allowedipranges"
+	NetworkBypassField           = "networkbypass"
+	PrivateDNSZoneIDField        = "privatednszoneid"
+	PrivateEndpointSubnetIDField = "privateendpointsubnetid"
 )
 
 // ConvertTagsToMap convert the tags from string to map
 // the valid tags format is "key1=value1,key2=value2", which could be converted to
-// {"key1": "value1", "key2": "value2"}
+// {"key1": "value1", "key2": "value2"}. A value may contain "=", a key may not.
 func ConvertTagsToMap(tags string) (map[string]string, error) {
 	m := make(map[string]string)
 	if tags == "" {
@@ -46,21 +56,36 @@ func ConvertTagsToMap(tags string) (map[string]string, error) {
 	}
 	s := strings.Split(tags, TagsDelimiter)
 	for _, tag := range s {
-		kv := strings.Split(tag, TagKeyValueDelimiter)
-		if len(kv) != 2 {
+		k, v, found := strings.Cut(tag, TagKeyValueDelimiter)
+		if !found {
 			return nil, fmt.Errorf("Tags '%s' are invalid, the format should like: 'key1=value1,key2=value2'", tags)
 		}
-		key := strings.TrimSpace(kv[0])
+		key := strings.TrimSpace(k)
 		if key == "" {
 			return nil, fmt.Errorf("Tags '%s' are invalid, the format should like: 'key1=value1,key2=value2'", tags)
 		}
-		value := strings.TrimSpace(kv[1])
+		value := strings.TrimSpace(v)
 		m[key] = value
 	}
 
 	return m, nil
 }
 
+// ConvertMapToTags converts tags to the format read by ConvertTagsToMap, sorted by key.
+// Keys must not contain "=" or ",", and values must not contain ",".
+func ConvertMapToTags(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := make([]string, 0, len(keys))
+	for _, k := range keys {
+		tags = append(tags, k+TagKeyValueDelimiter+m[k])
+	}
+	return strings.Join(tags, TagsDelimiter)
+}
+
 func ConvertMapToMapPointer(origin map[string]string) map[string]*string {
 	newly := make(map[string]*string)
 	for k, v := range origin {
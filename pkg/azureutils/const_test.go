@@ -5,6 +5,7 @@ import (
 	"github.com/Azure/azure-cosi-driver/pkg/constant"
 	"github.com/Azure/azure-cosi-driver/pkg/types"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Azure/go-autorest/autorest/to"
@@ -44,9 +45,15 @@ func TestConvertTagsToMap(t *testing.T) {
 			expectedOut: map[string]string{"key1": "value1", "key2": "value2"},
 		},
 		{
-			testName:    "too many values in key",
+			testName:    "= inside value",
 			input:       "key1=value1=value3,key2=value2",
-			expectedErr: fmt.Errorf("Tags '%s' are invalid, the format should like: 'key1=value1,key2=value2'", "key1=value1=value3,key2=value2"),
+			expectedErr: nil,
+			expectedOut: map[string]string{"key1": "value1=value3", "key2": "value2"},
+		},
+		{
+			testName:    "empty key",
+			input:       " =value1",
+			expectedErr: fmt.Errorf("Tags '%s' are invalid, the format should like: 'key1=value1,key2=value2'", " =value1"),
 			expectedOut: map[string]string{},
 		},
 		{
@@ -85,3 +92,49 @@ func TestConvertMapToMapPointer(t *testing.T) {
 		}
 	}
 }
+
+func FuzzConvertTagsToMap(f *testing.F) {
+	for _, seed := range []string{"", "key1=value1,key2=value2", "key1=value1=value3", "value1,key2=value2", " key = value ", "=value", "key=", ",", "key=value,"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, tags string) {
+		m, err := ConvertTagsToMap(tags)
+		if err != nil {
+			return
+		}
+		for k := range m {
+			if k == "" || strings.Contains(k, TagKeyValueDelimiter) {
+				t.Errorf("invalid key %q parsed from %q", k, tags)
+			}
+		}
+		// tags string -> map -> tags string -> map must be stable
+		again, err := ConvertTagsToMap(ConvertMapToTags(m))
+		if err != nil {
+			t.Fatalf("could not parse %q, formatted from %q: %v", ConvertMapToTags(m), tags, err)
+		}
+		if !reflect.DeepEqual(again, m) {
+			t.Errorf("round trip of %q: expected %v, got %v", tags, m, again)
+		}
+	})
+}
+
+func FuzzConvertMapToTags(f *testing.F) {
+	f.Add("key", "value")
+	f.Add("owner", "a=b")
+	f.Add("k", "")
+	f.Fuzz(func(t *testing.T, key, value string) {
+		if key == "" || key != strings.TrimSpace(key) || value != strings.TrimSpace(value) ||
+			strings.ContainsAny(key, TagKeyValueDelimiter+TagsDelimiter) || strings.Contains(value, TagsDelimiter) {
+			t.Skip("not representable as tags")
+		}
+		m := map[string]string{key: value, "other": "value"}
+		tags := ConvertMapToTags(m)
+		out, err := ConvertTagsToMap(tags)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", tags, err)
+		}
+		if !reflect.DeepEqual(out, m) {
+			t.Errorf("round trip of %v through %q: got %v", m, tags, out)
+		}
+	})
+}
@@ -17,16 +17,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
 	"github.com/Azure/azure-cosi-driver/pkg/types"
 	"regexp"
+	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
 )
 
 const (
@@ -34,7 +39,8 @@ const (
 )
 
 var (
-	storageAccountRE = regexp.MustCompile(`https://(.+).blob.core.windows.net/([^/]*)/?(.*)`)
+	// storageAccountRE matches https://<account>.[privatelink.]<service>.<suffix>/[<container>[/<blob>]]
+	storageAccountRE = regexp.MustCompile(`(?s)^https://([^/.]+)\.(?:privatelink\.)?(?:blob|dfs|file)\.[^/]+/([^/]*)/?(.*)$`)
 )
 
 func createContainerBucket(
@@ -42,26 +48,58 @@ func createContainerBucket(
 	bucketName string,
 	parameters *BucketClassParameters,
 	cloud *azure.Cloud) (string, error) {
+	subsID := cloud.SubscriptionID
+	if parameters.subscriptionID != "" {
+		subsID = parameters.subscriptionID
+	}
+	if parameters.storageAccountPool != "" {
+		defer lockPool(fmt.Sprintf("%s/%s/%s", subsID, parameters.resourceGroup, parameters.storageAccountPool))()
+		name, err := selectPoolAccount(ctx, subsID, bucketName, parameters, cloud)
+		if err != nil {
+			return "", err
+		}
+		parameters.storageAccountName = name
+	}
 	accOptions := getAccountOptions(parameters)
-	_, key, err := cloud.EnsureStorageAccount(ctx, accOptions, "")
+
+	var key string
+	err := withRetry(ctx, subsID, "EnsureStorageAccount", func() (err error) {
+		_, key, err = cloud.EnsureStorageAccount(ctx, accOptions, "")
+		return err
+	})
 	if err != nil {
-		return "", status.Error(codes.Internal, fmt.Sprintf("Could not ensure storage account %s exists: %v", accOptions.Name, err))
+		return "", newAzureError(err, "Could not ensure storage account %s exists: %v", accOptions.Name, err)
+	}
+	if err := ensureAccountNetworking(ctx, subsID, parameters.resourceGroup, parameters.storageAccountName, parameters, cloud); err != nil {
+		return "", newAzureError(err, "Could not configure network access of storage account %s: %v", parameters.storageAccountName, err)
 	}
 	containerParams := make(map[string]string) //NOTE: Container parameters still need to be filled/implemented
 
+	corsRule := getCORSRule(parameters)
+	corsRuleKey := ""
+	if corsRule != nil {
+		corsRuleKey = getCORSRuleKey(corsRule)
+		containerParams[CORSRuleMetadataKey] = corsRuleKey
+	}
+
 	container, err := createAzureContainer(ctx, parameters.storageAccountName, key, bucketName, containerParams)
 	if err != nil {
 		return "", err
 	}
+	// the rule is added once the container exists, so a failed create leaves the account untouched,
+	// a failure here is retried with the next CreateBucket since the container already exists
+	if corsRule != nil {
+		if err := addCORSRuleToAccount(ctx, subsID, parameters.resourceGroup, parameters.storageAccountName, key, corsRule, cloud); err != nil {
+			return "", err
+		}
+	}
 
 	id := types.BucketID{
+		SubID:         subsID,
 		ResourceGroup: parameters.resourceGroup,
 		URL:           container,
-	}
-	if parameters.subscriptionID != "" {
-		id.SubID = parameters.subscriptionID
-	} else {
-		id.SubID = cloud.SubscriptionID
+		CORSRule:      corsRuleKey,
+		PrivateLink:   usesBlobPrivateEndpoint(parameters),
 	}
 	base64ID, err := id.Encode()
 	if err != nil {
@@ -78,7 +116,7 @@ func DeleteContainerBucket(
 	// Get storage account name from bucket url
 	storageAccountName := getStorageAccountNameFromContainerURL(bucketID.URL)
 	// Get access keys for the storage account
-	accessKey, err := cloud.GetStorageAccesskey(ctx, bucketID.SubID, storageAccountName, bucketID.ResourceGroup)
+	accessKey, err := getStorageAccountKey(ctx, bucketID.SubID, storageAccountName, bucketID.ResourceGroup, cloud)
 	if err != nil {
 		return err
 	}
@@ -86,7 +124,13 @@ func DeleteContainerBucket(
 	containerName := getContainerNameFromContainerURL(bucketID.URL)
 	err = deleteAzureContainer(ctx, storageAccountName, accessKey, containerName)
 	if err != nil {
-		return fmt.Errorf("Error deleting container %s in storage account %s : %v", containerName, storageAccountName, err)
+		return newAzureError(err, "Error deleting container %s in storage account %s : %v", containerName, storageAccountName, err)
+	}
+
+	if bucketID.CORSRule != "" {
+		if err := removeCORSRuleFromAccount(ctx, bucketID.SubID, bucketID.ResourceGroup, storageAccountName, accessKey, bucketID.CORSRule, cloud); err != nil {
+			return err
+		}
 	}
 
 	// Now, we check and delete the storage account if its empty
@@ -123,6 +167,10 @@ func deleteAzureContainer(
 	}
 
 	_, err = containerClient.Delete(ctx, nil)
+	if bloberror.HasCode(err, bloberror.ContainerNotFound) {
+		// the container was deleted by an earlier call
+		return nil
+	}
 	return err
 }
 
@@ -136,9 +184,9 @@ func createContainerClient(
 		return nil, fmt.Errorf("Invalid credentials with error : %v", err)
 	}
 
-	containerURL := fmt.Sprintf("https://%s.blob.core.windows.net/%s", storageAccount, containerName)
+	containerURL := fmt.Sprintf("https://%s.%s/%s", storageAccount, getBlobDomain(), containerName)
 
-	containerClient, err := container.NewClientWithSharedKeyCredential(containerURL, credential, nil)
+	containerClient, err := container.NewClientWithSharedKeyCredential(containerURL, credential, &container.ClientOptions{ClientOptions: getClientOptions()})
 
 	return containerClient, err
 }
@@ -161,6 +209,11 @@ func parseContainerURL(containerURL string) (string, string, string, error) {
 	if len(matches) > 3 {
 		blobName = matches[3]
 	}
+	if containerName == "" && blobName != "" {
+		errStr := fmt.Sprintf("Invalid URL has been passed: %s, blob %s has no container", containerURL, blobName)
+		klog.Errorf("Error in parseContainerURL :: %s", errStr)
+		return "", "", "", errors.New(errStr)
+	}
 
 	return storageAccount, containerName, blobName, nil
 }
@@ -186,10 +239,10 @@ func createAzureContainer(
 		Access:   nil,
 	})
 	if err != nil {
-		if err.Error() == "ResourceExistsError" {
+		if bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
 			return containerClient.URL(), nil
 		}
-		return "", fmt.Errorf("Error creating container from containterURL : %s, Error : %v", containerClient.URL(), err)
+		return "", newAzureError(err, "Error creating container from containterURL : %s, Error : %v", containerClient.URL(), err)
 	}
 
 	return containerClient.URL(), nil
@@ -237,7 +290,42 @@ func createContainerSASURL(ctx context.Context, bucketID string, parameters *Buc
 	}
 
 	queryParams := sasQueryParams.Encode()
-	accountID := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	accountID := fmt.Sprintf("https://%s.%s/", account, getBlobDomain())
 	sasURL := fmt.Sprintf("%s?%s", accountID, queryParams)
 	return sasURL, accountID, nil
 }
+
+// parsePathPrefix normalises the pathprefix of a BucketAccessClass to a relative path
+func parsePathPrefix(value string) (string, error) {
+	prefix := strings.Trim(value, "/")
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid %s %s, must be a relative path without empty, . or .. segments", constant.PathPrefixField, value))
+		}
+	}
+	return prefix, nil
+}
+
+// createContainerPrefixSASURL signs a directory SAS for the pathprefix within a container.
+// Blob SAS can only be scoped below a container on hierarchical namespace accounts,
+// stored access policies scope permissions and lifetime but not paths, so flat namespace accounts are rejected.
+func createContainerPrefixSASURL(ctx context.Context, id *types.BucketID, parameters *BucketAccessClassParameters, accountKey string, cloud *azure.Cloud) (string, string, error) {
+	account, containerName, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return "", "", err
+	}
+
+	var props storage.Account
+	rerr := withRetryError(ctx, id.SubID, "GetStorageAccountProperties", func() (rerr *retry.Error) {
+		props, rerr = cloud.StorageAccountClient.GetProperties(ctx, id.SubID, id.ResourceGroup, account)
+		return rerr
+	})
+	if rerr != nil {
+		return "", "", status.Error(retryErrorCode(rerr), fmt.Sprintf("Could not get properties of storage account %s: %v", account, rerr.Error()))
+	}
+	if props.AccountProperties == nil || props.AccountProperties.IsHnsEnabled == nil || !*props.AccountProperties.IsHnsEnabled {
+		return "", "", status.Error(codes.InvalidArgument, fmt.Sprintf("%s requires storage account %s to have hierarchical namespace enabled", constant.PathPrefixField, account))
+	}
+
+	return createDirectorySASURL(ctx, account, containerName, parameters.pathPrefix, getBlobDomain(), parameters, accountKey)
+}
@@ -7,7 +7,10 @@ import (
 	"github.com/Azure/azure-cosi-driver/pkg/constant"
 	"github.com/Azure/azure-cosi-driver/pkg/types"
 	"net/http"
+	"net/url"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
@@ -15,6 +18,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
 )
@@ -75,7 +79,7 @@ func TestDeleteContainerBucket(t *testing.T) {
 				URL:           constant.ValidContainerURL,
 			},
 			clientNil:   false,
-			expectedErr: fmt.Errorf("Error deleting container %s in storage account %s : %v", constant.ValidContainer, constant.ValidAccount, fmt.Errorf("Invalid credentials with error : decode account key: illegal base64 data at input byte 0")),
+			expectedErr: status.Error(codes.Internal, fmt.Sprintf("Error deleting container %s in storage account %s : %v", constant.ValidContainer, constant.ValidAccount, fmt.Errorf("Invalid credentials with error : decode account key: illegal base64 data at input byte 0"))),
 		},
 	}
 
@@ -131,6 +135,55 @@ func TestParseContainerURL(t *testing.T) {
 	}
 }
 
+func FuzzParseContainerURL(f *testing.F) {
+	for _, seed := range []string{
+		constant.ValidBlobURL,
+		constant.ValidContainerURL,
+		constant.ValidAccountURL,
+		"https://validaccount.dfs.core.windows.net/filesystem/dir/sub",
+		"https://validaccount.privatelink.blob.core.windows.net/validcontainer",
+		"https://validaccount.blob.core.windows.net//blob",
+		"https://a/b.blob.core.windows.net/container",
+		"xhttps://validaccount.blob.core.windows.net/container",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, containerURL string) {
+		account, container, blob, err := parseContainerURL(containerURL)
+		if err != nil {
+			return
+		}
+		if account == "" || strings.ContainsAny(account, "/.") {
+			t.Errorf("invalid account %q parsed from %q", account, containerURL)
+		}
+		if strings.Contains(container, "/") {
+			t.Errorf("invalid container %q parsed from %q", container, containerURL)
+		}
+		if container == "" && blob != "" {
+			t.Errorf("blob %q without a container parsed from %q", blob, containerURL)
+		}
+	})
+}
+
+func FuzzContainerURLRoundTrip(f *testing.F) {
+	f.Add(constant.ValidAccount, constant.ValidContainer, constant.ValidBlob)
+	f.Add(constant.ValidAccount, constant.ValidContainer, "dir/blob.txt")
+	f.Add(constant.ValidAccount, constant.ValidContainer, "")
+	f.Fuzz(func(t *testing.T, account, container, blob string) {
+		if !regexp.MustCompile(`^[a-z0-9]{3,24}$`).MatchString(account) || container == "" || strings.Contains(container, "/") {
+			t.Skip("not a storage account and container name")
+		}
+		containerURL := fmt.Sprintf("https://%s.%s/%s/%s", account, getBlobDomain(), container, blob)
+		a, c, b, err := parseContainerURL(containerURL)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", containerURL, err)
+		}
+		if a != account || c != container || b != blob {
+			t.Errorf("parsed %q as (%q, %q, %q)", containerURL, a, c, b)
+		}
+	})
+}
+
 func TestGetStorageAccountNameFromContainerURL(t *testing.T) {
 	tests := []struct {
 		testName              string
@@ -329,3 +382,86 @@ func TestCreateContainerSASURL(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePathPrefix(t *testing.T) {
+	tests := []struct {
+		testName       string
+		value          string
+		expectedPrefix string
+		expectedErr    error
+	}{
+		{
+			testName:       "Single segment",
+			value:          "app1",
+			expectedPrefix: "app1",
+		},
+		{
+			testName:       "Leading and trailing slashes",
+			value:          "/app1/logs/",
+			expectedPrefix: "app1/logs",
+		},
+		{
+			testName:    "Parent segment",
+			value:       "app1/../app2",
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid %s %s, must be a relative path without empty, . or .. segments", constant.PathPrefixField, "app1/../app2")),
+		},
+		{
+			testName:    "Empty segment",
+			value:       "app1//logs",
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid %s %s, must be a relative path without empty, . or .. segments", constant.PathPrefixField, "app1//logs")),
+		},
+	}
+	for _, test := range tests {
+		prefix, err := parsePathPrefix(test.value)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if prefix != test.expectedPrefix {
+			t.Errorf("\nTestCase: %s\nExpected Prefix: %v\nActual Prefix: %v", test.testName, test.expectedPrefix, prefix)
+		}
+	}
+}
+
+func TestCreateContainerPrefixSASURL(t *testing.T) {
+	tests := []struct {
+		testName    string
+		hnsEnabled  bool
+		expectedErr error
+	}{
+		{
+			testName:   "Hierarchical namespace",
+			hnsEnabled: true,
+		},
+		{
+			testName:    "Flat namespace",
+			hnsEnabled:  false,
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s requires storage account %s to have hierarchical namespace enabled", constant.PathPrefixField, constant.ValidAccount)),
+		},
+	}
+	id := &types.BucketID{SubID: constant.ValidSub, ResourceGroup: constant.ValidResourceGroup, URL: constant.ValidContainerURL}
+	params := &BucketAccessClassParameters{enableRead: true, enableList: true, validationPeriod: 1000, pathPrefix: "app1/logs"}
+	key := base64.StdEncoding.EncodeToString([]byte{1, 2, 3, 4})
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		cloud := azure.GetTestCloud(ctrl)
+		saClient := mockstorageaccountclient.NewMockInterface(ctrl)
+		saClient.EXPECT().
+			GetProperties(gomock.Any(), constant.ValidSub, constant.ValidResourceGroup, constant.ValidAccount).
+			Return(storage.Account{AccountProperties: &storage.AccountProperties{IsHnsEnabled: to.BoolPtr(test.hnsEnabled)}}, nil).
+			Times(1)
+		cloud.StorageAccountClient = saClient
+
+		sasURL, _, err := createContainerPrefixSASURL(context.Background(), id, params, key, cloud)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil {
+			u, _ := url.Parse(sasURL)
+			if u.Path != "/"+constant.ValidContainer+"/app1/logs" || u.Query().Get("sr") != "d" || u.Query().Get("sdd") != "2" {
+				t.Errorf("\nTestCase: %s\nunexpected SAS URL: %s", test.testName, sasURL)
+			}
+		}
+		ctrl.Finish()
+	}
+}
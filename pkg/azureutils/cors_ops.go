@@ -0,0 +1,328 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	// CORSRuleMetadataKey is the container metadata key recording which CORS rule a container bucket added
+	CORSRuleMetadataKey = "cosicorsrule"
+	// CORSRuleTagPrefix is followed by the key of a CORS rule in the storage account tag marking the
+	// rule as added by the driver, rules without the tag were already there and are never removed
+	CORSRuleTagPrefix = "cosi-cors-rule-"
+	// DefaultCORSMaxAgeInSeconds is used when corsmaxageinseconds is not set
+	DefaultCORSMaxAgeInSeconds = 3600
+	// MaxCORSRules is the maximum number of CORS rules allowed on a blob service
+	MaxCORSRules = 5
+)
+
+var (
+	corsAllowedMethods = map[string]bool{
+		"DELETE":  true,
+		"GET":     true,
+		"HEAD":    true,
+		"MERGE":   true,
+		"OPTIONS": true,
+		"PATCH":   true,
+		"POST":    true,
+		"PUT":     true,
+	}
+
+	// corsLock serialises the read-modify-write of blob service properties,
+	// since several buckets may share a storage account.
+	corsLock sync.Mutex
+)
+
+// splitCORSList splits a comma separated BucketClass value, dropping empty entries
+func splitCORSList(value string) []string {
+	list := []string{}
+	for _, v := range strings.Split(value, TagsDelimiter) {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func hasCORSParameters(params *BucketClassParameters) bool {
+	return len(params.corsAllowedOrigins) > 0 ||
+		len(params.corsAllowedMethods) > 0 ||
+		len(params.corsAllowedHeaders) > 0 ||
+		len(params.corsExposedHeaders) > 0 ||
+		params.corsMaxAgeInSeconds != nil
+}
+
+func validateCORSParameters(params *BucketClassParameters) error {
+	if !hasCORSParameters(params) {
+		return nil
+	}
+	if len(params.corsAllowedOrigins) == 0 {
+		return status.Error(codes.InvalidArgument, "corsallowedorigins is required when CORS parameters are set")
+	}
+	if len(params.corsAllowedMethods) == 0 {
+		return status.Error(codes.InvalidArgument, "corsallowedmethods is required when CORS parameters are set")
+	}
+	for _, method := range params.corsAllowedMethods {
+		if !corsAllowedMethods[strings.ToUpper(method)] {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("CORS method %s is unsupported", method))
+		}
+	}
+	return nil
+}
+
+// getCORSRule builds the blob service CORS rule requested by the BucketClass, or nil if none was requested
+func getCORSRule(params *BucketClassParameters) *service.CorsRule {
+	if !hasCORSParameters(params) {
+		return nil
+	}
+
+	methods := make([]string, 0, len(params.corsAllowedMethods))
+	for _, method := range params.corsAllowedMethods {
+		methods = append(methods, strings.ToUpper(method))
+	}
+	allowedHeaders := params.corsAllowedHeaders
+	if len(allowedHeaders) == 0 {
+		allowedHeaders = []string{"*"}
+	}
+	exposedHeaders := params.corsExposedHeaders
+	if len(exposedHeaders) == 0 {
+		exposedHeaders = []string{"*"}
+	}
+	maxAge := int32(DefaultCORSMaxAgeInSeconds)
+	if params.corsMaxAgeInSeconds != nil {
+		maxAge = *params.corsMaxAgeInSeconds
+	}
+
+	return &service.CorsRule{
+		AllowedOrigins:  to.StringPtr(strings.Join(params.corsAllowedOrigins, TagsDelimiter)),
+		AllowedMethods:  to.StringPtr(strings.Join(methods, TagsDelimiter)),
+		AllowedHeaders:  to.StringPtr(strings.Join(allowedHeaders, TagsDelimiter)),
+		ExposedHeaders:  to.StringPtr(strings.Join(exposedHeaders, TagsDelimiter)),
+		MaxAgeInSeconds: to.Int32Ptr(maxAge),
+	}
+}
+
+// getCORSRuleKey returns a stable identifier for a CORS rule, independent of list ordering and spacing
+func getCORSRuleKey(rule *service.CorsRule) string {
+	normalize := func(value *string, upper bool) string {
+		list := splitCORSList(to.String(value))
+		if upper {
+			for i := range list {
+				list[i] = strings.ToUpper(list[i])
+			}
+		}
+		sort.Strings(list)
+		return strings.Join(list, TagsDelimiter)
+	}
+
+	data := strings.Join([]string{
+		normalize(rule.AllowedOrigins, false),
+		normalize(rule.AllowedMethods, true),
+		normalize(rule.AllowedHeaders, false),
+		normalize(rule.ExposedHeaders, false),
+		fmt.Sprintf("%d", to.Int32(rule.MaxAgeInSeconds)),
+	}, "|")
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:8])
+}
+
+// mergeCORSRule adds rule to rules unless an equivalent rule is already present.
+// The boolean result reports whether rules were changed.
+func mergeCORSRule(rules []*service.CorsRule, rule *service.CorsRule) ([]*service.CorsRule, bool, error) {
+	key := getCORSRuleKey(rule)
+	for _, r := range rules {
+		if getCORSRuleKey(r) == key {
+			return rules, false, nil
+		}
+	}
+	if len(rules) >= MaxCORSRules {
+		return rules, false, status.Error(codes.ResourceExhausted, fmt.Sprintf("blob service already has the maximum of %d CORS rules", MaxCORSRules))
+	}
+	return append(rules, rule), true, nil
+}
+
+// removeCORSRule drops every rule matching key from rules.
+// The boolean result reports whether rules were changed.
+func removeCORSRule(rules []*service.CorsRule, key string) ([]*service.CorsRule, bool) {
+	remaining := make([]*service.CorsRule, 0, len(rules))
+	for _, r := range rules {
+		if getCORSRuleKey(r) != key {
+			remaining = append(remaining, r)
+		}
+	}
+	return remaining, len(remaining) != len(rules)
+}
+
+func createServiceClient(storageAccount, accessKey string) (*service.Client, error) {
+	credential, err := service.NewSharedKeyCredential(storageAccount, accessKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid credentials with error : %v", err)
+	}
+
+	serviceURL := fmt.Sprintf("https://%s.%s/", storageAccount, getBlobDomain())
+	return service.NewClientWithSharedKeyCredential(serviceURL, credential, &service.ClientOptions{ClientOptions: getClientOptions()})
+}
+
+// isDriverCORSRule reports whether the storage account is tagged as having the CORS rule identified by key added by the driver
+func isDriverCORSRule(ctx context.Context, subsID, resourceGroup, storageAccount, key string, cloud *azure.Cloud) (bool, error) {
+	var account storage.Account
+	rerr := withRetryError(ctx, subsID, "GetStorageAccountProperties", func() (rerr *retry.Error) {
+		account, rerr = cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, storageAccount)
+		return rerr
+	})
+	if rerr != nil {
+		return false, newAzureError(rerr.Error(), "Could not get storage account %s: %v", storageAccount, rerr.Error())
+	}
+	_, ok := account.Tags[CORSRuleTagPrefix+key]
+	return ok, nil
+}
+
+// addCORSRuleToAccount merges rule into the blob service CORS rules of the storage account,
+// keeping any rules added by other buckets or by hand. A rule the driver adds is tagged on the
+// storage account so only driver added rules are removed later.
+func addCORSRuleToAccount(
+	ctx context.Context,
+	subsID,
+	resourceGroup,
+	storageAccount,
+	accessKey string,
+	rule *service.CorsRule,
+	cloud *azure.Cloud) error {
+	serviceClient, err := createServiceClient(storageAccount, accessKey)
+	if err != nil {
+		return err
+	}
+
+	if resourceGroup == "" {
+		resourceGroup = cloud.ResourceGroup
+	}
+
+	corsLock.Lock()
+	defer corsLock.Unlock()
+
+	props, err := serviceClient.GetProperties(ctx, nil)
+	if err != nil {
+		return newAzureError(err, "Error getting blob service properties of storage account %s : %v", storageAccount, err)
+	}
+
+	rules, changed, err := mergeCORSRule(props.Cors, rule)
+	if err != nil || !changed {
+		return err
+	}
+
+	key := getCORSRuleKey(rule)
+	// the tag goes first, a rule left untagged by a failure would be kept forever
+	rerr := withRetryError(ctx, subsID, "AddStorageAccountTags", func() *retry.Error {
+		return cloud.AddStorageAccountTags(ctx, subsID, resourceGroup, storageAccount, map[string]*string{CORSRuleTagPrefix + key: to.StringPtr("true")})
+	})
+	if rerr != nil {
+		return newAzureError(rerr.Error(), "Could not tag storage account %s: %v", storageAccount, rerr.Error())
+	}
+
+	klog.Infof("Adding CORS rule %s to storage account %s", key, storageAccount)
+	_, err = serviceClient.SetProperties(ctx, &service.SetPropertiesOptions{Cors: rules})
+	if err != nil {
+		return newAzureError(err, "Error setting CORS rules of storage account %s : %v", storageAccount, err)
+	}
+	return nil
+}
+
+// removeCORSRuleFromAccount removes the CORS rule identified by key from the storage account if the
+// driver added it, unless another container in the account was created with the same rule.
+func removeCORSRuleFromAccount(
+	ctx context.Context,
+	subsID,
+	resourceGroup,
+	storageAccount,
+	accessKey,
+	key string,
+	cloud *azure.Cloud) error {
+	serviceClient, err := createServiceClient(storageAccount, accessKey)
+	if err != nil {
+		return err
+	}
+
+	if resourceGroup == "" {
+		resourceGroup = cloud.ResourceGroup
+	}
+
+	corsLock.Lock()
+	defer corsLock.Unlock()
+
+	owned, err := isDriverCORSRule(ctx, subsID, resourceGroup, storageAccount, key, cloud)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		klog.Infof("CORS rule %s of storage account %s was not added by the driver, keeping it", key, storageAccount)
+		return nil
+	}
+
+	pager := serviceClient.NewListContainersPager(&service.ListContainersOptions{
+		Include: service.ListContainersInclude{Metadata: true},
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return newAzureError(err, "Error listing containers of storage account %s : %v", storageAccount, err)
+		}
+		for _, item := range page.ContainerItems {
+			for k, v := range item.Metadata {
+				if strings.EqualFold(k, CORSRuleMetadataKey) && to.String(v) == key {
+					klog.Infof("CORS rule %s is still used by container %s, keeping it", key, to.String(item.Name))
+					return nil
+				}
+			}
+		}
+	}
+
+	props, err := serviceClient.GetProperties(ctx, nil)
+	if err != nil {
+		return newAzureError(err, "Error getting blob service properties of storage account %s : %v", storageAccount, err)
+	}
+
+	if rules, changed := removeCORSRule(props.Cors, key); changed {
+		klog.Infof("Removing CORS rule %s from storage account %s", key, storageAccount)
+		_, err = serviceClient.SetProperties(ctx, &service.SetPropertiesOptions{Cors: rules})
+		if err != nil {
+			return newAzureError(err, "Error setting CORS rules of storage account %s : %v", storageAccount, err)
+		}
+	}
+
+	rerr := withRetryError(ctx, subsID, "RemoveStorageAccountTag", func() *retry.Error {
+		return cloud.RemoveStorageAccountTag(ctx, subsID, resourceGroup, storageAccount, CORSRuleTagPrefix+key)
+	})
+	if rerr != nil {
+		return newAzureError(rerr.Error(), "Could not untag storage account %s: %v", storageAccount, rerr.Error())
+	}
+	return nil
+}
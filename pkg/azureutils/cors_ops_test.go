@@ -0,0 +1,306 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/Azure/go-autorest/autorest/to"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestCORSRule(origins, methods string) *service.CorsRule {
+	return &service.CorsRule{
+		AllowedOrigins:  to.StringPtr(origins),
+		AllowedMethods:  to.StringPtr(methods),
+		AllowedHeaders:  to.StringPtr("*"),
+		ExposedHeaders:  to.StringPtr("*"),
+		MaxAgeInSeconds: to.Int32Ptr(DefaultCORSMaxAgeInSeconds),
+	}
+}
+
+func TestValidateCORSParameters(t *testing.T) {
+	tests := []struct {
+		testName    string
+		params      *BucketClassParameters
+		expectedErr error
+	}{
+		{
+			testName:    "No CORS parameters",
+			params:      &BucketClassParameters{},
+			expectedErr: nil,
+		},
+		{
+			testName:    "Missing origins",
+			params:      &BucketClassParameters{corsAllowedMethods: []string{"GET"}},
+			expectedErr: status.Error(codes.InvalidArgument, "corsallowedorigins is required when CORS parameters are set"),
+		},
+		{
+			testName:    "Missing methods",
+			params:      &BucketClassParameters{corsAllowedOrigins: []string{"https://contoso.com"}},
+			expectedErr: status.Error(codes.InvalidArgument, "corsallowedmethods is required when CORS parameters are set"),
+		},
+		{
+			testName: "Unsupported method",
+			params: &BucketClassParameters{
+				corsAllowedOrigins: []string{"https://contoso.com"},
+				corsAllowedMethods: []string{"GET", "CONNECT"},
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("CORS method %s is unsupported", "CONNECT")),
+		},
+		{
+			testName: "Valid parameters",
+			params: &BucketClassParameters{
+				corsAllowedOrigins: []string{"https://contoso.com"},
+				corsAllowedMethods: []string{"get", "put"},
+			},
+			expectedErr: nil,
+		},
+	}
+	for _, test := range tests {
+		err := validateCORSParameters(test.params)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+	}
+}
+
+func TestGetCORSRule(t *testing.T) {
+	tests := []struct {
+		testName     string
+		params       *BucketClassParameters
+		expectedRule *service.CorsRule
+	}{
+		{
+			testName:     "No CORS parameters",
+			params:       &BucketClassParameters{},
+			expectedRule: nil,
+		},
+		{
+			testName: "Defaults",
+			params: &BucketClassParameters{
+				corsAllowedOrigins: []string{"https://contoso.com"},
+				corsAllowedMethods: []string{"get", "put"},
+			},
+			expectedRule: newTestCORSRule("https://contoso.com", "GET,PUT"),
+		},
+		{
+			testName: "All fields",
+			params: &BucketClassParameters{
+				corsAllowedOrigins:  []string{"https://contoso.com", "https://fabrikam.com"},
+				corsAllowedMethods:  []string{"GET"},
+				corsAllowedHeaders:  []string{"x-ms-meta-*"},
+				corsExposedHeaders:  []string{"x-ms-request-id"},
+				corsMaxAgeInSeconds: to.Int32Ptr(60),
+			},
+			expectedRule: &service.CorsRule{
+				AllowedOrigins:  to.StringPtr("https://contoso.com,https://fabrikam.com"),
+				AllowedMethods:  to.StringPtr("GET"),
+				AllowedHeaders:  to.StringPtr("x-ms-meta-*"),
+				ExposedHeaders:  to.StringPtr("x-ms-request-id"),
+				MaxAgeInSeconds: to.Int32Ptr(60),
+			},
+		},
+	}
+	for _, test := range tests {
+		rule := getCORSRule(test.params)
+		if !reflect.DeepEqual(rule, test.expectedRule) {
+			t.Errorf("\nTestCase: %s\nExpected Rule: %+v\nActual Rule: %+v", test.testName, test.expectedRule, rule)
+		}
+	}
+}
+
+func TestGetCORSRuleKey(t *testing.T) {
+	a := newTestCORSRule("https://contoso.com,https://fabrikam.com", "GET,PUT")
+	b := newTestCORSRule("https://fabrikam.com, https://contoso.com", "put,get")
+	c := newTestCORSRule("https://contoso.com", "GET,PUT")
+
+	if getCORSRuleKey(a) != getCORSRuleKey(b) {
+		t.Errorf("equivalent rules have different keys: %s, %s", getCORSRuleKey(a), getCORSRuleKey(b))
+	}
+	if getCORSRuleKey(a) == getCORSRuleKey(c) {
+		t.Errorf("different rules have the same key: %s", getCORSRuleKey(a))
+	}
+}
+
+func TestMergeCORSRule(t *testing.T) {
+	existing := newTestCORSRule("https://contoso.com", "GET")
+	rule := newTestCORSRule("https://fabrikam.com", "GET,PUT")
+	full := []*service.CorsRule{}
+	for i := 0; i < MaxCORSRules; i++ {
+		full = append(full, newTestCORSRule(fmt.Sprintf("https://%d.contoso.com", i), "GET"))
+	}
+
+	tests := []struct {
+		testName        string
+		rules           []*service.CorsRule
+		expectedRules   []*service.CorsRule
+		expectedChanged bool
+		expectedErr     error
+	}{
+		{
+			testName:        "No existing rules",
+			rules:           nil,
+			expectedRules:   []*service.CorsRule{rule},
+			expectedChanged: true,
+		},
+		{
+			testName:        "Existing rules are kept",
+			rules:           []*service.CorsRule{existing},
+			expectedRules:   []*service.CorsRule{existing, rule},
+			expectedChanged: true,
+		},
+		{
+			testName:        "Rule already present",
+			rules:           []*service.CorsRule{existing, newTestCORSRule("https://fabrikam.com", "PUT,GET")},
+			expectedRules:   []*service.CorsRule{existing, newTestCORSRule("https://fabrikam.com", "PUT,GET")},
+			expectedChanged: false,
+		},
+		{
+			testName:        "Too many rules",
+			rules:           full,
+			expectedRules:   full,
+			expectedChanged: false,
+			expectedErr:     status.Error(codes.ResourceExhausted, fmt.Sprintf("blob service already has the maximum of %d CORS rules", MaxCORSRules)),
+		},
+	}
+	for _, test := range tests {
+		rules, changed, err := mergeCORSRule(test.rules, rule)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if changed != test.expectedChanged {
+			t.Errorf("\nTestCase: %s\nExpected Changed: %v\nActual Changed: %v", test.testName, test.expectedChanged, changed)
+		}
+		if !reflect.DeepEqual(rules, test.expectedRules) {
+			t.Errorf("\nTestCase: %s\nExpected Rules: %+v\nActual Rules: %+v", test.testName, test.expectedRules, rules)
+		}
+	}
+}
+
+func TestRemoveCORSRule(t *testing.T) {
+	existing := newTestCORSRule("https://contoso.com", "GET")
+	rule := newTestCORSRule("https://fabrikam.com", "GET,PUT")
+
+	tests := []struct {
+		testName        string
+		rules           []*service.CorsRule
+		expectedRules   []*service.CorsRule
+		expectedChanged bool
+	}{
+		{
+			testName:        "Rule removed, others kept",
+			rules:           []*service.CorsRule{existing, rule},
+			expectedRules:   []*service.CorsRule{existing},
+			expectedChanged: true,
+		},
+		{
+			testName:        "Last rule removed",
+			rules:           []*service.CorsRule{rule},
+			expectedRules:   []*service.CorsRule{},
+			expectedChanged: true,
+		},
+		{
+			testName:        "Rule not present",
+			rules:           []*service.CorsRule{existing},
+			expectedRules:   []*service.CorsRule{existing},
+			expectedChanged: false,
+		},
+	}
+	for _, test := range tests {
+		rules, changed := removeCORSRule(test.rules, getCORSRuleKey(rule))
+		if changed != test.expectedChanged {
+			t.Errorf("\nTestCase: %s\nExpected Changed: %v\nActual Changed: %v", test.testName, test.expectedChanged, changed)
+		}
+		if !reflect.DeepEqual(rules, test.expectedRules) {
+			t.Errorf("\nTestCase: %s\nExpected Rules: %+v\nActual Rules: %+v", test.testName, test.expectedRules, rules)
+		}
+	}
+}
+
+func TestContainerBucketCORSRules(t *testing.T) {
+	ctx := context.Background()
+	s := newPoolTestServer(t)
+	s.CreateAccount(fakeazure.SubscriptionID, fakeazure.ResourceGroup, "fakeaccount")
+	keys, _ := s.AccountKeys("fakeaccount")
+	serviceClient, err := createServiceClient("fakeaccount", keys[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	getRuleKeys := func() []string {
+		props, err := serviceClient.GetProperties(ctx, nil)
+		if err != nil {
+			t.Fatalf("unexpected error getting the CORS rules: %v", err)
+		}
+		ruleKeys := []string{}
+		for _, rule := range props.Cors {
+			ruleKeys = append(ruleKeys, getCORSRuleKey(rule))
+		}
+		return ruleKeys
+	}
+
+	// a rule added by hand that a bucket asks for too
+	manual := newTestCORSRule("https://contoso.com", "GET")
+	if _, err := serviceClient.SetProperties(ctx, &service.SetPropertiesOptions{Cors: []*service.CorsRule{manual}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	added := newTestCORSRule("https://fabrikam.com", "GET,PUT")
+
+	createBucket := func(name string, rule *service.CorsRule) string {
+		bucketID, err := CreateBucket(ctx, name, map[string]string{
+			constant.BucketUnitTypeField:      constant.Container.String(),
+			constant.StorageAccountNameField:  "fakeaccount",
+			constant.ResourceGroupField:       fakeazure.ResourceGroup,
+			constant.CORSAllowedOriginsField:  to.String(rule.AllowedOrigins),
+			constant.CORSAllowedMethodsField:  to.String(rule.AllowedMethods),
+			constant.CORSMaxAgeInSecondsField: fmt.Sprint(to.Int32(rule.MaxAgeInSeconds)),
+		}, s.Cloud())
+		if err != nil {
+			t.Fatalf("unexpected error creating bucket %s: %v", name, err)
+		}
+		return bucketID
+	}
+	manualBucket := createBucket("manual", manual)
+	addedBucket := createBucket("added", added)
+	if expected := []string{getCORSRuleKey(manual), getCORSRuleKey(added)}; !reflect.DeepEqual(getRuleKeys(), expected) {
+		t.Errorf("expected CORS rules %v, got %v", expected, getRuleKeys())
+	}
+	for key, expected := range map[string]bool{getCORSRuleKey(manual): false, getCORSRuleKey(added): true} {
+		if owned, err := isDriverCORSRule(ctx, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "fakeaccount", key, s.Cloud()); err != nil || owned != expected {
+			t.Errorf("expected CORS rule %s to be tagged %v, got %v: %v", key, expected, owned, err)
+		}
+	}
+
+	for _, bucketID := range []string{manualBucket, addedBucket} {
+		if err := DeleteBucket(ctx, bucketID, s.Cloud()); err != nil {
+			t.Fatalf("unexpected error deleting bucket: %v", err)
+		}
+	}
+	if expected := []string{getCORSRuleKey(manual)}; !reflect.DeepEqual(getRuleKeys(), expected) {
+		t.Errorf("expected only the rule added by hand to be kept, got %v", getRuleKeys())
+	}
+	for _, key := range []string{getCORSRuleKey(manual), getCORSRuleKey(added)} {
+		if owned, err := isDriverCORSRule(ctx, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "fakeaccount", key, s.Cloud()); err != nil || owned {
+			t.Errorf("expected CORS rule %s not to be tagged, got %v: %v", key, owned, err)
+		}
+	}
+}
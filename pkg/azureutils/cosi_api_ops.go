@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/Azure/azure-cosi-driver/pkg/types"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	"github.com/Azure/go-autorest/autorest/to"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -24,6 +26,7 @@ type BucketClassParameters struct {
 	createStorageAccount           *bool
 	subscriptionID                 string
 	storageAccountName             string
+	storageAccountNamePrefix       string
 	region                         string
 	accessTier                     constant.AccessTier
 	SKUName                        constant.SKU
@@ -45,6 +48,36 @@ type BucketClassParameters struct {
 	isHnsEnabled              bool
 	enableNfsV3               bool
 	enableLargeFileShare      bool
+	//encryption options, a key vault key makes the account use a customer managed key
+	keyVaultURI string
+	keyName     string
+	keyVersion  string
+	//network options
+	networkDefaultAction    storage.DefaultAction
+	allowedIPRanges         []string
+	networkBypass           []storage.Bypass
+	privateDNSZoneID        string
+	privateEndpointSubnetID string
+	//cors options
+	corsAllowedOrigins  []string
+	corsAllowedMethods  []string
+	corsAllowedHeaders  []string
+	corsExposedHeaders  []string
+	corsMaxAgeInSeconds *int32
+	//filesystem options
+	rootDirectory string
+	owner         string
+	group         string
+	acl           string
+	//fileshare options
+	shareQuota      int
+	shareAccessTier string
+	shareProtocol   storage.EnabledProtocols
+	//pool options, container buckets of a pool are spread over driver-tagged storage accounts
+	storageAccountPool  string
+	poolSelectionPolicy string
+	poolMaxContainers   int
+	poolMaxCapacityGiB  int
 }
 
 /*
@@ -72,15 +105,37 @@ type BucketAccessClassParameters struct {
 	allowServiceSignedResourceType   bool
 	allowContainerSignedResourceType bool
 	allowObjectSignedResourceType    bool
+	principalID                      string
+	pathPrefix                       string
 }
 
 func CreateBucket(ctx context.Context,
 	bucketName string,
 	parameters map[string]string,
 	cloud *azure.Cloud) (string, error) {
-	bucketClassParams, err := parseBucketClassParameters(parameters)
+	location := ""
+	if cloud != nil {
+		location = cloud.Location
+	}
+	bucketClassParams, err := getBucketClassParameters(parameters, location)
 	if err != nil {
-		return "", status.Error(codes.Unknown, fmt.Sprintf("Error parsing parameters : %v", err))
+		// keep the code of the error, a policy violation is PermissionDenied
+		if _, ok := status.FromError(err); ok {
+			return "", err
+		}
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("Error parsing parameters : %v", err))
+	}
+
+	if bucketClassParams.storageAccountName == "" && bucketClassParams.storageAccountPool == "" {
+		if bucketClassParams.createStorageAccount != nil && !to.Bool(bucketClassParams.createStorageAccount) {
+			return "", status.Error(codes.InvalidArgument, fmt.Sprintf("%s is required when %s is false", constant.StorageAccountNameField, constant.CreateStorageAccountField))
+		}
+		name, err := generateStorageAccountName(ctx, bucketName, bucketClassParams, cloud)
+		if err != nil {
+			return "", err
+		}
+		bucketClassParams.storageAccountName = name
+		bucketClassParams.createStorageAccount = to.BoolPtr(true)
 	}
 
 	switch bucketClassParams.bucketUnitType {
@@ -90,6 +145,12 @@ func CreateBucket(ctx context.Context,
 	case constant.StorageAccount:
 		klog.Info("Creating a storage account")
 		return createStorageAccountBucket(ctx, bucketName, bucketClassParams, cloud)
+	case constant.Filesystem:
+		klog.Info("Creating a filesystem")
+		return createFilesystemBucket(ctx, bucketName, bucketClassParams, cloud)
+	case constant.FileShare:
+		klog.Info("Creating a file share")
+		return createFileShareBucket(ctx, bucketName, bucketClassParams, cloud)
 	}
 	return "", status.Error(codes.InvalidArgument, "Invalid BucketUnitType")
 }
@@ -101,7 +162,7 @@ func DeleteBucket(ctx context.Context,
 	klog.Info("Decoding bucketID from base64 string to BucketID struct")
 	id, err := types.DecodeToBucketID(bucketID)
 	if err != nil {
-		return err
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("could not decode ID: %v", err))
 	}
 
 	//determine if the bucket is an account or a blob container
@@ -119,7 +180,13 @@ func DeleteBucket(ctx context.Context,
 		return status.Error(codes.InvalidArgument, "Individual Blobs unsupported. Please use Blob Containers or Storage Accounts instead.")
 	}
 
-	if container == "" { //container not present, deleting storage account
+	if id.UnitType == constant.Filesystem.String() { //a filesystem is deleted through its blob container
+		klog.Info("Deleting bucket of type filesystem")
+		err = DeleteContainerBucket(ctx, id, cloud)
+	} else if id.UnitType == constant.FileShare.String() {
+		klog.Info("Deleting bucket of type fileshare")
+		err = DeleteFileShareBucket(ctx, id, cloud)
+	} else if container == "" { //container not present, deleting storage account
 		klog.Info("Deleting bucket of type storage account")
 		err = DeleteStorageAccount(ctx, id, cloud)
 	} else { //container name present, deleting container
@@ -131,10 +198,13 @@ func DeleteBucket(ctx context.Context,
 
 // creates bucketSASURL and returns (SASURL, accountID, err)
 func CreateBucketSASURL(ctx context.Context, bucketID string, parameters map[string]string, cloud *azure.Cloud) (string, string, error) {
-	bucketAccessClassParams, err := parseBucketAccessClassParameters(parameters)
+	bucketAccessClassParams, err := getBucketAccessClassParameters(parameters)
 	if err != nil {
 		return "", "", err
 	}
+	if err := checkBucketAccessClassPolicy(getPolicy(), bucketAccessClassParams, true); err != nil {
+		return "", "", err
+	}
 
 	id, err := types.DecodeToBucketID(bucketID)
 	if err != nil {
@@ -155,25 +225,66 @@ func CreateBucketSASURL(ctx context.Context, bucketID string, parameters map[str
 	subsID := id.SubID
 	resourceGroup := id.ResourceGroup
 
-	key, err := cloud.GetStorageAccesskey(ctx, subsID, storageAccountName, resourceGroup)
+	key, err := getSASSigningKey(ctx, subsID, storageAccountName, resourceGroup, cloud)
 	if err != nil {
 		return "", "", err
 	}
 
-	if bucketUnitType == constant.Container {
+	if bucketAccessClassParams.pathPrefix != "" &&
+		(id.UnitType == constant.FileShare.String() || (id.UnitType == "" && bucketUnitType != constant.Container)) {
+		return "", "", status.Error(codes.InvalidArgument, fmt.Sprintf("%s is only supported for container and filesystem buckets", constant.PathPrefixField))
+	}
+
+	var sasURL, accountID string
+	if id.UnitType == constant.Filesystem.String() {
+		klog.Info("Creating a Filesystem SAS")
+		sasURL, accountID, err = createFilesystemSASURL(ctx, id, bucketAccessClassParams, key)
+	} else if id.UnitType == constant.FileShare.String() {
+		klog.Info("Creating a File Share SAS")
+		sasURL, accountID, err = createFileShareSASURL(ctx, id, bucketAccessClassParams, key)
+	} else if bucketUnitType == constant.Container && bucketAccessClassParams.pathPrefix != "" {
+		klog.Info("Creating a Directory SAS")
+		sasURL, accountID, err = createContainerPrefixSASURL(ctx, id, bucketAccessClassParams, key, cloud)
+	} else if bucketUnitType == constant.Container {
 		klog.Info("Creating a Container SAS")
-		return createContainerSASURL(ctx, url, bucketAccessClassParams, key)
+		sasURL, accountID, err = createContainerSASURL(ctx, url, bucketAccessClassParams, key)
 	} else if bucketUnitType == constant.StorageAccount {
 		klog.Info("Creating an Account SAS")
-		return createAccountSASURL(ctx, url, bucketAccessClassParams, key)
+		sasURL, accountID, err = createAccountSASURL(ctx, url, bucketAccessClassParams, key)
+	} else {
+		return "", "", status.Error(codes.InvalidArgument, "invalid bucket type")
+	}
+	if err != nil {
+		return "", "", err
 	}
 
-	return "", "", status.Error(codes.InvalidArgument, "invalid bucket type")
+	if id.PrivateLink {
+		return toPrivateLinkURL(sasURL), toPrivateLinkURL(accountID), nil
+	}
+	return sasURL, accountID, nil
 }
 
 func parseBucketClassParameters(parameters map[string]string) (*BucketClassParameters, error) {
+	BCParams, errs := collectBucketClassParameters(parameters)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return BCParams, nil
+}
+
+// collectBucketClassParameters parses the BucketClass parameters and returns the error of every
+// invalid one, in the order of their keys, followed by those of the checks across parameters.
+// An invalid parameter is left unset.
+func collectBucketClassParameters(parameters map[string]string) (*BucketClassParameters, []error) {
 	BCParams := &BucketClassParameters{}
-	for k, v := range parameters {
+	errs := []error{}
+	keys := make([]string, 0, len(parameters))
+	for k := range parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := parameters[k]
 		switch strings.ToLower(k) {
 		case constant.BucketUnitTypeField:
 			//determine unit type and set to container as default if blank
@@ -182,8 +293,13 @@ func parseBucketClassParameters(parameters map[string]string) (*BucketClassParam
 				BCParams.bucketUnitType = constant.Container
 			case constant.StorageAccount.String():
 				BCParams.bucketUnitType = constant.StorageAccount
+			case constant.Filesystem.String():
+				BCParams.bucketUnitType = constant.Filesystem
+			case constant.FileShare.String():
+				BCParams.bucketUnitType = constant.FileShare
 			default:
-				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid BucketUnitType %s", v))
+				errs = append(errs, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid BucketUnitType %s", v)))
+				continue
 			}
 		case constant.CreateBucketField:
 			if strings.EqualFold(v, TrueValue) {
@@ -201,6 +317,12 @@ func parseBucketClassParameters(parameters map[string]string) (*BucketClassParam
 			BCParams.subscriptionID = v
 		case constant.StorageAccountNameField:
 			BCParams.storageAccountName = v
+		case constant.StorageAccountNamePrefixField:
+			if err := validateStorageAccountNamePrefix(v); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			BCParams.storageAccountNamePrefix = v
 		case constant.RegionField:
 			BCParams.region = v
 		case constant.AccessTierField:
@@ -212,7 +334,8 @@ func parseBucketClassParameters(parameters map[string]string) (*BucketClassParam
 			case constant.Archive.String():
 				BCParams.accessTier = constant.Archive
 			default:
-				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Access Tier %s is unsupported", v))
+				errs = append(errs, status.Error(codes.InvalidArgument, fmt.Sprintf("Access Tier %s is unsupported", v)))
+				continue
 			}
 		case constant.SKUNameField:
 			switch strings.ToLower(v) {
@@ -225,7 +348,8 @@ func parseBucketClassParameters(parameters map[string]string) (*BucketClassParam
 			case strings.ToLower(constant.PremiumLRS.String()):
 				BCParams.SKUName = constant.PremiumLRS
 			default:
-				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Access Tier %s is unsupported", v))
+				errs = append(errs, status.Error(codes.InvalidArgument, fmt.Sprintf("Access Tier %s is unsupported", v)))
+				continue
 			}
 		case constant.ResourceGroupField:
 			BCParams.resourceGroup = v
@@ -256,7 +380,8 @@ func parseBucketClassParameters(parameters map[string]string) (*BucketClassParam
 		case constant.BlobDeleteRetentionDaysField:
 			days, err := strconv.Atoi(v)
 			if err != nil {
-				return nil, status.Error(codes.InvalidArgument, err.Error())
+				errs = append(errs, status.Error(codes.InvalidArgument, err.Error()))
+				continue
 			}
 			BCParams.blobDeleteRetentionDays = days
 		case constant.EnableContainerDeleteRetentionField:
@@ -268,7 +393,8 @@ func parseBucketClassParameters(parameters map[string]string) (*BucketClassParam
 		case constant.ContainerDeleteRetentionDaysField:
 			days, err := strconv.Atoi(v)
 			if err != nil {
-				return nil, status.Error(codes.InvalidArgument, err.Error())
+				errs = append(errs, status.Error(codes.InvalidArgument, err.Error()))
+				continue
 			}
 			BCParams.containerDeleteRetentionDays = days
 		case StorageAccountTypeField: //Account Options Variables
@@ -286,14 +412,22 @@ func parseBucketClassParameters(parameters map[string]string) (*BucketClassParam
 			case strings.ToLower(constant.FileStorage.String()):
 				BCParams.kind = constant.FileStorage
 			default:
-				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Account Kind %s is unsupported", v))
+				errs = append(errs, status.Error(codes.InvalidArgument, fmt.Sprintf("Account Kind %s is unsupported", v)))
+				continue
 			}
 		case TagsField:
 			tags, err := ConvertTagsToMap(v)
 			if err != nil {
-				return nil, err
+				errs = append(errs, status.Error(codes.InvalidArgument, err.Error()))
+				continue
 			}
 			BCParams.tags = tags
+		case KeyVaultURIField:
+			BCParams.keyVaultURI = v
+		case KeyNameField:
+			BCParams.keyName = v
+		case KeyVersionField:
+			BCParams.keyVersion = v
 		case VNResourceIdsField:
 			BCParams.virtualNetworkResourceIDs = strings.Split(v, TagsDelimiter)
 		case HTTPSTrafficOnlyField:
@@ -316,16 +450,152 @@ func parseBucketClassParameters(parameters map[string]string) (*BucketClassParam
 			if strings.EqualFold(v, TrueValue) {
 				BCParams.enableLargeFileShare = true
 			}
+		case NetworkDefaultActionField:
+			action, err := parseNetworkDefaultAction(v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			BCParams.networkDefaultAction = action
+		case AllowedIPRangesField:
+			ranges, err := parseAllowedIPRanges(v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			BCParams.allowedIPRanges = ranges
+		case NetworkBypassField:
+			bypass, err := parseNetworkBypass(v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			BCParams.networkBypass = bypass
+		case PrivateDNSZoneIDField:
+			BCParams.privateDNSZoneID = v
+		case PrivateEndpointSubnetIDField:
+			BCParams.privateEndpointSubnetID = v
+		case constant.RootDirectoryField:
+			BCParams.rootDirectory = strings.Trim(v, "/")
+		case constant.OwnerField:
+			BCParams.owner = v
+		case constant.GroupField:
+			BCParams.group = v
+		case constant.ACLField:
+			if err := validateACL(v); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			BCParams.acl = v
+		case constant.ShareQuotaField:
+			quota, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, status.Error(codes.InvalidArgument, err.Error()))
+				continue
+			}
+			if quota <= 0 {
+				errs = append(errs, status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s must be positive", constant.ShareQuotaField, v)))
+				continue
+			}
+			BCParams.shareQuota = quota
+		case constant.StorageAccountPoolField:
+			BCParams.storageAccountPool = v
+		case constant.PoolSelectionPolicyField:
+			policy, err := parsePoolSelectionPolicy(v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			BCParams.poolSelectionPolicy = policy
+		case constant.PoolMaxContainersField:
+			maxContainers, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, status.Error(codes.InvalidArgument, err.Error()))
+				continue
+			}
+			if maxContainers <= 0 {
+				errs = append(errs, status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s must be positive", constant.PoolMaxContainersField, v)))
+				continue
+			}
+			BCParams.poolMaxContainers = maxContainers
+		case constant.PoolMaxCapacityGiBField:
+			maxCapacity, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, status.Error(codes.InvalidArgument, err.Error()))
+				continue
+			}
+			if maxCapacity <= 0 {
+				errs = append(errs, status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s must be positive", constant.PoolMaxCapacityGiBField, v)))
+				continue
+			}
+			BCParams.poolMaxCapacityGiB = maxCapacity
+		case constant.ShareAccessTierField:
+			tier, err := parseShareAccessTier(v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			BCParams.shareAccessTier = tier
+		case constant.ShareProtocolField:
+			protocol, err := parseShareProtocol(v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			BCParams.shareProtocol = protocol
+		case constant.CORSAllowedOriginsField:
+			BCParams.corsAllowedOrigins = splitCORSList(v)
+		case constant.CORSAllowedMethodsField:
+			BCParams.corsAllowedMethods = splitCORSList(v)
+		case constant.CORSAllowedHeadersField:
+			BCParams.corsAllowedHeaders = splitCORSList(v)
+		case constant.CORSExposedHeadersField:
+			BCParams.corsExposedHeaders = splitCORSList(v)
+		case constant.CORSMaxAgeInSecondsField:
+			maxAge, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				errs = append(errs, status.Error(codes.InvalidArgument, err.Error()))
+				continue
+			}
+			if maxAge < 0 {
+				errs = append(errs, status.Error(codes.InvalidArgument, fmt.Sprintf("CORS max age %s must not be negative", v)))
+				continue
+			}
+			BCParams.corsMaxAgeInSeconds = to.Int32Ptr(int32(maxAge))
 		}
 	}
 
+	// Filesystem buckets need a hierarchical namespace account
+	if BCParams.bucketUnitType == constant.Filesystem {
+		BCParams.isHnsEnabled = true
+	}
+
+	if err := validateFilesystemParameters(BCParams); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateFileShareParameters(BCParams); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateNetworkParameters(BCParams); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateCORSParameters(BCParams); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateEncryptionParameters(BCParams); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validatePoolParameters(BCParams); err != nil {
+		errs = append(errs, err)
+	}
+
 	// If the unit type of bucket is StorageAccount and the create storage account is not set,
 	// We will create a storage account if not present.
 	if BCParams.bucketUnitType == constant.StorageAccount && BCParams.createStorageAccount == nil {
 		BCParams.createStorageAccount = to.BoolPtr(true)
 	}
 
-	return BCParams, nil
+	return BCParams, errs
 }
 
 func parseBucketAccessClassParameters(parameters map[string]string) (*BucketAccessClassParameters, error) {
@@ -346,6 +616,14 @@ func parseBucketAccessClassParameters(parameters map[string]string) (*BucketAcce
 			BACParams.storageAccountName = v
 		case constant.RegionField:
 			BACParams.region = v
+		case constant.PrincipalIDField:
+			BACParams.principalID = v
+		case constant.PathPrefixField:
+			prefix, err := parsePathPrefix(v)
+			if err != nil {
+				return nil, err
+			}
+			BACParams.pathPrefix = prefix
 		case constant.SignedVersionField:
 			BACParams.signedversion = v
 		case constant.SignedProtocolField:
@@ -354,29 +632,24 @@ func parseBucketAccessClassParameters(parameters map[string]string) (*BucketAcce
 				BACParams.signedProtocol = sas.ProtocolHTTPS
 			case string(sas.ProtocolHTTPSandHTTP):
 				BACParams.signedProtocol = sas.ProtocolHTTPSandHTTP
+			default:
+				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid SAS Protocol %s", v))
 			}
-			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid SAS Protocol %s", v))
 		case constant.SignedIPField:
 			iplist := strings.Split(v, "-")
-			switch len(iplist) {
-			case 1:
-				start := net.ParseIP(iplist[0])
-				if start == nil {
-					klog.Warning(fmt.Sprintf("IP %s is an invalid ip, no range will be set", iplist[0]))
-				}
-				BACParams.signedIP = sas.IPRange{Start: start}
-			case 2:
-				start := net.ParseIP(iplist[0])
-				end := net.ParseIP(iplist[1])
-				if start == nil {
-					klog.Warning(fmt.Sprintf("IP %s is an invalid ip, no range will be set", iplist[0]))
-				}
-				if end == nil {
-					klog.Warning(fmt.Sprintf("IP %s is an invalid ip, no end to the range will be set", iplist[0]))
+			if len(iplist) > 2 {
+				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid IP Range %s, Must be formatted as <ip> or <ip1>-<ip2>", v))
+			}
+			ips := make([]net.IP, len(iplist))
+			for i, ip := range iplist {
+				if ips[i] = net.ParseIP(ip); ips[i] == nil {
+					return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid IP Range %s, %s is not an IP address", v, ip))
 				}
-				BACParams.signedIP = sas.IPRange{Start: start, End: end}
 			}
-			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid IP Range %s, Must be formatted as <ip> or <ip1>-<ip2>", v))
+			BACParams.signedIP = sas.IPRange{Start: ips[0]}
+			if len(ips) == 2 {
+				BACParams.signedIP.End = ips[1]
+			}
 		case constant.ValidationPeriodField:
 			msec, err := strconv.ParseUint(v, 10, 64)
 			if err != nil {
@@ -439,9 +712,9 @@ func parseBucketAccessClassParameters(parameters map[string]string) (*BucketAcce
 			}
 		case constant.AllowContainerSignedResourceTypeField:
 			if strings.EqualFold(v, TrueValue) {
-				BACParams.allowObjectSignedResourceType = true
+				BACParams.allowContainerSignedResourceType = true
 			} else if strings.EqualFold(v, FalseValue) {
-				BACParams.allowObjectSignedResourceType = false
+				BACParams.allowContainerSignedResourceType = false
 			}
 		case constant.AllowObjectSignedResourceTypeField:
 			if strings.EqualFold(v, TrueValue) {
@@ -465,14 +738,30 @@ func getAccountOptions(params *BucketClassParameters) *azure.AccountOptions {
 		Location:                  params.region,
 		Type:                      params.storageAccountType,
 		Kind:                      params.kind.String(),
-		Tags:                      params.tags,
+		Tags:                      map[string]string{},
 		VirtualNetworkResourceIDs: params.virtualNetworkResourceIDs,
 		EnableHTTPSTrafficOnly:    params.enableHTTPSTrafficOnly,
-		CreatePrivateEndpoint:     params.createPrivateEndpoint,
+		CreatePrivateEndpoint:     params.createPrivateEndpoint && !usesBlobPrivateEndpoint(params),
 		IsHnsEnabled:              to.BoolPtr(params.isHnsEnabled),
 		EnableNfsV3:               to.BoolPtr(params.enableNfsV3),
 		EnableLargeFileShare:      params.enableLargeFileShare,
 		CreateAccount:             createStorageAccount,
 	}
+	for k, v := range params.tags {
+		options.Tags[k] = v
+	}
+	// set after the tags of the class, which must not move the account out of its pool
+	if params.storageAccountPool != "" {
+		options.Tags[StorageAccountPoolTag] = params.storageAccountPool
+	}
+	// only applied when the account is created, so accounts supplied by the user are never marked
+	options.Tags[ManagedTag] = TrueValue
+	if params.keyVaultURI != "" {
+		options.KeyVaultURI = to.StringPtr(params.keyVaultURI)
+		options.KeyName = to.StringPtr(params.keyName)
+		if params.keyVersion != "" {
+			options.KeyVersion = to.StringPtr(params.keyVersion)
+		}
+	}
 	return options
 }
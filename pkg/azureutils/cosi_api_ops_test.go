@@ -6,10 +6,15 @@ import (
 	"fmt"
 	"github.com/Azure/azure-cosi-driver/pkg/constant"
 	"github.com/Azure/azure-cosi-driver/pkg/types"
+	"hash/fnv"
+	"net"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/golang/mock/gomock"
@@ -28,11 +33,10 @@ func TestCreateBucket(t *testing.T) {
 		params      map[string]string
 	}{
 		{
-			testName: "Parsing Error (invalid bucket unit type)",
-			bucket:   constant.ValidContainerURL,
-			params:   map[string]string{constant.BucketUnitTypeField: "invalid type"},
-			expectedErr: status.Error(codes.Unknown, fmt.Sprintf("Error parsing parameters : %v",
-				status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid BucketUnitType %s", "invalid type")))),
+			testName:    "Parsing Error (invalid bucket unit type)",
+			bucket:      constant.ValidContainerURL,
+			params:      map[string]string{constant.BucketUnitTypeField: "invalid type"},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid BucketUnitType %s", "invalid type")),
 		},
 		{
 			testName: "Create storage Account Bucket",
@@ -53,6 +57,14 @@ func TestCreateBucket(t *testing.T) {
 			expectedErr: status.Error(codes.Internal, fmt.Sprintf("Could not ensure storage account %s exists: %v", constant.InvalidAccount,
 				fmt.Errorf("could not get storage key for storage account "+constant.InvalidAccount+": "+retry.GetError(&http.Response{}, fmt.Errorf("Invalid Account")).Error().Error()))),
 		},
+		{
+			testName: "Missing storage account name without account creation",
+			bucket:   constant.ValidContainer,
+			params: map[string]string{
+				constant.BucketUnitTypeField:       constant.Container.String(),
+				constant.CreateStorageAccountField: FalseValue},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s is required when %s is false", constant.StorageAccountNameField, constant.CreateStorageAccountField)),
+		},
 	}
 	ctrl := gomock.NewController(t)
 	cloud := azure.GetTestCloud(ctrl)
@@ -109,7 +121,7 @@ func TestDeleteBucket(t *testing.T) {
 				ResourceGroup: constant.ValidResourceGroup,
 				URL:           constant.ValidContainerURL,
 			},
-			expectedErr: fmt.Errorf("Error deleting container %s in storage account %s : %v", constant.ValidContainer, constant.ValidAccount, fmt.Errorf("Delete \"https://validaccount.blob.core.windows.net/validcontainer?restype=container\": dial tcp: lookup validaccount.blob.core.windows.net: no such host")),
+			expectedErr: status.Error(codes.Unavailable, fmt.Sprintf("Error deleting container %s in storage account %s : %v", constant.ValidContainer, constant.ValidAccount, fmt.Errorf("Delete \"https://validaccount.blob.core.windows.net/validcontainer?restype=container\": dial tcp: lookup validaccount.blob.core.windows.net: no such host"))),
 		},
 	}
 	ctrl := gomock.NewController(t)
@@ -131,6 +143,13 @@ func TestDeleteBucket(t *testing.T) {
 	}
 }
 
+func TestDeleteBucketInvalidID(t *testing.T) {
+	err := DeleteBucket(context.Background(), "not a bucket id", nil)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
+
 func TestParseBucketClassParameters(t *testing.T) {
 	tests := []struct {
 		testName       string
@@ -174,6 +193,18 @@ func TestParseBucketClassParameters(t *testing.T) {
 			expectedErr:    nil,
 			expectedParams: BucketClassParameters{storageAccountName: constant.ValidAccount},
 		},
+		{
+			testName:       "Valid Storage Account Name Prefix",
+			parameters:     map[string]string{constant.StorageAccountNamePrefixField: "team1"},
+			expectedErr:    nil,
+			expectedParams: BucketClassParameters{storageAccountNamePrefix: "team1"},
+		},
+		{
+			testName:       "Invalid Storage Account Name Prefix",
+			parameters:     map[string]string{constant.StorageAccountNamePrefixField: "Team-1"},
+			expectedErr:    status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid %s %q, it must be 1 to %d lowercase letters and digits", constant.StorageAccountNamePrefixField, "Team-1", maxStorageAccountNamePrefixLength)),
+			expectedParams: BucketClassParameters{},
+		},
 		{
 			testName:       "Valid Region",
 			parameters:     map[string]string{constant.RegionField: constant.ValidRegion},
@@ -360,6 +391,32 @@ func TestParseBucketClassParameters(t *testing.T) {
 			expectedErr:    nil,
 			expectedParams: BucketClassParameters{enableLargeFileShare: true},
 		},
+		{
+			testName: "CORS rule",
+			parameters: map[string]string{
+				constant.CORSAllowedOriginsField:  "https://contoso.com, https://fabrikam.com",
+				constant.CORSAllowedMethodsField:  "GET,PUT",
+				constant.CORSMaxAgeInSecondsField: "60",
+			},
+			expectedErr: nil,
+			expectedParams: BucketClassParameters{
+				corsAllowedOrigins:  []string{"https://contoso.com", "https://fabrikam.com"},
+				corsAllowedMethods:  []string{"GET", "PUT"},
+				corsMaxAgeInSeconds: to.Int32Ptr(60),
+			},
+		},
+		{
+			testName:       "CORS rule missing origins",
+			parameters:     map[string]string{constant.CORSAllowedMethodsField: "GET"},
+			expectedErr:    status.Error(codes.InvalidArgument, "corsallowedorigins is required when CORS parameters are set"),
+			expectedParams: BucketClassParameters{},
+		},
+		{
+			testName:       "CORS max age negative",
+			parameters:     map[string]string{constant.CORSMaxAgeInSecondsField: "-1"},
+			expectedErr:    status.Error(codes.InvalidArgument, fmt.Sprintf("CORS max age %s must not be negative", "-1")),
+			expectedParams: BucketClassParameters{},
+		},
 	}
 	for _, test := range tests {
 		params, err := parseBucketClassParameters(test.parameters)
@@ -380,7 +437,52 @@ func TestParseBucketClassParameters(t *testing.T) {
 }
 
 func TestParseBucketAccessClassParameters(t *testing.T) {
-
+	tests := []struct {
+		testName         string
+		params           map[string]string
+		expectedProtocol sas.Protocol
+		expectedErr      bool
+	}{
+		{
+			testName:         "Default protocol",
+			params:           map[string]string{},
+			expectedProtocol: sas.ProtocolHTTPSandHTTP,
+		},
+		{
+			testName:         "HTTPS only",
+			params:           map[string]string{constant.SignedProtocolField: "https"},
+			expectedProtocol: sas.ProtocolHTTPS,
+		},
+		{
+			testName:         "HTTPS and HTTP",
+			params:           map[string]string{constant.SignedProtocolField: "https,http"},
+			expectedProtocol: sas.ProtocolHTTPSandHTTP,
+		},
+		{
+			testName:    "Invalid protocol",
+			params:      map[string]string{constant.SignedProtocolField: "http"},
+			expectedErr: true,
+		},
+		{
+			testName:         "Signed IP range",
+			params:           map[string]string{constant.SignedIPField: "10.0.0.1-10.0.0.255"},
+			expectedProtocol: sas.ProtocolHTTPSandHTTP,
+		},
+		{
+			testName:    "Invalid signed IP",
+			params:      map[string]string{constant.SignedIPField: "10.0.0.1-localhost"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		params, err := parseBucketAccessClassParameters(test.params)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && params.signedProtocol != test.expectedProtocol {
+			t.Errorf("\nTestCase: %s\nExpected Protocol: %v\nActual Protocol: %v", test.testName, test.expectedProtocol, params.signedProtocol)
+		}
+	}
 }
 
 func TestGetAccountOptions(t *testing.T) {
@@ -405,7 +507,7 @@ func TestGetAccountOptions(t *testing.T) {
 			Location:                  constant.ValidRegion,
 			Type:                      constant.ValidAccountType,
 			Kind:                      constant.StorageV2.String(),
-			Tags:                      map[string]string{"foo": "bar"},
+			Tags:                      map[string]string{"foo": "bar", ManagedTag: TrueValue},
 			VirtualNetworkResourceIDs: []string{"id1"},
 			EnableHTTPSTrafficOnly:    true,
 			CreatePrivateEndpoint:     true,
@@ -418,4 +520,264 @@ func TestGetAccountOptions(t *testing.T) {
 			t.Errorf("\nExpected Options: %+v\nActual Options: %+v", expectedOutput, output)
 		}
 	})
+
+	t.Run("Pool Tag Overrides Class Tags", func(t *testing.T) {
+		input := &BucketClassParameters{
+			storageAccountPool: "pool",
+			tags:               map[string]string{"foo": "bar", StorageAccountPoolTag: "other", ManagedTag: "false"},
+		}
+		expectedTags := map[string]string{"foo": "bar", StorageAccountPoolTag: "pool", ManagedTag: TrueValue}
+		if output := getAccountOptions(input); !reflect.DeepEqual(output.Tags, expectedTags) {
+			t.Errorf("\nExpected Tags: %v\nActual Tags: %v", expectedTags, output.Tags)
+		}
+	})
+
+	t.Run("Blob Private Endpoint Wired By Driver", func(t *testing.T) {
+		input := &BucketClassParameters{
+			storageAccountName:    constant.ValidAccount,
+			createPrivateEndpoint: true,
+			privateDNSZoneID:      "zoneid",
+		}
+		output := getAccountOptions(input)
+		if output.CreatePrivateEndpoint {
+			t.Errorf("\nExpected CreatePrivateEndpoint: false\nActual Options: %+v", output)
+		}
+	})
+}
+
+// parsedField renders a valid value of a parameter from a number and reads the parsed value back
+type parsedField[T any] struct {
+	key    string
+	render func(n uint32) string
+	get    func(T) string
+}
+
+// boolField is a parsedField of a true/false parameter
+func boolField[T any](key string, get func(T) bool) parsedField[T] {
+	return parsedField[T]{
+		key:    key,
+		render: func(n uint32) string { return strconv.FormatBool(n%2 == 0) },
+		get:    func(params T) string { return strconv.FormatBool(get(params)) },
+	}
+}
+
+// pick chooses one of the values from a number
+func pick(n uint32, values ...string) string {
+	return values[n%uint32(len(values))]
+}
+
+// ipv4 renders a number as an IPv4 address
+func ipv4(n uint32) string {
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).String()
+}
+
+// checkParseProperties checks the properties every parameter parser must have: errors are
+// InvalidArgument, parsing is deterministic, parameter names are case insensitive and a valid
+// value of each field parses back to the same value
+func checkParseProperties[T any](t *testing.T, parse func(map[string]string) (T, error), fields []parsedField[T], k1, v1, k2, v2 string) {
+	parameters := map[string]string{k1: v1, k2: v2}
+	params, err := parse(parameters)
+	if err != nil {
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("parsing %v: expected InvalidArgument, got %v", parameters, err)
+		}
+	}
+	// parameters are parsed in map order: when both are invalid either error is returned,
+	// and when both names are the same field either value is kept
+	again, errAgain := parse(parameters)
+	if !strings.EqualFold(k1, k2) && ((err == nil) != (errAgain == nil) || !reflect.DeepEqual(params, again)) {
+		t.Errorf("parsing %v twice gave (%+v, %v) and (%+v, %v)", parameters, params, err, again, errAgain)
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(k1 + v1 + k2 + v2))
+	n := h.Sum32()
+	for _, field := range fields {
+		value := field.render(n)
+		parsed, err := parse(map[string]string{field.key: value})
+		if err != nil {
+			t.Errorf("parsing %s %q: unexpected error: %v", field.key, value, err)
+			continue
+		}
+		if actual := field.get(parsed); actual != value {
+			t.Errorf("parsing %s %q gave %q", field.key, value, actual)
+		}
+	}
+
+	if strings.EqualFold(k1, k2) {
+		return
+	}
+	lower := map[string]string{strings.ToLower(k1): v1, strings.ToLower(k2): v2}
+	lowerParams, lowerErr := parse(lower)
+	if (err == nil) != (lowerErr == nil) || (err == nil && !reflect.DeepEqual(params, lowerParams)) {
+		t.Errorf("parsing %v gave (%+v, %v), parsing %v gave (%+v, %v)", parameters, params, err, lower, lowerParams, lowerErr)
+	}
+}
+
+var bucketClassFields = []parsedField[*BucketClassParameters]{
+	{
+		key:    constant.BucketUnitTypeField,
+		render: func(n uint32) string { return pick(n, "container", "storageaccount", "filesystem", "fileshare") },
+		get:    func(p *BucketClassParameters) string { return p.bucketUnitType.String() },
+	},
+	{
+		key:    constant.AccessTierField,
+		render: func(n uint32) string { return pick(n, "hot", "cool", "archive") },
+		get:    func(p *BucketClassParameters) string { return p.accessTier.String() },
+	},
+	{
+		key:    constant.SKUNameField,
+		render: func(n uint32) string { return pick(n, "Standard_LRS", "Standard_GRS", "Standard_RAGRS", "Premium_LRS") },
+		get:    func(p *BucketClassParameters) string { return p.SKUName.String() },
+	},
+	{
+		key: KindField,
+		render: func(n uint32) string {
+			return pick(n, "StorageV2", "Storage", "BlobStorage", "BlockBlobStorage", "FileStorage")
+		},
+		get: func(p *BucketClassParameters) string { return p.kind.String() },
+	},
+	{
+		key:    constant.StorageAccountNameField,
+		render: func(n uint32) string { return fmt.Sprintf("account%d", n) },
+		get:    func(p *BucketClassParameters) string { return p.storageAccountName },
+	},
+	{
+		key:    constant.StorageAccountNamePrefixField,
+		render: func(n uint32) string { return fmt.Sprintf("cosi%d", n%10000) },
+		get:    func(p *BucketClassParameters) string { return p.storageAccountNamePrefix },
+	},
+	{
+		key:    constant.RegionField,
+		render: func(n uint32) string { return pick(n, "eastus", "westus2", "westeurope") },
+		get:    func(p *BucketClassParameters) string { return p.region },
+	},
+	{
+		key:    constant.ResourceGroupField,
+		render: func(n uint32) string { return fmt.Sprintf("rg-%d", n) },
+		get:    func(p *BucketClassParameters) string { return p.resourceGroup },
+	},
+	{
+		key:    constant.BlobDeleteRetentionDaysField,
+		render: func(n uint32) string { return strconv.Itoa(int(n%365) + 1) },
+		get:    func(p *BucketClassParameters) string { return strconv.Itoa(p.blobDeleteRetentionDays) },
+	},
+	{
+		key:    constant.ContainerDeleteRetentionDaysField,
+		render: func(n uint32) string { return strconv.Itoa(int(n%365) + 1) },
+		get:    func(p *BucketClassParameters) string { return strconv.Itoa(p.containerDeleteRetentionDays) },
+	},
+	boolField(constant.CreateBucketField, func(p *BucketClassParameters) bool { return p.createBucket }),
+	boolField(constant.AllowBlobAccessField, func(p *BucketClassParameters) bool { return p.allowBlobAccess }),
+	boolField(constant.AllowSharedAccessKeyField, func(p *BucketClassParameters) bool { return p.allowSharedAccessKey }),
+	boolField(constant.EnableBlobVersioningField, func(p *BucketClassParameters) bool { return p.enableBlobVersioning }),
+	boolField(constant.EnableBlobDeleteRetentionField, func(p *BucketClassParameters) bool { return p.enableBlobDeleteRetention }),
+	boolField(constant.EnableContainerDeleteRetentionField, func(p *BucketClassParameters) bool { return p.enableContainerDeleteRetention }),
+}
+
+var bucketAccessClassFields = []parsedField[*BucketAccessClassParameters]{
+	{
+		key:    constant.StorageAccountNameField,
+		render: func(n uint32) string { return fmt.Sprintf("account%d", n) },
+		get:    func(p *BucketAccessClassParameters) string { return p.storageAccountName },
+	},
+	{
+		key:    constant.RegionField,
+		render: func(n uint32) string { return pick(n, "eastus", "westus2", "westeurope") },
+		get:    func(p *BucketAccessClassParameters) string { return p.region },
+	},
+	{
+		key:    constant.PrincipalIDField,
+		render: func(n uint32) string { return fmt.Sprintf("%08x-0000-0000-0000-000000000000", n) },
+		get:    func(p *BucketAccessClassParameters) string { return p.principalID },
+	},
+	{
+		key:    constant.PathPrefixField,
+		render: func(n uint32) string { return fmt.Sprintf("dir%d/sub", n) },
+		get:    func(p *BucketAccessClassParameters) string { return p.pathPrefix },
+	},
+	{
+		key:    constant.SignedVersionField,
+		render: func(n uint32) string { return pick(n, "2020-02-10", "2021-06-08") },
+		get:    func(p *BucketAccessClassParameters) string { return p.signedversion },
+	},
+	{
+		key:    constant.SignedProtocolField,
+		render: func(n uint32) string { return pick(n, string(sas.ProtocolHTTPS), string(sas.ProtocolHTTPSandHTTP)) },
+		get:    func(p *BucketAccessClassParameters) string { return string(p.signedProtocol) },
+	},
+	{
+		key: constant.SignedIPField,
+		render: func(n uint32) string {
+			if n%2 == 0 {
+				return ipv4(n)
+			}
+			return ipv4(n) + "-" + ipv4(n+1)
+		},
+		get: func(p *BucketAccessClassParameters) string { return p.signedIP.String() },
+	},
+	{
+		key:    constant.ValidationPeriodField,
+		render: func(n uint32) string { return strconv.FormatUint(uint64(n), 10) },
+		get:    func(p *BucketAccessClassParameters) string { return strconv.FormatUint(p.validationPeriod, 10) },
+	},
+	boolField(constant.EnableListField, func(p *BucketAccessClassParameters) bool { return p.enableList }),
+	boolField(constant.EnableReadField, func(p *BucketAccessClassParameters) bool { return p.enableRead }),
+	boolField(constant.EnableWriteField, func(p *BucketAccessClassParameters) bool { return p.enableWrite }),
+	boolField(constant.EnableDeleteField, func(p *BucketAccessClassParameters) bool { return p.enableDelete }),
+	boolField(constant.EnablePermanentDeleteField, func(p *BucketAccessClassParameters) bool { return p.enablePermanentDelete }),
+	boolField(constant.EnableAddField, func(p *BucketAccessClassParameters) bool { return p.enableAdd }),
+	boolField(constant.EnableTagsField, func(p *BucketAccessClassParameters) bool { return p.enableTags }),
+	boolField(constant.EnableFilterField, func(p *BucketAccessClassParameters) bool { return p.enableFilter }),
+	boolField(constant.AllowServiceSignedResourceTypeField, func(p *BucketAccessClassParameters) bool { return p.allowServiceSignedResourceType }),
+	boolField(constant.AllowContainerSignedResourceTypeField, func(p *BucketAccessClassParameters) bool { return p.allowContainerSignedResourceType }),
+	boolField(constant.AllowObjectSignedResourceTypeField, func(p *BucketAccessClassParameters) bool { return p.allowObjectSignedResourceType }),
+}
+
+func FuzzParseBucketClassParameters(f *testing.F) {
+	for k, v := range map[string]string{
+		constant.BucketUnitTypeField:                 constant.Filesystem.String(),
+		constant.CreateStorageAccountField:           TrueValue,
+		constant.AccessTierField:                     constant.Cool.String(),
+		constant.SKUNameField:                        "Standard_GRS",
+		TagsField:                                    "key1=value1,key2=a=b",
+		constant.BlobDeleteRetentionDaysField:        "7",
+		constant.ContainerDeleteRetentionDaysField:   "-1",
+		constant.CORSAllowedOriginsField:             "https://example.com",
+		constant.CORSAllowedMethodsField:             "GET,PUT",
+		constant.CORSMaxAgeInSecondsField:            "99999999999",
+		constant.ShareQuotaField:                     "100",
+		constant.ACLField:                            "user::rwx,group::r-x,other::---",
+		NetworkDefaultActionField:                    "Deny",
+		AllowedIPRangesField:                         "10.0.0.0/24,1.2.3.4",
+		VNResourceIdsField:                           ",",
+		constant.RootDirectoryField:                  "../dir",
+		constant.EnableContainerDeleteRetentionField: "maybe",
+	} {
+		f.Add(k, v, constant.BucketUnitTypeField, constant.Container.String())
+		f.Add(strings.ToUpper(k), v, constant.BucketUnitTypeField, constant.StorageAccount.String())
+	}
+	f.Fuzz(func(t *testing.T, k1, v1, k2, v2 string) {
+		checkParseProperties(t, parseBucketClassParameters, bucketClassFields, k1, v1, k2, v2)
+	})
+}
+
+func FuzzParseBucketAccessClassParameters(f *testing.F) {
+	for k, v := range map[string]string{
+		constant.SignedVersionField:                 "2020-02-10",
+		constant.ValidationPeriodField:              "18446744073709551615",
+		constant.SignedIPField:                      "1.2.3.4-5.6.7.8",
+		constant.SignedProtocolField:                "https",
+		constant.EnableWriteField:                   TrueValue,
+		constant.EnableListField:                    FalseValue,
+		constant.PrincipalIDField:                   "00000000-0000-0000-0000-000000000000",
+		constant.PathPrefixField:                    "dir/../other",
+		constant.AllowObjectSignedResourceTypeField: "",
+	} {
+		f.Add(k, v, constant.EnableReadField, TrueValue)
+		f.Add(strings.ToUpper(k), v, constant.SignedIPField, "-")
+	}
+	f.Fuzz(func(t *testing.T, k1, v1, k2, v2 string) {
+		checkParseProperties(t, parseBucketAccessClassParameters, bucketAccessClassFields, k1, v1, k2, v2)
+	})
 }
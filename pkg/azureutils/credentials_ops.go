@@ -0,0 +1,137 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// SASAccountIDPrefix marks the AccountId of a grant made with a SAS
+	SASAccountIDPrefix = "sas:"
+	// IAMAccountIDPrefix marks the AccountId of a grant made through the filesystem ACL of a principal
+	IAMAccountIDPrefix = "iam:"
+)
+
+// CreateBucketAccess creates a SAS for the bucket and returns the AccountId of the grant with its secrets
+func CreateBucketAccess(ctx context.Context, bucketID, accessName string, parameters map[string]string, cloud *azure.Cloud) (string, map[string]string, error) {
+	id, err := types.DecodeToBucketID(bucketID)
+	if err != nil {
+		return "", nil, status.Error(codes.InvalidArgument, fmt.Sprintf("could not decode ID: %v", err))
+	}
+
+	sasURL, accountURL, err := CreateBucketSASURL(ctx, bucketID, parameters, cloud)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secrets, err := getSASCredentials(id, sasURL, accountURL)
+	if err != nil {
+		return "", nil, err
+	}
+	return getSASAccountID(id, accessName), secrets, nil
+}
+
+// getSASAccountID names a SAS grant after the bucket and the BucketAccess, so retries of the
+// same grant return the same AccountId and DriverRevokeBucketAccess can tell what it refers to
+func getSASAccountID(id *types.BucketID, accessName string) string {
+	account, bucket, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return SASAccountIDPrefix + accessName
+	}
+	parts := []string{}
+	for _, part := range []string{account, bucket, accessName} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return SASAccountIDPrefix + strings.Join(parts, "/")
+}
+
+func isSASAccountID(accountID string) bool {
+	return strings.HasPrefix(accountID, SASAccountIDPrefix)
+}
+
+// getSASCredentials splits a SAS URL into the secrets handed to workloads
+func getSASCredentials(id *types.BucketID, sasURL, accountURL string) (map[string]string, error) {
+	u, err := url.Parse(sasURL)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("could not parse SAS URL: %v", err))
+	}
+	account, bucket, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	token := u.RawQuery
+	secrets := map[string]string{
+		constant.AccessToken:   sasURL,
+		constant.SASToken:      token,
+		constant.AccountName:   account,
+		constant.Endpoint:      accountURL,
+		constant.ContainerName: bucket,
+	}
+
+	if se := u.Query().Get("se"); se != "" {
+		expiry, err := time.Parse(sas.TimeFormat, se)
+		if err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("could not parse SAS expiry %s: %v", se, err))
+		}
+		secrets[constant.ExpiryTimestamp] = expiry.UTC().Format(time.RFC3339)
+	}
+
+	// Connection strings have no Data Lake endpoint, filesystems are reached through the blob endpoint
+	endpointName := "BlobEndpoint"
+	endpoint := strings.Replace(accountURL, "."+getDFSDomain(), "."+getBlobDomain(), 1)
+	if id.UnitType == constant.FileShare.String() {
+		endpointName = "FileEndpoint"
+	}
+	secrets[constant.ConnectionString] = fmt.Sprintf("%s=%s;SharedAccessSignature=%s", endpointName, endpoint, token)
+	return secrets, nil
+}
+
+// GetBucketLocation returns the storage account, bucket and directory a BucketID refers to.
+// The bucket is empty for storageaccount buckets.
+func GetBucketLocation(bucketID string) (string, string, string, error) {
+	id, err := types.DecodeToBucketID(bucketID)
+	if err != nil {
+		return "", "", "", status.Error(codes.InvalidArgument, fmt.Sprintf("could not decode ID: %v", err))
+	}
+	account, bucket, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return "", "", "", err
+	}
+	return account, bucket, id.Directory, nil
+}
+
+// GetIAMPermissions returns the ACL permissions GrantBucketIAMAccess grants for the BucketAccessClass parameters
+func GetIAMPermissions(parameters map[string]string) (string, error) {
+	params, err := getBucketAccessClassParameters(parameters)
+	if err != nil {
+		return "", err
+	}
+	return getACLPermissions(params), nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+)
+
+func TestGetSASAccountID(t *testing.T) {
+	tests := []struct {
+		testName   string
+		url        string
+		expectedID string
+	}{
+		{
+			testName:   "Container",
+			url:        constant.ValidContainerURL,
+			expectedID: "sas:validaccount/validcontainer/access",
+		},
+		{
+			testName:   "Storage account",
+			url:        constant.ValidAccountURL,
+			expectedID: "sas:validaccount/access",
+		},
+		{
+			testName:   "Invalid URL",
+			url:        "",
+			expectedID: "sas:access",
+		},
+	}
+	for _, test := range tests {
+		id := getSASAccountID(&types.BucketID{URL: test.url}, "access")
+		if id != test.expectedID {
+			t.Errorf("\nTestCase: %s\nExpected ID: %v\nActual ID: %v", test.testName, test.expectedID, id)
+		}
+		if !isSASAccountID(id) {
+			t.Errorf("\nTestCase: %s\n%s is not recognised as a SAS account ID", test.testName, id)
+		}
+	}
+}
+
+func TestGetSASCredentials(t *testing.T) {
+	token := "se=2026-01-02T03%3A04%3A05Z&sig=c2ln&sp=rl&sv=2020-02-10"
+	tests := []struct {
+		testName        string
+		id              *types.BucketID
+		sasURL          string
+		accountURL      string
+		expectedSecrets map[string]string
+	}{
+		{
+			testName:   "Container",
+			id:         &types.BucketID{URL: constant.ValidContainerURL},
+			sasURL:     "https://validaccount.blob.core.windows.net/?" + token,
+			accountURL: "https://validaccount.blob.core.windows.net/",
+			expectedSecrets: map[string]string{
+				constant.AccessToken:      "https://validaccount.blob.core.windows.net/?" + token,
+				constant.SASToken:         token,
+				constant.AccountName:      "validaccount",
+				constant.Endpoint:         "https://validaccount.blob.core.windows.net/",
+				constant.ContainerName:    "validcontainer",
+				constant.ExpiryTimestamp:  "2026-01-02T03:04:05Z",
+				constant.ConnectionString: "BlobEndpoint=https://validaccount.blob.core.windows.net/;SharedAccessSignature=" + token,
+			},
+		},
+		{
+			testName:   "Filesystem",
+			id:         &types.BucketID{URL: "https://validaccount.dfs.core.windows.net/fs", UnitType: constant.Filesystem.String()},
+			sasURL:     "https://validaccount.dfs.core.windows.net/fs?" + token,
+			accountURL: "https://validaccount.dfs.core.windows.net/",
+			expectedSecrets: map[string]string{
+				constant.AccessToken:      "https://validaccount.dfs.core.windows.net/fs?" + token,
+				constant.SASToken:         token,
+				constant.AccountName:      "validaccount",
+				constant.Endpoint:         "https://validaccount.dfs.core.windows.net/",
+				constant.ContainerName:    "fs",
+				constant.ExpiryTimestamp:  "2026-01-02T03:04:05Z",
+				constant.ConnectionString: "BlobEndpoint=https://validaccount.blob.core.windows.net/;SharedAccessSignature=" + token,
+			},
+		},
+		{
+			testName:   "File share",
+			id:         &types.BucketID{URL: "https://validaccount.file.core.windows.net/share", UnitType: constant.FileShare.String()},
+			sasURL:     "https://validaccount.file.core.windows.net/share?" + token,
+			accountURL: "https://validaccount.file.core.windows.net/",
+			expectedSecrets: map[string]string{
+				constant.AccessToken:      "https://validaccount.file.core.windows.net/share?" + token,
+				constant.SASToken:         token,
+				constant.AccountName:      "validaccount",
+				constant.Endpoint:         "https://validaccount.file.core.windows.net/",
+				constant.ContainerName:    "share",
+				constant.ExpiryTimestamp:  "2026-01-02T03:04:05Z",
+				constant.ConnectionString: "FileEndpoint=https://validaccount.file.core.windows.net/;SharedAccessSignature=" + token,
+			},
+		},
+	}
+	for _, test := range tests {
+		secrets, err := getSASCredentials(test.id, test.sasURL, test.accountURL)
+		if err != nil {
+			t.Errorf("\nTestCase: %s\nunexpected error: %v", test.testName, err)
+		}
+		if !reflect.DeepEqual(secrets, test.expectedSecrets) {
+			t.Errorf("\nTestCase: %s\nExpected Secrets: %v\nActual Secrets: %v", test.testName, test.expectedSecrets, secrets)
+		}
+	}
+}
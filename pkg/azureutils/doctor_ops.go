@@ -0,0 +1,186 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/to"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/cloud-provider-azure/pkg/auth"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// Names of the doctor checks, in the order they run
+const (
+	DoctorCreateAccount     = "create storage account"
+	DoctorListKeys          = "list storage account keys"
+	DoctorCreateContainer   = "create and delete container"
+	DoctorUserDelegationKey = "get user delegation key"
+	DoctorDeleteAccount     = "delete storage account"
+)
+
+// DoctorCheck is one permission the driver's identity needs
+type DoctorCheck struct {
+	Name string
+	// Requires is the name of a check that must pass for this one to run
+	Requires string
+	Run      func(ctx context.Context) error
+}
+
+// DoctorOptions selects where the doctor checks create their temporary storage account
+type DoctorOptions struct {
+	// ResourceGroup defaults to the resource group of the cloud config
+	ResourceGroup string
+	// Location defaults to the location of the cloud config
+	Location string
+}
+
+// GetDoctorChecks returns the checks of the permissions the driver uses, in order.
+// They create a temporary storage account and delete it again in the last check.
+func GetDoctorChecks(cloud *azure.Cloud, opts DoctorOptions) []DoctorCheck {
+	subsID := cloud.SubscriptionID
+	resourceGroup := opts.ResourceGroup
+	if resourceGroup == "" {
+		resourceGroup = cloud.ResourceGroup
+	}
+	location := opts.Location
+	if location == "" {
+		location = cloud.Location
+	}
+	account := "cosidoctor" + rand.String(8)
+	containerName := "cosidoctor"
+	var key string
+
+	return []DoctorCheck{
+		{
+			Name: DoctorCreateAccount,
+			Run: func(ctx context.Context) error {
+				parameters := storage.AccountCreateParameters{
+					Sku:      &storage.Sku{Name: storage.SkuNameStandardLRS},
+					Kind:     storage.KindStorageV2,
+					Location: to.StringPtr(location),
+				}
+				err := withRetryError(ctx, subsID, "CreateStorageAccount", func() *retry.Error {
+					return cloud.StorageAccountClient.Create(ctx, subsID, resourceGroup, account, parameters)
+				})
+				if err != nil {
+					return fmt.Errorf("account %s in resource group %s: %v", account, resourceGroup, err.Error())
+				}
+				return nil
+			},
+		},
+		{
+			Name:     DoctorListKeys,
+			Requires: DoctorCreateAccount,
+			Run: func(ctx context.Context) (err error) {
+				key, err = getStorageAccountKey(ctx, subsID, account, resourceGroup, cloud)
+				return err
+			},
+		},
+		{
+			Name:     DoctorCreateContainer,
+			Requires: DoctorListKeys,
+			Run: func(ctx context.Context) error {
+				if _, err := createAzureContainer(ctx, account, key, containerName, nil); err != nil {
+					return err
+				}
+				return deleteAzureContainer(ctx, account, key, containerName)
+			},
+		},
+		{
+			Name:     DoctorUserDelegationKey,
+			Requires: DoctorCreateAccount,
+			Run: func(ctx context.Context) error {
+				return getUserDelegationKey(ctx, cloud, account)
+			},
+		},
+		{
+			Name:     DoctorDeleteAccount,
+			Requires: DoctorCreateAccount,
+			Run: func(ctx context.Context) error {
+				err := withRetryError(ctx, subsID, "DeleteStorageAccount", func() *retry.Error {
+					return cloud.StorageAccountClient.Delete(ctx, subsID, resourceGroup, account)
+				})
+				if err != nil {
+					return fmt.Errorf("delete the temporary account %s by hand: %v", account, err.Error())
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// RunDoctorChecks runs the checks in order and writes a pass/fail line for each of them.
+// A check whose requirement did not pass is skipped. It returns whether every check passed.
+func RunDoctorChecks(ctx context.Context, checks []DoctorCheck, w io.Writer) bool {
+	passed := map[string]bool{}
+	ok := true
+	for _, check := range checks {
+		if check.Requires != "" && !passed[check.Requires] {
+			ok = false
+			fmt.Fprintf(w, "SKIP  %s: requires %s\n", check.Name, check.Requires)
+			continue
+		}
+		if err := check.Run(ctx); err != nil {
+			ok = false
+			fmt.Fprintf(w, "FAIL  %s: %v\n", check.Name, err)
+			continue
+		}
+		passed[check.Name] = true
+		fmt.Fprintf(w, "PASS  %s\n", check.Name)
+	}
+	return ok
+}
+
+// getUserDelegationKey requests a user delegation key of the account with the identity of the cloud config
+func getUserDelegationKey(ctx context.Context, cloud *azure.Cloud, account string) error {
+	token, err := auth.GetServicePrincipalToken(&cloud.AzureAuthConfig, &cloud.Environment, cloud.Environment.ResourceIdentifiers.Storage)
+	if err != nil {
+		return err
+	}
+	client, err := service.NewClient(fmt.Sprintf("https://%s.%s/", account, getBlobDomain()), &tokenCredential{token: token}, &service.ClientOptions{ClientOptions: getClientOptions()})
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = client.GetUserDelegationCredential(ctx, service.KeyInfo{
+		Start:  to.StringPtr(now.Format(sas.TimeFormat)),
+		Expiry: to.StringPtr(now.Add(time.Hour).Format(sas.TimeFormat)),
+	}, nil)
+	return err
+}
+
+// tokenCredential adapts the service principal token of the cloud provider to the storage SDK
+type tokenCredential struct {
+	token *adal.ServicePrincipalToken
+}
+
+func (c *tokenCredential) GetToken(ctx context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if err := c.token.EnsureFreshWithContext(ctx); err != nil {
+		return azcore.AccessToken{}, err
+	}
+	t := c.token.Token()
+	return azcore.AccessToken{Token: t.AccessToken, ExpiresOn: t.Expires()}, nil
+}
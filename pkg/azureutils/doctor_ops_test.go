@@ -0,0 +1,120 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestRunDoctorChecks(t *testing.T) {
+	pass := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("AuthorizationFailed") }
+	tests := []struct {
+		testName       string
+		checks         []DoctorCheck
+		expectedOK     bool
+		expectedReport string
+	}{
+		{
+			testName:       "All pass",
+			checks:         []DoctorCheck{{Name: "a", Run: pass}, {Name: "b", Requires: "a", Run: pass}},
+			expectedOK:     true,
+			expectedReport: "PASS  a\nPASS  b\n",
+		},
+		{
+			testName: "Failed requirement skips",
+			checks: []DoctorCheck{
+				{Name: "a", Run: pass},
+				{Name: "b", Requires: "a", Run: fail},
+				{Name: "c", Requires: "b", Run: pass},
+				{Name: "d", Requires: "a", Run: pass},
+			},
+			expectedReport: "PASS  a\nFAIL  b: AuthorizationFailed\nSKIP  c: requires b\nPASS  d\n",
+		},
+	}
+	for _, test := range tests {
+		report := &bytes.Buffer{}
+		if ok := RunDoctorChecks(context.Background(), test.checks, report); ok != test.expectedOK || report.String() != test.expectedReport {
+			t.Errorf("\nTestCase: %s\nExpected: %v %q\nActual: %v %q", test.testName, test.expectedOK, test.expectedReport, ok, report.String())
+		}
+	}
+}
+
+func TestGetDoctorChecks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cloud := azure.GetTestCloud(ctrl)
+	cl := mockstorageaccountclient.NewMockInterface(ctrl)
+	cloud.StorageAccountClient = cl
+
+	var account string
+	cl.EXPECT().
+		Create(gomock.Any(), cloud.SubscriptionID, "doctor-rg", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, subsID, rg, name string, parameters storage.AccountCreateParameters) *retry.Error {
+			account = name
+			if to.String(parameters.Location) != "westus2" {
+				t.Errorf("unexpected location %s", to.String(parameters.Location))
+			}
+			return nil
+		})
+	cl.EXPECT().
+		ListKeys(gomock.Any(), gomock.Any(), "doctor-rg", gomock.Any()).
+		Return(storage.AccountListKeysResult{}, retry.GetError(&http.Response{StatusCode: http.StatusForbidden}, errors.New("AuthorizationFailed")))
+	cl.EXPECT().
+		Delete(gomock.Any(), gomock.Any(), "doctor-rg", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, subsID, rg, name string) *retry.Error {
+			if name != account {
+				t.Errorf("expected the created account %s to be deleted, got %s", account, name)
+			}
+			return nil
+		})
+
+	checks := GetDoctorChecks(cloud, DoctorOptions{ResourceGroup: "doctor-rg", Location: "westus2"})
+	// the user delegation key check needs Azure AD, the others run against the mock
+	filtered := []DoctorCheck{}
+	for _, check := range checks {
+		if check.Name != DoctorUserDelegationKey {
+			filtered = append(filtered, check)
+		}
+	}
+	report := &bytes.Buffer{}
+	if RunDoctorChecks(context.Background(), filtered, report) {
+		t.Errorf("expected the checks to fail")
+	}
+	expected := []string{
+		"PASS  " + DoctorCreateAccount,
+		"FAIL  " + DoctorListKeys,
+		"SKIP  " + DoctorCreateContainer + ": requires " + DoctorListKeys,
+		"PASS  " + DoctorDeleteAccount,
+	}
+	for _, line := range expected {
+		if !strings.Contains(report.String(), line) {
+			t.Errorf("\nExpected Line: %s\nActual Report: %s", line, report)
+		}
+	}
+	if !strings.HasPrefix(account, "cosidoctor") || len(account) > 24 {
+		t.Errorf("invalid storage account name %s", account)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/go-autorest/autorest"
+	autorestazure "github.com/Azure/go-autorest/autorest/azure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+var (
+	// cloud-provider-azure flattens retry.Error into a string, see retry.Error.Error
+	httpStatusCodeRE = regexp.MustCompile(`HTTPStatusCode: (\d{3})`)
+	// autorest and azcore both render the service error code into the message
+	serviceErrorCodeRE = regexp.MustCompile(`(?:Code="|ERROR CODE: )(\w+)`)
+)
+
+// serviceErrorCodes maps the error codes of the storage data plane and ARM to gRPC codes.
+// Codes not listed here are classified by their HTTP status.
+var serviceErrorCodes = map[string]codes.Code{
+	// conflicts
+	"ContainerAlreadyExists":      codes.AlreadyExists,
+	"ShareAlreadyExists":          codes.AlreadyExists,
+	"PathAlreadyExists":           codes.AlreadyExists,
+	"FilesystemAlreadyExists":     codes.AlreadyExists,
+	"StorageAccountAlreadyExists": codes.AlreadyExists,
+	"StorageAccountAlreadyTaken":  codes.AlreadyExists,
+	"ResourceAlreadyExists":       codes.AlreadyExists,
+	// transient conflicts, retrying later succeeds
+	"ContainerBeingDeleted":  codes.Unavailable,
+	"ShareBeingDeleted":      codes.Unavailable,
+	"FilesystemBeingDeleted": codes.Unavailable,
+	"ServerBusy":             codes.Unavailable,
+	"OperationTimedOut":      codes.DeadlineExceeded,
+	// missing resources
+	"ContainerNotFound":      codes.NotFound,
+	"ShareNotFound":          codes.NotFound,
+	"PathNotFound":           codes.NotFound,
+	"FilesystemNotFound":     codes.NotFound,
+	"ResourceNotFound":       codes.NotFound,
+	"ResourceGroupNotFound":  codes.NotFound,
+	"StorageAccountNotFound": codes.NotFound,
+	"SubscriptionNotFound":   codes.NotFound,
+	"ParentResourceNotFound": codes.NotFound,
+	// authorization
+	"AuthorizationFailure":               codes.PermissionDenied,
+	"AuthorizationFailed":                codes.PermissionDenied,
+	"AuthorizationPermissionMismatch":    codes.PermissionDenied,
+	"AuthorizationSourceIPMismatch":      codes.PermissionDenied,
+	"InsufficientAccountPermissions":     codes.PermissionDenied,
+	"KeyBasedAuthenticationNotPermitted": codes.PermissionDenied,
+	"LinkedAuthorizationFailed":          codes.PermissionDenied,
+	"AuthenticationFailed":               codes.Unauthenticated,
+	"InvalidAuthenticationInfo":          codes.Unauthenticated,
+	"ExpiredAuthenticationToken":         codes.Unauthenticated,
+	"InvalidAuthenticationToken":         codes.Unauthenticated,
+	// quotas
+	"QuotaExceeded":               codes.ResourceExhausted,
+	"StorageAccountCountExceeded": codes.ResourceExhausted,
+	"ShareSizeLimitReached":       codes.ResourceExhausted,
+	"ContainerQuotaExceeded":      codes.ResourceExhausted,
+	// state of the account
+	"AccountIsDisabled":   codes.FailedPrecondition,
+	"ContainerDisabled":   codes.FailedPrecondition,
+	"ConditionNotMet":     codes.FailedPrecondition,
+	"LeaseIdMissing":      codes.FailedPrecondition,
+	"FeatureNotEnabled":   codes.FailedPrecondition,
+	"FeatureNotSupported": codes.Unimplemented,
+	// bad requests
+	"AccountNameInvalid":                  codes.InvalidArgument,
+	"InvalidResourceName":                 codes.InvalidArgument,
+	"InvalidParameter":                    codes.InvalidArgument,
+	"InvalidRequestContent":               codes.InvalidArgument,
+	"InvalidQueryParameterValue":          codes.InvalidArgument,
+	"InvalidHeaderValue":                  codes.InvalidArgument,
+	"OutOfRangeInput":                     codes.InvalidArgument,
+	"InvalidResourceLocation":             codes.InvalidArgument,
+	"LocationNotAvailableForResourceType": codes.InvalidArgument,
+}
+
+// httpStatusToCode maps an HTTP status returned by Azure to a gRPC code
+func httpStatusToCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	if statusCode >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+// AzureErrorCode classifies an error returned by the Azure SDKs or cloud-provider-azure.
+// Errors that cannot be classified are Internal.
+func AzureErrorCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		return s.Code()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codes.DeadlineExceeded
+	}
+	if errors.Is(err, context.Canceled) {
+		return codes.Canceled
+	}
+
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		if code, ok := serviceErrorCodes[respErr.ErrorCode]; ok {
+			return code
+		}
+		if code := httpStatusToCode(respErr.StatusCode); code != codes.Unknown {
+			return code
+		}
+	}
+
+	var requestErr *autorestazure.RequestError
+	if errors.As(err, &requestErr) && requestErr.ServiceError != nil {
+		if code, ok := serviceErrorCodes[requestErr.ServiceError.Code]; ok {
+			return code
+		}
+	}
+	var detailedErr autorest.DetailedError
+	if errors.As(err, &detailedErr) {
+		if statusCode, ok := detailedErr.StatusCode.(int); ok {
+			if code := httpStatusToCode(statusCode); code != codes.Unknown {
+				return code
+			}
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return codes.DeadlineExceeded
+		}
+		return codes.Unavailable
+	}
+
+	// cloud-provider-azure returns errors flattened to strings
+	msg := err.Error()
+	if m := serviceErrorCodeRE.FindStringSubmatch(msg); m != nil {
+		if code, ok := serviceErrorCodes[m[1]]; ok {
+			return code
+		}
+	}
+	if m := httpStatusCodeRE.FindStringSubmatch(msg); m != nil {
+		statusCode, _ := strconv.Atoi(m[1])
+		if code := httpStatusToCode(statusCode); code != codes.Unknown {
+			return code
+		}
+	}
+	return codes.Internal
+}
+
+// retryErrorCode classifies a retry.Error returned by the cloud-provider-azure clients
+func retryErrorCode(rerr *retry.Error) codes.Code {
+	if rerr == nil {
+		return codes.OK
+	}
+	if rerr.RawError != nil {
+		if code := AzureErrorCode(rerr.RawError); code != codes.Internal {
+			return code
+		}
+	}
+	if code := httpStatusToCode(rerr.HTTPStatusCode); code != codes.Unknown {
+		return code
+	}
+	if rerr.Retriable {
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// newAzureError returns a status error with the formatted message and the code of err
+func newAzureError(err error, format string, a ...interface{}) error {
+	return status.Error(AzureErrorCode(err), fmt.Sprintf(format, a...))
+}
+
+// ToGRPCError converts an error returned to the COSI sidecar into a status error.
+// Status errors with a code are returned unchanged, any other error is classified.
+func ToGRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		return err
+	}
+	return status.Error(AzureErrorCode(err), err.Error())
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestHTTPStatusToCode(t *testing.T) {
+	tests := []struct {
+		statusCode   int
+		expectedCode codes.Code
+	}{
+		{statusCode: http.StatusBadRequest, expectedCode: codes.InvalidArgument},
+		{statusCode: http.StatusUnauthorized, expectedCode: codes.Unauthenticated},
+		{statusCode: http.StatusForbidden, expectedCode: codes.PermissionDenied},
+		{statusCode: http.StatusNotFound, expectedCode: codes.NotFound},
+		{statusCode: http.StatusConflict, expectedCode: codes.AlreadyExists},
+		{statusCode: http.StatusPreconditionFailed, expectedCode: codes.FailedPrecondition},
+		{statusCode: http.StatusTooManyRequests, expectedCode: codes.ResourceExhausted},
+		{statusCode: http.StatusServiceUnavailable, expectedCode: codes.Unavailable},
+		{statusCode: http.StatusGatewayTimeout, expectedCode: codes.DeadlineExceeded},
+		{statusCode: http.StatusInternalServerError, expectedCode: codes.Internal},
+		{statusCode: http.StatusOK, expectedCode: codes.Unknown},
+	}
+	for _, test := range tests {
+		if code := httpStatusToCode(test.statusCode); code != test.expectedCode {
+			t.Errorf("\nTestCase: %d\nExpected Code: %v\nActual Code: %v", test.statusCode, test.expectedCode, code)
+		}
+	}
+}
+
+func newTestResponseError(statusCode int, errorCode string) error {
+	req, _ := http.NewRequest(http.MethodPut, "https://validaccount.blob.core.windows.net/validcontainer", nil)
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{"X-Ms-Error-Code": []string{errorCode}},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	return runtime.NewResponseError(resp)
+}
+
+func TestAzureErrorCode(t *testing.T) {
+	tests := []struct {
+		testName     string
+		err          error
+		expectedCode codes.Code
+	}{
+		{
+			testName:     "No error",
+			err:          nil,
+			expectedCode: codes.OK,
+		},
+		{
+			testName:     "Status error",
+			err:          status.Error(codes.InvalidArgument, "bad"),
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			testName:     "Deadline exceeded",
+			err:          fmt.Errorf("wrapped: %w", context.DeadlineExceeded),
+			expectedCode: codes.DeadlineExceeded,
+		},
+		{
+			testName:     "Canceled",
+			err:          context.Canceled,
+			expectedCode: codes.Canceled,
+		},
+		{
+			testName:     "Response error with known error code",
+			err:          newTestResponseError(http.StatusConflict, "ContainerBeingDeleted"),
+			expectedCode: codes.Unavailable,
+		},
+		{
+			testName:     "Response error with unknown error code",
+			err:          newTestResponseError(http.StatusForbidden, "SomethingElse"),
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			testName:     "Flattened retry error",
+			err:          fmt.Errorf("Retriable: false, RetryAfter: 0s, HTTPStatusCode: 404, RawError: not found"),
+			expectedCode: codes.NotFound,
+		},
+		{
+			testName:     "Flattened service error code",
+			err:          fmt.Errorf(`storage.AccountsClient#Create: Failure sending request: StatusCode=409 -- Original Error: Code="StorageAccountAlreadyTaken"`),
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			testName:     "DNS error",
+			err:          &net.DNSError{Err: "no such host", Name: "validaccount.blob.core.windows.net"},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			testName:     "Unclassified error",
+			err:          fmt.Errorf("test error"),
+			expectedCode: codes.Internal,
+		},
+	}
+	for _, test := range tests {
+		if code := AzureErrorCode(test.err); code != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %v\nActual Code: %v", test.testName, test.expectedCode, code)
+		}
+	}
+}
+
+func TestRetryErrorCode(t *testing.T) {
+	tests := []struct {
+		testName     string
+		rerr         *retry.Error
+		expectedCode codes.Code
+	}{
+		{
+			testName:     "Not found",
+			rerr:         &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("not found")},
+			expectedCode: codes.NotFound,
+		},
+		{
+			testName:     "Retriable without status",
+			rerr:         &retry.Error{Retriable: true, RawError: fmt.Errorf("test error")},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			testName:     "Unclassified",
+			rerr:         &retry.Error{RawError: fmt.Errorf("test error")},
+			expectedCode: codes.Internal,
+		},
+	}
+	for _, test := range tests {
+		if code := retryErrorCode(test.rerr); code != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %v\nActual Code: %v", test.testName, test.expectedCode, code)
+		}
+	}
+}
+
+func TestToGRPCError(t *testing.T) {
+	tests := []struct {
+		testName    string
+		err         error
+		expectedErr error
+	}{
+		{
+			testName:    "No error",
+			err:         nil,
+			expectedErr: nil,
+		},
+		{
+			testName:    "Status error is unchanged",
+			err:         status.Error(codes.Aborted, "in progress"),
+			expectedErr: status.Error(codes.Aborted, "in progress"),
+		},
+		{
+			testName:    "Plain error is classified",
+			err:         fmt.Errorf("HTTPStatusCode: 429, RawError: throttled"),
+			expectedErr: status.Error(codes.ResourceExhausted, "HTTPStatusCode: 429, RawError: throttled"),
+		},
+	}
+	for _, test := range tests {
+		err := ToGRPCError(test.err)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// FilePublicDomain is the Azure Files domain of the public cloud, see getFileDomain
+	FilePublicDomain = "file.core.windows.net"
+
+	// DefaultShareQuotaGiB is the provisioned size of a share when sharequota is not set,
+	// the minimum size of a premium share
+	DefaultShareQuotaGiB = 100
+	// MaxStandardShareQuotaGiB is the largest share a standard account can hold without large file shares
+	MaxStandardShareQuotaGiB = 5120
+	// MaxShareQuotaGiB is the largest share Azure Files supports
+	MaxShareQuotaGiB = 102400
+)
+
+var shareAccessTiers = []string{"TransactionOptimized", "Hot", "Cool", "Premium"}
+
+func parseShareAccessTier(value string) (string, error) {
+	for _, tier := range shareAccessTiers {
+		if strings.EqualFold(value, tier) {
+			return tier, nil
+		}
+	}
+	return "", status.Error(codes.InvalidArgument, fmt.Sprintf("Share Access Tier %s is unsupported", value))
+}
+
+func parseShareProtocol(value string) (storage.EnabledProtocols, error) {
+	switch strings.ToLower(value) {
+	case strings.ToLower(string(storage.EnabledProtocolsSMB)), "":
+		return storage.EnabledProtocolsSMB, nil
+	case strings.ToLower(string(storage.EnabledProtocolsNFS)):
+		return storage.EnabledProtocolsNFS, nil
+	}
+	return "", status.Error(codes.InvalidArgument, fmt.Sprintf("Share Protocol %s is unsupported", value))
+}
+
+// validateFileShareParameters rejects fileshare parameters on other unit types and blob service
+// parameters on fileshares, then fills in the account settings a share needs
+func validateFileShareParameters(params *BucketClassParameters) error {
+	if params.bucketUnitType != constant.FileShare {
+		if params.shareQuota != 0 || params.shareAccessTier != "" || params.shareProtocol != "" {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("%s, %s and %s are only supported for BucketUnitType %s",
+				constant.ShareQuotaField, constant.ShareAccessTierField, constant.ShareProtocolField, constant.FileShare.String()))
+		}
+		return nil
+	}
+
+	if hasCORSParameters(params) {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("CORS parameters are not supported for BucketUnitType %s", constant.FileShare.String()))
+	}
+	if params.privateDNSZoneID != "" {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s is not supported for BucketUnitType %s", PrivateDNSZoneIDField, constant.FileShare.String()))
+	}
+
+	if params.shareProtocol == "" {
+		params.shareProtocol = storage.EnabledProtocolsSMB
+	}
+	if params.shareQuota == 0 {
+		params.shareQuota = DefaultShareQuotaGiB
+	}
+	if params.shareQuota < 0 || params.shareQuota > MaxShareQuotaGiB {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s %d must be between 1 and %d", constant.ShareQuotaField, params.shareQuota, MaxShareQuotaGiB))
+	}
+
+	// NFS shares are only offered on premium FileStorage accounts
+	if params.shareProtocol == storage.EnabledProtocolsNFS {
+		if params.storageAccountType == "" {
+			params.storageAccountType = string(storage.SkuNamePremiumLRS)
+		}
+		if !strings.HasPrefix(strings.ToLower(params.storageAccountType), "premium") {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s requires a premium %s", constant.ShareProtocolField, params.shareProtocol, StorageAccountTypeField))
+		}
+		params.kind = constant.FileStorage
+	}
+	if params.shareQuota > MaxStandardShareQuotaGiB && !strings.HasPrefix(strings.ToLower(params.storageAccountType), "premium") {
+		params.enableLargeFileShare = true
+	}
+	return nil
+}
+
+func getFileShareURL(account, share string) string {
+	return fmt.Sprintf("https://%s.%s/%s", account, getFileDomain(), share)
+}
+
+func createFileShareBucket(
+	ctx context.Context,
+	bucketName string,
+	parameters *BucketClassParameters,
+	cloud *azure.Cloud) (string, error) {
+	accOptions := getAccountOptions(parameters)
+	shareOptions := &fileclient.ShareOptions{
+		Name:       bucketName,
+		Protocol:   parameters.shareProtocol,
+		RequestGiB: parameters.shareQuota,
+		AccessTier: parameters.shareAccessTier,
+	}
+
+	subsID := cloud.SubscriptionID
+	if parameters.subscriptionID != "" {
+		subsID = parameters.subscriptionID
+	}
+
+	// CreateFileShare ensures the storage account exists before creating the share
+	var accountName string
+	err := withRetry(ctx, subsID, "CreateFileShare", func() (err error) {
+		accountName, _, err = cloud.CreateFileShare(ctx, accOptions, shareOptions)
+		return err
+	})
+	if err != nil {
+		return "", newAzureError(err, "Could not create file share %s: %v", bucketName, err)
+	}
+	if err := ensureAccountNetworking(ctx, subsID, accOptions.ResourceGroup, accountName, parameters, cloud); err != nil {
+		return "", newAzureError(err, "Could not configure network access of storage account %s: %v", accountName, err)
+	}
+
+	id := types.BucketID{
+		SubID:         subsID,
+		ResourceGroup: accOptions.ResourceGroup,
+		URL:           getFileShareURL(accountName, bucketName),
+		UnitType:      constant.FileShare.String(),
+		Protocol:      string(parameters.shareProtocol),
+	}
+	base64ID, err := id.Encode()
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("could not encode ID: %v", err))
+	}
+
+	return base64ID, nil
+}
+
+func DeleteFileShareBucket(
+	ctx context.Context,
+	bucketID *types.BucketID,
+	cloud *azure.Cloud) error {
+	storageAccountName := getStorageAccountNameFromContainerURL(bucketID.URL)
+	shareName := getContainerNameFromContainerURL(bucketID.URL)
+
+	err := withRetry(ctx, bucketID.SubID, "DeleteFileShare", func() error {
+		return cloud.DeleteFileShare(bucketID.SubID, bucketID.ResourceGroup, storageAccountName, shareName)
+	})
+	if err != nil {
+		return newAzureError(err, "Error deleting file share %s in storage account %s : %v", shareName, storageAccountName, err)
+	}
+	return nil
+}
+
+// getFileSharePermissions returns the share SAS permissions in the order the file service expects
+func getFileSharePermissions(parameters *BucketAccessClassParameters) string {
+	var b strings.Builder
+	if parameters.enableRead {
+		b.WriteRune('r')
+	}
+	if parameters.enableAdd || parameters.enableWrite {
+		b.WriteRune('c')
+	}
+	if parameters.enableWrite {
+		b.WriteRune('w')
+	}
+	if parameters.enableDelete {
+		b.WriteRune('d')
+	}
+	if parameters.enableList {
+		b.WriteRune('l')
+	}
+	return b.String()
+}
+
+// createFileShareSASURL signs a file service SAS for the share.
+// azblob only signs blob resources, so the string to sign is built here.
+// https://learn.microsoft.com/en-us/rest/api/storageservices/create-service-sas
+func createFileShareSASURL(ctx context.Context, id *types.BucketID, parameters *BucketAccessClassParameters, accountKey string) (string, string, error) {
+	if id.Protocol == string(storage.EnabledProtocolsNFS) {
+		return "", "", status.Error(codes.InvalidArgument, "NFS file shares do not support SAS access")
+	}
+
+	account, share, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid credentials with error : decode account key: %v", err)
+	}
+
+	version := parameters.signedversion
+	if version == "" {
+		version = sas.Version
+	}
+	start := time.Now().UTC()
+	expiry := start.Add(time.Millisecond * time.Duration(parameters.validationPeriod))
+	permissions := getFileSharePermissions(parameters)
+	ipRange := parameters.signedIP.String()
+
+	stringToSign := strings.Join([]string{
+		permissions,
+		start.Format(sas.TimeFormat),
+		expiry.Format(sas.TimeFormat),
+		fmt.Sprintf("/file/%s/%s", account, share),
+		"", // signed identifier
+		ipRange,
+		string(parameters.signedProtocol),
+		version,
+		"", "", "", "", "", // response headers
+	}, "\n")
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	query := url.Values{}
+	query.Set("sv", version)
+	query.Set("st", start.Format(sas.TimeFormat))
+	query.Set("se", expiry.Format(sas.TimeFormat))
+	query.Set("sr", "s")
+	query.Set("sp", permissions)
+	if parameters.signedProtocol != "" {
+		query.Set("spr", string(parameters.signedProtocol))
+	}
+	if ipRange != "" {
+		query.Set("sip", ipRange)
+	}
+	query.Set("sig", signature)
+
+	klog.Infof("Created SAS for file share %s in storage account %s", share, account)
+	accountID := fmt.Sprintf("https://%s.%s/", account, getFileDomain())
+	sasURL := fmt.Sprintf("%s?%s", getFileShareURL(account, share), query.Encode())
+	return sasURL, accountID, nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/fileclient/mockfileclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func TestParseFileShareParameters(t *testing.T) {
+	tests := []struct {
+		testName       string
+		parameters     map[string]string
+		expectedErr    error
+		expectedParams BucketClassParameters
+	}{
+		{
+			testName:   "Defaults",
+			parameters: map[string]string{constant.BucketUnitTypeField: constant.FileShare.String()},
+			expectedParams: BucketClassParameters{
+				bucketUnitType: constant.FileShare,
+				shareQuota:     DefaultShareQuotaGiB,
+				shareProtocol:  storage.EnabledProtocolsSMB,
+			},
+		},
+		{
+			testName: "Large standard share",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:  constant.FileShare.String(),
+				constant.ShareQuotaField:      "10240",
+				constant.ShareAccessTierField: "cool",
+			},
+			expectedParams: BucketClassParameters{
+				bucketUnitType:       constant.FileShare,
+				shareQuota:           10240,
+				shareAccessTier:      "Cool",
+				shareProtocol:        storage.EnabledProtocolsSMB,
+				enableLargeFileShare: true,
+			},
+		},
+		{
+			testName: "NFS share",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.FileShare.String(),
+				constant.ShareProtocolField:  "nfs",
+			},
+			expectedParams: BucketClassParameters{
+				bucketUnitType:     constant.FileShare,
+				shareQuota:         DefaultShareQuotaGiB,
+				shareProtocol:      storage.EnabledProtocolsNFS,
+				storageAccountType: string(storage.SkuNamePremiumLRS),
+				kind:               constant.FileStorage,
+			},
+		},
+		{
+			testName: "NFS share on standard account",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.FileShare.String(),
+				constant.ShareProtocolField:  "NFS",
+				StorageAccountTypeField:      "Standard_LRS",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s requires a premium %s", constant.ShareProtocolField, storage.EnabledProtocolsNFS, StorageAccountTypeField)),
+		},
+		{
+			testName: "Quota too large",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.FileShare.String(),
+				constant.ShareQuotaField:     "102401",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s %d must be between 1 and %d", constant.ShareQuotaField, 102401, MaxShareQuotaGiB)),
+		},
+		{
+			testName: "Invalid quota",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.FileShare.String(),
+				constant.ShareQuotaField:     "0",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s must be positive", constant.ShareQuotaField, "0")),
+		},
+		{
+			testName: "Invalid access tier",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:  constant.FileShare.String(),
+				constant.ShareAccessTierField: "archive",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Share Access Tier %s is unsupported", "archive")),
+		},
+		{
+			testName: "Share parameters on container",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.Container.String(),
+				constant.ShareQuotaField:     "100",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s, %s and %s are only supported for BucketUnitType %s",
+				constant.ShareQuotaField, constant.ShareAccessTierField, constant.ShareProtocolField, constant.FileShare.String())),
+		},
+		{
+			testName: "CORS on share",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:     constant.FileShare.String(),
+				constant.CORSAllowedOriginsField: "*",
+				constant.CORSAllowedMethodsField: "GET",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("CORS parameters are not supported for BucketUnitType %s", constant.FileShare.String())),
+		},
+	}
+	for _, test := range tests {
+		params, err := parseBucketClassParameters(test.parameters)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(*params, test.expectedParams) {
+			t.Errorf("\nTestCase: %s\nExpected Params: %+v\nActual Params: %+v", test.testName, test.expectedParams, params)
+		}
+	}
+}
+
+func TestGetFileSharePermissions(t *testing.T) {
+	tests := []struct {
+		testName      string
+		params        *BucketAccessClassParameters
+		expectedPerms string
+	}{
+		{
+			testName:      "Read and list",
+			params:        &BucketAccessClassParameters{enableRead: true, enableList: true},
+			expectedPerms: "rl",
+		},
+		{
+			testName:      "All",
+			params:        &BucketAccessClassParameters{enableRead: true, enableWrite: true, enableDelete: true, enableList: true},
+			expectedPerms: "rcwdl",
+		},
+		{
+			testName:      "Add only",
+			params:        &BucketAccessClassParameters{enableAdd: true},
+			expectedPerms: "c",
+		},
+	}
+	for _, test := range tests {
+		perms := getFileSharePermissions(test.params)
+		if perms != test.expectedPerms {
+			t.Errorf("\nTestCase: %s\nExpected Permissions: %v\nActual Permissions: %v", test.testName, test.expectedPerms, perms)
+		}
+	}
+}
+
+func TestCreateFileShareSASURL(t *testing.T) {
+	key := []byte{1, 2, 3, 4}
+	params := &BucketAccessClassParameters{enableRead: true, enableList: true, validationPeriod: 1000, signedProtocol: sas.ProtocolHTTPS}
+	id := &types.BucketID{URL: "https://validaccount.file.core.windows.net/share", UnitType: constant.FileShare.String(), Protocol: string(storage.EnabledProtocolsSMB)}
+
+	sasURL, accountID, err := createFileShareSASURL(context.Background(), id, params, base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if accountID != "https://validaccount.file.core.windows.net/" {
+		t.Errorf("unexpected account ID: %s", accountID)
+	}
+	u, err := url.Parse(sasURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	query := u.Query()
+	if u.Host != "validaccount.file.core.windows.net" || u.Path != "/share" || query.Get("sr") != "s" || query.Get("sp") != "rl" || query.Get("spr") != "https" {
+		t.Errorf("unexpected SAS URL: %s", sasURL)
+	}
+
+	stringToSign := strings.Join([]string{
+		"rl", query.Get("st"), query.Get("se"), "/file/validaccount/share", "", "", "https", sas.Version, "", "", "", "", "",
+	}, "\n")
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	if expected := base64.StdEncoding.EncodeToString(h.Sum(nil)); query.Get("sig") != expected {
+		t.Errorf("unexpected signature: expected %s, got %s", expected, query.Get("sig"))
+	}
+
+	id.Protocol = string(storage.EnabledProtocolsNFS)
+	_, _, err = createFileShareSASURL(context.Background(), id, params, base64.StdEncoding.EncodeToString(key))
+	if expectedErr := status.Error(codes.InvalidArgument, "NFS file shares do not support SAS access"); !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("\nExpected Error: %v\nActual Error: %v", expectedErr, err)
+	}
+}
+
+func TestDeleteFileShareBucket(t *testing.T) {
+	tests := []struct {
+		testName    string
+		deleteErr   error
+		expectedErr error
+	}{
+		{
+			testName:    "Share deleted",
+			deleteErr:   nil,
+			expectedErr: nil,
+		},
+		{
+			testName:    "Delete fails",
+			deleteErr:   fmt.Errorf("test error"),
+			expectedErr: status.Error(codes.Internal, fmt.Sprintf("Error deleting file share %s in storage account %s : %v", "share", constant.ValidAccount, fmt.Errorf("test error"))),
+		},
+	}
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		cloud := azure.GetTestCloud(ctrl)
+		fileClient := mockfileclient.NewMockInterface(ctrl)
+		fileClient.EXPECT().WithSubscriptionID(gomock.Any()).Return(fileClient).AnyTimes()
+		fileClient.EXPECT().DeleteFileShare("rg", constant.ValidAccount, "share").Return(test.deleteErr).Times(1)
+		cloud.FileClient = fileClient
+
+		id := &types.BucketID{SubID: "subs", ResourceGroup: "rg", URL: "https://validaccount.file.core.windows.net/share", UnitType: constant.FileShare.String()}
+		err := DeleteFileShareBucket(context.Background(), id, cloud)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		ctrl.Finish()
+	}
+}
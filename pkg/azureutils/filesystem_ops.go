@@ -0,0 +1,656 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// DFSPublicDomain is the Data Lake Storage Gen2 domain of the public cloud, see getDFSDomain
+	DFSPublicDomain = "dfs.core.windows.net"
+
+	dfsAPIVersion = "2021-06-08"
+)
+
+var (
+	aclEntryRE = regexp.MustCompile(`^(default:)?(user|group|mask|other):[^:]*:[r-][w-][x-]$`)
+)
+
+// dfsClient is a minimal client for the Data Lake Storage Gen2 path APIs the driver needs.
+// azblob only speaks the blob endpoint, which cannot create directories or manage ACLs.
+type dfsClient struct {
+	accountName string
+	accountKey  []byte
+	endpoint    string
+	httpClient  *http.Client
+}
+
+func newDFSClient(storageAccount, accessKey string) (*dfsClient, error) {
+	key, err := base64.StdEncoding.DecodeString(accessKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid credentials with error : decode account key: %v", err)
+	}
+	return &dfsClient{
+		accountName: storageAccount,
+		accountKey:  key,
+		endpoint:    fmt.Sprintf("https://%s.%s", storageAccount, getDFSDomain()),
+		httpClient:  getHTTPClient(),
+	}, nil
+}
+
+// stringToSign builds the SharedKey string to sign of a request
+// https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (c *dfsClient) stringToSign(req *http.Request) string {
+	headers := req.Header
+	contentLength := headers.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+
+	msHeaders := []string{}
+	for k := range headers {
+		name := strings.ToLower(strings.TrimSpace(k))
+		if strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name)
+		}
+	}
+	sort.Strings(msHeaders)
+	canonicalizedHeaders := make([]string, 0, len(msHeaders))
+	for _, name := range msHeaders {
+		canonicalizedHeaders = append(canonicalizedHeaders, name+":"+strings.Join(headers.Values(name), ","))
+	}
+
+	resource := "/" + c.accountName + req.URL.EscapedPath()
+	if req.URL.Path == "" {
+		resource += "/"
+	}
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		headers.Get("Content-Encoding"),
+		headers.Get("Content-Language"),
+		contentLength,
+		headers.Get("Content-MD5"),
+		headers.Get("Content-Type"),
+		"", // x-ms-date is always set
+		headers.Get("If-Modified-Since"),
+		headers.Get("If-Match"),
+		headers.Get("If-None-Match"),
+		headers.Get("If-Unmodified-Since"),
+		headers.Get("Range"),
+		strings.Join(canonicalizedHeaders, "\n"),
+		resource,
+	}, "\n")
+}
+
+// do sends a signed request, retrying throttled and failed requests with backoff
+func (c *dfsClient) do(ctx context.Context, method, path string, query url.Values, headers map[string]string) (*http.Response, error) {
+	var resp *http.Response
+	err := withDataPlaneRetry(ctx, fmt.Sprintf("%s %s", method, path), func() (err error) {
+		resp, err = c.doOnce(ctx, method, path, query, headers)
+		return err
+	})
+	return resp, err
+}
+
+func (c *dfsClient) doOnce(ctx context.Context, method, path string, query url.Values, headers map[string]string) (*http.Response, error) {
+	reqURL := c.endpoint + "/" + strings.TrimPrefix(path, "/")
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", dfsAPIVersion)
+
+	h := hmac.New(sha256.New, c.accountKey)
+	h.Write([]byte(c.stringToSign(req)))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", c.accountName, base64.StdEncoding.EncodeToString(h.Sum(nil))))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return resp, runtime.NewResponseError(resp)
+	}
+	return resp, nil
+}
+
+func getDFSPath(filesystem, directory string) string {
+	return filesystem + "/" + strings.Trim(directory, "/")
+}
+
+func (c *dfsClient) createDirectory(ctx context.Context, filesystem, directory string) error {
+	resp, err := c.do(ctx, http.MethodPut, getDFSPath(filesystem, directory), url.Values{"resource": {"directory"}}, nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusConflict {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *dfsClient) getAccessControl(ctx context.Context, filesystem, directory string) (string, error) {
+	resp, err := c.do(ctx, http.MethodHead, getDFSPath(filesystem, directory), url.Values{"action": {"getAccessControl"}}, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("x-ms-acl"), nil
+}
+
+func (c *dfsClient) setAccessControl(ctx context.Context, filesystem, directory, owner, group, acl string) error {
+	headers := map[string]string{}
+	if owner != "" {
+		headers["x-ms-owner"] = owner
+	}
+	if group != "" {
+		headers["x-ms-group"] = group
+	}
+	if acl != "" {
+		headers["x-ms-acl"] = acl
+	}
+	resp, err := c.do(ctx, http.MethodPatch, getDFSPath(filesystem, directory), url.Values{"action": {"setAccessControl"}}, headers)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// setAccessControlRecursiveResponse is the outcome of one batch of a recursive ACL update
+type setAccessControlRecursiveResponse struct {
+	DirectoriesSuccessful int `json:"directoriesSuccessful"`
+	FilesSuccessful       int `json:"filesSuccessful"`
+	FailureCount          int `json:"failureCount"`
+	FailedEntries         []struct {
+		Name         string `json:"name"`
+		ErrorMessage string `json:"errorMessage"`
+	} `json:"failedEntries"`
+}
+
+// setAccessControlRecursive modifies, or removes, the ACL entries of the directory and everything below it.
+// Default entries only apply to directories. Batches are sent until the service has no continuation left.
+func (c *dfsClient) setAccessControlRecursive(ctx context.Context, filesystem, directory, mode, acl string) error {
+	continuation := ""
+	for {
+		query := url.Values{"action": {"setAccessControlRecursive"}, "mode": {mode}}
+		if continuation != "" {
+			query.Set("continuation", continuation)
+		}
+		resp, err := c.do(ctx, http.MethodPatch, getDFSPath(filesystem, directory), query, map[string]string{"x-ms-acl": acl})
+		if err != nil {
+			return err
+		}
+		result := setAccessControlRecursiveResponse{}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("could not decode the recursive ACL update: %v", err)
+		}
+		if result.FailureCount > 0 {
+			failed := []string{}
+			for _, entry := range result.FailedEntries {
+				failed = append(failed, fmt.Sprintf("%s: %s", entry.Name, entry.ErrorMessage))
+			}
+			return fmt.Errorf("could not update the ACL of %d paths: %s", result.FailureCount, strings.Join(failed, "; "))
+		}
+		continuation = resp.Header.Get("x-ms-continuation")
+		if continuation == "" {
+			return nil
+		}
+	}
+}
+
+func validateACL(acl string) error {
+	for _, entry := range strings.Split(acl, TagsDelimiter) {
+		if !aclEntryRE.MatchString(strings.TrimSpace(entry)) {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid ACL entry %s, must be formatted as [default:]<type>:[id]:<rwx>", entry))
+		}
+	}
+	return nil
+}
+
+func validateFilesystemParameters(params *BucketClassParameters) error {
+	if params.bucketUnitType == constant.Filesystem {
+		// only a blob private endpoint is wired, the dfs endpoint handed to workloads would stay public
+		if params.privateDNSZoneID != "" {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("%s is not supported for BucketUnitType %s", PrivateDNSZoneIDField, constant.Filesystem.String()))
+		}
+		return nil
+	}
+	if params.rootDirectory != "" || params.owner != "" || params.group != "" || params.acl != "" {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s, %s, %s and %s are only supported for BucketUnitType %s",
+			constant.RootDirectoryField, constant.OwnerField, constant.GroupField, constant.ACLField, constant.Filesystem.String()))
+	}
+	return nil
+}
+
+// setACLEntry adds, or replaces, the access and default ACL entries of a named user
+func setACLEntry(acl, principalID, permissions string) string {
+	entries, _ := removeACLEntry(acl, principalID)
+	list := []string{}
+	if entries != "" {
+		list = strings.Split(entries, TagsDelimiter)
+	}
+	list = append(list,
+		fmt.Sprintf("user:%s:%s", principalID, permissions),
+		fmt.Sprintf("default:user:%s:%s", principalID, permissions))
+	return strings.Join(list, TagsDelimiter)
+}
+
+// removeACLEntry drops the access and default ACL entries of a named user.
+// The boolean result reports whether any entry was removed.
+func removeACLEntry(acl, principalID string) (string, bool) {
+	prefix := fmt.Sprintf("user:%s:", principalID)
+	list := []string{}
+	removed := false
+	for _, entry := range strings.Split(acl, TagsDelimiter) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimPrefix(entry, "default:"), prefix) {
+			removed = true
+			continue
+		}
+		list = append(list, entry)
+	}
+	return strings.Join(list, TagsDelimiter), removed
+}
+
+// getACLEntrySpec returns the access and default entries of a named user without permissions,
+// as the recursive remove mode expects them
+func getACLEntrySpec(principalID string) string {
+	return fmt.Sprintf("user:%s,default:user:%s", principalID, principalID)
+}
+
+// getAncestors returns the paths above directory in the filesystem, from the root down
+func getAncestors(directory string) []string {
+	directory = strings.Trim(directory, "/")
+	if directory == "" {
+		return nil
+	}
+	ancestors := []string{""}
+	segments := strings.Split(directory, "/")
+	for i := 1; i < len(segments); i++ {
+		ancestors = append(ancestors, strings.Join(segments[:i], "/"))
+	}
+	return ancestors
+}
+
+// addTraverseEntry gives a named user execute permission in an access ACL, keeping any permissions it already has.
+// The boolean result reports whether the ACL was changed.
+func addTraverseEntry(acl, principalID string) (string, bool) {
+	prefix := fmt.Sprintf("user:%s:", principalID)
+	list := []string{}
+	found, changed := false, false
+	for _, entry := range strings.Split(acl, TagsDelimiter) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.HasPrefix(entry, prefix) && len(entry) == len(prefix)+3 {
+			found = true
+			if entry[len(entry)-1] != 'x' {
+				entry = entry[:len(entry)-1] + "x"
+				changed = true
+			}
+		}
+		list = append(list, entry)
+	}
+	if !found {
+		list = append(list, prefix+"--x")
+		changed = true
+	}
+	return strings.Join(list, TagsDelimiter), changed
+}
+
+// removeTraverseEntry drops the execute only access entry of a named user added by addTraverseEntry.
+// Entries with more permissions were set by someone else and are kept.
+func removeTraverseEntry(acl, principalID string) (string, bool) {
+	traverse := fmt.Sprintf("user:%s:--x", principalID)
+	list := []string{}
+	removed := false
+	for _, entry := range strings.Split(acl, TagsDelimiter) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == traverse {
+			removed = true
+			continue
+		}
+		list = append(list, entry)
+	}
+	return strings.Join(list, TagsDelimiter), removed
+}
+
+// getACLPermissions maps the BucketAccessClass permissions onto a POSIX permission string
+func getACLPermissions(params *BucketAccessClassParameters) string {
+	perms := []byte("---")
+	if params.enableRead {
+		perms[0] = 'r'
+	}
+	if params.enableWrite || params.enableAdd || params.enableDelete {
+		perms[1] = 'w'
+	}
+	if params.enableList || params.enableRead {
+		perms[2] = 'x'
+	}
+	return string(perms)
+}
+
+func getFilesystemURL(storageAccount, filesystem, directory string) string {
+	u := fmt.Sprintf("https://%s.%s/%s", storageAccount, getDFSDomain(), filesystem)
+	if directory != "" {
+		u += "/" + directory
+	}
+	return u
+}
+
+func createFilesystemBucket(
+	ctx context.Context,
+	bucketName string,
+	parameters *BucketClassParameters,
+	cloud *azure.Cloud) (string, error) {
+	accOptions := getAccountOptions(parameters)
+	subsID := cloud.SubscriptionID
+	if parameters.subscriptionID != "" {
+		subsID = parameters.subscriptionID
+	}
+
+	var key string
+	err := withRetry(ctx, subsID, "EnsureStorageAccount", func() (err error) {
+		_, key, err = cloud.EnsureStorageAccount(ctx, accOptions, "")
+		return err
+	})
+	if err != nil {
+		return "", newAzureError(err, "Could not ensure storage account %s exists: %v", accOptions.Name, err)
+	}
+	if err := ensureAccountNetworking(ctx, subsID, parameters.resourceGroup, parameters.storageAccountName, parameters, cloud); err != nil {
+		return "", newAzureError(err, "Could not configure network access of storage account %s: %v", parameters.storageAccountName, err)
+	}
+
+	// On a hierarchical namespace account a blob container is the filesystem
+	if _, err := createAzureContainer(ctx, parameters.storageAccountName, key, bucketName, map[string]string{}); err != nil {
+		return "", err
+	}
+
+	client, err := newDFSClient(parameters.storageAccountName, key)
+	if err != nil {
+		return "", err
+	}
+	if parameters.rootDirectory != "" {
+		klog.Infof("Creating root directory %s in filesystem %s", parameters.rootDirectory, bucketName)
+		if err := client.createDirectory(ctx, bucketName, parameters.rootDirectory); err != nil {
+			return "", newAzureError(err, "Error creating directory %s in filesystem %s : %v", parameters.rootDirectory, bucketName, err)
+		}
+	}
+	if parameters.owner != "" || parameters.group != "" || parameters.acl != "" {
+		if err := client.setAccessControl(ctx, bucketName, parameters.rootDirectory, parameters.owner, parameters.group, parameters.acl); err != nil {
+			return "", newAzureError(err, "Error setting access control of filesystem %s : %v", bucketName, err)
+		}
+	}
+
+	id := types.BucketID{
+		SubID:         subsID,
+		ResourceGroup: parameters.resourceGroup,
+		URL:           getFilesystemURL(parameters.storageAccountName, bucketName, ""),
+		UnitType:      constant.Filesystem.String(),
+		Directory:     parameters.rootDirectory,
+	}
+	base64ID, err := id.Encode()
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("could not encode ID: %v", err))
+	}
+
+	return base64ID, nil
+}
+
+// createFilesystemSASURL signs a SAS for the filesystem, scoped to its root directory
+// and the pathprefix of the BucketAccessClass when they are set
+func createFilesystemSASURL(ctx context.Context, id *types.BucketID, parameters *BucketAccessClassParameters, accountKey string) (string, string, error) {
+	account, filesystem, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return "", "", err
+	}
+	directory := joinPath(id.Directory, parameters.pathPrefix)
+	return createDirectorySASURL(ctx, account, filesystem, directory, getDFSDomain(), parameters, accountKey)
+}
+
+// createDirectorySASURL signs a SAS for a container of a hierarchical namespace account.
+// A non empty directory yields a directory SAS (sr=d) whose depth is the number of path segments.
+func createDirectorySASURL(ctx context.Context, account, containerName, directory, domain string, parameters *BucketAccessClassParameters, accountKey string) (string, string, error) {
+	cred, err := container.NewSharedKeyCredential(account, accountKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	permission := sas.BlobPermissions{}
+	permission.Read = parameters.enableRead
+	permission.Add = parameters.enableAdd
+	permission.Create = parameters.enableWrite
+	permission.Write = parameters.enableWrite
+	permission.Delete = parameters.enableDelete
+	permission.List = parameters.enableList
+	permission.Execute = parameters.enableList
+	permission.Tag = parameters.enableTags
+
+	start := time.Now()
+	expiry := start.Add(time.Millisecond * time.Duration(parameters.validationPeriod))
+
+	sasQueryParams, err := sas.BlobSignatureValues{
+		Protocol:      parameters.signedProtocol,
+		StartTime:     start,
+		ExpiryTime:    expiry,
+		Permissions:   permission.String(),
+		IPRange:       parameters.signedIP,
+		Version:       parameters.signedversion,
+		ContainerName: containerName,
+		Directory:     directory,
+	}.SignWithSharedKey(cred)
+	if err != nil {
+		return "", "", err
+	}
+
+	accountID := fmt.Sprintf("https://%s.%s/", account, domain)
+	sasURL := fmt.Sprintf("%s%s?%s", accountID, joinPath(containerName, directory), sasQueryParams.Encode())
+	return sasURL, accountID, nil
+}
+
+func joinPath(elems ...string) string {
+	parts := []string{}
+	for _, elem := range elems {
+		if elem = strings.Trim(elem, "/"); elem != "" {
+			parts = append(parts, elem)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// GrantBucketIAMAccess adds access and default ACL entries for the principal named in the BucketAccessClass
+// to the root directory and everything below it, and execute to the directories above it. It returns
+// (accountID, endpoint, err). Only filesystem buckets support IAM grants.
+func GrantBucketIAMAccess(ctx context.Context, bucketID, accessName string, parameters map[string]string, cloud *azure.Cloud) (string, string, error) {
+	id, err := types.DecodeToBucketID(bucketID)
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, fmt.Sprintf("could not decode ID: %v", err))
+	}
+	if id.UnitType != constant.Filesystem.String() {
+		return "", "", status.Error(codes.Unimplemented, "AuthenticationType IAM not implemented.")
+	}
+
+	bucketAccessClassParams, err := getBucketAccessClassParameters(parameters)
+	if err != nil {
+		return "", "", err
+	}
+	if err := checkBucketAccessClassPolicy(getPolicy(), bucketAccessClassParams, false); err != nil {
+		return "", "", err
+	}
+	if bucketAccessClassParams.principalID == "" {
+		return "", "", status.Error(codes.InvalidArgument, fmt.Sprintf("%s is required for AuthenticationType IAM", constant.PrincipalIDField))
+	}
+
+	account, filesystem, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := getStorageAccountKey(ctx, id.SubID, account, id.ResourceGroup, cloud)
+	if err != nil {
+		return "", "", err
+	}
+	client, err := newDFSClient(account, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	principalID := bucketAccessClassParams.principalID
+	// the principal needs execute on every directory above the root directory to reach it
+	for _, ancestor := range getAncestors(id.Directory) {
+		acl, err := client.getAccessControl(ctx, filesystem, ancestor)
+		if err != nil {
+			return "", "", newAzureError(err, "Error getting access control of filesystem %s : %v", filesystem, err)
+		}
+		if acl, changed := addTraverseEntry(acl, principalID); changed {
+			if err := client.setAccessControl(ctx, filesystem, ancestor, "", "", acl); err != nil {
+				return "", "", newAzureError(err, "Error setting access control of filesystem %s : %v", filesystem, err)
+			}
+		}
+	}
+
+	// the access entries reach the existing paths below the root directory, the default entries the new ones
+	acl := setACLEntry("", principalID, getACLPermissions(bucketAccessClassParams))
+	klog.Infof("Granting %s access to filesystem %s", principalID, filesystem)
+	if err := client.setAccessControlRecursive(ctx, filesystem, id.Directory, "modify", acl); err != nil {
+		return "", "", newAzureError(err, "Error setting access control of filesystem %s : %v", filesystem, err)
+	}
+
+	return getIAMAccountID(principalID, account, filesystem, accessName), getFilesystemURL(account, filesystem, id.Directory), nil
+}
+
+// getIAMAccountID names the principal, the filesystem and the BucketAccess of an IAM grant, so that
+// BucketAccesses granting the same principal the same filesystem get AccountIds of their own
+func getIAMAccountID(principalID, account, filesystem, accessName string) string {
+	return IAMAccountIDPrefix + strings.Join([]string{principalID, account, filesystem, accessName}, "/")
+}
+
+// getIAMPrincipalID returns the principal of an IAM AccountId. AccountIds granted before they named
+// the BucketAccess are the bare principal.
+func getIAMPrincipalID(accountID string) string {
+	if !strings.HasPrefix(accountID, IAMAccountIDPrefix) {
+		return accountID
+	}
+	accountID = strings.TrimPrefix(accountID, IAMAccountIDPrefix)
+	if i := strings.Index(accountID, "/"); i >= 0 {
+		return accountID[:i]
+	}
+	return accountID
+}
+
+// GetIAMGrantScope returns the principal and filesystem of an IAM AccountId, the part BucketAccesses
+// sharing the same ACL entries have in common, or "" for other AccountIds
+func GetIAMGrantScope(accountID string) string {
+	if !strings.HasPrefix(accountID, IAMAccountIDPrefix) {
+		return ""
+	}
+	return accountID[:strings.LastIndex(accountID, "/")+1]
+}
+
+// RevokeBucketAccess removes the ACL entries added for accountID by GrantBucketIAMAccess, from the root
+// directory and everything below it, and the execute only entries of the directories above it.
+// SAS grants cannot be revoked individually, so other bucket unit types are left untouched.
+func RevokeBucketAccess(ctx context.Context, bucketID, accountID string, cloud *azure.Cloud) error {
+	id, err := types.DecodeToBucketID(bucketID)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("could not decode ID: %v", err))
+	}
+	if id.UnitType != constant.Filesystem.String() || accountID == "" || isSASAccountID(accountID) {
+		return nil
+	}
+
+	account, filesystem, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return err
+	}
+	key, err := getStorageAccountKey(ctx, id.SubID, account, id.ResourceGroup, cloud)
+	if err != nil {
+		return err
+	}
+	client, err := newDFSClient(account, key)
+	if err != nil {
+		return err
+	}
+
+	principalID := getIAMPrincipalID(accountID)
+	klog.Infof("Revoking %s access to filesystem %s", principalID, filesystem)
+	if err := client.setAccessControlRecursive(ctx, filesystem, id.Directory, "remove", getACLEntrySpec(principalID)); err != nil {
+		return newAzureError(err, "Error setting access control of filesystem %s : %v", filesystem, err)
+	}
+	for _, ancestor := range getAncestors(id.Directory) {
+		acl, err := client.getAccessControl(ctx, filesystem, ancestor)
+		if err != nil {
+			return newAzureError(err, "Error getting access control of filesystem %s : %v", filesystem, err)
+		}
+		if acl, removed := removeTraverseEntry(acl, principalID); removed {
+			if err := client.setAccessControl(ctx, filesystem, ancestor, "", "", acl); err != nil {
+				return newAzureError(err, "Error setting access control of filesystem %s : %v", filesystem, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,465 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const testPrincipalID = "00000000-0000-0000-0000-000000000001"
+
+func TestParseFilesystemParameters(t *testing.T) {
+	tests := []struct {
+		testName       string
+		parameters     map[string]string
+		expectedErr    error
+		expectedParams BucketClassParameters
+	}{
+		{
+			testName:       "Filesystem enables hierarchical namespace",
+			parameters:     map[string]string{constant.BucketUnitTypeField: constant.Filesystem.String()},
+			expectedParams: BucketClassParameters{bucketUnitType: constant.Filesystem, isHnsEnabled: true},
+		},
+		{
+			testName: "Filesystem with root directory and ACL",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.Filesystem.String(),
+				constant.RootDirectoryField:  "/data/team/",
+				constant.OwnerField:          testPrincipalID,
+				constant.GroupField:          "$superuser",
+				constant.ACLField:            "user::rwx,group::r-x,other::---",
+			},
+			expectedParams: BucketClassParameters{
+				bucketUnitType: constant.Filesystem,
+				isHnsEnabled:   true,
+				rootDirectory:  "data/team",
+				owner:          testPrincipalID,
+				group:          "$superuser",
+				acl:            "user::rwx,group::r-x,other::---",
+			},
+		},
+		{
+			testName: "Root directory on container",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.Container.String(),
+				constant.RootDirectoryField:  "data",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s, %s, %s and %s are only supported for BucketUnitType %s",
+				constant.RootDirectoryField, constant.OwnerField, constant.GroupField, constant.ACLField, constant.Filesystem.String())),
+		},
+		{
+			testName: "Invalid ACL",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.Filesystem.String(),
+				constant.ACLField:            "user::rwx,everyone::rwx",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid ACL entry %s, must be formatted as [default:]<type>:[id]:<rwx>", "everyone::rwx")),
+		},
+	}
+	for _, test := range tests {
+		params, err := parseBucketClassParameters(test.parameters)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(*params, test.expectedParams) {
+			t.Errorf("\nTestCase: %s\nExpected Params: %+v\nActual Params: %+v", test.testName, test.expectedParams, params)
+		}
+	}
+}
+
+func TestSetACLEntry(t *testing.T) {
+	tests := []struct {
+		testName    string
+		acl         string
+		expectedACL string
+	}{
+		{
+			testName:    "New entry",
+			acl:         "user::rwx,group::r-x,other::---",
+			expectedACL: "user::rwx,group::r-x,other::---,user:" + testPrincipalID + ":r-x,default:user:" + testPrincipalID + ":r-x",
+		},
+		{
+			testName:    "Replace entry",
+			acl:         "user::rwx,user:" + testPrincipalID + ":rwx,default:user:" + testPrincipalID + ":rwx,other::---",
+			expectedACL: "user::rwx,other::---,user:" + testPrincipalID + ":r-x,default:user:" + testPrincipalID + ":r-x",
+		},
+		{
+			testName:    "Empty ACL",
+			acl:         "",
+			expectedACL: "user:" + testPrincipalID + ":r-x,default:user:" + testPrincipalID + ":r-x",
+		},
+	}
+	for _, test := range tests {
+		acl := setACLEntry(test.acl, testPrincipalID, "r-x")
+		if acl != test.expectedACL {
+			t.Errorf("\nTestCase: %s\nExpected ACL: %v\nActual ACL: %v", test.testName, test.expectedACL, acl)
+		}
+	}
+}
+
+func TestRemoveACLEntry(t *testing.T) {
+	acl, removed := removeACLEntry("user::rwx,user:"+testPrincipalID+":rwx,default:user:"+testPrincipalID+":rwx,other::---", testPrincipalID)
+	if !removed || acl != "user::rwx,other::---" {
+		t.Errorf("expected entries of %s to be removed, got %s", testPrincipalID, acl)
+	}
+
+	acl, removed = removeACLEntry("user::rwx,other::---", testPrincipalID)
+	if removed || acl != "user::rwx,other::---" {
+		t.Errorf("expected ACL to be unchanged, got %s", acl)
+	}
+}
+
+func TestTraverseEntry(t *testing.T) {
+	tests := []struct {
+		testName        string
+		acl             string
+		expectedACL     string
+		expectedChanged bool
+	}{
+		{
+			testName:        "New entry",
+			acl:             "user::rwx,other::---",
+			expectedACL:     "user::rwx,other::---,user:" + testPrincipalID + ":--x",
+			expectedChanged: true,
+		},
+		{
+			testName:        "Entry without execute",
+			acl:             "user::rwx,user:" + testPrincipalID + ":r--",
+			expectedACL:     "user::rwx,user:" + testPrincipalID + ":r-x",
+			expectedChanged: true,
+		},
+		{
+			testName:        "Entry with execute",
+			acl:             "user::rwx,user:" + testPrincipalID + ":rwx,default:user:" + testPrincipalID + ":r--",
+			expectedACL:     "user::rwx,user:" + testPrincipalID + ":rwx,default:user:" + testPrincipalID + ":r--",
+			expectedChanged: false,
+		},
+	}
+	for _, test := range tests {
+		acl, changed := addTraverseEntry(test.acl, testPrincipalID)
+		if acl != test.expectedACL || changed != test.expectedChanged {
+			t.Errorf("\nTestCase: %s\nExpected ACL: %v, %v\nActual ACL: %v, %v", test.testName, test.expectedACL, test.expectedChanged, acl, changed)
+		}
+	}
+
+	acl, removed := removeTraverseEntry("user::rwx,user:"+testPrincipalID+":--x,other::---", testPrincipalID)
+	if !removed || acl != "user::rwx,other::---" {
+		t.Errorf("expected the execute only entry to be removed, got %s", acl)
+	}
+	acl, removed = removeTraverseEntry("user::rwx,user:"+testPrincipalID+":r-x", testPrincipalID)
+	if removed || acl != "user::rwx,user:"+testPrincipalID+":r-x" {
+		t.Errorf("expected an entry with more permissions to be kept, got %s", acl)
+	}
+}
+
+func TestGetAncestors(t *testing.T) {
+	tests := map[string][]string{
+		"":               nil,
+		"data":           {""},
+		"/data/team/a/":  {"", "data", "data/team"},
+		"data/team/logs": {"", "data", "data/team"},
+	}
+	for directory, expected := range tests {
+		if ancestors := getAncestors(directory); !reflect.DeepEqual(ancestors, expected) {
+			t.Errorf("expected ancestors %v of %q, got %v", expected, directory, ancestors)
+		}
+	}
+}
+
+func TestGetACLPermissions(t *testing.T) {
+	tests := []struct {
+		testName      string
+		params        *BucketAccessClassParameters
+		expectedPerms string
+	}{
+		{
+			testName:      "Read and list",
+			params:        &BucketAccessClassParameters{enableRead: true, enableList: true},
+			expectedPerms: "r-x",
+		},
+		{
+			testName:      "Write only",
+			params:        &BucketAccessClassParameters{enableWrite: true},
+			expectedPerms: "-w-",
+		},
+		{
+			testName:      "None",
+			params:        &BucketAccessClassParameters{},
+			expectedPerms: "---",
+		},
+	}
+	for _, test := range tests {
+		perms := getACLPermissions(test.params)
+		if perms != test.expectedPerms {
+			t.Errorf("\nTestCase: %s\nExpected Permissions: %v\nActual Permissions: %v", test.testName, test.expectedPerms, perms)
+		}
+	}
+}
+
+func TestDFSClient(t *testing.T) {
+	requests := []*http.Request{}
+	acl := "user::rwx,group::r-x,other::---"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey "+constant.ValidAccount+":") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/fs/exists":
+			w.Header().Set("x-ms-error-code", "PathAlreadyExists")
+			w.WriteHeader(http.StatusConflict)
+		case r.Method == http.MethodPut:
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodHead:
+			w.Header().Set("x-ms-acl", acl)
+		case r.Method == http.MethodPatch:
+			acl = r.Header.Get("x-ms-acl")
+		}
+	}))
+	defer server.Close()
+
+	client, err := newDFSClient(constant.ValidAccount, base64.StdEncoding.EncodeToString([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.endpoint = server.URL
+
+	ctx := context.Background()
+	if err := client.createDirectory(ctx, "fs", "data/team"); err != nil {
+		t.Errorf("createDirectory: unexpected error: %v", err)
+	}
+	if requests[0].URL.Path != "/fs/data/team" || requests[0].URL.Query().Get("resource") != "directory" {
+		t.Errorf("createDirectory: unexpected request %s %s", requests[0].Method, requests[0].URL)
+	}
+	if err := client.createDirectory(ctx, "fs", "exists"); err != nil {
+		t.Errorf("createDirectory: existing directory should not fail: %v", err)
+	}
+
+	if err := client.setAccessControl(ctx, "fs", "", "owner", "", "user::rwx"); err != nil {
+		t.Errorf("setAccessControl: unexpected error: %v", err)
+	}
+	last := requests[len(requests)-1]
+	if last.URL.Path != "/fs/" || last.URL.Query().Get("action") != "setAccessControl" || last.Header.Get("x-ms-owner") != "owner" || last.Header.Get("x-ms-group") != "" {
+		t.Errorf("setAccessControl: unexpected request %s %s %v", last.Method, last.URL, last.Header)
+	}
+
+	got, err := client.getAccessControl(ctx, "fs", "")
+	if err != nil || got != "user::rwx" {
+		t.Errorf("getAccessControl: expected user::rwx, got %s, %v", got, err)
+	}
+}
+
+func TestDFSClientStringToSign(t *testing.T) {
+	client := &dfsClient{accountName: constant.ValidAccount}
+	req, _ := http.NewRequest(http.MethodPatch, "https://validaccount.dfs.core.windows.net/fs/dir?action=setAccessControl", nil)
+	req.Header.Set("x-ms-version", dfsAPIVersion)
+	req.Header.Set("x-ms-date", "Mon, 02 Jan 2006 15:04:05 GMT")
+	req.Header.Set("x-ms-acl", "user::rwx")
+
+	expected := strings.Join([]string{
+		"PATCH", "", "", "", "", "", "", "", "", "", "", "",
+		"x-ms-acl:user::rwx\nx-ms-date:Mon, 02 Jan 2006 15:04:05 GMT\nx-ms-version:" + dfsAPIVersion,
+		"/validaccount/fs/dir\naction:setAccessControl",
+	}, "\n")
+	if got := client.stringToSign(req); got != expected {
+		t.Errorf("\nExpected:\n%s\nActual:\n%s", expected, got)
+	}
+}
+
+func TestCreateFilesystemSASURL(t *testing.T) {
+	tests := []struct {
+		testName      string
+		id            *types.BucketID
+		pathPrefix    string
+		expectedPath  string
+		expectedSR    string
+		expectedDepth string
+	}{
+		{
+			testName:     "Filesystem SAS",
+			id:           &types.BucketID{URL: "https://validaccount.dfs.core.windows.net/fs", UnitType: constant.Filesystem.String()},
+			expectedPath: "/fs",
+			expectedSR:   "c",
+		},
+		{
+			testName:      "Directory SAS",
+			id:            &types.BucketID{URL: "https://validaccount.dfs.core.windows.net/fs", UnitType: constant.Filesystem.String(), Directory: "data/team"},
+			expectedPath:  "/fs/data/team",
+			expectedSR:    "d",
+			expectedDepth: "2",
+		},
+		{
+			testName:      "Directory SAS with path prefix",
+			id:            &types.BucketID{URL: "https://validaccount.dfs.core.windows.net/fs", UnitType: constant.Filesystem.String(), Directory: "data/team"},
+			pathPrefix:    "app1",
+			expectedPath:  "/fs/data/team/app1",
+			expectedSR:    "d",
+			expectedDepth: "3",
+		},
+	}
+	key := base64.StdEncoding.EncodeToString([]byte{1, 2, 3, 4})
+	for _, test := range tests {
+		params := &BucketAccessClassParameters{enableRead: true, enableList: true, validationPeriod: 1000, pathPrefix: test.pathPrefix}
+		sasURL, accountID, err := createFilesystemSASURL(context.Background(), test.id, params, key)
+		if err != nil {
+			t.Errorf("\nTestCase: %s\nunexpected error: %v", test.testName, err)
+			continue
+		}
+		if accountID != "https://validaccount.dfs.core.windows.net/" {
+			t.Errorf("\nTestCase: %s\nunexpected account ID: %s", test.testName, accountID)
+		}
+		u, err := url.Parse(sasURL)
+		if err != nil {
+			t.Errorf("\nTestCase: %s\nunexpected error: %v", test.testName, err)
+			continue
+		}
+		if u.Path != test.expectedPath || u.Query().Get("sr") != test.expectedSR || u.Query().Get("sdd") != test.expectedDepth {
+			t.Errorf("\nTestCase: %s\nunexpected SAS URL: %s", test.testName, sasURL)
+		}
+	}
+}
+
+func TestGrantBucketIAMAccess(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cloud := azure.GetTestCloud(ctrl)
+
+	tests := []struct {
+		testName    string
+		id          *types.BucketID
+		params      map[string]string
+		expectedErr error
+	}{
+		{
+			testName:    "Container bucket",
+			id:          &types.BucketID{URL: constant.ValidContainerURL},
+			params:      map[string]string{},
+			expectedErr: status.Error(codes.Unimplemented, "AuthenticationType IAM not implemented."),
+		},
+		{
+			testName:    "Missing principal",
+			id:          &types.BucketID{URL: "https://validaccount.dfs.core.windows.net/fs", UnitType: constant.Filesystem.String()},
+			params:      map[string]string{},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s is required for AuthenticationType IAM", constant.PrincipalIDField)),
+		},
+	}
+	for _, test := range tests {
+		bucketID, _ := test.id.Encode()
+		_, _, err := GrantBucketIAMAccess(context.Background(), bucketID, "access", test.params, cloud)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+	}
+}
+
+func TestGrantAndRevokeFilesystemACL(t *testing.T) {
+	s := fakeazure.NewServer()
+	defer s.Close()
+	s.CreateAccount(fakeazure.SubscriptionID, fakeazure.ResourceGroup, "fakeaccount")
+
+	principal := "user:" + testPrincipalID
+	acls := map[string]string{
+		"/fs/":          "user::rwx,other::---",
+		"/fs/data":      "user::rwx,other::---," + principal + ":r-x",
+		"/fs/data/team": "user::rwx,other::---",
+	}
+	recursive := []string{}
+	dfs := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("action") {
+		case "getAccessControl":
+			w.Header().Set("x-ms-acl", acls[r.URL.Path])
+		case "setAccessControl":
+			acls[r.URL.Path] = r.Header.Get("x-ms-acl")
+		case "setAccessControlRecursive":
+			recursive = append(recursive, fmt.Sprintf("%s %s %s", r.URL.Path, r.URL.Query().Get("mode"), r.Header.Get("x-ms-acl")))
+			// the first batch leaves a continuation
+			if r.URL.Query().Get("continuation") == "" {
+				w.Header().Set("x-ms-continuation", "next")
+			}
+			fmt.Fprint(w, `{"directoriesSuccessful":1,"filesSuccessful":2,"failureCount":0,"failedEntries":[]}`)
+		}
+	}))
+	defer dfs.Close()
+	dialer := &net.Dialer{}
+	SetHTTPClient(&http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, dfs.Listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+	}})
+	defer SetHTTPClient(nil)
+
+	bucketID, _ := (&types.BucketID{
+		SubID:         fakeazure.SubscriptionID,
+		ResourceGroup: fakeazure.ResourceGroup,
+		URL:           "https://fakeaccount.dfs.core.windows.net/fs",
+		UnitType:      constant.Filesystem.String(),
+		Directory:     "data/team",
+	}).Encode()
+	params := map[string]string{constant.PrincipalIDField: testPrincipalID}
+	bucketAccessClassParams, _ := parseBucketAccessClassParameters(params)
+	perms := getACLPermissions(bucketAccessClassParams)
+
+	accountID, endpoint, err := GrantBucketIAMAccess(context.Background(), bucketID, "access", params, s.Cloud())
+	if err != nil {
+		t.Fatalf("unexpected error granting access: %v", err)
+	}
+	if accountID != IAMAccountIDPrefix+testPrincipalID+"/fakeaccount/fs/access" || endpoint != "https://fakeaccount.dfs.core.windows.net/fs/data/team" {
+		t.Errorf("unexpected grant %s %s", accountID, endpoint)
+	}
+	expectedACLs := map[string]string{
+		"/fs/":          "user::rwx,other::---," + principal + ":--x",
+		"/fs/data":      "user::rwx,other::---," + principal + ":r-x",
+		"/fs/data/team": "user::rwx,other::---",
+	}
+	if !reflect.DeepEqual(acls, expectedACLs) {
+		t.Errorf("expected execute on the directories above the root directory, got %v", acls)
+	}
+	modify := fmt.Sprintf("/fs/data/team modify %s:%s,default:%s:%s", principal, perms, principal, perms)
+	if !reflect.DeepEqual(recursive, []string{modify, modify}) {
+		t.Errorf("expected the root directory to be granted recursively, got %v", recursive)
+	}
+
+	recursive = []string{}
+	if err := RevokeBucketAccess(context.Background(), bucketID, accountID, s.Cloud()); err != nil {
+		t.Fatalf("unexpected error revoking access: %v", err)
+	}
+	expectedACLs["/fs/"] = "user::rwx,other::---"
+	if !reflect.DeepEqual(acls, expectedACLs) {
+		t.Errorf("expected only the execute entries added by the grant to be removed, got %v", acls)
+	}
+	remove := fmt.Sprintf("/fs/data/team remove %s,default:%s", principal, principal)
+	if !reflect.DeepEqual(recursive, []string{remove, remove}) {
+		t.Errorf("expected the root directory to be revoked recursively, got %v", recursive)
+	}
+}
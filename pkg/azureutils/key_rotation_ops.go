@@ -0,0 +1,171 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	// SigningKeyTag records on a storage account which of its keys signs new SAS, key1 when absent
+	SigningKeyTag = "cosi-sas-signing-key"
+	// ManagedTag marks the storage accounts the driver created, the only ones whose keys it rotates
+	ManagedTag = "cosi-managed"
+	// Key1 and Key2 are the names of the two keys of a storage account
+	Key1 = "key1"
+	Key2 = "key2"
+)
+
+var (
+	keyRotationLock    sync.RWMutex
+	keyRotationEnabled bool
+)
+
+// SetKeyRotation makes SAS be signed with the key named by the SigningKeyTag of the storage account.
+// Without it every SAS is signed with the first key, as returned by GetStorageAccesskey.
+func SetKeyRotation(enabled bool) {
+	keyRotationLock.Lock()
+	defer keyRotationLock.Unlock()
+	keyRotationEnabled = enabled
+}
+
+func isKeyRotationEnabled() bool {
+	keyRotationLock.RLock()
+	defer keyRotationLock.RUnlock()
+	return keyRotationEnabled
+}
+
+// getOtherKeyName returns the key that is not keyName
+func getOtherKeyName(keyName string) string {
+	if strings.EqualFold(keyName, Key2) {
+		return Key1
+	}
+	return Key2
+}
+
+// getAccountTags returns the tags of the storage account
+func getAccountTags(ctx context.Context, subsID, accountName, resourceGroup string, cloud *azure.Cloud) (map[string]*string, error) {
+	var account storage.Account
+	rerr := withRetryError(ctx, subsID, "GetStorageAccountProperties", func() (rerr *retry.Error) {
+		account, rerr = cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+		return rerr
+	})
+	if rerr != nil {
+		return nil, newAzureError(rerr.Error(), "Could not get storage account %s: %v", accountName, rerr.Error())
+	}
+	return account.Tags, nil
+}
+
+// getSigningKeyName returns the name of the key that signs new SAS for the storage account
+func getSigningKeyName(ctx context.Context, subsID, accountName, resourceGroup string, cloud *azure.Cloud) (string, error) {
+	tags, err := getAccountTags(ctx, subsID, accountName, resourceGroup, cloud)
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(to.String(tags[SigningKeyTag]), Key2) {
+		return Key2, nil
+	}
+	return Key1, nil
+}
+
+// IsManagedAccount tells whether the driver created the storage account, either tagged with the
+// ManagedTag or as a member of a storage account pool. The keys of other accounts belong to the user.
+func IsManagedAccount(ctx context.Context, subsID, resourceGroup, accountName string, cloud *azure.Cloud) (bool, error) {
+	tags, err := getAccountTags(ctx, subsID, accountName, resourceGroup, cloud)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(to.String(tags[ManagedTag]), TrueValue) || to.String(tags[StorageAccountPoolTag]) != "", nil
+}
+
+// getSASSigningKey returns the key new SAS of the storage account are signed with
+func getSASSigningKey(ctx context.Context, subsID, accountName, resourceGroup string, cloud *azure.Cloud) (string, error) {
+	if !isKeyRotationEnabled() {
+		return getStorageAccountKey(ctx, subsID, accountName, resourceGroup, cloud)
+	}
+
+	keyName, err := getSigningKeyName(ctx, subsID, accountName, resourceGroup, cloud)
+	if err != nil {
+		return "", err
+	}
+	var keys storage.AccountListKeysResult
+	rerr := withRetryError(ctx, subsID, "ListStorageAccountKeys", func() (rerr *retry.Error) {
+		keys, rerr = cloud.StorageAccountClient.ListKeys(ctx, subsID, resourceGroup, accountName)
+		return rerr
+	})
+	if rerr != nil {
+		return "", newAzureError(rerr.Error(), "Could not list keys of storage account %s: %v", accountName, rerr.Error())
+	}
+	for _, key := range valueOrEmpty(keys.Keys) {
+		if strings.EqualFold(to.String(key.KeyName), keyName) && to.String(key.Value) != "" {
+			return to.String(key.Value), nil
+		}
+	}
+	return "", status.Error(codes.NotFound, fmt.Sprintf("Storage account %s has no key %s", accountName, keyName))
+}
+
+// RotateAccountKey switches the storage account to signing new SAS with its other key, calls regrant to
+// reissue the SAS signed with the previous key and then regenerates the previous key, which revokes
+// every SAS still signed with it. The previous key is kept when regrant fails, the next rotation
+// switches back to it and tries again. It returns the key that now signs SAS.
+func RotateAccountKey(
+	ctx context.Context,
+	subsID,
+	resourceGroup,
+	accountName string,
+	cloud *azure.Cloud,
+	regrant func(ctx context.Context) error) (string, error) {
+	previous, err := getSigningKeyName(ctx, subsID, accountName, resourceGroup, cloud)
+	if err != nil {
+		return "", err
+	}
+	next := getOtherKeyName(previous)
+
+	klog.Infof("Switching storage account %s to signing SAS with %s", accountName, next)
+	rerr := withRetryError(ctx, subsID, "AddStorageAccountTags", func() *retry.Error {
+		return cloud.AddStorageAccountTags(ctx, subsID, resourceGroup, accountName, map[string]*string{SigningKeyTag: to.StringPtr(next)})
+	})
+	if rerr != nil {
+		return "", newAzureError(rerr.Error(), "Could not tag storage account %s: %v", accountName, rerr.Error())
+	}
+
+	if err := regrant(ctx); err != nil {
+		return next, err
+	}
+
+	client, err := newAccountsClient(cloud, subsID)
+	if err != nil {
+		return next, status.Error(codes.Internal, fmt.Sprintf("Could not create storage accounts client: %v", err))
+	}
+	klog.Infof("Regenerating %s of storage account %s", previous, accountName)
+	err = withRetry(ctx, subsID, "RegenerateStorageAccountKey", func() (err error) {
+		_, err = client.RegenerateKey(ctx, resourceGroup, accountName, storage.AccountRegenerateKeyParameters{KeyName: to.StringPtr(previous)})
+		return err
+	})
+	if err != nil {
+		return next, newAzureError(err, "Could not regenerate %s of storage account %s: %v", previous, accountName, err)
+	}
+	return next, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// newKeyRotationTestServer returns a fake with one storage account and key rotation enabled
+func newKeyRotationTestServer(t *testing.T) *fakeazure.Server {
+	s := fakeazure.NewServer()
+	s.CreateAccount(fakeazure.SubscriptionID, fakeazure.ResourceGroup, "account")
+	SetKeyRotation(true)
+	t.Cleanup(func() {
+		SetKeyRotation(false)
+		s.Close()
+	})
+	return s
+}
+
+func TestGetSASSigningKey(t *testing.T) {
+	ctx := context.Background()
+	s := newKeyRotationTestServer(t)
+	keys, _ := s.AccountKeys("account")
+
+	key, err := getSASSigningKey(ctx, fakeazure.SubscriptionID, "account", fakeazure.ResourceGroup, s.Cloud())
+	if err != nil || key != keys[0] {
+		t.Errorf("expected SAS to be signed with key1 by default, got %q: %v", key, err)
+	}
+
+	if rerr := s.Cloud().AddStorageAccountTags(ctx, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "account", map[string]*string{SigningKeyTag: to.StringPtr(Key2)}); rerr != nil {
+		t.Fatalf("unexpected error tagging the account: %v", rerr.Error())
+	}
+	key, err = getSASSigningKey(ctx, fakeazure.SubscriptionID, "account", fakeazure.ResourceGroup, s.Cloud())
+	if err != nil || key != keys[1] {
+		t.Errorf("expected SAS to be signed with key2 once tagged, got %q: %v", key, err)
+	}
+}
+
+func TestRotateAccountKey(t *testing.T) {
+	ctx := context.Background()
+	s := newKeyRotationTestServer(t)
+	before, _ := s.AccountKeys("account")
+
+	regranted := 0
+	next, err := RotateAccountKey(ctx, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "account", s.Cloud(), func(ctx context.Context) error {
+		// grants reissued by regrant must already be signed with the next key
+		key, err := getSASSigningKey(ctx, fakeazure.SubscriptionID, "account", fakeazure.ResourceGroup, s.Cloud())
+		if err != nil || key != before[1] {
+			t.Errorf("expected regrant to sign with key2, got %q: %v", key, err)
+		}
+		regranted++
+		return nil
+	})
+	if err != nil || next != Key2 {
+		t.Fatalf("expected rotation to key2, got %q: %v", next, err)
+	}
+	if regranted != 1 {
+		t.Errorf("expected regrant to be called once, got %d", regranted)
+	}
+	after, _ := s.AccountKeys("account")
+	if after[0] == before[0] || after[1] != before[1] {
+		t.Errorf("expected only key1 to be regenerated, keys went from %v to %v", before, after)
+	}
+
+	// the next rotation goes back to key1 and regenerates key2
+	if next, err = RotateAccountKey(ctx, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "account", s.Cloud(), func(context.Context) error { return nil }); err != nil || next != Key1 {
+		t.Fatalf("expected rotation to key1, got %q: %v", next, err)
+	}
+	again, _ := s.AccountKeys("account")
+	if again[0] != after[0] || again[1] == after[1] {
+		t.Errorf("expected only key2 to be regenerated, keys went from %v to %v", after, again)
+	}
+}
+
+func TestRotateAccountKeyRegrantFails(t *testing.T) {
+	ctx := context.Background()
+	s := newKeyRotationTestServer(t)
+	before, _ := s.AccountKeys("account")
+
+	regrantErr := errors.New("regrant failed")
+	next, err := RotateAccountKey(ctx, fakeazure.SubscriptionID, fakeazure.ResourceGroup, "account", s.Cloud(), func(context.Context) error { return regrantErr })
+	if !errors.Is(err, regrantErr) || next != Key2 {
+		t.Errorf("expected the regrant error after switching to key2, got %q: %v", next, err)
+	}
+	if after, _ := s.AccountKeys("account"); after[0] != before[0] || after[1] != before[1] {
+		t.Errorf("expected no key to be regenerated when regrant fails, keys went from %v to %v", before, after)
+	}
+}
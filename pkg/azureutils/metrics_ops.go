@@ -0,0 +1,104 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/preview/monitor/mgmt/2021-07-01-preview/insights"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"sigs.k8s.io/cloud-provider-azure/pkg/auth"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// UsedCapacityMetric is the Azure Monitor metric with the bytes stored in a storage account
+	UsedCapacityMetric = "UsedCapacity"
+	// usedCapacityWindow is how far back to look for a sample, storage capacity metrics are emitted hourly
+	usedCapacityWindow = 3 * time.Hour
+)
+
+// newMetricsClient returns the Azure Monitor client used to read storage metrics, tests replace it
+var newMetricsClient = func(cloud *azure.Cloud, subsID string) (*insights.MetricsClient, error) {
+	token, err := auth.GetServicePrincipalToken(&cloud.Config.AzureAuthConfig, &cloud.Environment, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not get service principal token: %v", err)
+	}
+	client := insights.NewMetricsClientWithBaseURI(cloud.Environment.ResourceManagerEndpoint, subsID)
+	client.Authorizer = autorest.NewBearerAuthorizer(token)
+	return &client, nil
+}
+
+// getUsedCapacity returns the latest UsedCapacity sample of the storage account with the resource ID, in bytes.
+// Accounts too new to have a sample yet report 0.
+func getUsedCapacity(ctx context.Context, client *insights.MetricsClient, subsID, resourceID string) (int64, error) {
+	resp, err := listCapacityMetrics(ctx, client, subsID, resourceID, UsedCapacityMetric, "", "")
+	if err != nil {
+		return 0, err
+	}
+
+	var used int64
+	for _, metric := range valueOrEmpty(resp.Value) {
+		for _, series := range valueOrEmpty(metric.Timeseries) {
+			used = getLatestAverage(series)
+		}
+	}
+	return used, nil
+}
+
+// listCapacityMetrics lists the hourly averages of the metrics of the resource over usedCapacityWindow
+func listCapacityMetrics(
+	ctx context.Context,
+	client *insights.MetricsClient,
+	subsID,
+	resourceID,
+	metricNames,
+	filter,
+	metricNamespace string) (insights.Response, error) {
+	end := time.Now().UTC()
+	timespan := fmt.Sprintf("%s/%s", end.Add(-usedCapacityWindow).Format(time.RFC3339), end.Format(time.RFC3339))
+
+	var resp insights.Response
+	err := withRetry(ctx, subsID, "ListMetrics", func() (err error) {
+		resp, err = client.List(ctx, strings.TrimPrefix(resourceID, "/"), timespan, to.StringPtr("PT1H"), metricNames,
+			string(insights.AggregationTypeAverage), nil, "", filter, insights.ResultTypeData, metricNamespace)
+		return err
+	})
+	if err != nil {
+		return resp, newAzureError(err, "Could not get %s of %s: %v", metricNames, resourceID, err)
+	}
+	return resp, nil
+}
+
+// getLatestAverage returns the last sample of the time series, 0 when it has none
+func getLatestAverage(series insights.TimeSeriesElement) int64 {
+	var latest *float64
+	for _, sample := range valueOrEmpty(series.Data) {
+		if sample.Average != nil {
+			latest = sample.Average
+		}
+	}
+	return int64(to.Float64(latest))
+}
+
+func valueOrEmpty[T any](values *[]T) []T {
+	if values == nil {
+		return nil
+	}
+	return *values
+}
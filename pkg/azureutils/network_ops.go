@@ -0,0 +1,362 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-08-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	"sigs.k8s.io/cloud-provider-azure/pkg/auth"
+	azclients "sigs.k8s.io/cloud-provider-azure/pkg/azureclients"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privatednszonegroupclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privateendpointclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	// BlobPrivateLinkGroupID is the private link sub-resource of the blob service
+	BlobPrivateLinkGroupID = "blob"
+	// BlobPrivateLinkDomain is the private link blob domain of the public cloud, see getBlobPrivateLinkDomain
+	BlobPrivateLinkDomain = "privatelink.blob.core.windows.net"
+	// BlobPublicDomain is the blob domain of the public cloud, see getBlobDomain
+	BlobPublicDomain = "blob.core.windows.net"
+
+	privateEndpointSuffix     = "-blob-pvtendpoint"
+	privateLinkConnSuffix     = "-blob-pvtsvcconn"
+	privateDNSZoneGroupSuffix = "-blob-dnszonegroup"
+)
+
+var (
+	networkBypassValues = map[string]storage.Bypass{
+		strings.ToLower(string(storage.BypassAzureServices)): storage.BypassAzureServices,
+		strings.ToLower(string(storage.BypassLogging)):       storage.BypassLogging,
+		strings.ToLower(string(storage.BypassMetrics)):       storage.BypassMetrics,
+		strings.ToLower(string(storage.BypassNone)):          storage.BypassNone,
+	}
+)
+
+// privateEndpointClients are the network clients needed to wire a blob private endpoint.
+// cloud-provider-azure keeps its own clients unexported and only ever links the file sub-resource,
+// so the driver builds its own from the cloud config, in the subscription of the storage account.
+type privateEndpointClients struct {
+	subscriptionID string
+	endpoints      privateendpointclient.Interface
+	zoneGroups     privatednszonegroupclient.Interface
+}
+
+var newPrivateEndpointClients = func(cloud *azure.Cloud, subsID string) (*privateEndpointClients, error) {
+	token, err := auth.GetServicePrincipalToken(&cloud.Config.AzureAuthConfig, &cloud.Environment, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not get service principal token: %v", err)
+	}
+	config := &azclients.ClientConfig{
+		CloudName:               cloud.Config.Cloud,
+		Location:                cloud.Config.Location,
+		SubscriptionID:          subsID,
+		ResourceManagerEndpoint: cloud.Environment.ResourceManagerEndpoint,
+		Authorizer:              autorest.NewBearerAuthorizer(token),
+		Backoff:                 &retry.Backoff{Steps: 1},
+		DisableAzureStackCloud:  cloud.Config.DisableAzureStackCloud,
+		UserAgent:               cloud.Config.UserAgent,
+	}
+	return &privateEndpointClients{
+		subscriptionID: subsID,
+		endpoints:      privateendpointclient.New(config.WithRateLimiter(cloud.Config.PrivateEndpointRateLimit)),
+		zoneGroups:     privatednszonegroupclient.New(config.WithRateLimiter(cloud.Config.PrivateDNSZoneGroupRateLimit)),
+	}, nil
+}
+
+func parseNetworkDefaultAction(value string) (storage.DefaultAction, error) {
+	switch strings.ToLower(value) {
+	case strings.ToLower(string(storage.DefaultActionAllow)):
+		return storage.DefaultActionAllow, nil
+	case strings.ToLower(string(storage.DefaultActionDeny)):
+		return storage.DefaultActionDeny, nil
+	}
+	return "", status.Error(codes.InvalidArgument, fmt.Sprintf("Network default action %s is unsupported", value))
+}
+
+func parseAllowedIPRanges(value string) ([]string, error) {
+	ranges := []string{}
+	for _, r := range strings.Split(value, TagsDelimiter) {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(r); err != nil && net.ParseIP(r) == nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid IP range %s, must be an IP address or CIDR", r))
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseNetworkBypass(value string) ([]storage.Bypass, error) {
+	bypass := []storage.Bypass{}
+	for _, b := range strings.Split(value, TagsDelimiter) {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+		v, ok := networkBypassValues[strings.ToLower(b)]
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Network bypass %s is unsupported", b))
+		}
+		bypass = append(bypass, v)
+	}
+	return bypass, nil
+}
+
+func validateNetworkParameters(params *BucketClassParameters) error {
+	if params.privateDNSZoneID != "" && !params.createPrivateEndpoint {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s requires %s to be true", PrivateDNSZoneIDField, CreatePrivateEndpointField))
+	}
+	if params.privateEndpointSubnetID != "" && params.privateDNSZoneID == "" {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s requires %s to be set", PrivateEndpointSubnetIDField, PrivateDNSZoneIDField))
+	}
+	return nil
+}
+
+// usesBlobPrivateEndpoint reports whether the driver, rather than cloud-provider-azure, wires the private endpoint
+func usesBlobPrivateEndpoint(params *BucketClassParameters) bool {
+	return params.createPrivateEndpoint && params.privateDNSZoneID != ""
+}
+
+func hasNetworkRuleParameters(params *BucketClassParameters) bool {
+	return params.networkDefaultAction != "" ||
+		len(params.allowedIPRanges) > 0 ||
+		len(params.networkBypass) > 0 ||
+		usesBlobPrivateEndpoint(params)
+}
+
+// getNetworkRuleSet merges the requested firewall settings into the existing rule set of the account,
+// keeping IP and virtual network rules added by other buckets.
+func getNetworkRuleSet(existing *storage.NetworkRuleSet, params *BucketClassParameters) *storage.NetworkRuleSet {
+	ruleSet := &storage.NetworkRuleSet{DefaultAction: storage.DefaultActionAllow}
+	if existing != nil {
+		ruleSet.DefaultAction = existing.DefaultAction
+		ruleSet.Bypass = existing.Bypass
+		ruleSet.IPRules = existing.IPRules
+		ruleSet.VirtualNetworkRules = existing.VirtualNetworkRules
+		ruleSet.ResourceAccessRules = existing.ResourceAccessRules
+	}
+
+	if params.networkDefaultAction != "" {
+		ruleSet.DefaultAction = params.networkDefaultAction
+	} else if usesBlobPrivateEndpoint(params) {
+		ruleSet.DefaultAction = storage.DefaultActionDeny
+	}
+
+	if len(params.networkBypass) > 0 {
+		bypass := make([]string, 0, len(params.networkBypass))
+		for _, b := range params.networkBypass {
+			bypass = append(bypass, string(b))
+		}
+		ruleSet.Bypass = storage.Bypass(strings.Join(bypass, ", "))
+	}
+
+	ipRules := []storage.IPRule{}
+	if ruleSet.IPRules != nil {
+		ipRules = append(ipRules, *ruleSet.IPRules...)
+	}
+	for _, r := range params.allowedIPRanges {
+		found := false
+		for _, existingRule := range ipRules {
+			if strings.EqualFold(to.String(existingRule.IPAddressOrRange), r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ipRules = append(ipRules, storage.IPRule{IPAddressOrRange: to.StringPtr(r), Action: storage.ActionAllow})
+		}
+	}
+	ruleSet.IPRules = &ipRules
+
+	vnetRules := []storage.VirtualNetworkRule{}
+	if ruleSet.VirtualNetworkRules != nil {
+		vnetRules = append(vnetRules, *ruleSet.VirtualNetworkRules...)
+	}
+	for _, id := range params.virtualNetworkResourceIDs {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		found := false
+		for _, existingRule := range vnetRules {
+			if strings.EqualFold(to.String(existingRule.VirtualNetworkResourceID), id) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			vnetRules = append(vnetRules, storage.VirtualNetworkRule{VirtualNetworkResourceID: to.StringPtr(id), Action: storage.ActionAllow})
+		}
+	}
+	ruleSet.VirtualNetworkRules = &vnetRules
+
+	return ruleSet
+}
+
+// getPrivateEndpointSubnetID returns the subnet the blob private endpoint is placed in,
+// defaulting to the cluster subnet from the cloud config. The cluster subnet is in the network
+// subscription of the cloud config, which may differ from the subscription of the storage account.
+func getPrivateEndpointSubnetID(params *BucketClassParameters, cloud *azure.Cloud) string {
+	if params.privateEndpointSubnetID != "" {
+		return params.privateEndpointSubnetID
+	}
+	vnetSubsID := cloud.Config.SubscriptionID
+	if cloud.Config.UsesNetworkResourceInDifferentSubscription() {
+		vnetSubsID = cloud.Config.NetworkResourceSubscriptionID
+	}
+	vnetResourceGroup := cloud.Config.ResourceGroup
+	if cloud.Config.VnetResourceGroup != "" {
+		vnetResourceGroup = cloud.Config.VnetResourceGroup
+	}
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s/subnets/%s",
+		vnetSubsID, vnetResourceGroup, cloud.Config.VnetName, cloud.Config.SubnetName)
+}
+
+// ensureAccountNetworking applies firewall rules to the storage account and, when a private DNS zone is given,
+// creates a blob private endpoint registered in that zone.
+func ensureAccountNetworking(
+	ctx context.Context,
+	subsID,
+	resourceGroup,
+	accountName string,
+	params *BucketClassParameters,
+	cloud *azure.Cloud) error {
+	if !hasNetworkRuleParameters(params) {
+		return nil
+	}
+	if cloud.StorageAccountClient == nil {
+		return fmt.Errorf("StorageAccountClient is nil")
+	}
+
+	var account storage.Account
+	rerr := withRetryError(ctx, subsID, "GetStorageAccountProperties", func() (rerr *retry.Error) {
+		account, rerr = cloud.StorageAccountClient.GetProperties(ctx, subsID, resourceGroup, accountName)
+		return rerr
+	})
+	if rerr != nil {
+		return status.Error(retryErrorCode(rerr), fmt.Sprintf("Failed to get the properties of storage account %s : %v", accountName, rerr.Error()))
+	}
+
+	var existing *storage.NetworkRuleSet
+	if account.AccountProperties != nil {
+		existing = account.AccountProperties.NetworkRuleSet
+	}
+	klog.Infof("Updating network rules of storage account %s", accountName)
+	update := storage.AccountUpdateParameters{
+		AccountPropertiesUpdateParameters: &storage.AccountPropertiesUpdateParameters{
+			NetworkRuleSet: getNetworkRuleSet(existing, params),
+		},
+	}
+	rerr = withRetryError(ctx, subsID, "UpdateStorageAccount", func() *retry.Error {
+		return cloud.StorageAccountClient.Update(ctx, subsID, resourceGroup, accountName, update)
+	})
+	if rerr != nil {
+		return status.Error(retryErrorCode(rerr), fmt.Sprintf("Failed to update network rules of storage account %s : %v", accountName, rerr.Error()))
+	}
+
+	if !usesBlobPrivateEndpoint(params) {
+		return nil
+	}
+
+	clients, err := newPrivateEndpointClients(cloud, subsID)
+	if err != nil {
+		return err
+	}
+	return ensureBlobPrivateEndpoint(ctx, clients, resourceGroup, accountName, account.ID, account.Location, getPrivateEndpointSubnetID(params, cloud), params.privateDNSZoneID)
+}
+
+func ensureBlobPrivateEndpoint(
+	ctx context.Context,
+	clients *privateEndpointClients,
+	resourceGroup,
+	accountName string,
+	accountID,
+	location *string,
+	subnetID,
+	privateDNSZoneID string) error {
+	endpointName := accountName + privateEndpointSuffix
+
+	rerr := withRetryError(ctx, clients.subscriptionID, "GetPrivateEndpoint", func() (rerr *retry.Error) {
+		_, rerr = clients.endpoints.Get(ctx, resourceGroup, endpointName, "")
+		return rerr
+	})
+	if rerr != nil && rerr.HTTPStatusCode != http.StatusNotFound {
+		return status.Error(retryErrorCode(rerr), fmt.Sprintf("Failed to get private endpoint %s : %v", endpointName, rerr.Error()))
+	}
+	if rerr != nil {
+		klog.Infof("Creating blob private endpoint %s for storage account %s", endpointName, accountName)
+		endpoint := network.PrivateEndpoint{
+			Location: location,
+			PrivateEndpointProperties: &network.PrivateEndpointProperties{
+				Subnet: &network.Subnet{ID: to.StringPtr(subnetID)},
+				PrivateLinkServiceConnections: &[]network.PrivateLinkServiceConnection{{
+					Name: to.StringPtr(accountName + privateLinkConnSuffix),
+					PrivateLinkServiceConnectionProperties: &network.PrivateLinkServiceConnectionProperties{
+						GroupIds:             &[]string{BlobPrivateLinkGroupID},
+						PrivateLinkServiceID: accountID,
+					},
+				}},
+			},
+		}
+		rerr := withRetryError(ctx, clients.subscriptionID, "CreatePrivateEndpoint", func() *retry.Error {
+			return clients.endpoints.CreateOrUpdate(ctx, resourceGroup, endpointName, endpoint, "", true)
+		})
+		if rerr != nil {
+			return status.Error(retryErrorCode(rerr), fmt.Sprintf("Failed to create private endpoint %s : %v", endpointName, rerr.Error()))
+		}
+	}
+
+	zoneName := privateDNSZoneID[strings.LastIndex(privateDNSZoneID, "/")+1:]
+	zoneGroup := network.PrivateDNSZoneGroup{
+		PrivateDNSZoneGroupPropertiesFormat: &network.PrivateDNSZoneGroupPropertiesFormat{
+			PrivateDNSZoneConfigs: &[]network.PrivateDNSZoneConfig{{
+				Name: to.StringPtr(zoneName),
+				PrivateDNSZonePropertiesFormat: &network.PrivateDNSZonePropertiesFormat{
+					PrivateDNSZoneID: to.StringPtr(privateDNSZoneID),
+				},
+			}},
+		},
+	}
+	rerr = withRetryError(ctx, clients.subscriptionID, "CreatePrivateDNSZoneGroup", func() *retry.Error {
+		return clients.zoneGroups.CreateOrUpdate(ctx, resourceGroup, endpointName, accountName+privateDNSZoneGroupSuffix, zoneGroup, "", true)
+	})
+	if rerr != nil {
+		return status.Error(retryErrorCode(rerr), fmt.Sprintf("Failed to link private endpoint %s to DNS zone %s : %v", endpointName, privateDNSZoneID, rerr.Error()))
+	}
+	return nil
+}
+
+// toPrivateLinkURL rewrites a public blob URL to the private link hostname of the account
+func toPrivateLinkURL(url string) string {
+	if strings.Contains(url, getBlobPrivateLinkDomain()) {
+		return url
+	}
+	return strings.Replace(url, "."+getBlobDomain(), "."+getBlobPrivateLinkDomain(), 1)
+}
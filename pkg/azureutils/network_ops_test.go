@@ -0,0 +1,340 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-08-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privatednszonegroupclient/mockprivatednszonegroupclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/privateendpointclient/mockprivateendpointclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient/mockstorageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	testPrivateDNSZoneID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/privateDnsZones/privatelink.blob.core.windows.net"
+	testSubnetID         = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+)
+
+func TestParseNetworkParameters(t *testing.T) {
+	tests := []struct {
+		testName       string
+		parameters     map[string]string
+		expectedErr    error
+		expectedParams BucketClassParameters
+	}{
+		{
+			testName:       "Default action deny",
+			parameters:     map[string]string{NetworkDefaultActionField: "deny"},
+			expectedParams: BucketClassParameters{networkDefaultAction: storage.DefaultActionDeny},
+		},
+		{
+			testName:    "Default action unsupported",
+			parameters:  map[string]string{NetworkDefaultActionField: "block"},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Network default action %s is unsupported", "block")),
+		},
+		{
+			testName:       "Allowed IP ranges",
+			parameters:     map[string]string{AllowedIPRangesField: "20.1.2.3, 20.1.0.0/16"},
+			expectedParams: BucketClassParameters{allowedIPRanges: []string{"20.1.2.3", "20.1.0.0/16"}},
+		},
+		{
+			testName:    "Allowed IP ranges invalid",
+			parameters:  map[string]string{AllowedIPRangesField: "20.1.2.3,foo"},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid IP range %s, must be an IP address or CIDR", "foo")),
+		},
+		{
+			testName:       "Bypass",
+			parameters:     map[string]string{NetworkBypassField: "azureservices,Metrics"},
+			expectedParams: BucketClassParameters{networkBypass: []storage.Bypass{storage.BypassAzureServices, storage.BypassMetrics}},
+		},
+		{
+			testName:    "Bypass unsupported",
+			parameters:  map[string]string{NetworkBypassField: "Everything"},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Network bypass %s is unsupported", "Everything")),
+		},
+		{
+			testName: "Private DNS zone",
+			parameters: map[string]string{
+				CreatePrivateEndpointField: TrueValue,
+				PrivateDNSZoneIDField:      testPrivateDNSZoneID,
+			},
+			expectedParams: BucketClassParameters{createPrivateEndpoint: true, privateDNSZoneID: testPrivateDNSZoneID},
+		},
+		{
+			testName:    "Private DNS zone without private endpoint",
+			parameters:  map[string]string{PrivateDNSZoneIDField: testPrivateDNSZoneID},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s requires %s to be true", PrivateDNSZoneIDField, CreatePrivateEndpointField)),
+		},
+		{
+			testName:    "Private endpoint subnet without private DNS zone",
+			parameters:  map[string]string{PrivateEndpointSubnetIDField: testSubnetID},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s requires %s to be set", PrivateEndpointSubnetIDField, PrivateDNSZoneIDField)),
+		},
+		{
+			testName: "Private DNS zone on a filesystem bucket",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField: constant.Filesystem.String(),
+				CreatePrivateEndpointField:   TrueValue,
+				PrivateDNSZoneIDField:        testPrivateDNSZoneID,
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s is not supported for BucketUnitType %s", PrivateDNSZoneIDField, constant.Filesystem.String())),
+		},
+	}
+	for _, test := range tests {
+		params, err := parseBucketClassParameters(test.parameters)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(*params, test.expectedParams) {
+			t.Errorf("\nTestCase: %s\nExpected Params: %+v\nActual Params: %+v", test.testName, test.expectedParams, params)
+		}
+	}
+}
+
+func TestGetNetworkRuleSet(t *testing.T) {
+	existing := &storage.NetworkRuleSet{
+		DefaultAction: storage.DefaultActionAllow,
+		IPRules:       &[]storage.IPRule{{IPAddressOrRange: to.StringPtr("20.1.2.3"), Action: storage.ActionAllow}},
+	}
+
+	tests := []struct {
+		testName        string
+		existing        *storage.NetworkRuleSet
+		params          *BucketClassParameters
+		expectedRuleSet *storage.NetworkRuleSet
+	}{
+		{
+			testName: "New rule set",
+			existing: nil,
+			params: &BucketClassParameters{
+				networkDefaultAction: storage.DefaultActionDeny,
+				allowedIPRanges:      []string{"20.1.0.0/16"},
+				networkBypass:        []storage.Bypass{storage.BypassAzureServices, storage.BypassLogging},
+			},
+			expectedRuleSet: &storage.NetworkRuleSet{
+				DefaultAction:       storage.DefaultActionDeny,
+				Bypass:              storage.Bypass("AzureServices, Logging"),
+				IPRules:             &[]storage.IPRule{{IPAddressOrRange: to.StringPtr("20.1.0.0/16"), Action: storage.ActionAllow}},
+				VirtualNetworkRules: &[]storage.VirtualNetworkRule{},
+			},
+		},
+		{
+			testName: "Existing rules are kept",
+			existing: existing,
+			params: &BucketClassParameters{
+				allowedIPRanges:           []string{"20.1.2.3", "20.2.0.0/16"},
+				virtualNetworkResourceIDs: []string{testSubnetID},
+			},
+			expectedRuleSet: &storage.NetworkRuleSet{
+				DefaultAction: storage.DefaultActionAllow,
+				IPRules: &[]storage.IPRule{
+					{IPAddressOrRange: to.StringPtr("20.1.2.3"), Action: storage.ActionAllow},
+					{IPAddressOrRange: to.StringPtr("20.2.0.0/16"), Action: storage.ActionAllow},
+				},
+				VirtualNetworkRules: &[]storage.VirtualNetworkRule{{VirtualNetworkResourceID: to.StringPtr(testSubnetID), Action: storage.ActionAllow}},
+			},
+		},
+		{
+			testName: "Private endpoint denies by default",
+			existing: existing,
+			params: &BucketClassParameters{
+				createPrivateEndpoint: true,
+				privateDNSZoneID:      testPrivateDNSZoneID,
+			},
+			expectedRuleSet: &storage.NetworkRuleSet{
+				DefaultAction:       storage.DefaultActionDeny,
+				IPRules:             existing.IPRules,
+				VirtualNetworkRules: &[]storage.VirtualNetworkRule{},
+			},
+		},
+	}
+	for _, test := range tests {
+		ruleSet := getNetworkRuleSet(test.existing, test.params)
+		if !reflect.DeepEqual(ruleSet, test.expectedRuleSet) {
+			t.Errorf("\nTestCase: %s\nExpected Rule Set: %+v\nActual Rule Set: %+v", test.testName, test.expectedRuleSet, ruleSet)
+		}
+	}
+}
+
+func TestEnsureAccountNetworking(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cloud := azure.GetTestCloud(ctrl)
+	accountID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/" + constant.ValidAccount
+
+	saClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	saClient.EXPECT().
+		GetProperties(gomock.Any(), constant.ValidSub, constant.ValidResourceGroup, constant.ValidAccount).
+		Return(storage.Account{ID: to.StringPtr(accountID), Location: to.StringPtr(constant.ValidRegion), AccountProperties: &storage.AccountProperties{}}, nil).
+		Times(1)
+	saClient.EXPECT().
+		Update(gomock.Any(), constant.ValidSub, constant.ValidResourceGroup, constant.ValidAccount, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, subsID, rg, account string, parameters storage.AccountUpdateParameters) *retry.Error {
+			if parameters.NetworkRuleSet.DefaultAction != storage.DefaultActionDeny {
+				t.Errorf("expected default action %s, got %s", storage.DefaultActionDeny, parameters.NetworkRuleSet.DefaultAction)
+			}
+			return nil
+		}).
+		Times(1)
+	cloud.StorageAccountClient = saClient
+
+	endpointClient := mockprivateendpointclient.NewMockInterface(ctrl)
+	endpointClient.EXPECT().
+		Get(gomock.Any(), constant.ValidResourceGroup, constant.ValidAccount+privateEndpointSuffix, "").
+		Return(network.PrivateEndpoint{}, &retry.Error{HTTPStatusCode: http.StatusNotFound}).
+		Times(1)
+	endpointClient.EXPECT().
+		CreateOrUpdate(gomock.Any(), constant.ValidResourceGroup, constant.ValidAccount+privateEndpointSuffix, gomock.Any(), "", true).
+		DoAndReturn(func(ctx context.Context, rg, name string, endpoint network.PrivateEndpoint, etag string, wait bool) *retry.Error {
+			conn := (*endpoint.PrivateLinkServiceConnections)[0]
+			if (*conn.GroupIds)[0] != BlobPrivateLinkGroupID {
+				t.Errorf("expected group id %s, got %s", BlobPrivateLinkGroupID, (*conn.GroupIds)[0])
+			}
+			if to.String(conn.PrivateLinkServiceID) != accountID {
+				t.Errorf("expected private link service %s, got %s", accountID, to.String(conn.PrivateLinkServiceID))
+			}
+			if to.String(endpoint.Subnet.ID) != testSubnetID {
+				t.Errorf("expected subnet %s, got %s", testSubnetID, to.String(endpoint.Subnet.ID))
+			}
+			return nil
+		}).
+		Times(1)
+	zoneGroupClient := mockprivatednszonegroupclient.NewMockInterface(ctrl)
+	zoneGroupClient.EXPECT().
+		CreateOrUpdate(gomock.Any(), constant.ValidResourceGroup, constant.ValidAccount+privateEndpointSuffix, constant.ValidAccount+privateDNSZoneGroupSuffix, gomock.Any(), "", true).
+		Return(nil).
+		Times(1)
+
+	newClients := newPrivateEndpointClients
+	defer func() { newPrivateEndpointClients = newClients }()
+	newPrivateEndpointClients = func(cloud *azure.Cloud, subsID string) (*privateEndpointClients, error) {
+		return &privateEndpointClients{subscriptionID: subsID, endpoints: endpointClient, zoneGroups: zoneGroupClient}, nil
+	}
+
+	params := &BucketClassParameters{
+		createPrivateEndpoint:   true,
+		privateDNSZoneID:        testPrivateDNSZoneID,
+		privateEndpointSubnetID: testSubnetID,
+	}
+	err := ensureAccountNetworking(context.Background(), constant.ValidSub, constant.ValidResourceGroup, constant.ValidAccount, params, cloud)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// No network parameters: nothing is called
+	err = ensureAccountNetworking(context.Background(), constant.ValidSub, constant.ValidResourceGroup, constant.ValidAccount, &BucketClassParameters{}, cloud)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEnsureAccountNetworkingOtherSubscription(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cloud := azure.GetTestCloud(ctrl)
+	otherSub := "other-subscription"
+	accountID := "/subscriptions/" + otherSub + "/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/" + constant.ValidAccount
+	// the endpoint defaults to the cluster subnet, which stays in the subscription of the cloud config
+	clusterSubnetID := "/subscriptions/subscription/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+
+	saClient := mockstorageaccountclient.NewMockInterface(ctrl)
+	saClient.EXPECT().
+		GetProperties(gomock.Any(), otherSub, constant.ValidResourceGroup, constant.ValidAccount).
+		Return(storage.Account{ID: to.StringPtr(accountID), Location: to.StringPtr(constant.ValidRegion), AccountProperties: &storage.AccountProperties{}}, nil).
+		Times(1)
+	saClient.EXPECT().
+		Update(gomock.Any(), otherSub, constant.ValidResourceGroup, constant.ValidAccount, gomock.Any()).
+		Return(nil).
+		Times(1)
+	cloud.StorageAccountClient = saClient
+
+	endpointClient := mockprivateendpointclient.NewMockInterface(ctrl)
+	endpointClient.EXPECT().
+		Get(gomock.Any(), constant.ValidResourceGroup, constant.ValidAccount+privateEndpointSuffix, "").
+		Return(network.PrivateEndpoint{}, &retry.Error{HTTPStatusCode: http.StatusNotFound}).
+		Times(1)
+	endpointClient.EXPECT().
+		CreateOrUpdate(gomock.Any(), constant.ValidResourceGroup, constant.ValidAccount+privateEndpointSuffix, gomock.Any(), "", true).
+		DoAndReturn(func(ctx context.Context, rg, name string, endpoint network.PrivateEndpoint, etag string, wait bool) *retry.Error {
+			if to.String(endpoint.Subnet.ID) != clusterSubnetID {
+				t.Errorf("expected subnet %s, got %s", clusterSubnetID, to.String(endpoint.Subnet.ID))
+			}
+			return nil
+		}).
+		Times(1)
+	zoneGroupClient := mockprivatednszonegroupclient.NewMockInterface(ctrl)
+	zoneGroupClient.EXPECT().
+		CreateOrUpdate(gomock.Any(), constant.ValidResourceGroup, constant.ValidAccount+privateEndpointSuffix, constant.ValidAccount+privateDNSZoneGroupSuffix, gomock.Any(), "", true).
+		Return(nil).
+		Times(1)
+
+	newClients := newPrivateEndpointClients
+	defer func() { newPrivateEndpointClients = newClients }()
+	newPrivateEndpointClients = func(cloud *azure.Cloud, subsID string) (*privateEndpointClients, error) {
+		if subsID != otherSub {
+			t.Errorf("expected the private endpoint clients of subscription %s, got %s", otherSub, subsID)
+		}
+		return &privateEndpointClients{subscriptionID: subsID, endpoints: endpointClient, zoneGroups: zoneGroupClient}, nil
+	}
+
+	params := &BucketClassParameters{
+		createPrivateEndpoint: true,
+		privateDNSZoneID:      testPrivateDNSZoneID,
+	}
+	if err := ensureAccountNetworking(context.Background(), otherSub, constant.ValidResourceGroup, constant.ValidAccount, params, cloud); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestToPrivateLinkURL(t *testing.T) {
+	tests := []struct {
+		testName    string
+		url         string
+		expectedURL string
+	}{
+		{
+			testName:    "Account URL",
+			url:         constant.ValidAccountURL,
+			expectedURL: "https://validaccount.privatelink.blob.core.windows.net/",
+		},
+		{
+			testName:    "Container SAS URL",
+			url:         constant.ValidContainerURL + "?sv=2020-02-10",
+			expectedURL: "https://validaccount.privatelink.blob.core.windows.net/validcontainer?sv=2020-02-10",
+		},
+		{
+			testName:    "Already private link",
+			url:         "https://validaccount.privatelink.blob.core.windows.net/",
+			expectedURL: "https://validaccount.privatelink.blob.core.windows.net/",
+		},
+	}
+	for _, test := range tests {
+		url := toPrivateLinkURL(test.url)
+		if url != test.expectedURL {
+			t.Errorf("\nTestCase: %s\nExpected URL: %v\nActual URL: %v", test.testName, test.expectedURL, url)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-cosi-driver/pkg/config"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	adminPolicyLock sync.RWMutex
+	adminPolicy     = &config.Policy{}
+)
+
+// SetPolicy replaces the admin policy that class parameters are checked against
+func SetPolicy(p *config.Policy) error {
+	if p == nil {
+		p = &config.Policy{}
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	adminPolicyLock.Lock()
+	defer adminPolicyLock.Unlock()
+	adminPolicy = p
+	return nil
+}
+
+func getPolicy() *config.Policy {
+	adminPolicyLock.RLock()
+	defer adminPolicyLock.RUnlock()
+	return adminPolicy
+}
+
+// policyViolation is the error of a class that breaks a rule, the driver config cannot lift it
+func policyViolation(rule, format string, args ...interface{}) error {
+	return status.Error(codes.PermissionDenied, fmt.Sprintf("%s %s: %s", config.PolicyViolationPrefix, rule, fmt.Sprintf(format, args...)))
+}
+
+// checkBucketClassPolicy checks the parsed BucketClass parameters, after the driver config defaults
+func checkBucketClassPolicy(p *config.Policy, params *BucketClassParameters) error {
+	if violations := bucketClassPolicyViolations(p, params); len(violations) > 0 {
+		return violations[0]
+	}
+	return nil
+}
+
+func bucketClassPolicyViolations(p *config.Policy, params *BucketClassParameters) []error {
+	violations := []error{}
+	for _, key := range p.RequiredTags {
+		if strings.TrimSpace(params.tags[key]) == "" {
+			violations = append(violations, policyViolation(config.RuleRequiredTags, "%s must set the tag %s", TagsField, key))
+		}
+	}
+	if p.RequireCustomerManagedKey && params.keyVaultURI == "" {
+		violations = append(violations, policyViolation(config.RuleRequireCustomerManagedKey, "%s and %s must be set", KeyVaultURIField, KeyNameField))
+	}
+	return violations
+}
+
+// checkBucketAccessClassPolicy checks the parsed BucketAccessClass parameters.
+// The SAS rules only apply to SAS grants, IAM grants have no lifetime or protocol.
+func checkBucketAccessClassPolicy(p *config.Policy, params *BucketAccessClassParameters, forSAS bool) error {
+	if violations := bucketAccessClassPolicyViolations(p, params, forSAS); len(violations) > 0 {
+		return violations[0]
+	}
+	return nil
+}
+
+func bucketAccessClassPolicyViolations(p *config.Policy, params *BucketAccessClassParameters, forSAS bool) []error {
+	violations := []error{}
+	if p.DenyPermanentDelete && params.enablePermanentDelete {
+		violations = append(violations, policyViolation(config.RuleDenyPermanentDelete, "%s must not be true", constant.EnablePermanentDeleteField))
+	}
+	if !forSAS {
+		return violations
+	}
+	if max := p.MaxValidationPeriod; max.Duration > 0 && params.validationPeriod > uint64(max.Milliseconds()) {
+		violations = append(violations, policyViolation(config.RuleMaxValidationPeriod, "%s %dms exceeds %v", constant.ValidationPeriodField, params.validationPeriod, max.Duration))
+	}
+	if p.RequireHTTPS && params.signedProtocol != sas.ProtocolHTTPS {
+		violations = append(violations, policyViolation(config.RuleRequireHTTPS, "%s must be %s", constant.SignedProtocolField, sas.ProtocolHTTPS))
+	}
+	return violations
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/config"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func setTestPolicy(t *testing.T, p *config.Policy) {
+	if err := SetPolicy(p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = SetPolicy(nil) })
+}
+
+func TestCheckBucketClassPolicy(t *testing.T) {
+	p := &config.Policy{
+		RequiredTags:              []string{"costcenter"},
+		RequireCustomerManagedKey: true,
+	}
+	valid := func() *BucketClassParameters {
+		return &BucketClassParameters{
+			tags:        map[string]string{"costcenter": "42"},
+			keyVaultURI: "https://vault.vault.azure.net",
+			keyName:     "key",
+		}
+	}
+	tests := []struct {
+		testName     string
+		modify       func(*BucketClassParameters)
+		expectedRule string
+	}{
+		{testName: "Compliant", modify: func(*BucketClassParameters) {}},
+		{testName: "Missing tag", modify: func(b *BucketClassParameters) { b.tags = map[string]string{"owner": "me"} }, expectedRule: config.RuleRequiredTags},
+		{testName: "Empty tag", modify: func(b *BucketClassParameters) { b.tags["costcenter"] = "" }, expectedRule: config.RuleRequiredTags},
+		{testName: "No customer managed key", modify: func(b *BucketClassParameters) { b.keyVaultURI = "" }, expectedRule: config.RuleRequireCustomerManagedKey},
+	}
+	for _, test := range tests {
+		params := valid()
+		test.modify(params)
+		err := checkBucketClassPolicy(p, params)
+		checkPolicyError(t, test.testName, test.expectedRule, err)
+	}
+
+	if err := checkBucketClassPolicy(&config.Policy{}, &BucketClassParameters{}); err != nil {
+		t.Errorf("expected an empty policy to allow everything, got %v", err)
+	}
+}
+
+func TestCheckBucketAccessClassPolicy(t *testing.T) {
+	p := &config.Policy{
+		MaxValidationPeriod: metav1.Duration{Duration: time.Hour},
+		RequireHTTPS:        true,
+		DenyPermanentDelete: true,
+	}
+	tests := []struct {
+		testName     string
+		params       BucketAccessClassParameters
+		forSAS       bool
+		expectedRule string
+	}{
+		{
+			testName: "Compliant SAS",
+			params:   BucketAccessClassParameters{validationPeriod: 3600000, signedProtocol: sas.ProtocolHTTPS},
+			forSAS:   true,
+		},
+		{
+			testName:     "Validation period",
+			params:       BucketAccessClassParameters{validationPeriod: 3600001, signedProtocol: sas.ProtocolHTTPS},
+			forSAS:       true,
+			expectedRule: config.RuleMaxValidationPeriod,
+		},
+		{
+			testName:     "HTTP allowed",
+			params:       BucketAccessClassParameters{validationPeriod: 60000, signedProtocol: sas.ProtocolHTTPSandHTTP},
+			forSAS:       true,
+			expectedRule: config.RuleRequireHTTPS,
+		},
+		{
+			testName:     "Permanent delete",
+			params:       BucketAccessClassParameters{validationPeriod: 60000, signedProtocol: sas.ProtocolHTTPS, enablePermanentDelete: true},
+			forSAS:       true,
+			expectedRule: config.RuleDenyPermanentDelete,
+		},
+		{
+			testName: "IAM grants ignore SAS rules",
+			params:   BucketAccessClassParameters{validationPeriod: 604800000, signedProtocol: sas.ProtocolHTTPSandHTTP},
+		},
+		{
+			testName:     "IAM permanent delete",
+			params:       BucketAccessClassParameters{enablePermanentDelete: true},
+			expectedRule: config.RuleDenyPermanentDelete,
+		},
+	}
+	for _, test := range tests {
+		params := test.params
+		err := checkBucketAccessClassPolicy(p, &params, test.forSAS)
+		checkPolicyError(t, test.testName, test.expectedRule, err)
+	}
+}
+
+func TestPolicyOverridesDriverConfig(t *testing.T) {
+	// the default tags of the driver config lack a tag that the policy requires
+	setTestDriverConfig(t, &config.Config{BucketClassDefaults: map[string]string{TagsField: "owner=team"}})
+	setTestPolicy(t, &config.Policy{RequiredTags: []string{"costcenter"}})
+
+	_, err := getBucketClassParameters(map[string]string{}, "")
+	checkPolicyError(t, "Default tags", config.RuleRequiredTags, err)
+	if _, err := getBucketClassParameters(map[string]string{TagsField: "costcenter=42"}, ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCreateBucketPolicyViolation(t *testing.T) {
+	setTestPolicy(t, &config.Policy{RequiredTags: []string{"costcenter"}})
+
+	// the policy is checked before any call to Azure
+	_, err := CreateBucket(context.Background(), "bucket", map[string]string{}, nil)
+	checkPolicyError(t, "CreateBucket", config.RuleRequiredTags, err)
+}
+
+func checkPolicyError(t *testing.T, testName, expectedRule string, err error) {
+	if expectedRule == "" {
+		if err != nil {
+			t.Errorf("\nTestCase: %s\nunexpected error: %v", testName, err)
+		}
+		return
+	}
+	if status.Code(err) != codes.PermissionDenied || !strings.Contains(err.Error(), "policy rule "+expectedRule+":") {
+		t.Errorf("\nTestCase: %s\nExpected: PermissionDenied naming %s\nActual: %v", testName, expectedRule, err)
+	}
+}
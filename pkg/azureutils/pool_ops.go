@@ -0,0 +1,278 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/Azure/azure-sdk-for-go/services/preview/monitor/mgmt/2021-07-01-preview/insights"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+const (
+	// StorageAccountPoolTag marks the storage accounts that belong to a pool, its value is the pool name
+	StorageAccountPoolTag = "cosi-storage-account-pool"
+	// PoolSelectionContainerCount places buckets in the pool member with the fewest containers
+	PoolSelectionContainerCount = "containercount"
+	// PoolSelectionLeastUsage places buckets in the pool member with the lowest used capacity
+	PoolSelectionLeastUsage = "leastusage"
+	// DefaultPoolMaxContainers is the number of containers after which a pool member is full
+	DefaultPoolMaxContainers = 100
+	// maxPoolMembers bounds the search for the name of a new pool member
+	maxPoolMembers = 250
+
+	bytesPerGiB = 1 << 30
+)
+
+var (
+	storageAccountPoolRE = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+	// poolLocks serializes the placement of buckets in each pool so concurrent creates see each other's containers
+	poolLocks     = map[string]*sync.Mutex{}
+	poolLocksLock sync.Mutex
+)
+
+// poolMember is a storage account of a pool with what is needed to place a bucket in it
+type poolMember struct {
+	name       string
+	containers int
+	usedBytes  int64
+	hasBucket  bool
+}
+
+func parsePoolSelectionPolicy(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case PoolSelectionContainerCount:
+		return PoolSelectionContainerCount, nil
+	case PoolSelectionLeastUsage:
+		return PoolSelectionLeastUsage, nil
+	}
+	return "", status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s is unsupported", constant.PoolSelectionPolicyField, value))
+}
+
+func hasPoolParameters(params *BucketClassParameters) bool {
+	return params.storageAccountPool != "" ||
+		params.poolSelectionPolicy != "" ||
+		params.poolMaxContainers != 0 ||
+		params.poolMaxCapacityGiB != 0
+}
+
+func validatePoolParameters(params *BucketClassParameters) error {
+	if !hasPoolParameters(params) {
+		return nil
+	}
+	if params.storageAccountPool == "" {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s is required when pool parameters are set", constant.StorageAccountPoolField))
+	}
+	if !storageAccountPoolRE.MatchString(params.storageAccountPool) {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid %s %q, it must be 1 to 63 lowercase letters, digits and dashes", constant.StorageAccountPoolField, params.storageAccountPool))
+	}
+	if params.bucketUnitType != constant.Container {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s is only supported for BucketUnitType %s", constant.StorageAccountPoolField, constant.Container.String()))
+	}
+	if params.storageAccountName != "" {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s and %s cannot both be set", constant.StorageAccountPoolField, constant.StorageAccountNameField))
+	}
+
+	if params.poolSelectionPolicy == "" {
+		params.poolSelectionPolicy = PoolSelectionContainerCount
+	}
+	if params.poolMaxContainers == 0 {
+		params.poolMaxContainers = DefaultPoolMaxContainers
+	}
+	return nil
+}
+
+func lockPool(key string) func() {
+	poolLocksLock.Lock()
+	lock, ok := poolLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		poolLocks[key] = lock
+	}
+	poolLocksLock.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// selectPoolAccount picks the storage account of the pool that gets the container bucket. A member
+// already holding the bucket is picked again, otherwise the member that is not full with the fewest
+// containers or the lowest used capacity. When every member is full a new member is named and
+// parameters.createStorageAccount is set so createContainerBucket creates it.
+func selectPoolAccount(
+	ctx context.Context,
+	subsID string,
+	bucketName string,
+	parameters *BucketClassParameters,
+	cloud *azure.Cloud) (string, error) {
+	resourceGroup := parameters.resourceGroup
+	if resourceGroup == "" {
+		resourceGroup = cloud.ResourceGroup
+	}
+
+	members, err := listPoolMembers(ctx, subsID, resourceGroup, bucketName, parameters, cloud)
+	if err != nil {
+		return "", err
+	}
+
+	var selected *poolMember
+	for i := range members {
+		member := &members[i]
+		if member.hasBucket {
+			klog.Infof("Container %s already exists in storage account %s of pool %s", bucketName, member.name, parameters.storageAccountPool)
+			return member.name, nil
+		}
+		if isPoolMemberFull(member, parameters) {
+			continue
+		}
+		if selected == nil || isLessLoaded(member, selected, parameters.poolSelectionPolicy) {
+			selected = member
+		}
+	}
+	if selected != nil {
+		klog.Infof("Placing container %s in storage account %s of pool %s", bucketName, selected.name, parameters.storageAccountPool)
+		return selected.name, nil
+	}
+
+	if parameters.createStorageAccount != nil && !to.Bool(parameters.createStorageAccount) {
+		return "", status.Error(codes.ResourceExhausted, fmt.Sprintf("All %d storage accounts of pool %s are full and %s is false", len(members), parameters.storageAccountPool, constant.CreateStorageAccountField))
+	}
+	name, err := getNewPoolMemberName(ctx, members, parameters, cloud)
+	if err != nil {
+		return "", err
+	}
+	klog.Infof("All %d storage accounts of pool %s are full, adding storage account %s", len(members), parameters.storageAccountPool, name)
+	parameters.createStorageAccount = to.BoolPtr(true)
+	return name, nil
+}
+
+// listPoolMembers returns the storage accounts of the resource group tagged with the pool, sorted by name
+func listPoolMembers(
+	ctx context.Context,
+	subsID,
+	resourceGroup,
+	bucketName string,
+	parameters *BucketClassParameters,
+	cloud *azure.Cloud) ([]poolMember, error) {
+	var accounts []storage.Account
+	rerr := withRetryError(ctx, subsID, "ListStorageAccounts", func() (rerr *retry.Error) {
+		accounts, rerr = cloud.StorageAccountClient.ListByResourceGroup(ctx, subsID, resourceGroup)
+		return rerr
+	})
+	if rerr != nil {
+		return nil, newAzureError(rerr.Error(), "Could not list storage accounts of resource group %s: %v", resourceGroup, rerr.Error())
+	}
+
+	var metrics *insights.MetricsClient
+	members := []poolMember{}
+	for _, account := range accounts {
+		if to.String(account.Tags[StorageAccountPoolTag]) != parameters.storageAccountPool {
+			continue
+		}
+		member := poolMember{name: to.String(account.Name)}
+
+		key, err := getStorageAccountKey(ctx, subsID, member.name, resourceGroup, cloud)
+		if err != nil {
+			return nil, newAzureError(err, "Could not get key of storage account %s: %v", member.name, err)
+		}
+		if member.containers, member.hasBucket, err = countContainers(ctx, member.name, key, bucketName); err != nil {
+			return nil, err
+		}
+
+		if parameters.poolSelectionPolicy == PoolSelectionLeastUsage || parameters.poolMaxCapacityGiB > 0 {
+			if metrics == nil {
+				if metrics, err = newMetricsClient(cloud, subsID); err != nil {
+					return nil, status.Error(codes.Internal, fmt.Sprintf("Could not create metrics client: %v", err))
+				}
+			}
+			if member.usedBytes, err = getUsedCapacity(ctx, metrics, subsID, to.String(account.ID)); err != nil {
+				return nil, err
+			}
+		}
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].name < members[j].name })
+	return members, nil
+}
+
+// countContainers returns the number of containers of the storage account and whether one is named bucketName
+func countContainers(ctx context.Context, accountName, key, bucketName string) (int, bool, error) {
+	serviceClient, err := createServiceClient(accountName, key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	count, found := 0, false
+	pager := serviceClient.NewListContainersPager(&service.ListContainersOptions{})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return 0, false, newAzureError(err, "Error listing containers of storage account %s : %v", accountName, err)
+		}
+		for _, item := range page.ContainerItems {
+			count++
+			if to.String(item.Name) == bucketName {
+				found = true
+			}
+		}
+	}
+	return count, found, nil
+}
+
+func isPoolMemberFull(member *poolMember, parameters *BucketClassParameters) bool {
+	if member.containers >= parameters.poolMaxContainers {
+		return true
+	}
+	return parameters.poolMaxCapacityGiB > 0 && member.usedBytes >= int64(parameters.poolMaxCapacityGiB)*bytesPerGiB
+}
+
+func isLessLoaded(a, b *poolMember, policy string) bool {
+	if policy == PoolSelectionLeastUsage && a.usedBytes != b.usedBytes {
+		return a.usedBytes < b.usedBytes
+	}
+	return a.containers < b.containers
+}
+
+// getNewPoolMemberName returns an available name for the next member of the pool. Names derive from
+// the pool name and the member number, so the first candidate skips the numbers of existing members.
+func getNewPoolMemberName(ctx context.Context, members []poolMember, parameters *BucketClassParameters, cloud *azure.Cloud) (string, error) {
+	existing := map[string]bool{}
+	for _, member := range members {
+		existing[member.name] = true
+	}
+	for n := len(members); n < len(members)+maxPoolMembers; n++ {
+		name, err := generateStorageAccountName(ctx, fmt.Sprintf("%s-%d", parameters.storageAccountPool, n), parameters, cloud)
+		if err != nil {
+			return "", err
+		}
+		if !existing[name] {
+			return name, nil
+		}
+	}
+	return "", status.Error(codes.ResourceExhausted, fmt.Sprintf("Could not name a new storage account for pool %s", parameters.storageAccountPool))
+}
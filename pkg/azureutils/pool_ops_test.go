@@ -0,0 +1,221 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidatePoolParameters(t *testing.T) {
+	tests := []struct {
+		testName       string
+		parameters     map[string]string
+		expectedErr    error
+		expectedPolicy string
+		expectedMax    int
+	}{
+		{
+			testName: "pool with defaults",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:     constant.Container.String(),
+				constant.StorageAccountPoolField: "team-a",
+			},
+			expectedPolicy: PoolSelectionContainerCount,
+			expectedMax:    DefaultPoolMaxContainers,
+		},
+		{
+			testName: "pool with options",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:      constant.Container.String(),
+				constant.StorageAccountPoolField:  "team-a",
+				constant.PoolSelectionPolicyField: "LeastUsage",
+				constant.PoolMaxContainersField:   "10",
+				constant.PoolMaxCapacityGiBField:  "500",
+			},
+			expectedPolicy: PoolSelectionLeastUsage,
+			expectedMax:    10,
+		},
+		{
+			testName: "pool options without pool",
+			parameters: map[string]string{
+				constant.PoolMaxContainersField: "10",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s is required when pool parameters are set", constant.StorageAccountPoolField)),
+		},
+		{
+			testName: "invalid pool name",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:     constant.Container.String(),
+				constant.StorageAccountPoolField: "Team_A",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid %s %q, it must be 1 to 63 lowercase letters, digits and dashes", constant.StorageAccountPoolField, "Team_A")),
+		},
+		{
+			testName: "pool with storage account name",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:     constant.Container.String(),
+				constant.StorageAccountPoolField: "team-a",
+				constant.StorageAccountNameField: constant.ValidAccount,
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s and %s cannot both be set", constant.StorageAccountPoolField, constant.StorageAccountNameField)),
+		},
+		{
+			testName: "pool of storage account buckets",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:     constant.StorageAccount.String(),
+				constant.StorageAccountPoolField: "team-a",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s is only supported for BucketUnitType %s", constant.StorageAccountPoolField, constant.Container.String())),
+		},
+		{
+			testName: "unsupported selection policy",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:      constant.Container.String(),
+				constant.StorageAccountPoolField:  "team-a",
+				constant.PoolSelectionPolicyField: "random",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s is unsupported", constant.PoolSelectionPolicyField, "random")),
+		},
+		{
+			testName: "zero max containers",
+			parameters: map[string]string{
+				constant.BucketUnitTypeField:     constant.Container.String(),
+				constant.StorageAccountPoolField: "team-a",
+				constant.PoolMaxContainersField:  "0",
+			},
+			expectedErr: status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s must be positive", constant.PoolMaxContainersField, "0")),
+		},
+	}
+	for _, test := range tests {
+		params, err := parseBucketClassParameters(test.parameters)
+		if !reflect.DeepEqual(err, test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && (params.poolSelectionPolicy != test.expectedPolicy || params.poolMaxContainers != test.expectedMax) {
+			t.Errorf("\nTestCase: %s\nExpected policy %s and max %d, got %s and %d", test.testName, test.expectedPolicy, test.expectedMax, params.poolSelectionPolicy, params.poolMaxContainers)
+		}
+	}
+}
+
+// newPoolTestServer starts a fake that the data plane clients of the package talk to
+func newPoolTestServer(t *testing.T) *fakeazure.Server {
+	s := fakeazure.NewServer()
+	SetHTTPClient(s.HTTPClient())
+	t.Cleanup(func() {
+		SetHTTPClient(nil)
+		s.Close()
+	})
+	return s
+}
+
+// createPoolBucket creates a container bucket in the pool and returns the storage account it was placed in
+func createPoolBucket(t *testing.T, s *fakeazure.Server, bucketName string, parameters map[string]string) (string, error) {
+	params := map[string]string{
+		constant.BucketUnitTypeField:     constant.Container.String(),
+		constant.ResourceGroupField:      fakeazure.ResourceGroup,
+		constant.StorageAccountPoolField: "pool",
+	}
+	for k, v := range parameters {
+		params[k] = v
+	}
+	base64ID, err := CreateBucket(context.Background(), bucketName, params, s.Cloud())
+	if err != nil {
+		return "", err
+	}
+	id, err := types.DecodeToBucketID(base64ID)
+	if err != nil {
+		t.Fatalf("unexpected error decoding bucket ID: %v", err)
+	}
+	return getStorageAccountNameFromContainerURL(id.URL), nil
+}
+
+func TestCreateContainerBucketInPool(t *testing.T) {
+	s := newPoolTestServer(t)
+	parameters := map[string]string{constant.PoolMaxContainersField: "2"}
+
+	placements := map[string]string{}
+	for i := 0; i < 4; i++ {
+		bucketName := "bucket" + strconv.Itoa(i)
+		account, err := createPoolBucket(t, s, bucketName, parameters)
+		if err != nil {
+			t.Fatalf("unexpected error creating %s: %v", bucketName, err)
+		}
+		placements[bucketName] = account
+	}
+
+	// the first member fills up before a second one is created
+	if placements["bucket0"] != placements["bucket1"] || placements["bucket2"] != placements["bucket3"] || placements["bucket0"] == placements["bucket2"] {
+		t.Errorf("expected two buckets per storage account, got %v", placements)
+	}
+	if accounts := s.Accounts(); len(accounts) != 2 {
+		t.Errorf("expected 2 storage accounts in the pool, got %v", accounts)
+	}
+	for _, account := range []string{placements["bucket0"], placements["bucket2"]} {
+		if containers := s.Containers(account); len(containers) != 2 {
+			t.Errorf("expected 2 containers in %s, got %v", account, containers)
+		}
+	}
+
+	// creating a bucket again finds it in the member that holds it, even though that member is full
+	account, err := createPoolBucket(t, s, "bucket0", parameters)
+	if err != nil || account != placements["bucket0"] {
+		t.Errorf("expected bucket0 to stay in %s, got %s: %v", placements["bucket0"], account, err)
+	}
+
+	// without account creation a full pool cannot take more buckets
+	parameters[constant.CreateStorageAccountField] = FalseValue
+	if _, err := createPoolBucket(t, s, "bucket4", parameters); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted when the pool is full, got %v", err)
+	}
+}
+
+func TestCreateContainerBucketInPoolLeastUsage(t *testing.T) {
+	s := newPoolTestServer(t)
+
+	// one container per member gives a pool of two members
+	full, err := createPoolBucket(t, s, "bucket0", map[string]string{constant.PoolMaxContainersField: "1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, err := createPoolBucket(t, s, "bucket1", map[string]string{constant.PoolMaxContainersField: "1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if full == other {
+		t.Fatalf("expected buckets in two storage accounts, got %s", full)
+	}
+
+	// both members hold one container, usage decides between them
+	if !s.PutBlob(full, "bucket0", "data", make([]byte, 1024)) {
+		t.Fatalf("could not add a blob to %s", full)
+	}
+	account, err := createPoolBucket(t, s, "bucket2", map[string]string{constant.PoolSelectionPolicyField: PoolSelectionLeastUsage})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if account != other {
+		t.Errorf("expected bucket2 in the least used storage account %s, got %s", other, account)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/go-autorest/autorest"
+	"google.golang.org/grpc/codes"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// RetryPolicy configures how calls to Azure are retried and rate limited
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt, 0 disables retries
+	MaxRetries int
+	// BaseDelay is the delay before the first retry, doubled on every further retry
+	BaseDelay time.Duration
+	// MaxDelay caps the exponential backoff. A longer Retry-After from Azure is still honoured.
+	MaxDelay time.Duration
+	// QPS and Burst size the token bucket shared by the ARM calls of a subscription.
+	// A QPS of 0 disables client side rate limiting.
+	QPS   float32
+	Burst int
+}
+
+// DefaultRetryPolicy stays below the ARM write limit of a subscription when many buckets are created at once
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  time.Second,
+	MaxDelay:   time.Minute,
+	QPS:        5,
+	Burst:      10,
+}
+
+var (
+	retryPolicyLock sync.RWMutex
+	retryPolicy     = DefaultRetryPolicy
+	// armRateLimiters holds the token bucket of each subscription
+	armRateLimiters = map[string]flowcontrol.RateLimiter{}
+
+	httpClientLock sync.RWMutex
+	// httpClient is nil for http.DefaultClient
+	httpClient *http.Client
+
+	retryAfterRE = regexp.MustCompile(`RetryAfter: (\d+)s`)
+)
+
+// Validate checks that the policy can be used
+func (p RetryPolicy) Validate() error {
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("max retries %d must not be negative", p.MaxRetries)
+	}
+	if p.BaseDelay <= 0 || p.MaxDelay < p.BaseDelay {
+		return fmt.Errorf("retry delays must satisfy 0 < base delay (%v) <= max delay (%v)", p.BaseDelay, p.MaxDelay)
+	}
+	if p.QPS < 0 {
+		return fmt.Errorf("qps %v must not be negative", p.QPS)
+	}
+	if p.QPS > 0 && p.Burst < 1 {
+		return fmt.Errorf("burst %d must be at least 1 when rate limiting is enabled", p.Burst)
+	}
+	return nil
+}
+
+// SetRetryPolicy replaces the retry policy used for all Azure calls.
+// The token buckets of all subscriptions are reset.
+func SetRetryPolicy(p RetryPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	retryPolicyLock.Lock()
+	defer retryPolicyLock.Unlock()
+	retryPolicy = p
+	armRateLimiters = map[string]flowcontrol.RateLimiter{}
+	return nil
+}
+
+func getRetryPolicy() RetryPolicy {
+	retryPolicyLock.RLock()
+	defer retryPolicyLock.RUnlock()
+	return retryPolicy
+}
+
+// getARMRateLimiter returns the token bucket of the subscription, nil when rate limiting is disabled
+func getARMRateLimiter(subsID string) flowcontrol.RateLimiter {
+	retryPolicyLock.Lock()
+	defer retryPolicyLock.Unlock()
+	if retryPolicy.QPS == 0 {
+		return nil
+	}
+	limiter, ok := armRateLimiters[subsID]
+	if !ok {
+		limiter = flowcontrol.NewTokenBucketRateLimiter(retryPolicy.QPS, retryPolicy.Burst)
+		armRateLimiters[subsID] = limiter
+	}
+	return limiter
+}
+
+// backoff returns the delay before retry number attempt (starting at 0), with jitter
+func (p RetryPolicy) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 32 && p.BaseDelay<<attempt < p.MaxDelay {
+		delay = p.BaseDelay << attempt
+	}
+	// spread retries of concurrent calls over [delay/2, delay)
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if retryAfter > delay {
+		return retryAfter
+	}
+	return delay
+}
+
+// getHTTPStatusCode returns the HTTP status of an Azure error, 0 if it has none
+func getHTTPStatusCode(err error) int {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode
+	}
+	var detailedErr autorest.DetailedError
+	if errors.As(err, &detailedErr) {
+		if statusCode, ok := detailedErr.StatusCode.(int); ok {
+			return statusCode
+		}
+	}
+	if m := httpStatusCodeRE.FindStringSubmatch(err.Error()); m != nil {
+		statusCode, _ := strconv.Atoi(m[1])
+		return statusCode
+	}
+	return 0
+}
+
+// getRetryAfter returns how long Azure asked the caller to wait, 0 if it did not
+func getRetryAfter(err error) time.Duration {
+	var header http.Header
+	var respErr *azcore.ResponseError
+	var detailedErr autorest.DetailedError
+	if errors.As(err, &respErr) && respErr.RawResponse != nil {
+		header = respErr.RawResponse.Header
+	} else if errors.As(err, &detailedErr) && detailedErr.Response != nil {
+		header = detailedErr.Response.Header
+	}
+	if header != nil {
+		if ms, err := strconv.Atoi(header.Get("x-ms-retry-after-ms")); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		if value := header.Get("Retry-After"); value != "" {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second
+			}
+			if t, err := http.ParseTime(value); err == nil {
+				return time.Until(t)
+			}
+		}
+	}
+	if m := retryAfterRE.FindStringSubmatch(err.Error()); m != nil {
+		seconds, _ := strconv.Atoi(m[1])
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// isRetriable reports whether a call that failed with err may succeed when repeated
+func isRetriable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	statusCode := getHTTPStatusCode(err)
+	if statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError {
+		return true
+	}
+	switch AzureErrorCode(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not retriable or runs out of retries.
+// Every attempt takes a token from the bucket of subsID, an empty subsID is the subscription of the cloud config.
+// It is the only retry of ARM calls: the cloud-provider-azure clients are created without backoff
+// (see disableClientBackoff) and the track 1 SDK clients only retry resource provider registration.
+func withRetry(ctx context.Context, subsID, operation string, fn func() error) error {
+	return retryWithLimiter(ctx, getARMRateLimiter(subsID), operation, fn)
+}
+
+// withRetryError is withRetry for the cloud-provider-azure clients that return a retry.Error
+func withRetryError(ctx context.Context, subsID, operation string, fn func() *retry.Error) *retry.Error {
+	var rerr *retry.Error
+	err := withRetry(ctx, subsID, operation, func() error {
+		if rerr = fn(); rerr != nil {
+			return rerr.Error()
+		}
+		return nil
+	})
+	if err != nil && rerr == nil {
+		// the context ended while waiting for a token
+		return retry.NewError(false, err)
+	}
+	return rerr
+}
+
+// withDataPlaneRetry retries calls to the storage data plane, which is not rate limited per subscription.
+// Calls through the azblob clients must not use it, their pipeline already retries (see getClientOptions).
+func withDataPlaneRetry(ctx context.Context, operation string, fn func() error) error {
+	return retryWithLimiter(ctx, nil, operation, fn)
+}
+
+func retryWithLimiter(ctx context.Context, limiter flowcontrol.RateLimiter, operation string, fn func() error) error {
+	p := getRetryPolicy()
+	for attempt := 0; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return fmt.Errorf("%s: waiting for rate limiter: %w", operation, ctx.Err())
+			}
+		}
+		err := fn()
+		if err == nil || attempt >= p.MaxRetries || !isRetriable(err) {
+			return err
+		}
+
+		delay := p.backoff(attempt, getRetryAfter(err))
+		klog.Warningf("%s failed (attempt %d of %d), retrying in %v: %v", operation, attempt+1, p.MaxRetries+1, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// getClientOptions returns the options of the azblob clients.
+// The azcore pipeline retries with backoff and honours Retry-After itself.
+func getClientOptions() azcore.ClientOptions {
+	p := getRetryPolicy()
+	maxRetries := int32(p.MaxRetries)
+	if maxRetries == 0 {
+		// azcore treats 0 as its default, a negative value disables retries
+		maxRetries = -1
+	}
+	options := azcore.ClientOptions{
+		Retry: policy.RetryOptions{
+			MaxRetries:    maxRetries,
+			RetryDelay:    p.BaseDelay,
+			MaxRetryDelay: p.MaxDelay,
+		},
+	}
+	if c := getHTTPClient(); c != http.DefaultClient {
+		options.Transport = c
+	}
+	return options
+}
+
+// SetHTTPClient replaces the HTTP client of the storage data plane calls, nil restores the default.
+// Tests use it to send blob and dfs requests to a fake service.
+func SetHTTPClient(c *http.Client) {
+	httpClientLock.Lock()
+	defer httpClientLock.Unlock()
+	httpClient = c
+}
+
+func getHTTPClient() *http.Client {
+	httpClientLock.RLock()
+	defer httpClientLock.RUnlock()
+	if httpClient == nil {
+		return http.DefaultClient
+	}
+	return httpClient
+}
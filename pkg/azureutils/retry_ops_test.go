@@ -0,0 +1,230 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// setTestRetryPolicy installs a fast retry policy for the duration of the test
+func setTestRetryPolicy(t *testing.T, p RetryPolicy) {
+	if err := SetRetryPolicy(p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = SetRetryPolicy(DefaultRetryPolicy) })
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	tests := []struct {
+		testName    string
+		policy      RetryPolicy
+		expectedErr bool
+	}{
+		{testName: "Default", policy: DefaultRetryPolicy},
+		{testName: "No rate limiting", policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second}},
+		{testName: "Negative retries", policy: RetryPolicy{MaxRetries: -1, BaseDelay: time.Second, MaxDelay: time.Second}, expectedErr: true},
+		{testName: "Max delay below base delay", policy: RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Second}, expectedErr: true},
+		{testName: "Zero burst", policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second, QPS: 1}, expectedErr: true},
+	}
+	for _, test := range tests {
+		if err := test.policy.Validate(); (err != nil) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		testName   string
+		attempt    int
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{testName: "First retry", attempt: 0, min: 500 * time.Millisecond, max: time.Second},
+		{testName: "Third retry", attempt: 2, min: 2 * time.Second, max: 4 * time.Second},
+		{testName: "Capped", attempt: 40, min: 5 * time.Second, max: 10 * time.Second},
+		{testName: "Retry-After", attempt: 0, retryAfter: 30 * time.Second, min: 30 * time.Second, max: 30 * time.Second},
+	}
+	for _, test := range tests {
+		delay := p.backoff(test.attempt, test.retryAfter)
+		if delay < test.min || delay > test.max {
+			t.Errorf("\nTestCase: %s\nExpected Delay: [%v, %v]\nActual Delay: %v", test.testName, test.min, test.max, delay)
+		}
+	}
+}
+
+func TestGetRetryAfter(t *testing.T) {
+	tests := []struct {
+		testName   string
+		err        error
+		retryAfter time.Duration
+	}{
+		{
+			testName:   "Retry-After header",
+			err:        newTestResponseErrorWithHeader(http.StatusTooManyRequests, "Retry-After", "7"),
+			retryAfter: 7 * time.Second,
+		},
+		{
+			testName:   "x-ms-retry-after-ms header",
+			err:        newTestResponseErrorWithHeader(http.StatusServiceUnavailable, "x-ms-retry-after-ms", "1500"),
+			retryAfter: 1500 * time.Millisecond,
+		},
+		{
+			testName:   "Flattened retry error",
+			err:        fmt.Errorf("Retriable: true, RetryAfter: 12s, HTTPStatusCode: 429, RawError: throttled"),
+			retryAfter: 12 * time.Second,
+		},
+		{
+			testName:   "No Retry-After",
+			err:        fmt.Errorf("test error"),
+			retryAfter: 0,
+		},
+	}
+	for _, test := range tests {
+		if retryAfter := getRetryAfter(test.err); retryAfter != test.retryAfter {
+			t.Errorf("\nTestCase: %s\nExpected Retry-After: %v\nActual Retry-After: %v", test.testName, test.retryAfter, retryAfter)
+		}
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		testName  string
+		err       error
+		retriable bool
+	}{
+		{testName: "Throttled", err: newTestResponseError(http.StatusTooManyRequests, "TooManyRequests"), retriable: true},
+		{testName: "Server error", err: newTestResponseError(http.StatusInternalServerError, "InternalError"), retriable: true},
+		{testName: "Being deleted", err: newTestResponseError(http.StatusConflict, "ContainerBeingDeleted"), retriable: true},
+		{testName: "Flattened throttling", err: fmt.Errorf("Retriable: true, RetryAfter: 0s, HTTPStatusCode: 429, RawError: throttled"), retriable: true},
+		{testName: "Already exists", err: newTestResponseError(http.StatusConflict, "ContainerAlreadyExists"), retriable: false},
+		{testName: "Not found", err: newTestResponseError(http.StatusNotFound, "ResourceNotFound"), retriable: false},
+		{testName: "Quota exceeded", err: fmt.Errorf(`Code="StorageAccountCountExceeded"`), retriable: false},
+		{testName: "Canceled", err: context.Canceled, retriable: false},
+		{testName: "Unclassified", err: fmt.Errorf("test error"), retriable: false},
+	}
+	for _, test := range tests {
+		if retriable := isRetriable(test.err); retriable != test.retriable {
+			t.Errorf("\nTestCase: %s\nExpected Retriable: %v\nActual Retriable: %v", test.testName, test.retriable, retriable)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	throttled := fmt.Errorf("Retriable: true, RetryAfter: 0s, HTTPStatusCode: 429, RawError: throttled")
+	tests := []struct {
+		testName         string
+		errs             []error
+		expectedErr      error
+		expectedAttempts int
+	}{
+		{
+			testName:         "Succeeds",
+			errs:             []error{nil},
+			expectedAttempts: 1,
+		},
+		{
+			testName:         "Succeeds after throttling",
+			errs:             []error{throttled, throttled, nil},
+			expectedAttempts: 3,
+		},
+		{
+			testName:         "Out of retries",
+			errs:             []error{throttled, throttled, throttled, throttled, nil},
+			expectedErr:      throttled,
+			expectedAttempts: 4,
+		},
+		{
+			testName:         "Not retriable",
+			errs:             []error{fmt.Errorf("test error"), nil},
+			expectedErr:      fmt.Errorf("test error"),
+			expectedAttempts: 1,
+		},
+	}
+	for _, test := range tests {
+		attempts := 0
+		err := withRetry(context.Background(), "subs", test.testName, func() error {
+			err := test.errs[attempts]
+			attempts++
+			return err
+		})
+		if fmt.Sprint(err) != fmt.Sprint(test.expectedErr) {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if attempts != test.expectedAttempts {
+			t.Errorf("\nTestCase: %s\nExpected Attempts: %d\nActual Attempts: %d", test.testName, test.expectedAttempts, attempts)
+		}
+	}
+}
+
+func TestWithRetryError(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	attempts := 0
+	rerr := withRetryError(context.Background(), "subs", "test", func() *retry.Error {
+		attempts++
+		if attempts == 1 {
+			return &retry.Error{Retriable: true, HTTPStatusCode: http.StatusTooManyRequests, RawError: fmt.Errorf("throttled")}
+		}
+		return &retry.Error{HTTPStatusCode: http.StatusNotFound, RawError: fmt.Errorf("not found")}
+	})
+	if rerr == nil || rerr.HTTPStatusCode != http.StatusNotFound || attempts != 2 {
+		t.Errorf("expected a not found error after 2 attempts, got %v after %d", rerr, attempts)
+	}
+}
+
+func TestWithRetryRateLimit(t *testing.T) {
+	setTestRetryPolicy(t, RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, QPS: 0.001, Burst: 1})
+
+	if err := withRetry(context.Background(), "subs", "test", func() error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the bucket of subs is empty, another subscription has its own bucket
+	if err := withRetry(context.Background(), "other", "test", func() error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	called := false
+	err := withRetry(ctx, "subs", "test", func() error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Errorf("expected the call to wait for a token until the context ended, got %v", err)
+	}
+}
+
+func newTestResponseErrorWithHeader(statusCode int, name, value string) error {
+	req, _ := http.NewRequest(http.MethodPut, "https://validaccount.blob.core.windows.net/validcontainer", nil)
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	resp.Header.Set(name, value)
+	return runtime.NewResponseError(resp)
+}
@@ -16,6 +16,7 @@ package azureutils
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
 )
 
 func DeleteStorageAccount(
@@ -32,32 +34,59 @@ func DeleteStorageAccount(
 	id *types.BucketID,
 	cloud *azure.Cloud) error {
 	SAClient := cloud.StorageAccountClient
-	err := SAClient.Delete(ctx, id.SubID, id.ResourceGroup, getStorageAccountNameFromContainerURL(id.URL))
+	err := withRetryError(ctx, id.SubID, "DeleteStorageAccount", func() *retry.Error {
+		return SAClient.Delete(ctx, id.SubID, id.ResourceGroup, getStorageAccountNameFromContainerURL(id.URL))
+	})
 	if err != nil {
-		return err.Error()
+		return status.Error(retryErrorCode(err), err.Error().Error())
 	}
 	return nil
 }
 
+// getStorageAccountKey lists the keys of the storage account through ARM, retrying when throttled
+func getStorageAccountKey(ctx context.Context, subsID, accountName, resourceGroup string, cloud *azure.Cloud) (string, error) {
+	var key string
+	err := withRetry(ctx, subsID, "GetStorageAccesskey", func() (err error) {
+		key, err = cloud.GetStorageAccesskey(ctx, subsID, accountName, resourceGroup)
+		return err
+	})
+	return key, err
+}
+
 func createStorageAccountBucket(ctx context.Context,
 	bucketName string,
 	parameters *BucketClassParameters,
 	cloud *azure.Cloud) (string, error) {
-	accName, _, err := cloud.EnsureStorageAccount(ctx, getAccountOptions(parameters), "")
+	subsID := cloud.SubscriptionID
+	if parameters.subscriptionID != "" {
+		subsID = parameters.subscriptionID
+	}
+
+	var accName, key string
+	err := withRetry(ctx, subsID, "EnsureStorageAccount", func() (err error) {
+		accName, key, err = cloud.EnsureStorageAccount(ctx, getAccountOptions(parameters), "")
+		return err
+	})
 	if err != nil {
-		return "", status.Error(codes.Internal, fmt.Sprintf("Could not create storage account: %v", err))
+		return "", newAzureError(err, "Could not create storage account: %v", err)
+	}
+	if err := ensureAccountNetworking(ctx, subsID, parameters.resourceGroup, accName, parameters, cloud); err != nil {
+		return "", newAzureError(err, "Could not configure network access of storage account %s: %v", accName, err)
+	}
+
+	if corsRule := getCORSRule(parameters); corsRule != nil {
+		if err := addCORSRuleToAccount(ctx, subsID, parameters.resourceGroup, accName, key, corsRule, cloud); err != nil {
+			return "", err
+		}
 	}
 
-	accURL := fmt.Sprintf("https://%s.blob.core.windows.net/", accName)
+	accURL := fmt.Sprintf("https://%s.%s/", accName, getBlobDomain())
 
 	id := types.BucketID{
+		SubID:         subsID,
 		ResourceGroup: parameters.resourceGroup,
 		URL:           accURL,
-	}
-	if parameters.subscriptionID != "" {
-		id.SubID = parameters.subscriptionID
-	} else {
-		id.SubID = cloud.SubscriptionID
+		PrivateLink:   usesBlobPrivateEndpoint(parameters),
 	}
 	base64ID, err := id.Encode()
 	if err != nil {
@@ -117,3 +146,20 @@ func createAccountSASURL(ctx context.Context, bucketID string, parameters *Bucke
 	sasURL := fmt.Sprintf("%s/?%s", strings.TrimSuffix(bucketID, "/"), queryParams.Encode())
 	return sasURL, bucketID, nil
 }
+
+// validateEncryptionParameters checks the customer managed key of new storage accounts
+func validateEncryptionParameters(params *BucketClassParameters) error {
+	if params.keyVaultURI == "" {
+		if params.keyName != "" || params.keyVersion != "" {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("%s and %s require %s", KeyNameField, KeyVersionField, KeyVaultURIField))
+		}
+		return nil
+	}
+	if params.keyName == "" {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s requires %s", KeyVaultURIField, KeyNameField))
+	}
+	if u, err := url.Parse(params.keyVaultURI); err != nil || u.Scheme != "https" || u.Host == "" {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s %s must be an https URL", KeyVaultURIField, params.keyVaultURI))
+	}
+	return nil
+}
@@ -103,7 +103,7 @@ func TestDeleteStorageAccount(t *testing.T) {
 				ResourceGroup: constant.ValidResourceGroup,
 				URL:           constant.InvalidAccount,
 			},
-			expectedErr: retry.GetError(&http.Response{}, status.Error(codes.NotFound, "could not find storage account")).Error(),
+			expectedErr: status.Error(codes.NotFound, retry.GetError(&http.Response{}, status.Error(codes.NotFound, "could not find storage account")).Error().Error()),
 		},
 	}
 
@@ -216,3 +216,43 @@ func TestCreateAccountSASURL(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateEncryptionParameters(t *testing.T) {
+	tests := []struct {
+		testName    string
+		params      *BucketClassParameters
+		expectedErr bool
+	}{
+		{testName: "Microsoft managed key", params: &BucketClassParameters{}},
+		{
+			testName: "Customer managed key",
+			params:   &BucketClassParameters{keyVaultURI: "https://vault.vault.azure.net/", keyName: "key", keyVersion: "1"},
+		},
+		{
+			testName:    "Key without vault",
+			params:      &BucketClassParameters{keyName: "key"},
+			expectedErr: true,
+		},
+		{
+			testName:    "Vault without key",
+			params:      &BucketClassParameters{keyVaultURI: "https://vault.vault.azure.net/"},
+			expectedErr: true,
+		},
+		{
+			testName:    "Vault is not a URL",
+			params:      &BucketClassParameters{keyVaultURI: "vault", keyName: "key"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		err := validateEncryptionParameters(test.params)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+	}
+
+	options := getAccountOptions(&BucketClassParameters{keyVaultURI: "https://vault.vault.azure.net/", keyName: "key"})
+	if to.String(options.KeyVaultURI) != "https://vault.vault.azure.net/" || to.String(options.KeyName) != "key" || options.KeyVersion != nil {
+		t.Errorf("expected the key vault key in the account options, got %+v", options)
+	}
+}
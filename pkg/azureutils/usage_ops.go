@@ -0,0 +1,156 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/go-autorest/autorest/to"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// BlobCountMetric and BlobCapacityMetric are the Azure Monitor metrics of the blob service of a storage account
+	BlobCountMetric    = "BlobCount"
+	BlobCapacityMetric = "BlobCapacity"
+	// UnknownAccessTier is reported for blobs whose access tier is not known, such as on premium accounts
+	UnknownAccessTier = "Unknown"
+
+	blobServicesMetricNamespace = "Microsoft.Storage/storageAccounts/blobServices"
+	tierDimension               = "Tier"
+)
+
+// BucketUsage is what a bucket stores
+type BucketUsage struct {
+	BlobCount int64
+	Bytes     int64
+	// BytesPerTier splits Bytes by access tier: Hot, Cool, Archive or Unknown
+	BytesPerTier map[string]int64
+}
+
+func (u *BucketUsage) add(tier string, blobs, bytes int64) {
+	if tier == "" {
+		tier = UnknownAccessTier
+	}
+	u.BlobCount += blobs
+	u.Bytes += bytes
+	u.BytesPerTier[tier] += bytes
+}
+
+// GetBucketUsage returns the blobs stored in the bucket. Storage account buckets are measured with
+// the hourly Azure Monitor metrics of their blob service, container and filesystem buckets by
+// listing their blobs. Fileshare buckets are not measured.
+func GetBucketUsage(ctx context.Context, bucketID string, cloud *azure.Cloud) (*BucketUsage, error) {
+	id, err := types.DecodeToBucketID(bucketID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("could not decode ID: %v", err))
+	}
+	account, containerName, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case id.UnitType == constant.FileShare.String():
+		return nil, status.Error(codes.Unimplemented, fmt.Sprintf("Usage of fileshare %s in storage account %s is not collected", containerName, account))
+	case containerName == "":
+		return getAccountUsage(ctx, id, account, cloud)
+	default:
+		return getContainerUsage(ctx, id, account, containerName, cloud)
+	}
+}
+
+// getAccountUsage reads the blob count and capacity of the storage account per access tier from Azure Monitor
+func getAccountUsage(ctx context.Context, id *types.BucketID, account string, cloud *azure.Cloud) (*BucketUsage, error) {
+	client, err := newMetricsClient(cloud, id.SubID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("Could not create metrics client: %v", err))
+	}
+	resourceID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s/blobServices/default",
+		id.SubID, id.ResourceGroup, account)
+	resp, err := listCapacityMetrics(ctx, client, id.SubID, resourceID, BlobCountMetric+","+BlobCapacityMetric,
+		fmt.Sprintf("%s eq '*'", tierDimension), blobServicesMetricNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &BucketUsage{BytesPerTier: map[string]int64{}}
+	for _, metric := range valueOrEmpty(resp.Value) {
+		name := ""
+		if metric.Name != nil {
+			name = to.String(metric.Name.Value)
+		}
+		for _, series := range valueOrEmpty(metric.Timeseries) {
+			tier := ""
+			for _, dimension := range valueOrEmpty(series.Metadatavalues) {
+				if dimension.Name != nil && strings.EqualFold(to.String(dimension.Name.Value), tierDimension) {
+					tier = to.String(dimension.Value)
+				}
+			}
+			switch {
+			case strings.EqualFold(name, BlobCountMetric):
+				usage.add(tier, getLatestAverage(series), 0)
+			case strings.EqualFold(name, BlobCapacityMetric):
+				usage.add(tier, 0, getLatestAverage(series))
+			}
+		}
+	}
+	return usage, nil
+}
+
+// getContainerUsage adds up the blobs of the container, under the root directory of a filesystem bucket
+func getContainerUsage(ctx context.Context, id *types.BucketID, account, containerName string, cloud *azure.Cloud) (*BucketUsage, error) {
+	key, err := getStorageAccountKey(ctx, id.SubID, account, id.ResourceGroup, cloud)
+	if err != nil {
+		return nil, newAzureError(err, "Could not get key of storage account %s: %v", account, err)
+	}
+	serviceClient, err := createServiceClient(account, key)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &container.ListBlobsFlatOptions{}
+	if id.Directory != "" {
+		options.Prefix = to.StringPtr(strings.TrimSuffix(id.Directory, "/") + "/")
+	}
+	usage := &BucketUsage{BytesPerTier: map[string]int64{}}
+	pager := serviceClient.NewContainerClient(containerName).NewListBlobsFlatPager(options)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, newAzureError(err, "Error listing blobs of container %s in storage account %s : %v", containerName, account, err)
+		}
+		if page.Segment == nil {
+			continue
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item == nil || item.Properties == nil {
+				continue
+			}
+			tier := ""
+			if item.Properties.AccessTier != nil {
+				tier = string(*item.Properties.AccessTier)
+			}
+			usage.add(tier, 1, to.Int64(item.Properties.ContentLength))
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetBucketUsage(t *testing.T) {
+	ctx := context.Background()
+	s := newPoolTestServer(t)
+
+	accountID, err := CreateBucket(ctx, "fakeaccount", map[string]string{
+		constant.BucketUnitTypeField:     constant.StorageAccount.String(),
+		constant.StorageAccountNameField: "fakeaccount",
+		constant.ResourceGroupField:      fakeazure.ResourceGroup,
+	}, s.Cloud())
+	if err != nil {
+		t.Fatalf("unexpected error creating the storage account: %v", err)
+	}
+	containerID, err := CreateBucket(ctx, "bucket", map[string]string{
+		constant.BucketUnitTypeField:     constant.Container.String(),
+		constant.StorageAccountNameField: "fakeaccount",
+		constant.ResourceGroupField:      fakeazure.ResourceGroup,
+	}, s.Cloud())
+	if err != nil {
+		t.Fatalf("unexpected error creating the container: %v", err)
+	}
+	for name, size := range map[string]int{"a.txt": 10, "dir/b.txt": 20, "dir/c.txt": 100} {
+		if !s.PutBlob("fakeaccount", "bucket", name, make([]byte, size)) {
+			t.Fatalf("could not add blob %s", name)
+		}
+	}
+	if !s.SetBlobTier("fakeaccount", "bucket", "dir/c.txt", "Cool") {
+		t.Fatalf("could not set the tier of dir/c.txt")
+	}
+
+	id, err := types.DecodeToBucketID(containerID)
+	if err != nil {
+		t.Fatalf("unexpected error decoding bucket ID: %v", err)
+	}
+	directoryID, _ := (&types.BucketID{SubID: id.SubID, ResourceGroup: id.ResourceGroup, URL: id.URL, UnitType: constant.Filesystem.String(), Directory: "dir"}).Encode()
+	fileShareID, _ := (&types.BucketID{SubID: id.SubID, ResourceGroup: id.ResourceGroup, URL: id.URL, UnitType: constant.FileShare.String()}).Encode()
+
+	tests := []struct {
+		testName      string
+		bucketID      string
+		expectedUsage *BucketUsage
+		expectedCode  codes.Code
+	}{
+		{
+			testName:      "storage account bucket from metrics",
+			bucketID:      accountID,
+			expectedUsage: &BucketUsage{BlobCount: 3, Bytes: 130, BytesPerTier: map[string]int64{"Hot": 30, "Cool": 100}},
+		},
+		{
+			testName:      "container bucket from listing",
+			bucketID:      containerID,
+			expectedUsage: &BucketUsage{BlobCount: 3, Bytes: 130, BytesPerTier: map[string]int64{"Hot": 30, "Cool": 100}},
+		},
+		{
+			testName:      "filesystem bucket with a root directory",
+			bucketID:      directoryID,
+			expectedUsage: &BucketUsage{BlobCount: 2, Bytes: 120, BytesPerTier: map[string]int64{"Hot": 20, "Cool": 100}},
+		},
+		{
+			testName:     "fileshare bucket",
+			bucketID:     fileShareID,
+			expectedCode: codes.Unimplemented,
+		},
+		{
+			testName:     "invalid bucket ID",
+			bucketID:     "invalid",
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		usage, err := GetBucketUsage(ctx, test.bucketID, s.Cloud())
+		if status.Code(err) != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %v\nActual Error: %v", test.testName, test.expectedCode, err)
+		}
+		if !reflect.DeepEqual(usage, test.expectedUsage) {
+			t.Errorf("\nTestCase: %s\nExpected Usage: %+v\nActual Usage: %+v", test.testName, test.expectedUsage, usage)
+		}
+	}
+}
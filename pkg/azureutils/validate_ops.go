@@ -0,0 +1,60 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+)
+
+// ValidateBucketClassParameters checks BucketClass parameters the way DriverCreateBucket does, with
+// the driver config and policy in effect, but without calling Azure. location is the region of the
+// cluster, which buckets without a region are created in. It returns every error: those of the
+// invalid parameters, of the restrictions of the driver config and every policy violation.
+func ValidateBucketClassParameters(parameters map[string]string, location string) []error {
+	c := getDriverConfig()
+	params, errs := collectBucketClassParameters(withDefaults(c.BucketClassDefaults, parameters))
+	if err := checkBucketClassRestrictions(c, params, location); err != nil {
+		errs = append(errs, err)
+	}
+	return append(errs, bucketClassPolicyViolations(getPolicy(), params)...)
+}
+
+// ValidateBucketAccessClassParameters checks BucketAccessClass parameters the way
+// DriverGrantBucketAccess does for a SAS grant, or for an IAM grant when forSAS is false.
+func ValidateBucketAccessClassParameters(parameters map[string]string, forSAS bool) []error {
+	params, err := resolveBucketAccessClassParameters(getDriverConfig(), parameters)
+	if err != nil {
+		return []error{err}
+	}
+	return bucketAccessClassPolicyViolations(getPolicy(), params, forSAS)
+}
+
+// DescribeBucketID returns the storage account, the name of the bucket inside it and the bucket
+// unit type of a BucketID, the way DriverDeleteBucket tells them apart
+func DescribeBucketID(id *types.BucketID) (string, string, constant.BucketUnitType, error) {
+	account, bucket, _, err := parseContainerURL(id.URL)
+	if err != nil {
+		return "", "", constant.None, err
+	}
+	switch {
+	case id.UnitType == constant.Filesystem.String():
+		return account, bucket, constant.Filesystem, nil
+	case id.UnitType == constant.FileShare.String():
+		return account, bucket, constant.FileShare, nil
+	case bucket == "":
+		return account, bucket, constant.StorageAccount, nil
+	}
+	return account, bucket, constant.Container, nil
+}
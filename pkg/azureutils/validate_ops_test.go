@@ -0,0 +1,159 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureutils
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/config"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+)
+
+func TestValidateBucketClassParameters(t *testing.T) {
+	tests := []struct {
+		testName       string
+		parameters     map[string]string
+		expectedErrors int
+	}{
+		{
+			testName:   "Valid",
+			parameters: map[string]string{constant.BucketUnitTypeField: "container", constant.RegionField: "eastus", TagsField: "owner=team"},
+		},
+		{
+			testName:       "Invalid field",
+			parameters:     map[string]string{constant.BucketUnitTypeField: "bucket", constant.RegionField: "eastus", TagsField: "owner=team"},
+			expectedErrors: 1,
+		},
+		{
+			testName:       "Every invalid field is reported",
+			parameters:     map[string]string{constant.BucketUnitTypeField: "bucket", constant.AccessTierField: "lukewarm", constant.RegionField: "eastus", TagsField: "owner=team"},
+			expectedErrors: 2,
+		},
+		{
+			testName:       "Invalid field, driver config error and policy violation",
+			parameters:     map[string]string{constant.BucketUnitTypeField: "bucket", constant.RegionField: "westus2"},
+			expectedErrors: 3,
+		},
+		{
+			testName:       "Driver config error and policy violation",
+			parameters:     map[string]string{constant.RegionField: "westus2"},
+			expectedErrors: 2,
+		},
+	}
+	setTestDriverConfig(t, &config.Config{AllowedRegions: []string{"eastus"}})
+	if err := SetPolicy(&config.Policy{RequiredTags: []string{"owner"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = SetPolicy(nil) })
+	for _, test := range tests {
+		if errs := ValidateBucketClassParameters(test.parameters, ""); len(errs) != test.expectedErrors {
+			t.Errorf("\nTestCase: %s\nExpected Errors: %d\nActual Errors: %v", test.testName, test.expectedErrors, errs)
+		}
+	}
+}
+
+func TestValidateBucketAccessClassParameters(t *testing.T) {
+	if err := SetPolicy(&config.Policy{RequireHTTPS: true, DenyPermanentDelete: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = SetPolicy(nil) })
+	tests := []struct {
+		testName       string
+		parameters     map[string]string
+		forSAS         bool
+		expectedErrors int
+	}{
+		{
+			testName:   "Valid",
+			parameters: map[string]string{constant.SignedProtocolField: "https"},
+			forSAS:     true,
+		},
+		{
+			testName:       "Invalid field",
+			parameters:     map[string]string{constant.ValidationPeriodField: "a week", constant.EnablePermanentDeleteField: "true"},
+			forSAS:         true,
+			expectedErrors: 1,
+		},
+		{
+			testName:       "SAS rules",
+			parameters:     map[string]string{constant.SignedProtocolField: "https,http", constant.EnablePermanentDeleteField: "true"},
+			forSAS:         true,
+			expectedErrors: 2,
+		},
+		{
+			testName:       "SAS rules do not apply to IAM",
+			parameters:     map[string]string{constant.SignedProtocolField: "https,http", constant.EnablePermanentDeleteField: "true"},
+			expectedErrors: 1,
+		},
+	}
+	for _, test := range tests {
+		if errs := ValidateBucketAccessClassParameters(test.parameters, test.forSAS); len(errs) != test.expectedErrors {
+			t.Errorf("\nTestCase: %s\nExpected Errors: %d\nActual Errors: %v", test.testName, test.expectedErrors, errs)
+		}
+	}
+}
+
+func TestDescribeBucketID(t *testing.T) {
+	tests := []struct {
+		testName         string
+		id               *types.BucketID
+		expectedAccount  string
+		expectedBucket   string
+		expectedUnitType constant.BucketUnitType
+		expectedErr      bool
+	}{
+		{
+			testName:         "Container",
+			id:               &types.BucketID{URL: constant.ValidContainerURL},
+			expectedAccount:  constant.ValidAccount,
+			expectedBucket:   constant.ValidContainer,
+			expectedUnitType: constant.Container,
+		},
+		{
+			testName:         "Storage account",
+			id:               &types.BucketID{URL: "https://validaccount.blob.core.windows.net/"},
+			expectedAccount:  constant.ValidAccount,
+			expectedUnitType: constant.StorageAccount,
+		},
+		{
+			testName:         "Fileshare",
+			id:               &types.BucketID{URL: "https://validaccount.file.core.windows.net/share", UnitType: constant.FileShare.String()},
+			expectedAccount:  constant.ValidAccount,
+			expectedBucket:   "share",
+			expectedUnitType: constant.FileShare,
+		},
+		{
+			testName:         "Filesystem",
+			id:               &types.BucketID{URL: "https://validaccount.blob.core.windows.net/fs", UnitType: constant.Filesystem.String()},
+			expectedAccount:  constant.ValidAccount,
+			expectedBucket:   "fs",
+			expectedUnitType: constant.Filesystem,
+		},
+		{
+			testName:    "Invalid URL",
+			id:          &types.BucketID{URL: "not a url"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		account, bucket, unitType, err := DescribeBucketID(test.id)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if account != test.expectedAccount || bucket != test.expectedBucket || unitType != test.expectedUnitType {
+			t.Errorf("\nTestCase: %s\nExpected: %s %s %v\nActual: %s %s %v", test.testName, test.expectedAccount, test.expectedBucket, test.expectedUnitType, account, bucket, unitType)
+		}
+	}
+}
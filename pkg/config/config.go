@@ -0,0 +1,101 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultStorageEndpointSuffix is the DNS suffix of storage endpoints in the Azure public cloud
+const DefaultStorageEndpointSuffix = "core.windows.net"
+
+var dnsSuffixRE = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+// Config is the driver configuration file, written in YAML or JSON
+type Config struct {
+	// BucketClassDefaults are parameters applied to every BucketClass that does not set them
+	BucketClassDefaults map[string]string `json:"bucketClassDefaults,omitempty"`
+	// BucketAccessClassDefaults are parameters applied to every BucketAccessClass that does not set them
+	BucketAccessClassDefaults map[string]string `json:"bucketAccessClassDefaults,omitempty"`
+	// AllowedRegions restricts the region of buckets, empty allows every region. The policy has no
+	// region or SKU rules, these restrictions are the only ones.
+	AllowedRegions []string `json:"allowedRegions,omitempty"`
+	// AllowedSKUs restricts the storage account type of buckets, empty allows every SKU
+	AllowedSKUs []string `json:"allowedSKUs,omitempty"`
+	// MaxSASLifetime caps the validationPeriod of SAS grants, zero leaves it unlimited
+	MaxSASLifetime metav1.Duration `json:"maxSASLifetime,omitempty"`
+	Endpoints      Endpoints       `json:"endpoints,omitempty"`
+}
+
+// Endpoints overrides the Azure endpoints, for sovereign clouds and Azure Stack
+type Endpoints struct {
+	// StorageEndpointSuffix replaces core.windows.net in blob, dfs and file endpoints
+	StorageEndpointSuffix string `json:"storageEndpointSuffix,omitempty"`
+}
+
+// Load reads and validates the configuration file at path
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading driver config %s: %v", path, err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a configuration, rejecting unknown fields
+func Parse(data []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing driver config: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks the fields that do not depend on how the driver parses class parameters
+func (c *Config) Validate() error {
+	for _, region := range c.AllowedRegions {
+		if strings.TrimSpace(region) == "" {
+			return fmt.Errorf("allowedRegions contains an empty region")
+		}
+	}
+	for _, sku := range c.AllowedSKUs {
+		if strings.TrimSpace(sku) == "" {
+			return fmt.Errorf("allowedSKUs contains an empty SKU")
+		}
+	}
+	if c.MaxSASLifetime.Duration < 0 {
+		return fmt.Errorf("maxSASLifetime %v must not be negative", c.MaxSASLifetime.Duration)
+	}
+	if suffix := c.Endpoints.StorageEndpointSuffix; suffix != "" && !dnsSuffixRE.MatchString(suffix) {
+		return fmt.Errorf("endpoints.storageEndpointSuffix %q is not a DNS suffix", suffix)
+	}
+	return nil
+}
+
+// GetStorageEndpointSuffix returns the storage endpoint suffix, falling back to the public cloud
+func (c *Config) GetStorageEndpointSuffix() string {
+	if c == nil || c.Endpoints.StorageEndpointSuffix == "" {
+		return DefaultStorageEndpointSuffix
+	}
+	return c.Endpoints.StorageEndpointSuffix
+}
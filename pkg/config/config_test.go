@@ -0,0 +1,114 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParse(t *testing.T) {
+	expected := &Config{
+		BucketClassDefaults:       map[string]string{"resourcegroup": "cosi", "region": "eastus"},
+		BucketAccessClassDefaults: map[string]string{"signedprotocol": "https"},
+		AllowedRegions:            []string{"eastus", "westus2"},
+		AllowedSKUs:               []string{"Standard_LRS"},
+		MaxSASLifetime:            metav1.Duration{Duration: 24 * time.Hour},
+		Endpoints:                 Endpoints{StorageEndpointSuffix: "core.chinacloudapi.cn"},
+	}
+	tests := []struct {
+		testName       string
+		data           string
+		expectedConfig *Config
+		expectedErr    bool
+	}{
+		{
+			testName: "YAML",
+			data: `
+bucketClassDefaults:
+  resourcegroup: cosi
+  region: eastus
+bucketAccessClassDefaults:
+  signedprotocol: https
+allowedRegions: [eastus, westus2]
+allowedSKUs: [Standard_LRS]
+maxSASLifetime: 24h
+endpoints:
+  storageEndpointSuffix: core.chinacloudapi.cn
+`,
+			expectedConfig: expected,
+		},
+		{
+			testName: "JSON",
+			data: `{"bucketClassDefaults": {"resourcegroup": "cosi", "region": "eastus"},
+"bucketAccessClassDefaults": {"signedprotocol": "https"},
+"allowedRegions": ["eastus", "westus2"], "allowedSKUs": ["Standard_LRS"], "maxSASLifetime": "24h",
+"endpoints": {"storageEndpointSuffix": "core.chinacloudapi.cn"}}`,
+			expectedConfig: expected,
+		},
+		{
+			testName:       "Empty",
+			data:           "",
+			expectedConfig: &Config{},
+		},
+		{testName: "Unknown field", data: "allowedRegion: [eastus]", expectedErr: true},
+		{testName: "Invalid duration", data: "maxSASLifetime: a day", expectedErr: true},
+		{testName: "Negative duration", data: "maxSASLifetime: -1h", expectedErr: true},
+		{testName: "Empty region", data: `allowedRegions: [""]`, expectedErr: true},
+		{testName: "Empty SKU", data: `allowedSKUs: [" "]`, expectedErr: true},
+		{testName: "Invalid endpoint suffix", data: "endpoints: {storageEndpointSuffix: https://core.windows.net}", expectedErr: true},
+	}
+	for _, test := range tests {
+		c, err := Parse([]byte(test.data))
+		if (err != nil) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(c, test.expectedConfig) {
+			t.Errorf("\nTestCase: %s\nExpected Config: %+v\nActual Config: %+v", test.testName, test.expectedConfig, c)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if _, err := Load(path); err == nil {
+		t.Errorf("expected an error loading a missing file")
+	}
+	if err := os.WriteFile(path, []byte("allowedRegions: [eastus]"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(c.AllowedRegions, []string{"eastus"}) {
+		t.Errorf("unexpected config: %+v", c)
+	}
+}
+
+func TestGetStorageEndpointSuffix(t *testing.T) {
+	var c *Config
+	if suffix := c.GetStorageEndpointSuffix(); suffix != DefaultStorageEndpointSuffix {
+		t.Errorf("expected %s for a nil config, got %s", DefaultStorageEndpointSuffix, suffix)
+	}
+	c = &Config{Endpoints: Endpoints{StorageEndpointSuffix: "core.usgovcloudapi.net"}}
+	if suffix := c.GetStorageEndpointSuffix(); suffix != "core.usgovcloudapi.net" {
+		t.Errorf("expected the overridden suffix, got %s", suffix)
+	}
+}
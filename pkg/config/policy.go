@@ -0,0 +1,86 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Policy holds the rules of the cluster admin that every BucketClass and BucketAccessClass must satisfy.
+// Unlike the driver config it is only read at startup, and a class that breaks a rule is refused
+// rather than corrected. The JSON name of each field is the name of the rule in errors.
+// Regions and storage account types are restricted by Config.AllowedRegions and Config.AllowedSKUs.
+type Policy struct {
+	// MaxValidationPeriod caps the lifetime of SAS grants
+	MaxValidationPeriod metav1.Duration `json:"maxValidationPeriod,omitempty"`
+	// RequireHTTPS refuses SAS grants that can be used over plain HTTP
+	RequireHTTPS bool `json:"requireHTTPS,omitempty"`
+	// DenyPermanentDelete refuses grants with enablepermanentdelete
+	DenyPermanentDelete bool `json:"denyPermanentDelete,omitempty"`
+	// RequiredTags are tag keys every bucket must set a value for
+	RequiredTags []string `json:"requiredTags,omitempty"`
+	// RequireCustomerManagedKey refuses buckets without a key vault key
+	RequireCustomerManagedKey bool `json:"requireCustomerManagedKey,omitempty"`
+}
+
+// Policy rule names, as written in the policy file
+const (
+	RuleMaxValidationPeriod       = "maxValidationPeriod"
+	RuleRequireHTTPS              = "requireHTTPS"
+	RuleDenyPermanentDelete       = "denyPermanentDelete"
+	RuleRequiredTags              = "requiredTags"
+	RuleRequireCustomerManagedKey = "requireCustomerManagedKey"
+)
+
+// PolicyViolationPrefix starts the message of every error caused by a policy rule
+const PolicyViolationPrefix = "Denied by policy rule"
+
+// LoadPolicy reads and validates the policy file at path
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy %s: %v", path, err)
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy decodes and validates a policy, rejecting unknown rules
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("error parsing policy: %v", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks that every rule can be satisfied
+func (p *Policy) Validate() error {
+	if p.MaxValidationPeriod.Duration < 0 {
+		return fmt.Errorf("%s %v must not be negative", RuleMaxValidationPeriod, p.MaxValidationPeriod.Duration)
+	}
+	for _, key := range p.RequiredTags {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("%s contains an empty entry", RuleRequiredTags)
+		}
+	}
+	return nil
+}
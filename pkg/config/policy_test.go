@@ -0,0 +1,63 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		testName       string
+		data           string
+		expectedPolicy *Policy
+		expectedErr    bool
+	}{
+		{
+			testName: "All rules",
+			data: `
+maxValidationPeriod: 24h
+requireHTTPS: true
+denyPermanentDelete: true
+requiredTags: [costcenter]
+requireCustomerManagedKey: true
+`,
+			expectedPolicy: &Policy{
+				MaxValidationPeriod:       metav1.Duration{Duration: 24 * time.Hour},
+				RequireHTTPS:              true,
+				DenyPermanentDelete:       true,
+				RequiredTags:              []string{"costcenter"},
+				RequireCustomerManagedKey: true,
+			},
+		},
+		{testName: "Empty", data: "", expectedPolicy: &Policy{}},
+		{testName: "Unknown rule", data: "requireTLS: true", expectedErr: true},
+		{testName: "Region rule of the driver config", data: "allowedRegions: [eastus]", expectedErr: true},
+		{testName: "Negative validation period", data: "maxValidationPeriod: -1h", expectedErr: true},
+		{testName: "Empty tag", data: `requiredTags: [""]`, expectedErr: true},
+	}
+	for _, test := range tests {
+		p, err := ParsePolicy([]byte(test.data))
+		if (err != nil) != test.expectedErr {
+			t.Errorf("\nTestCase: %s\nExpected Error: %v\nActual Error: %v", test.testName, test.expectedErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(p, test.expectedPolicy) {
+			t.Errorf("\nTestCase: %s\nExpected Policy: %+v\nActual Policy: %+v", test.testName, test.expectedPolicy, p)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// DefaultReloadInterval is how often the configuration file is checked for changes
+const DefaultReloadInterval = 10 * time.Second
+
+// Watch reloads the configuration file at path whenever its content changes, until ctx is done.
+// The file is polled rather than watched for events, so a ConfigMap update, which swaps a
+// symlink, is picked up like an edit in place. A configuration that fails to parse or that
+// apply rejects is logged and the previous one stays in effect.
+func Watch(ctx context.Context, path string, interval time.Duration, apply func(*Config) error) {
+	last, err := os.ReadFile(path)
+	if err != nil {
+		klog.Errorf("Error reading driver config %s: %v", path, err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.Errorf("Error reading driver config %s: %v", path, err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		// an invalid file is only reported once, until it changes again
+		last = data
+		if err := reload(data, apply); err != nil {
+			klog.Errorf("Rejected driver config %s, keeping the previous one: %v", path, err)
+			continue
+		}
+		klog.Infof("Reloaded driver config %s", path)
+	}
+}
+
+func reload(data []byte, apply func(*Config) error) error {
+	c, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	return apply(c)
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	write("allowedRegions: [eastus]")
+
+	var lock sync.Mutex
+	var applied []*Config
+	apply := func(c *Config) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(c.AllowedSKUs) > 0 {
+			return fmt.Errorf("rejected by the driver")
+		}
+		applied = append(applied, c)
+		return nil
+	}
+	getApplied := func() []*Config {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]*Config{}, applied...)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, path, 10*time.Millisecond, apply)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the file the watch started with is not applied again
+	time.Sleep(50 * time.Millisecond)
+	if len(getApplied()) != 0 {
+		t.Fatalf("expected no reload of an unchanged file, got %d", len(getApplied()))
+	}
+
+	// invalid files are rejected
+	write("allowedRegions: eastus")
+	write("allowedSKUs: [Standard_LRS]")
+	time.Sleep(50 * time.Millisecond)
+	if len(getApplied()) != 0 {
+		t.Fatalf("expected invalid configs to be rejected, got %d", len(getApplied()))
+	}
+
+	write("allowedRegions: [westus2]")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(getApplied()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c := getApplied()[0]; len(c.AllowedRegions) != 1 || c.AllowedRegions[0] != "westus2" {
+		t.Errorf("unexpected config reloaded: %+v", c)
+	}
+}
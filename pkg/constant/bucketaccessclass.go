@@ -18,6 +18,14 @@ const (
 	AllowServiceSignedResourceTypeField   = "allowservicesignedresourcetypefield"
 	AllowContainerSignedResourceTypeField = "allowcontainersignedresourcetypefield"
 	AllowObjectSignedResourceTypeField    = "allowobjectsignedresourcetypefield"
+	PrincipalIDField                      = "principalid"
+	PathPrefixField                       = "pathprefix"
 	CredentialType                        = "azure"
 	AccessToken                           = "accessToken"
+	Endpoint                              = "endpoint"
+	AccountName                           = "accountName"
+	ContainerName                         = "containerName"
+	ExpiryTimestamp                       = "expiryTimestamp"
+	ConnectionString                      = "connectionString"
+	SASToken                              = "sasToken"
 )
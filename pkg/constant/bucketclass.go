@@ -7,6 +7,11 @@ const (
 	CreateStorageAccountField           = "createstorageaccount"
 	SubscriptionIDField                 = "subscriptionid"
 	StorageAccountNameField             = "storageaccountname"
+	StorageAccountNamePrefixField       = "storageaccountnameprefix"
+	StorageAccountPoolField             = "storageaccountpool"
+	PoolSelectionPolicyField            = "poolselectionpolicy"
+	PoolMaxContainersField              = "poolmaxcontainers"
+	PoolMaxCapacityGiBField             = "poolmaxcapacitygib"
 	ContainerNameField                  = "containername"
 	RegionField                         = "region"
 	AccessTierField                     = "accesstier"
@@ -19,6 +24,18 @@ const (
 	BlobDeleteRetentionDaysField        = "blobdeleteretentiondays"
 	EnableContainerDeleteRetentionField = "enablecontainerdeleteretention"
 	ContainerDeleteRetentionDaysField   = "containerdeleteretentiondays"
+	CORSAllowedOriginsField             = "corsallowedorigins"
+	CORSAllowedMethodsField             = "corsallowedmethods"
+	CORSAllowedHeadersField             = "corsallowedheaders"
+	CORSExposedHeadersField             = "corsexposedheaders"
+	CORSMaxAgeInSecondsField            = "corsmaxageinseconds"
+	RootDirectoryField                  = "rootdirectory"
+	OwnerField                          = "owner"
+	GroupField                          = "group"
+	ACLField                            = "acl"
+	ShareQuotaField                     = "sharequota"
+	ShareAccessTierField                = "shareaccesstier"
+	ShareProtocolField                  = "shareprotocol"
 )
 
 type BucketUnitType int
@@ -30,6 +47,8 @@ const (
 	None BucketUnitType = iota
 	Container
 	StorageAccount
+	Filesystem
+	FileShare
 )
 
 const (
@@ -59,6 +78,10 @@ func (b BucketUnitType) String() string {
 		return "container"
 	case StorageAccount:
 		return "storageaccount"
+	case Filesystem:
+		return "filesystem"
+	case FileShare:
+		return "fileshare"
 	}
 	return "unknown"
 }
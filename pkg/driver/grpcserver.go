@@ -33,29 +33,32 @@ type COSIServer struct {
 	server          *grpc.Server
 }
 
+// logGRPC logs every call and its response.
+// Requests and responses carry SAS tokens and keys, only redacted copies are logged.
+func logGRPC(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	klog.V(2).InfoS("GRPC call", "method", info.FullMethod, "request", redact(req))
+
+	resp, err := handler(ctx, req)
+	if err != nil {
+		klog.Errorf("GRPC error %s", RedactString(err.Error()))
+	} else {
+		klog.V(2).InfoS("GRPC response", "method", info.FullMethod, "response", redact(resp))
+	}
+
+	return resp, err
+}
+
 func newCOSIServer(
 	endpointProto string,
 	endpointAddr string,
 	identityServer spec.IdentityServer,
 	provisionerServer spec.ProvisionerServer) *COSIServer {
 	serverOpts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(
-			func(
-				ctx context.Context,
-				req interface{},
-				info *grpc.UnaryServerInfo,
-				handler grpc.UnaryHandler) (interface{}, error) {
-				klog.V(2).InfoS("GRPC call", "method", info.FullMethod, "request", req)
-
-				resp, err := handler(ctx, req)
-				if err != nil {
-					klog.Errorf("GRPC error %v", err)
-				} else {
-					klog.V(2).InfoS("GRPC response", "method", info.FullMethod, "response", resp)
-				}
-
-				return resp, err
-			}),
+		grpc.UnaryInterceptor(logGRPC),
 	}
 
 	server := grpc.NewServer(serverOpts...)
@@ -0,0 +1,119 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// RedactedValue replaces sensitive values in logs
+const RedactedValue = "***"
+
+var (
+	// secretsFields are the map fields of the spec whose values are credentials.
+	// Their values are masked unless the key is known to be safe to log.
+	secretsFields = map[protoreflect.Name]bool{
+		"secrets": true,
+	}
+	// publicSecretKeys are the keys of a credentials secret that are safe to log
+	publicSecretKeys = map[string]bool{
+		constant.Endpoint:        true,
+		constant.AccountName:     true,
+		constant.ContainerName:   true,
+		constant.ExpiryTimestamp: true,
+	}
+	// sensitiveKeyParts mark keys of any other map, e.g. BucketClass parameters, as sensitive
+	sensitiveKeyParts = []string{"key", "secret", "token", "password", "connectionstring", "credential"}
+
+	// sasSignatureRE matches the signature of a SAS anywhere in a string, e.g. in the URL of an error
+	sasSignatureRE = regexp.MustCompile(`(?i)(sig=)[^&\s"';]+`)
+	// accountKeyRE matches an account key in a connection string
+	accountKeyRE = regexp.MustCompile(`(?i)(AccountKey=)[^;\s"']+`)
+)
+
+// RedactString masks SAS signatures and account keys within s
+func RedactString(s string) string {
+	s = sasSignatureRE.ReplaceAllString(s, "${1}"+RedactedValue)
+	return accountKeyRE.ReplaceAllString(s, "${1}"+RedactedValue)
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns a copy of a gRPC request or response that is safe to log.
+// Values that are not protobuf messages are returned unchanged.
+func redact(msg interface{}) interface{} {
+	m, ok := msg.(proto.Message)
+	if !ok || m == nil {
+		return msg
+	}
+	clone := proto.Clone(m)
+	redactMessage(clone.ProtoReflect())
+	return clone
+}
+
+func redactMessage(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			redactMap(fd, v.Map())
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				if fd.Kind() == protoreflect.MessageKind {
+					redactMessage(list.Get(i).Message())
+				} else if fd.Kind() == protoreflect.StringKind {
+					list.Set(i, protoreflect.ValueOfString(RedactString(list.Get(i).String())))
+				}
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			redactMessage(v.Message())
+		case fd.Kind() == protoreflect.StringKind:
+			m.Set(fd, protoreflect.ValueOfString(RedactString(v.String())))
+		}
+		return true
+	})
+}
+
+func redactMap(fd protoreflect.FieldDescriptor, m protoreflect.Map) {
+	valueFd := fd.MapValue()
+	secrets := secretsFields[fd.Name()]
+	m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		key := k.String()
+		switch valueFd.Kind() {
+		case protoreflect.MessageKind:
+			redactMessage(v.Message())
+		case protoreflect.StringKind:
+			if (secrets && !publicSecretKeys[key]) || isSensitiveKey(key) {
+				m.Set(k, protoreflect.ValueOfString(RedactedValue))
+			} else {
+				m.Set(k, protoreflect.ValueOfString(RedactString(v.String())))
+			}
+		}
+		return true
+	})
+}
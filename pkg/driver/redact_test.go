@@ -0,0 +1,185 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	klog "k8s.io/klog/v2"
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+const (
+	testSignature  = "c2VjcmV0LXNpZ25hdHVyZQ%3D%3D"
+	testAccountKey = "c2VjcmV0LWtleQ=="
+	testSASToken   = "sv=2020-02-10&se=2030-01-01T00%3A00%3A00Z&sr=c&sp=rl&sig=" + testSignature
+	testSASURL     = "https://validaccount.blob.core.windows.net/validcontainer?" + testSASToken
+)
+
+func newTestGrantResponse() *spec.DriverGrantBucketAccessResponse {
+	return &spec.DriverGrantBucketAccessResponse{
+		AccountId: "sas:validaccount/validcontainer/access",
+		Credentials: map[string]*spec.CredentialDetails{constant.CredentialType: {
+			Secrets: map[string]string{
+				constant.AccessToken:      testSASURL,
+				constant.SASToken:         testSASToken,
+				constant.ConnectionString: "BlobEndpoint=https://validaccount.blob.core.windows.net/;SharedAccessSignature=" + testSASToken,
+				constant.Endpoint:         "https://validaccount.blob.core.windows.net/",
+				constant.AccountName:      "validaccount",
+			},
+		}},
+	}
+}
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		testName string
+		value    string
+		expected string
+	}{
+		{
+			testName: "SAS URL",
+			value:    testSASURL,
+			expected: "https://validaccount.blob.core.windows.net/validcontainer?sv=2020-02-10&se=2030-01-01T00%3A00%3A00Z&sr=c&sp=rl&sig=***",
+		},
+		{
+			testName: "Connection string",
+			value:    "DefaultEndpointsProtocol=https;AccountName=validaccount;AccountKey=" + testAccountKey + ";EndpointSuffix=core.windows.net",
+			expected: "DefaultEndpointsProtocol=https;AccountName=validaccount;AccountKey=***;EndpointSuffix=core.windows.net",
+		},
+		{
+			testName: "Nothing to redact",
+			value:    "validcontainer",
+			expected: "validcontainer",
+		},
+	}
+	for _, test := range tests {
+		if actual := RedactString(test.value); actual != test.expected {
+			t.Errorf("\nTestCase: %s\nExpected: %v\nActual: %v", test.testName, test.expected, actual)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	resp := newTestGrantResponse()
+	redacted := redact(resp).(*spec.DriverGrantBucketAccessResponse)
+
+	secrets := redacted.Credentials[constant.CredentialType].Secrets
+	for _, key := range []string{constant.AccessToken, constant.SASToken, constant.ConnectionString} {
+		if secrets[key] != RedactedValue {
+			t.Errorf("expected %s to be redacted, got %s", key, secrets[key])
+		}
+	}
+	for _, key := range []string{constant.Endpoint, constant.AccountName} {
+		if secrets[key] == RedactedValue {
+			t.Errorf("expected %s to be logged", key)
+		}
+	}
+	if redacted.AccountId != resp.AccountId {
+		t.Errorf("expected account id %s, got %s", resp.AccountId, redacted.AccountId)
+	}
+	if !proto.Equal(resp, newTestGrantResponse()) {
+		t.Errorf("expected the response to be unchanged")
+	}
+
+	req := &spec.DriverCreateBucketRequest{
+		Name: "bucket",
+		Parameters: map[string]string{
+			"bucketunittype":    "container",
+			"storageaccountkey": testAccountKey,
+			"signedversion":     "2020-02-10",
+			"origin":            testSASURL,
+		},
+	}
+	redactedReq := redact(req).(*spec.DriverCreateBucketRequest)
+	expected := map[string]string{
+		"bucketunittype":    "container",
+		"storageaccountkey": RedactedValue,
+		"signedversion":     "2020-02-10",
+		"origin":            RedactString(testSASURL),
+	}
+	for key, value := range expected {
+		if redactedReq.Parameters[key] != value {
+			t.Errorf("\nTestCase: %s\nExpected: %v\nActual: %v", key, value, redactedReq.Parameters[key])
+		}
+	}
+
+	if redact(nil) != nil {
+		t.Errorf("expected nil to be returned unchanged")
+	}
+}
+
+// captureKlog sends klog output at verbosity 2 to the returned buffer for the duration of the test
+func captureKlog(t *testing.T) *bytes.Buffer {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	for name, value := range map[string]string{"v": "2", "logtostderr": "false", "alsologtostderr": "false", "stderrthreshold": "FATAL"} {
+		if err := fs.Set(name, value); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	klog.SetOutput(buf)
+	t.Cleanup(func() {
+		_ = fs.Set("v", "0")
+		_ = fs.Set("logtostderr", "true")
+		klog.SetOutput(nil)
+	})
+	return buf
+}
+
+func TestLogGRPCRedactsSASSignatures(t *testing.T) {
+	buf := captureKlog(t)
+	info := &grpc.UnaryServerInfo{FullMethod: "/cosi.v1alpha1.Provisioner/DriverGrantBucketAccess"}
+	req := &spec.DriverGrantBucketAccessRequest{BucketId: "bucket", Name: "access", Parameters: map[string]string{"signedversion": "2020-02-10"}}
+
+	resp, err := logGRPC(context.Background(), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return newTestGrantResponse(), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.(*spec.DriverGrantBucketAccessResponse).Credentials[constant.CredentialType].Secrets[constant.AccessToken] != testSASURL {
+		t.Errorf("expected the response returned to the caller to keep its credentials")
+	}
+
+	_, err = logGRPC(context.Background(), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("PUT %s: 403 AuthenticationFailed", testSASURL))
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	klog.Flush()
+
+	output := buf.String()
+	if !strings.Contains(output, "GRPC response") || !strings.Contains(output, "GRPC error") {
+		t.Fatalf("expected calls to be logged, got %s", output)
+	}
+	for _, secret := range []string{testSignature, "sig=c2Vj", testAccountKey} {
+		if strings.Contains(output, secret) {
+			t.Errorf("expected %s to be redacted from the logs, got %s", secret, output)
+		}
+	}
+}
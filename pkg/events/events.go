@@ -0,0 +1,226 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/config"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// Reasons of the events recorded on Buckets and BucketAccesses
+const (
+	ReasonBucketCreated      = "BucketCreated"
+	ReasonBucketDeleted      = "BucketDeleted"
+	ReasonAccessGranted      = "AccessGranted"
+	ReasonAccessRevoked      = "AccessRevoked"
+	ReasonCredentialsRotated = "CredentialsRotated"
+	ReasonCredentialsRenewed = "CredentialsRenewed"
+	ReasonInvalidRequest     = "InvalidParameters"
+	ReasonAccessDenied       = "AccessDenied"
+	ReasonQuotaExceeded      = "QuotaExceeded"
+	ReasonNotFound           = "NotFound"
+	ReasonAlreadyExists      = "AlreadyExists"
+	ReasonAzureUnavailable   = "AzureUnavailable"
+	ReasonPolicyViolation    = "PolicyViolation"
+	ReasonFailed             = "Failed"
+
+	// maxMessageLength keeps event messages readable in kubectl describe
+	maxMessageLength = 1024
+	lookupTimeout    = 10 * time.Second
+)
+
+var (
+	BucketGVR            = schema.GroupVersionResource{Group: "objectstorage.k8s.io", Version: "v1alpha1", Resource: "buckets"}
+	BucketAccessGVR      = schema.GroupVersionResource{Group: "objectstorage.k8s.io", Version: "v1alpha1", Resource: "bucketaccesses"}
+	BucketClaimGVR       = schema.GroupVersionResource{Group: "objectstorage.k8s.io", Version: "v1alpha1", Resource: "bucketclaims"}
+	BucketAccessClassGVR = schema.GroupVersionResource{Group: "objectstorage.k8s.io", Version: "v1alpha1", Resource: "bucketaccessclasses"}
+)
+
+// failureHints tell users what to do about a failed Azure call
+var failureHints = map[codes.Code]struct {
+	reason string
+	hint   string
+}{
+	codes.InvalidArgument:    {ReasonInvalidRequest, "check the parameters of the class and the bucket or account name"},
+	codes.PermissionDenied:   {ReasonAccessDenied, "grant the driver identity access to the subscription, resource group or storage account"},
+	codes.Unauthenticated:    {ReasonAccessDenied, "check the credentials in the cloud config of the driver"},
+	codes.ResourceExhausted:  {ReasonQuotaExceeded, "an Azure quota or throttling limit was hit, request a quota increase or reuse a storage account"},
+	codes.NotFound:           {ReasonNotFound, "the storage account, resource group or subscription does not exist"},
+	codes.AlreadyExists:      {ReasonAlreadyExists, "the name is already taken, storage account names are global across Azure"},
+	codes.FailedPrecondition: {ReasonFailed, "the storage account is not in a state that allows the operation"},
+	codes.Unavailable:        {ReasonAzureUnavailable, "Azure is unavailable, the operation is retried"},
+	codes.DeadlineExceeded:   {ReasonAzureUnavailable, "the Azure call timed out, the operation is retried"},
+}
+
+// Recorder records Kubernetes Events on the COSI objects the driver acts on.
+// A nil Recorder records nothing.
+// Repeated events are aggregated and rate limited per object by the client-go event correlator.
+type Recorder struct {
+	client   dynamic.Interface
+	recorder record.EventRecorder
+	// pending counts events that are still being looked up
+	pending sync.WaitGroup
+}
+
+// NewRecorder creates a Recorder that sends events to the API server as component
+func NewRecorder(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, component string) *Recorder {
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return newRecorder(dynamicClient, broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component}))
+}
+
+func newRecorder(dynamicClient dynamic.Interface, recorder record.EventRecorder) *Recorder {
+	return &Recorder{client: dynamicClient, recorder: recorder}
+}
+
+// FailureReason returns the event reason and message of a failed operation
+func FailureReason(operation string, err error) (string, string) {
+	code := status.Code(err)
+	msg := err.Error()
+	if s, ok := status.FromError(err); ok {
+		msg = s.Message()
+	}
+	if code == codes.PermissionDenied && strings.HasPrefix(msg, config.PolicyViolationPrefix) {
+		return ReasonPolicyViolation, fmt.Sprintf("%s failed: the class breaks a rule of the driver policy: %s", operation, msg)
+	}
+	if h, ok := failureHints[code]; ok {
+		return h.reason, fmt.Sprintf("%s failed: %s: %s", operation, h.hint, msg)
+	}
+	return ReasonFailed, fmt.Sprintf("%s failed: %s", operation, msg)
+}
+
+// Bucket records an event on the Bucket
+func (r *Recorder) Bucket(name, eventType, reason, message string) {
+	r.record(BucketGVR, "", name, eventType, reason, message)
+}
+
+// BucketFailed records a warning on the Bucket for a failed operation
+func (r *Recorder) BucketFailed(name, operation string, err error) {
+	if skip(err) {
+		return
+	}
+	reason, message := FailureReason(operation, err)
+	r.Bucket(name, v1.EventTypeWarning, reason, message)
+}
+
+// BucketAccess records an event on the BucketAccess. An empty namespace looks it up by name alone,
+// as the COSI requests do not carry the namespace of the BucketAccess.
+func (r *Recorder) BucketAccess(namespace, name, eventType, reason, message string) {
+	r.record(BucketAccessGVR, namespace, name, eventType, reason, message)
+}
+
+// BucketAccessFailed records a warning on the BucketAccess for a failed operation
+func (r *Recorder) BucketAccessFailed(namespace, name, operation string, err error) {
+	if skip(err) {
+		return
+	}
+	reason, message := FailureReason(operation, err)
+	r.BucketAccess(namespace, name, v1.EventTypeWarning, reason, message)
+}
+
+// Wait blocks until all pending events were handed to the broadcaster
+func (r *Recorder) Wait() {
+	if r == nil {
+		return
+	}
+	r.pending.Wait()
+}
+
+// skip drops failures that are not worth an event, an Aborted call is a retry of one still in flight
+func skip(err error) bool {
+	return err == nil || status.Code(err) == codes.Aborted
+}
+
+func (r *Recorder) record(gvr schema.GroupVersionResource, namespace, name, eventType, reason, message string) {
+	if r == nil || name == "" {
+		return
+	}
+	if len(message) > maxMessageLength {
+		message = message[:maxMessageLength-3] + "..."
+	}
+	// looking up the object must not delay the gRPC response
+	r.pending.Add(1)
+	go func() {
+		defer r.pending.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		defer cancel()
+		ref, err := r.getObjectReference(ctx, gvr, namespace, name)
+		if err != nil {
+			klog.V(4).Infof("Not recording event %s on %s %s: %v", reason, gvr.Resource, name, err)
+			return
+		}
+		r.recorder.Event(ref, eventType, reason, message)
+	}()
+}
+
+// getObjectReference looks up the object, events need its UID to show up in kubectl describe.
+// Without a namespace the object is found by name across namespaces, which fails when several
+// namespaces hold a BucketAccess of the name.
+func (r *Recorder) getObjectReference(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*v1.ObjectReference, error) {
+	if namespace != "" {
+		obj, err := r.client.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return newObjectReference(obj), nil
+	}
+	list, err := r.client.Resource(gvr).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	found := 0
+	var obj unstructured.Unstructured
+	for _, item := range list.Items {
+		if item.GetName() == name {
+			obj = item
+			found++
+		}
+	}
+	if found != 1 {
+		// BucketAccesses of the same name in several namespaces cannot be told apart
+		return nil, fmt.Errorf("found %d objects named %s", found, name)
+	}
+	return newObjectReference(&obj), nil
+}
+
+func newObjectReference(obj *unstructured.Unstructured) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		UID:        obj.GetUID(),
+	}
+}
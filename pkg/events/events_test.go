@@ -0,0 +1,179 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestObject(kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("objectstorage.k8s.io/v1alpha1")
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+// newTestRecorder returns a Recorder backed by fake clients holding objects, and the events it records
+func newTestRecorder(objects ...runtime.Object) (*Recorder, *record.FakeRecorder) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		BucketGVR:       "BucketList",
+		BucketAccessGVR: "BucketAccessList",
+	}, objects...)
+	fake := record.NewFakeRecorder(10)
+	return newRecorder(client, fake), fake
+}
+
+func drain(fake *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case e := <-fake.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		testName        string
+		err             error
+		expectedReason  string
+		expectedMessage string
+	}{
+		{
+			testName:        "Quota exceeded",
+			err:             status.Error(codes.ResourceExhausted, "StorageAccountCountExceeded"),
+			expectedReason:  ReasonQuotaExceeded,
+			expectedMessage: "Creating bucket failed: an Azure quota or throttling limit was hit, request a quota increase or reuse a storage account: StorageAccountCountExceeded",
+		},
+		{
+			testName:        "Access denied",
+			err:             status.Error(codes.PermissionDenied, "AuthorizationFailed"),
+			expectedReason:  ReasonAccessDenied,
+			expectedMessage: "Creating bucket failed: grant the driver identity access to the subscription, resource group or storage account: AuthorizationFailed",
+		},
+		{
+			testName:        "Policy violation",
+			err:             status.Error(codes.PermissionDenied, "Denied by policy rule requireHTTPS: signedprotocol must be https"),
+			expectedReason:  ReasonPolicyViolation,
+			expectedMessage: "Creating bucket failed: the class breaks a rule of the driver policy: Denied by policy rule requireHTTPS: signedprotocol must be https",
+		},
+		{
+			testName:        "Invalid name",
+			err:             status.Error(codes.InvalidArgument, "AccountNameInvalid"),
+			expectedReason:  ReasonInvalidRequest,
+			expectedMessage: "Creating bucket failed: check the parameters of the class and the bucket or account name: AccountNameInvalid",
+		},
+		{
+			testName:        "Unclassified",
+			err:             fmt.Errorf("test error"),
+			expectedReason:  ReasonFailed,
+			expectedMessage: "Creating bucket failed: test error",
+		},
+	}
+	for _, test := range tests {
+		reason, message := FailureReason("Creating bucket", test.err)
+		if reason != test.expectedReason || message != test.expectedMessage {
+			t.Errorf("\nTestCase: %s\nExpected: %s %s\nActual: %s %s", test.testName, test.expectedReason, test.expectedMessage, reason, message)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r, fake := newTestRecorder(
+		newTestObject("Bucket", "", "bucket"),
+		newTestObject("BucketAccess", "ns", "access"),
+		newTestObject("BucketAccess", "ns1", "shared"),
+		newTestObject("BucketAccess", "ns2", "shared"),
+	)
+
+	tests := []struct {
+		testName       string
+		record         func()
+		expectedEvents []string
+	}{
+		{
+			testName:       "Bucket created",
+			record:         func() { r.Bucket("bucket", v1.EventTypeNormal, ReasonBucketCreated, "Created container") },
+			expectedEvents: []string{"Normal BucketCreated Created container"},
+		},
+		{
+			testName: "Bucket access failed",
+			record: func() {
+				r.BucketAccessFailed("", "access", "Granting access", status.Error(codes.PermissionDenied, "AuthorizationPermissionMismatch"))
+			},
+			expectedEvents: []string{"Warning AccessDenied Granting access failed: grant the driver identity access to the subscription, resource group or storage account: AuthorizationPermissionMismatch"},
+		},
+		{
+			testName:       "Aborted is not recorded",
+			record:         func() { r.BucketFailed("bucket", "Creating bucket", status.Error(codes.Aborted, "in progress")) },
+			expectedEvents: []string{},
+		},
+		{
+			testName:       "Unknown object",
+			record:         func() { r.Bucket("missing", v1.EventTypeNormal, ReasonBucketCreated, "Created container") },
+			expectedEvents: []string{},
+		},
+		{
+			testName:       "Ambiguous BucketAccess",
+			record:         func() { r.BucketAccess("", "shared", v1.EventTypeNormal, ReasonAccessGranted, "Granted") },
+			expectedEvents: []string{},
+		},
+		{
+			testName:       "BucketAccess in its namespace",
+			record:         func() { r.BucketAccess("ns2", "shared", v1.EventTypeNormal, ReasonCredentialsRenewed, "Renewed") },
+			expectedEvents: []string{"Normal CredentialsRenewed Renewed"},
+		},
+		{
+			testName:       "BucketAccess in another namespace",
+			record:         func() { r.BucketAccess("ns", "shared", v1.EventTypeNormal, ReasonCredentialsRenewed, "Renewed") },
+			expectedEvents: []string{},
+		},
+		{
+			testName:       "Long message",
+			record:         func() { r.Bucket("bucket", v1.EventTypeWarning, ReasonFailed, strings.Repeat("x", 2*maxMessageLength)) },
+			expectedEvents: []string{"Warning Failed " + strings.Repeat("x", maxMessageLength-3) + "..."},
+		},
+	}
+	for _, test := range tests {
+		test.record()
+		r.Wait()
+		events := drain(fake)
+		if strings.Join(events, "\n") != strings.Join(test.expectedEvents, "\n") {
+			t.Errorf("\nTestCase: %s\nExpected Events: %v\nActual Events: %v", test.testName, test.expectedEvents, events)
+		}
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.Bucket("bucket", v1.EventTypeNormal, ReasonBucketCreated, "Created")
+	r.BucketAccessFailed("", "access", "Granting access", fmt.Errorf("test error"))
+	r.Wait()
+}
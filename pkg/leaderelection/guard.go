@@ -0,0 +1,96 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"net/http"
+
+	"k8s.io/klog"
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+// guardedProvisioner forwards Provisioner calls only while the Elector is the leader
+type guardedProvisioner struct {
+	spec.UnimplementedProvisionerServer
+	elector     *Elector
+	provisioner spec.ProvisionerServer
+}
+
+var _ spec.ProvisionerServer = &guardedProvisioner{}
+
+// Guard wraps provisioner so that followers answer every call with Unavailable.
+// The identity server is not guarded, the sidecar of a follower needs it to start.
+func Guard(e *Elector, provisioner spec.ProvisionerServer) spec.ProvisionerServer {
+	if e == nil {
+		return provisioner
+	}
+	return &guardedProvisioner{elector: e, provisioner: provisioner}
+}
+
+func (g *guardedProvisioner) DriverCreateBucket(
+	ctx context.Context,
+	req *spec.DriverCreateBucketRequest) (*spec.DriverCreateBucketResponse, error) {
+	if err := g.elector.checkLeader(); err != nil {
+		return nil, err
+	}
+	return g.provisioner.DriverCreateBucket(ctx, req)
+}
+
+func (g *guardedProvisioner) DriverDeleteBucket(
+	ctx context.Context,
+	req *spec.DriverDeleteBucketRequest) (*spec.DriverDeleteBucketResponse, error) {
+	if err := g.elector.checkLeader(); err != nil {
+		return nil, err
+	}
+	return g.provisioner.DriverDeleteBucket(ctx, req)
+}
+
+func (g *guardedProvisioner) DriverGrantBucketAccess(
+	ctx context.Context,
+	req *spec.DriverGrantBucketAccessRequest) (*spec.DriverGrantBucketAccessResponse, error) {
+	if err := g.elector.checkLeader(); err != nil {
+		return nil, err
+	}
+	return g.provisioner.DriverGrantBucketAccess(ctx, req)
+}
+
+func (g *guardedProvisioner) DriverRevokeBucketAccess(
+	ctx context.Context,
+	req *spec.DriverRevokeBucketAccessRequest) (*spec.DriverRevokeBucketAccessResponse, error) {
+	if err := g.elector.checkLeader(); err != nil {
+		return nil, err
+	}
+	return g.provisioner.DriverRevokeBucketAccess(ctx, req)
+}
+
+// HealthHandler serves /readyz, which fails on followers, and /healthz
+func HealthHandler(e *Elector) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", check(e.Ready))
+	mux.HandleFunc("/healthz", check(e.Healthy))
+	return mux
+}
+
+func check(fn func(*http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := fn(req); err != nil {
+			klog.V(4).Infof("%s failed: %v", req.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	k8sleaderelection "k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+
+	// namespaceEnv is set from the downward API in resources/deployment.yaml
+	namespaceEnv     = "POD_NAMESPACE"
+	defaultNamespace = "default"
+)
+
+var invalidLeaseNameChars = regexp.MustCompile(`[^a-z0-9-]`)
+
+// Config of the Lease the replicas of the driver compete for
+type Config struct {
+	// LeaseName defaults to the driver name
+	LeaseName string
+	// Namespace defaults to the namespace of the pod
+	Namespace string
+	// Identity defaults to the hostname, which is the pod name
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Elector tracks whether this replica holds the Lease.
+// A nil Elector is always the leader, so a single replica runs without leader election.
+type Elector struct {
+	elector *k8sleaderelection.LeaderElector
+	// leader is 1 while the Lease is held
+	leader int32
+	// leading is closed once the Lease is first acquired
+	leading     chan struct{}
+	leadingOnce sync.Once
+	// watchdog fails the liveness check when the leader can no longer renew the Lease
+	watchdog *k8sleaderelection.HealthzAdaptor
+}
+
+// NewElector creates an Elector for the Lease in config, on behalf of the driver driverName
+func NewElector(kubeClient kubernetes.Interface, driverName string, config Config) (*Elector, error) {
+	if config.LeaseName == "" {
+		config.LeaseName = LeaseName(driverName)
+	}
+	if config.Namespace == "" {
+		config.Namespace = os.Getenv(namespaceEnv)
+	}
+	if config.Namespace == "" {
+		config.Namespace = defaultNamespace
+	}
+	if config.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error getting the identity for leader election: %v", err)
+		}
+		config.Identity = hostname
+	}
+	if config.LeaseDuration == 0 {
+		config.LeaseDuration = DefaultLeaseDuration
+	}
+	if config.RenewDeadline == 0 {
+		config.RenewDeadline = DefaultRenewDeadline
+	}
+	if config.RetryPeriod == 0 {
+		config.RetryPeriod = DefaultRetryPeriod
+	}
+
+	e := &Elector{
+		watchdog: k8sleaderelection.NewLeaderHealthzAdaptor(config.LeaseDuration / 2),
+		leading:  make(chan struct{}),
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      config.LeaseName,
+			Namespace: config.Namespace,
+		},
+		Client:     kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: config.Identity},
+	}
+	elector, err := k8sleaderelection.NewLeaderElector(k8sleaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: config.LeaseDuration,
+		RenewDeadline: config.RenewDeadline,
+		RetryPeriod:   config.RetryPeriod,
+		// a replica shutting down hands over at once instead of after LeaseDuration
+		ReleaseOnCancel: true,
+		WatchDog:        e.watchdog,
+		Name:            config.LeaseName,
+		Callbacks: k8sleaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				klog.Infof("%s acquired the lease %s/%s", config.Identity, config.Namespace, config.LeaseName)
+				atomic.StoreInt32(&e.leader, 1)
+				e.leadingOnce.Do(func() { close(e.leading) })
+			},
+			OnStoppedLeading: func() {
+				atomic.StoreInt32(&e.leader, 0)
+			},
+			OnNewLeader: func(identity string) {
+				klog.Infof("The leader of %s/%s is %s", config.Namespace, config.LeaseName, identity)
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.elector = elector
+	return e, nil
+}
+
+// LeaseName turns the driver name into a valid Lease name
+func LeaseName(driverName string) string {
+	return strings.Trim(invalidLeaseNameChars.ReplaceAllString(strings.ToLower(driverName), "-"), "-")
+}
+
+// Run competes for the Lease until ctx is cancelled or leadership is lost.
+// The state kept in memory by the provisioner is only valid while leading, so
+// the caller must exit once Run returns and restart as a follower.
+func (e *Elector) Run(ctx context.Context) {
+	if e == nil {
+		<-ctx.Done()
+		return
+	}
+	e.elector.Run(ctx)
+}
+
+// IsLeader returns true while this replica holds the Lease
+func (e *Elector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// Leading returns a channel that is closed once this replica holds the Lease. Work that must only
+// run on the leader waits for it, a replica that loses the Lease exits so it never stops leading.
+func (e *Elector) Leading() <-chan struct{} {
+	if e == nil {
+		leading := make(chan struct{})
+		close(leading)
+		return leading
+	}
+	return e.leading
+}
+
+// Ready is the readiness check, followers are not ready so Services only route to the leader
+func (e *Elector) Ready(_ *http.Request) error {
+	if !e.IsLeader() {
+		return fmt.Errorf("not the leader")
+	}
+	return nil
+}
+
+// Healthy is the liveness check, it fails when the leader could not renew the Lease in time
+func (e *Elector) Healthy(req *http.Request) error {
+	if e == nil {
+		return nil
+	}
+	return e.watchdog.Check(req)
+}
+
+// checkLeader refuses calls on followers, the sidecar of the leader handles the same objects
+func (e *Elector) checkLeader() error {
+	if !e.IsLeader() {
+		return status.Error(codes.Unavailable, "This replica is not the leader")
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leaderelection
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes/fake"
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+type fakeProvisioner struct {
+	spec.UnimplementedProvisionerServer
+}
+
+func (*fakeProvisioner) DriverCreateBucket(
+	ctx context.Context,
+	req *spec.DriverCreateBucketRequest) (*spec.DriverCreateBucketResponse, error) {
+	return &spec.DriverCreateBucketResponse{BucketId: req.GetName()}, nil
+}
+
+func newTestElector(t *testing.T, client *fake.Clientset, identity string) *Elector {
+	e, err := NewElector(client, "blob.cosi.azure.com", Config{
+		Namespace:     "kube-system",
+		Identity:      identity,
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return e
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the leader election")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestLeaseName(t *testing.T) {
+	tests := []struct {
+		driverName   string
+		expectedName string
+	}{
+		{driverName: "blob.cosi.azure.com", expectedName: "blob-cosi-azure-com"},
+		{driverName: "Blob_COSI", expectedName: "blob-cosi"},
+		{driverName: ".driver.", expectedName: "driver"},
+	}
+	for _, test := range tests {
+		name := LeaseName(test.driverName)
+		if name != test.expectedName {
+			t.Errorf("\nTestCase: %s\nExpected Name: %s\nActual Name: %s", test.driverName, test.expectedName, name)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	first := newTestElector(t, client, "first")
+	second := newTestElector(t, client, "second")
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		first.Run(firstCtx)
+		close(firstDone)
+	}()
+	waitFor(t, first.IsLeader)
+	select {
+	case <-first.Leading():
+	default:
+		t.Errorf("expected Leading to be closed on the leader")
+	}
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go second.Run(secondCtx)
+
+	select {
+	case <-second.Leading():
+		t.Errorf("expected Leading to stay open on a follower")
+	default:
+	}
+
+	guarded := Guard(second, &fakeProvisioner{})
+	_, err := guarded.DriverCreateBucket(context.Background(), &spec.DriverCreateBucketRequest{Name: "bucket"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected a follower to refuse calls with Unavailable, got %v", err)
+	}
+
+	handler := HealthHandler(second)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected a follower to be not ready, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected a follower to be healthy, got %d", rec.Code)
+	}
+
+	// the first replica shuts down and releases the lease
+	stopFirst()
+	<-firstDone
+	if first.IsLeader() {
+		t.Errorf("expected the first replica to stop leading")
+	}
+	waitFor(t, second.IsLeader)
+	<-second.Leading()
+
+	resp, err := guarded.DriverCreateBucket(context.Background(), &spec.DriverCreateBucketRequest{Name: "bucket"})
+	if err != nil || resp.GetBucketId() != "bucket" {
+		t.Errorf("expected the leader to serve calls, got %v %v", resp, err)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the leader to be ready, got %d", rec.Code)
+	}
+}
+
+func TestNilElector(t *testing.T) {
+	var e *Elector
+	if !e.IsLeader() {
+		t.Errorf("expected a nil elector to lead")
+	}
+	select {
+	case <-e.Leading():
+	default:
+		t.Errorf("expected Leading to be closed on a nil elector")
+	}
+	provisioner := &fakeProvisioner{}
+	if Guard(e, provisioner) != spec.ProvisionerServer(provisioner) {
+		t.Errorf("expected a nil elector not to guard the provisioner")
+	}
+	rec := httptest.NewRecorder()
+	HealthHandler(e).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected a nil elector to be ready, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics holds the Prometheus metrics the driver exposes on --metrics-address
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "azure_cosi"
+
+// Results of a SAS renewal
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	// Registry holds the metrics of the driver, separate from the default registry of client_golang
+	Registry = prometheus.NewRegistry()
+
+	// SASExpiry is the expiry of the SAS last issued for each BucketAccess
+	SASExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sas_expiry_timestamp_seconds",
+		Help:      "Unix time at which the SAS last issued for a BucketAccess expires.",
+	}, []string{"bucket_access", "namespace", "storage_account", "bucket"})
+
+	// SASRenewals counts the SAS reissued before they expire
+	SASRenewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sas_renewals_total",
+		Help:      "SAS the driver reissued before they expired, by result.",
+	}, []string{"result"})
+
+	// BucketBlobs, BucketBytes and BucketTierBytes are the usage of each bucket, labelled by the
+	// name of the Bucket and the namespace of its BucketClaim
+	BucketBlobs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bucket_blobs",
+		Help:      "Number of blobs stored in a bucket.",
+	}, []string{"bucket", "namespace"})
+	BucketBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bucket_bytes",
+		Help:      "Bytes stored in a bucket.",
+	}, []string{"bucket", "namespace"})
+	BucketTierBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bucket_tier_bytes",
+		Help:      "Bytes stored in a bucket per access tier.",
+	}, []string{"bucket", "namespace", "tier"})
+
+	// bucketTiers are the tiers published for each bucket, so tiers that emptied are removed
+	bucketTiers     = map[[2]string][]string{}
+	bucketTiersLock sync.Mutex
+)
+
+func init() {
+	Registry.MustRegister(SASExpiry, SASRenewals, BucketBlobs, BucketBytes, BucketTierBytes)
+}
+
+// SetBucketUsage publishes the usage of a bucket, replacing the tiers published before
+func SetBucketUsage(bucket, namespace string, blobs, bytes int64, bytesPerTier map[string]int64) {
+	bucketTiersLock.Lock()
+	defer bucketTiersLock.Unlock()
+	key := [2]string{bucket, namespace}
+	for _, tier := range bucketTiers[key] {
+		if _, ok := bytesPerTier[tier]; !ok {
+			BucketTierBytes.DeleteLabelValues(bucket, namespace, tier)
+		}
+	}
+
+	BucketBlobs.WithLabelValues(bucket, namespace).Set(float64(blobs))
+	BucketBytes.WithLabelValues(bucket, namespace).Set(float64(bytes))
+	tiers := make([]string, 0, len(bytesPerTier))
+	for tier, tierBytes := range bytesPerTier {
+		BucketTierBytes.WithLabelValues(bucket, namespace, tier).Set(float64(tierBytes))
+		tiers = append(tiers, tier)
+	}
+	bucketTiers[key] = tiers
+}
+
+// DeleteBucketUsage removes the usage of a bucket
+func DeleteBucketUsage(bucket, namespace string) {
+	bucketTiersLock.Lock()
+	defer bucketTiersLock.Unlock()
+	key := [2]string{bucket, namespace}
+	BucketBlobs.DeleteLabelValues(bucket, namespace)
+	BucketBytes.DeleteLabelValues(bucket, namespace)
+	for _, tier := range bucketTiers[key] {
+		BucketTierBytes.DeleteLabelValues(bucket, namespace, tier)
+	}
+	delete(bucketTiers, key)
+}
+
+// Handler serves the metrics of Registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-cosi-driver/pkg/audit"
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/driver"
+
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+// newAuditRecord fills in the bucket and outcome shared by grant and revoke records
+func newAuditRecord(action audit.Action, bucketID, accountID string, err error) audit.Record {
+	r := audit.Record{
+		Action:    action,
+		Outcome:   audit.OutcomeSuccess,
+		AccountID: accountID,
+	}
+	if err != nil {
+		r.Outcome = audit.OutcomeFailure
+		// errors of the storage clients may quote the request URL with its SAS
+		r.Error = driver.RedactString(err.Error())
+	}
+	if account, bucket, directory, err := azureutils.GetBucketLocation(bucketID); err == nil {
+		r.StorageAccount = account
+		r.Container = bucket
+		r.Directory = directory
+	}
+	return r
+}
+
+func (pr *provisioner) auditGrant(req *spec.DriverGrantBucketAccessRequest, resp *spec.DriverGrantBucketAccessResponse, err error) {
+	if pr.auditLogger == nil {
+		return
+	}
+	accountID := ""
+	if resp != nil {
+		accountID = resp.AccountId
+	}
+	r := newAuditRecord(audit.ActionGrant, req.GetBucketId(), accountID, err)
+	r.AuthenticationType = req.GetAuthenticationType().String()
+	r.AccessName = req.GetName()
+
+	if req.GetAuthenticationType() == spec.AuthenticationType_IAM {
+		if permissions, err := azureutils.GetIAMPermissions(req.GetParameters()); err == nil {
+			r.Permissions = permissions
+		}
+	} else if resp != nil {
+		// the scope is read back from the SAS that was issued, the signature is dropped
+		if details, ok := resp.Credentials[constant.CredentialType]; ok {
+			r.SetSASToken(details.Secrets[constant.SASToken])
+		}
+	}
+	pr.auditLogger.Log(r)
+}
+
+// auditReissue records a SAS reissued for a grant by key rotation or renewal, described by operation
+func (pr *provisioner) auditReissue(grant *grantDetails, secrets map[string]string, operation string, err error) {
+	if pr.auditLogger == nil {
+		return
+	}
+	r := newAuditRecord(audit.ActionGrant, grant.bucketID, grant.accountID, err)
+	r.AuthenticationType = spec.AuthenticationType_Key.String()
+	r.AccessName = grant.access.Name
+	r.Detail = operation
+	if err == nil {
+		r.SetSASToken(secrets[constant.SASToken])
+	}
+	pr.auditLogger.Log(r)
+}
+
+func (pr *provisioner) auditRevoke(req *spec.DriverRevokeBucketAccessRequest, err error) {
+	if pr.auditLogger == nil {
+		return
+	}
+	r := newAuditRecord(audit.ActionRevoke, req.GetBucketId(), req.GetAccountId(), err)
+	if strings.HasPrefix(req.GetAccountId(), azureutils.SASAccountIDPrefix) {
+		r.AuthenticationType = spec.AuthenticationType_Key.String()
+		r.Detail = "a SAS cannot be revoked individually, it stays valid until it expires"
+	}
+	pr.auditLogger.Log(r)
+}
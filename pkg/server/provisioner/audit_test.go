@@ -0,0 +1,167 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/audit"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	"github.com/golang/mock/gomock"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+func readAuditRecords(t *testing.T, buf *bytes.Buffer) []audit.Record {
+	records := []audit.Record{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r audit.Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestAuditGrantAndRevoke(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	pr := newFakeProvisioner(ctrl)
+	buf := &bytes.Buffer{}
+	pr.(*provisioner).auditLogger = audit.NewLogger(audit.NewWriterSink(buf))
+
+	id, err := (&types.BucketID{URL: constant.ValidAccountURL}).Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := pr.DriverGrantBucketAccess(context.Background(), &spec.DriverGrantBucketAccessRequest{
+		BucketId:           id,
+		Name:               "access",
+		AuthenticationType: spec.AuthenticationType_Key,
+		Parameters:         map[string]string{},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := pr.DriverGrantBucketAccess(context.Background(), &spec.DriverGrantBucketAccessRequest{
+		BucketId:           id,
+		Name:               "denied",
+		AuthenticationType: spec.AuthenticationType_IAM,
+		Parameters:         map[string]string{},
+	}); err == nil {
+		t.Fatalf("expected IAM access to a storage account to fail")
+	}
+	if _, err := pr.DriverRevokeBucketAccess(context.Background(), &spec.DriverRevokeBucketAccessRequest{
+		BucketId:  id,
+		AccountId: resp.AccountId,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sasToken := resp.Credentials[constant.CredentialType].Secrets[constant.SASToken]
+	query, err := url.ParseQuery(sasToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), query.Get("sig")) || strings.Contains(buf.String(), url.QueryEscape(query.Get("sig"))) {
+		t.Errorf("expected the SAS signature to be absent from the audit log: %s", buf.String())
+	}
+
+	records := readAuditRecords(t, buf)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records, got %d: %s", len(records), buf.String())
+	}
+	grant, failed, revoke := records[0], records[1], records[2]
+	if grant.Action != audit.ActionGrant || grant.Outcome != audit.OutcomeSuccess || grant.AccountID != resp.AccountId ||
+		grant.AccessName != "access" || grant.StorageAccount != constant.ValidAccount || grant.AuthenticationType != spec.AuthenticationType_Key.String() {
+		t.Errorf("unexpected grant record: %+v", grant)
+	}
+	if grant.Permissions != query.Get("sp") || grant.Expiry != query.Get("se") || grant.Protocol != query.Get("spr") || grant.Expiry == "" {
+		t.Errorf("expected the grant record to describe the SAS %s, got %+v", sasToken, grant)
+	}
+	if failed.Outcome != audit.OutcomeFailure || failed.Error == "" || failed.AccessName != "denied" {
+		t.Errorf("unexpected failed grant record: %+v", failed)
+	}
+	if revoke.Action != audit.ActionRevoke || revoke.Outcome != audit.OutcomeSuccess || revoke.AccountID != resp.AccountId || revoke.Detail == "" {
+		t.Errorf("unexpected revoke record: %+v", revoke)
+	}
+}
+
+func TestAuditReissuedGrant(t *testing.T) {
+	ctx := context.Background()
+	pr, _ := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{}
+	pr.accesses = accesses
+	grantResp, _ := grantContainerAccess(t, pr)
+	buf := &bytes.Buffer{}
+	pr.auditLogger = audit.NewLogger(audit.NewWriterSink(buf))
+
+	access := k8stypes.NamespacedName{Namespace: "app", Name: "access"}
+	grant := pr.grants.forAccount(storageAccountRef{subsID: fakeazure.SubscriptionID, resourceGroup: fakeazure.ResourceGroup, name: "fakeaccount"})[access]
+	if grant == nil {
+		t.Fatalf("expected the grant of %v to be tracked", access)
+	}
+	if err := pr.reissueGrant(ctx, grant, "Renewing credentials"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	accesses.err = errors.New("secret not found")
+	if err := pr.reissueGrant(ctx, grant, "Renewing credentials"); err == nil {
+		t.Fatalf("expected an error when the Secret cannot be updated")
+	}
+
+	query, err := url.ParseQuery(accesses.current[constant.SASToken])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), query.Get("sig")) || strings.Contains(buf.String(), url.QueryEscape(query.Get("sig"))) {
+		t.Errorf("expected the SAS signature to be absent from the audit log: %s", buf.String())
+	}
+	records := readAuditRecords(t, buf)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 audit records, got %d: %s", len(records), buf.String())
+	}
+	reissued, failed := records[0], records[1]
+	if reissued.Action != audit.ActionGrant || reissued.Outcome != audit.OutcomeSuccess || reissued.AccountID != grantResp.AccountId ||
+		reissued.AccessName != "access" || reissued.StorageAccount != "fakeaccount" || reissued.Detail != "Renewing credentials" {
+		t.Errorf("unexpected reissue record: %+v", reissued)
+	}
+	if reissued.Permissions != query.Get("sp") || reissued.Expiry != query.Get("se") || reissued.Expiry == "" {
+		t.Errorf("expected the reissue record to describe the reissued SAS, got %+v", reissued)
+	}
+	if failed.Action != audit.ActionGrant || failed.Outcome != audit.OutcomeFailure || failed.AccountID != grantResp.AccountId ||
+		!strings.Contains(failed.Error, "secret not found") {
+		t.Errorf("unexpected failed reissue record: %+v", failed)
+	}
+}
+
+func TestAuditRecordRedactsError(t *testing.T) {
+	err := errors.New("GET https://account.blob.core.windows.net/container?sv=2020-10-02&sig=c2VjcmV0&se=2030-01-01 failed, " +
+		"DefaultEndpointsProtocol=https;AccountName=account;AccountKey=a2V5;EndpointSuffix=core.windows.net")
+	r := newAuditRecord(audit.ActionGrant, "", "", err)
+	if strings.Contains(r.Error, "c2VjcmV0") || strings.Contains(r.Error, "a2V5") {
+		t.Errorf("expected the signature and the account key to be redacted, got %s", r.Error)
+	}
+	if r.Outcome != audit.OutcomeFailure || !strings.Contains(r.Error, "se=2030-01-01") {
+		t.Errorf("expected the rest of the error to be kept, got %+v", r)
+	}
+}
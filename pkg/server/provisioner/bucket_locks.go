@@ -0,0 +1,50 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"sync"
+)
+
+// bucketLocks tracks the bucket names or IDs that have an operation in flight.
+// The COSI sidecar retries concurrently, a call for a key that is already held
+// is answered with Aborted instead of reaching Azure a second time.
+type bucketLocks struct {
+	mux   sync.Mutex
+	locks map[string]struct{}
+}
+
+func newBucketLocks() *bucketLocks {
+	return &bucketLocks{
+		locks: make(map[string]struct{}),
+	}
+}
+
+// TryAcquire marks the key as in flight, returning false if it already was
+func (l *bucketLocks) TryAcquire(key string) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if _, exists := l.locks[key]; exists {
+		return false
+	}
+	l.locks[key] = struct{}{}
+	return true
+}
+
+// Release marks the key as no longer in flight
+func (l *bucketLocks) Release(key string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	delete(l.locks, key)
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+const concurrentCalls = 10
+
+func TestBucketLocks(t *testing.T) {
+	locks := newBucketLocks()
+	if !locks.TryAcquire("bucket") {
+		t.Errorf("expected first acquire to succeed")
+	}
+	if locks.TryAcquire("bucket") {
+		t.Errorf("expected second acquire to fail")
+	}
+	if !locks.TryAcquire("other") {
+		t.Errorf("expected acquire of another key to succeed")
+	}
+	locks.Release("bucket")
+	if !locks.TryAcquire("bucket") {
+		t.Errorf("expected acquire after release to succeed")
+	}
+}
+
+func TestBucketLocksConcurrent(t *testing.T) {
+	locks := newBucketLocks()
+	var acquired int32
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentCalls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if locks.TryAcquire("bucket") {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired != 1 {
+		t.Errorf("expected exactly one acquire to succeed, got %d", acquired)
+	}
+}
+
+// blockAzure replaces the Azure calls of the provisioner with ones that count their calls
+// and block until release is closed
+func blockAzure(t *testing.T) (calls *int32, started, release chan struct{}) {
+	calls = new(int32)
+	started = make(chan struct{}, concurrentCalls)
+	release = make(chan struct{})
+
+	origCreate, origDelete := createBucket, deleteBucket
+	t.Cleanup(func() { createBucket, deleteBucket = origCreate, origDelete })
+
+	createBucket = func(ctx context.Context, bucketName string, parameters map[string]string, cloud *azure.Cloud) (string, error) {
+		atomic.AddInt32(calls, 1)
+		notify(started)
+		<-release
+		return "id-" + bucketName, nil
+	}
+	deleteBucket = func(ctx context.Context, bucketID string, cloud *azure.Cloud) error {
+		atomic.AddInt32(calls, 1)
+		notify(started)
+		<-release
+		return nil
+	}
+	return calls, started, release
+}
+
+func notify(started chan struct{}) {
+	select {
+	case started <- struct{}{}:
+	default:
+	}
+}
+
+func TestDriverCreateBucketConcurrent(t *testing.T) {
+	calls, started, release := blockAzure(t)
+	ctrl := gomock.NewController(t)
+	pr := newFakeProvisioner(ctrl)
+	req := &spec.DriverCreateBucketRequest{Name: "bucket", Parameters: map[string]string{}}
+
+	var wg sync.WaitGroup
+	var firstErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, firstErr = pr.DriverCreateBucket(context.Background(), req)
+	}()
+	<-started
+
+	// every retry while the first create is in flight is aborted
+	errs := make([]error, concurrentCalls)
+	var retries sync.WaitGroup
+	for i := 0; i < concurrentCalls; i++ {
+		retries.Add(1)
+		go func(i int) {
+			defer retries.Done()
+			_, errs[i] = pr.DriverCreateBucket(context.Background(), req)
+		}(i)
+	}
+	retries.Wait()
+	close(release)
+	wg.Wait()
+
+	if firstErr != nil {
+		t.Errorf("unexpected error: %v", firstErr)
+	}
+	expectedErr := status.Error(codes.Aborted, fmt.Sprintf("An operation for bucket %s is already in progress", "bucket"))
+	for _, err := range errs {
+		if err == nil || err.Error() != expectedErr.Error() {
+			t.Errorf("expected %v, got %v", expectedErr, err)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("expected one Azure call, got %d", *calls)
+	}
+
+	// once the create finished, retries share its result
+	resp, err := pr.DriverCreateBucket(context.Background(), req)
+	if err != nil || resp.BucketId != "id-bucket" {
+		t.Errorf("expected bucket id id-bucket, got %v, %v", resp, err)
+	}
+	if *calls != 1 {
+		t.Errorf("expected one Azure call, got %d", *calls)
+	}
+}
+
+func TestDriverDeleteBucketConcurrent(t *testing.T) {
+	calls, started, release := blockAzure(t)
+	ctrl := gomock.NewController(t)
+	pr := newFakeProvisioner(ctrl)
+
+	close(release)
+	if _, err := pr.DriverCreateBucket(context.Background(), &spec.DriverCreateBucketRequest{Name: "bucket", Parameters: map[string]string{}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-started
+	release = make(chan struct{})
+	deleteBucket = func(ctx context.Context, bucketID string, cloud *azure.Cloud) error {
+		atomic.AddInt32(calls, 1)
+		notify(started)
+		<-release
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := pr.DriverDeleteBucket(context.Background(), &spec.DriverDeleteBucketRequest{BucketId: "id-bucket"}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+	<-started
+
+	_, err := pr.DriverDeleteBucket(context.Background(), &spec.DriverDeleteBucketRequest{BucketId: "id-bucket"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("expected concurrent delete to be aborted, got %v", err)
+	}
+	_, err = pr.DriverCreateBucket(context.Background(), &spec.DriverCreateBucketRequest{Name: "bucket", Parameters: map[string]string{}})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("expected create during delete to be aborted, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	if *calls != 2 {
+		t.Errorf("expected two Azure calls, got %d", *calls)
+	}
+
+	p := pr.(*provisioner)
+	p.bucketsLock.RLock()
+	defer p.bucketsLock.RUnlock()
+	if len(p.nameToBucketMap) != 0 || len(p.bucketIDToNameMap) != 0 {
+		t.Errorf("expected bucket maps to be empty, got %v, %v", p.nameToBucketMap, p.bucketIDToNameMap)
+	}
+}
+
+func TestDriverCreateBucketDistinctNamesConcurrent(t *testing.T) {
+	calls, _, release := blockAzure(t)
+	close(release)
+	ctrl := gomock.NewController(t)
+	pr := newFakeProvisioner(ctrl)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentCalls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("bucket%d", i)
+			if _, err := pr.DriverCreateBucket(context.Background(), &spec.DriverCreateBucketRequest{Name: name, Parameters: map[string]string{}}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if _, err := pr.DriverDeleteBucket(context.Background(), &spec.DriverDeleteBucketRequest{BucketId: "id-" + name}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if *calls != 2*concurrentCalls {
+		t.Errorf("expected %d Azure calls, got %d", 2*concurrentCalls, *calls)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/driver"
+	identityserver "github.com/Azure/azure-cosi-driver/pkg/server/identity"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/conformance"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+// startConformanceServer serves the identity server and pr on a unix socket and returns its endpoint
+func startConformanceServer(t *testing.T, pr *provisioner) string {
+	// t.TempDir can exceed the length limit of unix socket paths
+	dir, err := os.MkdirTemp("", "cosi")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	identityServer, err := identityserver.NewIdentityServer(driver.DriverName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	endpoint := "unix://" + filepath.Join(dir, "cosi.sock")
+	server, err := driver.StartServers(endpoint, identityServer, pr)
+	if err != nil {
+		t.Fatalf("unexpected error starting servers: %v", err)
+	}
+	t.Cleanup(func() {
+		server.Stop()
+		server.Wait()
+	})
+	return endpoint
+}
+
+func checkSASCredentials(t *testing.T, credentials map[string]*spec.CredentialDetails) {
+	azure, ok := credentials[constant.CredentialType]
+	if !ok {
+		t.Fatalf("expected %s credentials, got %v", constant.CredentialType, credentials)
+	}
+	for _, key := range []string{constant.AccessToken, constant.SASToken, constant.Endpoint, constant.AccountName, constant.ExpiryTimestamp} {
+		if azure.Secrets[key] == "" {
+			t.Errorf("expected secret %s to be set", key)
+		}
+	}
+}
+
+func TestConformanceContainerBuckets(t *testing.T) {
+	pr, _ := newFakeAzureProvisioner(t)
+	createFakeAzureAccount(t, pr, "fakeaccount")
+
+	conformance.Run(t, conformance.Driver{
+		Endpoint: startConformanceServer(t, pr),
+		Name:     driver.DriverName,
+		BucketParameters: map[string]string{
+			constant.BucketUnitTypeField:     constant.Container.String(),
+			constant.StorageAccountNameField: "fakeaccount",
+			constant.ResourceGroupField:      fakeazure.ResourceGroup,
+		},
+		ConflictingBucketParameters: map[string]string{
+			constant.BucketUnitTypeField:     constant.Container.String(),
+			constant.StorageAccountNameField: "otheraccount",
+			constant.ResourceGroupField:      fakeazure.ResourceGroup,
+		},
+		InvalidBucketParameters: map[string]string{
+			constant.BucketUnitTypeField: "invalid",
+		},
+		AccessParameters: map[string]string{
+			constant.EnableReadField:  "true",
+			constant.EnableWriteField: "true",
+		},
+		CheckCredentials: checkSASCredentials,
+	})
+}
+
+func TestConformanceStorageAccountBuckets(t *testing.T) {
+	pr, _ := newFakeAzureProvisioner(t)
+
+	conformance.Run(t, conformance.Driver{
+		Endpoint: startConformanceServer(t, pr),
+		Name:     driver.DriverName,
+		BucketParameters: map[string]string{
+			constant.BucketUnitTypeField: constant.StorageAccount.String(),
+			constant.ResourceGroupField:  fakeazure.ResourceGroup,
+		},
+		ConflictingBucketParameters: map[string]string{
+			constant.BucketUnitTypeField: constant.StorageAccount.String(),
+			constant.ResourceGroupField:  fakeazure.ResourceGroup,
+			constant.AccessTierField:     constant.Cool.String(),
+		},
+		InvalidBucketParameters: map[string]string{
+			constant.BucketUnitTypeField: constant.StorageAccount.String(),
+			constant.AccessTierField:     "invalid",
+		},
+		AccessParameters: map[string]string{
+			constant.EnableListField:                       "true",
+			constant.AllowServiceSignedResourceTypeField:   "true",
+			constant.AllowContainerSignedResourceTypeField: "true",
+		},
+		CheckCredentials: checkSASCredentials,
+	})
+}
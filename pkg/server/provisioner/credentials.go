@@ -0,0 +1,265 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/driver"
+	"github.com/Azure/azure-cosi-driver/pkg/events"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// bucketInfoKey is the Secret key under which the sidecar stores the BucketInfo document
+const bucketInfoKey = "BucketInfo"
+
+// reissuedKeys are the credentials that change when a SAS is reissued
+var reissuedKeys = []string{constant.AccessToken, constant.SASToken, constant.ConnectionString, constant.ExpiryTimestamp}
+
+// bucketAccesses reads the BucketAccesses of the cluster and hands reissued credentials to their workloads.
+// COSI only calls DriverGrantBucketAccess once per BucketAccess, so credentials the driver reissues on
+// its own never reach the Secret the sidecar wrote without it.
+type bucketAccesses interface {
+	// Resolve returns the BucketAccess named name that was granted the AccountId on the bucket
+	Resolve(ctx context.Context, name, bucketID, accountID string) (k8stypes.NamespacedName, error)
+	// ListAccountIDs returns the AccountId of every BucketAccess that is not being deleted, empty until it is granted
+	ListAccountIDs(ctx context.Context) (map[k8stypes.NamespacedName]string, error)
+	// GetGrant reads back what is needed to reissue the SAS of a granted BucketAccess
+	GetGrant(ctx context.Context, access k8stypes.NamespacedName) (*grantDetails, error)
+	// UpdateCredentials writes reissued credentials to the credentials Secret of the BucketAccess
+	UpdateCredentials(ctx context.Context, access k8stypes.NamespacedName, current map[string]string) error
+}
+
+// clusterBucketAccesses reads BucketAccesses and rewrites their credentials Secrets
+type clusterBucketAccesses struct {
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+}
+
+func newClusterBucketAccesses(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface) *clusterBucketAccesses {
+	return &clusterBucketAccesses{kubeClient: kubeClient, dynamicClient: dynamicClient}
+}
+
+// Resolve tells BucketAccesses of the same name in several namespaces apart by their bucket and by
+// the AccountId, which the sidecar only records once the grant returned
+func (u *clusterBucketAccesses) Resolve(ctx context.Context, name, bucketID, accountID string) (k8stypes.NamespacedName, error) {
+	list, err := u.dynamicClient.Resource(events.BucketAccessGVR).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+	if err != nil {
+		return k8stypes.NamespacedName{}, fmt.Errorf("could not list BucketAccess %s: %v", name, err)
+	}
+	var candidates []unstructured.Unstructured
+	for _, item := range list.Items {
+		if item.GetName() == name && item.GetDeletionTimestamp() == nil {
+			candidates = append(candidates, item)
+		}
+	}
+	if len(candidates) > 1 {
+		var matching []unstructured.Unstructured
+		for _, item := range candidates {
+			granted, _, _ := unstructured.NestedString(item.Object, "status", "accountID")
+			if granted != "" && granted != accountID {
+				continue
+			}
+			claimName, _, _ := unstructured.NestedString(item.Object, "spec", "bucketClaimName")
+			if id, err := u.getBucketID(ctx, item.GetNamespace(), claimName); err == nil && id == bucketID {
+				matching = append(matching, item)
+			}
+		}
+		candidates = matching
+	}
+	if len(candidates) != 1 {
+		return k8stypes.NamespacedName{}, fmt.Errorf("found %d BucketAccesses named %s for the bucket", len(candidates), name)
+	}
+	return k8stypes.NamespacedName{Namespace: candidates[0].GetNamespace(), Name: name}, nil
+}
+
+// ListAccountIDs lists the BucketAccesses of every namespace
+func (u *clusterBucketAccesses) ListAccountIDs(ctx context.Context) (map[k8stypes.NamespacedName]string, error) {
+	list, err := u.dynamicClient.Resource(events.BucketAccessGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list BucketAccesses: %v", err)
+	}
+	accountIDs := map[k8stypes.NamespacedName]string{}
+	for _, item := range list.Items {
+		if item.GetDeletionTimestamp() != nil {
+			continue
+		}
+		accountID, _, _ := unstructured.NestedString(item.Object, "status", "accountID")
+		accountIDs[k8stypes.NamespacedName{Namespace: item.GetNamespace(), Name: item.GetName()}] = accountID
+	}
+	return accountIDs, nil
+}
+
+// GetGrant follows the BucketAccess to its class for the parameters, to its BucketClaim and Bucket
+// for the BucketID, and to its credentials Secret for the expiry of the SAS
+func (u *clusterBucketAccesses) GetGrant(ctx context.Context, access k8stypes.NamespacedName) (*grantDetails, error) {
+	item, err := u.dynamicClient.Resource(events.BucketAccessGVR).Namespace(access.Namespace).Get(ctx, access.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get BucketAccess %s: %v", access, err)
+	}
+	accountID, _, _ := unstructured.NestedString(item.Object, "status", "accountID")
+	className, _, _ := unstructured.NestedString(item.Object, "spec", "bucketAccessClassName")
+	claimName, _, _ := unstructured.NestedString(item.Object, "spec", "bucketClaimName")
+
+	class, err := u.dynamicClient.Resource(events.BucketAccessClassGVR).Get(ctx, className, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get BucketAccessClass %s: %v", className, err)
+	}
+	if driverName, _, _ := unstructured.NestedString(class.Object, "driverName"); driverName != driver.DriverName {
+		return nil, fmt.Errorf("BucketAccessClass %s is for driver %s", className, driverName)
+	}
+	parameters, _, err := unstructured.NestedStringMap(class.Object, "parameters")
+	if err != nil {
+		return nil, fmt.Errorf("could not read the parameters of BucketAccessClass %s: %v", className, err)
+	}
+	bucketID, err := u.getBucketID(ctx, access.Namespace, claimName)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := u.getCredentialsSecret(ctx, access)
+	if err != nil {
+		return nil, err
+	}
+	grant := &grantDetails{access: access, accountID: accountID, bucketID: bucketID, parameters: parameters}
+	if expiry, err := time.Parse(time.RFC3339, getCredential(secret, constant.ExpiryTimestamp)); err == nil {
+		grant.expiry = expiry
+	}
+	return grant, nil
+}
+
+// UpdateCredentials writes the reissued values over the credentials the Secret holds, as keys of its
+// own and in the secretAzure of the BucketInfo document the sidecar writes, whichever it has
+func (u *clusterBucketAccesses) UpdateCredentials(ctx context.Context, access k8stypes.NamespacedName, current map[string]string) error {
+	secret, err := u.getCredentialsSecret(ctx, access)
+	if err != nil {
+		return err
+	}
+	written, err := setCredentials(secret, current)
+	if err != nil {
+		return fmt.Errorf("could not update Secret %s/%s of BucketAccess %s: %v", secret.Namespace, secret.Name, access, err)
+	}
+	if written == 0 {
+		return fmt.Errorf("the Secret %s/%s of BucketAccess %s holds no credentials of the driver", secret.Namespace, secret.Name, access)
+	}
+	if _, err := u.kubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update Secret %s/%s of BucketAccess %s: %v", secret.Namespace, secret.Name, access, err)
+	}
+	return nil
+}
+
+// getCredentialsSecret returns the credentials Secret of the BucketAccess
+func (u *clusterBucketAccesses) getCredentialsSecret(ctx context.Context, access k8stypes.NamespacedName) (*v1.Secret, error) {
+	item, err := u.dynamicClient.Resource(events.BucketAccessGVR).Namespace(access.Namespace).Get(ctx, access.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get BucketAccess %s: %v", access, err)
+	}
+	secretName, _, _ := unstructured.NestedString(item.Object, "spec", "credentialsSecretName")
+	if secretName == "" {
+		return nil, fmt.Errorf("BucketAccess %s has no credentialsSecretName", access)
+	}
+	secret, err := u.kubeClient.CoreV1().Secrets(access.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get Secret %s/%s of BucketAccess %s: %v", access.Namespace, secretName, access, err)
+	}
+	return secret, nil
+}
+
+// getBucketID returns the BucketID of the Bucket the BucketClaim is bound to
+func (u *clusterBucketAccesses) getBucketID(ctx context.Context, namespace, claimName string) (string, error) {
+	claim, err := u.dynamicClient.Resource(events.BucketClaimGVR).Namespace(namespace).Get(ctx, claimName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get BucketClaim %s/%s: %v", namespace, claimName, err)
+	}
+	bucketName, _, _ := unstructured.NestedString(claim.Object, "status", "bucketName")
+	if bucketName == "" {
+		return "", fmt.Errorf("BucketClaim %s/%s is not bound to a Bucket", namespace, claimName)
+	}
+	bucket, err := u.dynamicClient.Resource(events.BucketGVR).Get(ctx, bucketName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get Bucket %s: %v", bucketName, err)
+	}
+	bucketID, _, _ := unstructured.NestedString(bucket.Object, "status", "bucketID")
+	if bucketID == "" {
+		return "", fmt.Errorf("Bucket %s has no bucketID", bucketName)
+	}
+	return bucketID, nil
+}
+
+// getCredential returns a credential the Secret holds as a key of its own or in its BucketInfo
+func getCredential(secret *v1.Secret, key string) string {
+	if value, ok := secret.Data[key]; ok {
+		return string(value)
+	}
+	info := map[string]interface{}{}
+	if err := json.Unmarshal(secret.Data[bucketInfoKey], &info); err != nil {
+		return ""
+	}
+	value, _, _ := unstructured.NestedString(info, "spec", "secretAzure", key)
+	return value
+}
+
+// setCredentials writes the reissued credentials over those the Secret holds and returns how many it
+// wrote. Keys the Secret does not hold are not added, the layout is up to the sidecar.
+func setCredentials(secret *v1.Secret, current map[string]string) (int, error) {
+	written := 0
+	for _, key := range reissuedKeys {
+		value, reissued := current[key]
+		if _, ok := secret.Data[key]; ok && reissued {
+			secret.Data[key] = []byte(value)
+			written++
+		}
+	}
+
+	data, ok := secret.Data[bucketInfoKey]
+	if !ok {
+		return written, nil
+	}
+	info := map[string]interface{}{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return 0, fmt.Errorf("could not decode %s: %v", bucketInfoKey, err)
+	}
+	azure, found, err := unstructured.NestedMap(info, "spec", "secretAzure")
+	if !found || err != nil {
+		return written, nil
+	}
+	for _, key := range reissuedKeys {
+		value, reissued := current[key]
+		if _, ok := azure[key]; ok && reissued {
+			azure[key] = value
+			written++
+		}
+	}
+	if err := unstructured.SetNestedMap(info, azure, "spec", "secretAzure"); err != nil {
+		return 0, err
+	}
+	if data, err = json.Marshal(info); err != nil {
+		return 0, fmt.Errorf("could not encode %s: %v", bucketInfoKey, err)
+	}
+	secret.Data[bucketInfoKey] = data
+	return written, nil
+}
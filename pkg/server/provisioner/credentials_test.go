@@ -0,0 +1,260 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/driver"
+	"github.com/Azure/azure-cosi-driver/pkg/events"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestObject(kind, namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: fields}
+	object.SetAPIVersion("objectstorage.k8s.io/v1alpha1")
+	object.SetKind(kind)
+	object.SetNamespace(namespace)
+	object.SetName(name)
+	return object
+}
+
+// newTestBucketAccess returns a BucketAccess of the claim whose credentials are in the Secret creds
+func newTestBucketAccess(namespace, name, claimName, accountID string) *unstructured.Unstructured {
+	return newTestObject("BucketAccess", namespace, name, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"bucketClaimName":       claimName,
+			"bucketAccessClassName": "class",
+			"credentialsSecretName": "creds",
+		},
+		"status": map[string]interface{}{"accountID": accountID},
+	})
+}
+
+// newTestBucketAccesses returns BucketAccesses named access in the namespaces app and other, for the
+// buckets id and other-id, with the Secret app/creds holding secretData
+func newTestBucketAccesses(secretData map[string][]byte, objects ...runtime.Object) (*clusterBucketAccesses, *fake.Clientset) {
+	objects = append(objects,
+		newTestBucketAccess("app", "access", "claim", "sas:account/bucket/access"),
+		newTestBucketAccess("other", "access", "claim", ""),
+		newTestObject("BucketClaim", "app", "claim", map[string]interface{}{"status": map[string]interface{}{"bucketName": "bucket"}}),
+		newTestObject("BucketClaim", "other", "claim", map[string]interface{}{"status": map[string]interface{}{"bucketName": "other-bucket"}}),
+		newTestObject("Bucket", "", "bucket", map[string]interface{}{"status": map[string]interface{}{"bucketID": "id"}}),
+		newTestObject("Bucket", "", "other-bucket", map[string]interface{}{"status": map[string]interface{}{"bucketID": "other-id"}}),
+		newTestObject("BucketAccessClass", "", "class", map[string]interface{}{
+			"driverName": driver.DriverName,
+			"parameters": map[string]interface{}{constant.EnableReadField: "true"},
+		}),
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		events.BucketAccessGVR: "BucketAccessList",
+	}, objects...)
+	kubeClient := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "creds"},
+		Data:       secretData,
+	})
+	return newClusterBucketAccesses(kubeClient, dynamicClient), kubeClient
+}
+
+func TestUpdateCredentials(t *testing.T) {
+	ctx := context.Background()
+	current := map[string]string{
+		constant.AccountName:     "account",
+		constant.SASToken:        "sv=2020-10-02&sig=new",
+		constant.AccessToken:     "https://account.blob.core.windows.net/bucket?sv=2020-10-02&sig=new",
+		constant.ExpiryTimestamp: "2022-01-08T00:00:00Z",
+	}
+	access := k8stypes.NamespacedName{Namespace: "app", Name: "access"}
+
+	// the sidecar writes the credentials in a BucketInfo document
+	bucketInfo, _ := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"secretS3": nil, "secretAzure": map[string]string{
+		constant.AccessToken:     "https://account.blob.core.windows.net/bucket?sv=2020-10-02&sig=old",
+		constant.ExpiryTimestamp: "2022-01-01T00:00:00Z",
+	}}})
+	accesses, kubeClient := newTestBucketAccesses(map[string][]byte{bucketInfoKey: bucketInfo, "other": []byte("unrelated")})
+	if err := accesses.UpdateCredentials(ctx, access, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret, err := kubeClient.CoreV1().Secrets("app").Get(ctx, "creds", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var updated struct {
+		Spec struct {
+			SecretS3    interface{}       `json:"secretS3"`
+			SecretAzure map[string]string `json:"secretAzure"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(secret.Data[bucketInfoKey], &updated); err != nil {
+		t.Fatalf("unexpected error decoding the updated Secret: %v", err)
+	}
+	expected := map[string]string{
+		constant.AccessToken:     current[constant.AccessToken],
+		constant.ExpiryTimestamp: current[constant.ExpiryTimestamp],
+	}
+	if !reflect.DeepEqual(updated.Spec.SecretAzure, expected) {
+		t.Errorf("expected secretAzure %v, got %v", expected, updated.Spec.SecretAzure)
+	}
+	if string(secret.Data["other"]) != "unrelated" {
+		t.Errorf("expected unrelated data to be kept, got %q", secret.Data["other"])
+	}
+
+	// the credentials are keys of the Secret
+	accesses, kubeClient = newTestBucketAccesses(map[string][]byte{
+		constant.AccountName:     []byte("account"),
+		constant.SASToken:        []byte("sv=2020-10-02&sig=old"),
+		constant.ExpiryTimestamp: []byte("2022-01-01T00:00:00Z"),
+	})
+	if err := accesses.UpdateCredentials(ctx, access, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret, _ = kubeClient.CoreV1().Secrets("app").Get(ctx, "creds", metav1.GetOptions{})
+	actual := map[string]string{}
+	for key, value := range secret.Data {
+		actual[key] = string(value)
+	}
+	expected = map[string]string{
+		constant.AccountName:     "account",
+		constant.SASToken:        current[constant.SASToken],
+		constant.ExpiryTimestamp: current[constant.ExpiryTimestamp],
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected the Secret data %v, got %v", expected, actual)
+	}
+
+	accesses, _ = newTestBucketAccesses(map[string][]byte{"other": []byte("unrelated")})
+	err = accesses.UpdateCredentials(ctx, access, current)
+	if err == nil || !strings.Contains(err.Error(), "holds no credentials of the driver") {
+		t.Errorf("expected an error for a Secret without credentials, got %v", err)
+	}
+	// the BucketAccess of the same name in another namespace has no Secret
+	if err := accesses.UpdateCredentials(ctx, k8stypes.NamespacedName{Namespace: "other", Name: "access"}, current); err == nil {
+		t.Errorf("expected an error for a BucketAccess without its Secret")
+	}
+	if err := accesses.UpdateCredentials(ctx, k8stypes.NamespacedName{Namespace: "app", Name: "missing"}, current); err == nil {
+		t.Errorf("expected an error for an unknown BucketAccess")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	accesses, _ := newTestBucketAccesses(nil, newTestBucketAccess("app", "single", "claim", ""))
+	tests := []struct {
+		testName       string
+		name           string
+		bucketID       string
+		accountID      string
+		expectedAccess k8stypes.NamespacedName
+		expectedErr    bool
+	}{
+		{
+			testName:       "Only BucketAccess of the name",
+			name:           "single",
+			expectedAccess: k8stypes.NamespacedName{Namespace: "app", Name: "single"},
+		},
+		{
+			testName:       "Told apart by bucket",
+			name:           "access",
+			bucketID:       "other-id",
+			accountID:      "sas:account/other-bucket/access",
+			expectedAccess: k8stypes.NamespacedName{Namespace: "other", Name: "access"},
+		},
+		{
+			testName:       "Told apart by AccountId",
+			name:           "access",
+			bucketID:       "id",
+			accountID:      "sas:account/bucket/access",
+			expectedAccess: k8stypes.NamespacedName{Namespace: "app", Name: "access"},
+		},
+		{
+			testName:    "Granted to another AccountId",
+			name:        "access",
+			bucketID:    "id",
+			accountID:   "sas:account/bucket/other",
+			expectedErr: true,
+		},
+		{
+			testName:    "Unknown BucketAccess",
+			name:        "missing",
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		access, err := accesses.Resolve(context.Background(), test.name, test.bucketID, test.accountID)
+		if (err != nil) != test.expectedErr || access != test.expectedAccess {
+			t.Errorf("\nTestCase: %s\nExpected: %v %v\nActual: %v %v", test.testName, test.expectedAccess, test.expectedErr, access, err)
+		}
+	}
+}
+
+func TestGetGrant(t *testing.T) {
+	ctx := context.Background()
+	bucketInfo, _ := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"secretAzure": map[string]string{
+		constant.ExpiryTimestamp: "2022-01-08T00:00:00Z",
+	}}})
+	accesses, _ := newTestBucketAccesses(map[string][]byte{bucketInfoKey: bucketInfo})
+	access := k8stypes.NamespacedName{Namespace: "app", Name: "access"}
+
+	grant, err := accesses.GetGrant(ctx, access)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &grantDetails{
+		access:     access,
+		accountID:  "sas:account/bucket/access",
+		bucketID:   "id",
+		parameters: map[string]string{constant.EnableReadField: "true"},
+		expiry:     time.Date(2022, 1, 8, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(grant, expected) {
+		t.Errorf("expected %+v, got %+v", expected, grant)
+	}
+
+	if _, err := accesses.GetGrant(ctx, k8stypes.NamespacedName{Namespace: "other", Name: "access"}); err == nil {
+		t.Errorf("expected an error for a BucketAccess without its Secret")
+	}
+}
+
+func TestListAccountIDs(t *testing.T) {
+	deleting := newTestBucketAccess("app", "deleting", "claim", "sas:account/bucket/deleting")
+	now := metav1.Now()
+	deleting.SetDeletionTimestamp(&now)
+	accesses, _ := newTestBucketAccesses(nil, deleting, newTestBucketAccess("app", "iam", "claim", "iam:principal-id/account/fs/iam"))
+
+	accountIDs, err := accesses.ListAccountIDs(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[k8stypes.NamespacedName]string{
+		{Namespace: "app", Name: "access"}:   "sas:account/bucket/access",
+		{Namespace: "other", Name: "access"}: "",
+		{Namespace: "app", Name: "iam"}:      "iam:principal-id/account/fs/iam",
+	}
+	if !reflect.DeepEqual(accountIDs, expected) {
+		t.Errorf("expected %v, got %v", expected, accountIDs)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+// newFakeAzureProvisioner returns a provisioner whose ARM and blob calls go to a fakeazure.Server
+func newFakeAzureProvisioner(t *testing.T) (*provisioner, *fakeazure.Server) {
+	s := fakeazure.NewServer()
+	azureutils.SetHTTPClient(s.HTTPClient())
+	t.Cleanup(func() {
+		azureutils.SetHTTPClient(nil)
+		s.Close()
+	})
+
+	return &provisioner{
+		nameToBucketMap:   make(map[string]*bucketDetails),
+		bucketsLock:       sync.RWMutex{},
+		bucketIDToNameMap: make(map[string]string),
+		bucketNameLocks:   newBucketLocks(),
+		bucketIDLocks:     newBucketLocks(),
+		cloud:             s.Cloud(),
+		grants:            newGrantTracker(),
+	}, s
+}
+
+// createFakeAzureAccount creates the storage account container buckets name, as a storage account bucket
+func createFakeAzureAccount(t *testing.T, pr *provisioner, name string) {
+	if _, err := pr.DriverCreateBucket(context.Background(), &spec.DriverCreateBucketRequest{
+		Name: name,
+		Parameters: map[string]string{
+			constant.BucketUnitTypeField:     constant.StorageAccount.String(),
+			constant.StorageAccountNameField: name,
+			constant.ResourceGroupField:      fakeazure.ResourceGroup,
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error creating storage account %s: %v", name, err)
+	}
+}
+
+func TestContainerBucketLifecycleWithFakeAzure(t *testing.T) {
+	ctx := context.Background()
+	pr, s := newFakeAzureProvisioner(t)
+
+	createFakeAzureAccount(t, pr, "fakeaccount")
+
+	createResp, err := pr.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{
+		Name: "bucket",
+		Parameters: map[string]string{
+			constant.BucketUnitTypeField:     constant.Container.String(),
+			constant.StorageAccountNameField: "fakeaccount",
+			constant.ResourceGroupField:      fakeazure.ResourceGroup,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating bucket: %v", err)
+	}
+	if accounts := s.Accounts(); !reflect.DeepEqual(accounts, []string{"fakeaccount"}) {
+		t.Errorf("expected storage account fakeaccount, got %v", accounts)
+	}
+	if containers := s.Containers("fakeaccount"); !reflect.DeepEqual(containers, []string{"bucket"}) {
+		t.Errorf("expected container bucket, got %v", containers)
+	}
+
+	grantResp, err := pr.DriverGrantBucketAccess(ctx, &spec.DriverGrantBucketAccessRequest{
+		BucketId:           createResp.BucketId,
+		Name:               "access",
+		AuthenticationType: spec.AuthenticationType_Key,
+		Parameters: map[string]string{
+			constant.EnableReadField:  "true",
+			constant.EnableWriteField: "true",
+			constant.EnableListField:  "true",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error granting access: %v", err)
+	}
+	secrets := grantResp.Credentials[constant.CredentialType].Secrets
+	if secrets[constant.AccountName] != "fakeaccount" || secrets[constant.ContainerName] != "bucket" {
+		t.Errorf("unexpected secrets %v", secrets)
+	}
+
+	// workloads reach the container through the endpoint with the SAS token, it must let them write blobs
+	blobURL := secrets[constant.Endpoint] + secrets[constant.ContainerName] + "/hello.txt?" + secrets[constant.SASToken]
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, blobURL, bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	resp, err := s.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error uploading with the SAS: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected upload with the SAS to succeed, got %d: %s", resp.StatusCode, body)
+	}
+	if data, ok := s.Blob("fakeaccount", "bucket", "hello.txt"); !ok || string(data) != "hello" {
+		t.Errorf("expected blob hello.txt with content hello, got %q", data)
+	}
+
+	if _, err := pr.DriverRevokeBucketAccess(ctx, &spec.DriverRevokeBucketAccessRequest{
+		BucketId:  createResp.BucketId,
+		AccountId: grantResp.AccountId,
+	}); err != nil {
+		t.Errorf("unexpected error revoking access: %v", err)
+	}
+
+	if _, err := pr.DriverDeleteBucket(ctx, &spec.DriverDeleteBucketRequest{BucketId: createResp.BucketId}); err != nil {
+		t.Fatalf("unexpected error deleting bucket: %v", err)
+	}
+	if containers := s.Containers("fakeaccount"); len(containers) != 0 {
+		t.Errorf("expected no containers after delete, got %v", containers)
+	}
+}
+
+func TestStorageAccountBucketLifecycleWithFakeAzure(t *testing.T) {
+	ctx := context.Background()
+	pr, s := newFakeAzureProvisioner(t)
+
+	createResp, err := pr.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{
+		Name: "fakeaccount",
+		Parameters: map[string]string{
+			constant.BucketUnitTypeField:     constant.StorageAccount.String(),
+			constant.StorageAccountNameField: "fakeaccount",
+			constant.ResourceGroupField:      fakeazure.ResourceGroup,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating bucket: %v", err)
+	}
+	if accounts := s.Accounts(); !reflect.DeepEqual(accounts, []string{"fakeaccount"}) {
+		t.Errorf("expected storage account fakeaccount, got %v", accounts)
+	}
+
+	grantResp, err := pr.DriverGrantBucketAccess(ctx, &spec.DriverGrantBucketAccessRequest{
+		BucketId:           createResp.BucketId,
+		Name:               "access",
+		AuthenticationType: spec.AuthenticationType_Key,
+		Parameters: map[string]string{
+			constant.EnableListField:                       "true",
+			constant.AllowServiceSignedResourceTypeField:   "true",
+			constant.AllowContainerSignedResourceTypeField: "true",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error granting access: %v", err)
+	}
+
+	// an account SAS with list permission lets workloads list the containers of the account
+	secrets := grantResp.Credentials[constant.CredentialType].Secrets
+	resp, err := s.HTTPClient().Get(secrets[constant.Endpoint] + "?comp=list&" + secrets[constant.SASToken])
+	if err != nil {
+		t.Fatalf("unexpected error listing with the SAS: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected listing with the SAS to succeed, got %d: %s", resp.StatusCode, body)
+	}
+
+	if _, err := pr.DriverDeleteBucket(ctx, &spec.DriverDeleteBucketRequest{BucketId: createResp.BucketId}); err != nil {
+		t.Fatalf("unexpected error deleting bucket: %v", err)
+	}
+	if accounts := s.Accounts(); len(accounts) != 0 {
+		t.Errorf("expected no storage accounts after delete, got %v", accounts)
+	}
+}
+
+func TestRevokeSharedIAMAccessWithFakeAzure(t *testing.T) {
+	ctx := context.Background()
+	pr, _ := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{}
+	pr.accesses = accesses
+
+	// the storage account does not exist, so only a revoke that leaves the ACL alone succeeds
+	bucketID, _ := (&types.BucketID{
+		SubID:         fakeazure.SubscriptionID,
+		ResourceGroup: fakeazure.ResourceGroup,
+		URL:           "https://missingaccount.dfs.core.windows.net/fs",
+		UnitType:      constant.Filesystem.String(),
+	}).Encode()
+	accountID := azureutils.IAMAccountIDPrefix + "principal-id/missingaccount/fs/first"
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{
+		{Namespace: "app", Name: "second"}: azureutils.IAMAccountIDPrefix + "principal-id/missingaccount/fs/second",
+	}
+	if _, err := pr.DriverRevokeBucketAccess(ctx, &spec.DriverRevokeBucketAccessRequest{
+		BucketId:  bucketID,
+		AccountId: accountID,
+	}); err != nil {
+		t.Errorf("expected the ACL entries still used by another BucketAccess to be kept, got %v", err)
+	}
+
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{
+		{Namespace: "app", Name: "other"}: azureutils.IAMAccountIDPrefix + "principal-id/missingaccount/otherfs/other",
+	}
+	if _, err := pr.DriverRevokeBucketAccess(ctx, &spec.DriverRevokeBucketAccessRequest{
+		BucketId:  bucketID,
+		AccountId: accountID,
+	}); err == nil {
+		t.Errorf("expected the ACL entries of the last BucketAccess of the filesystem to be removed")
+	}
+}
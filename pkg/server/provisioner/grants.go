@@ -0,0 +1,289 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/metrics"
+	"github.com/Azure/azure-cosi-driver/pkg/types"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// grantDetails is what is needed to reissue the SAS of a BucketAccess
+type grantDetails struct {
+	// access is the BucketAccess, its namespace is empty until it is told apart from others of the same name
+	access     k8stypes.NamespacedName
+	accountID  string
+	bucketID   string
+	parameters map[string]string
+	// secrets are the credentials last issued by the driver, nil for a grant restored from the cluster
+	secrets map[string]string
+	// issued and expiry bound the validity of the SAS in secrets, expiry is zero when unknown
+	issued time.Time
+	expiry time.Time
+}
+
+// setSecrets records credentials issued now and reads their expiry
+func (g *grantDetails) setSecrets(secrets map[string]string) {
+	g.secrets = secrets
+	g.issued = time.Now()
+	g.expiry = time.Time{}
+	if expiry, err := time.Parse(time.RFC3339, secrets[constant.ExpiryTimestamp]); err == nil {
+		g.expiry = expiry
+	}
+}
+
+// metricLabels returns the labels of the grant in the SAS metrics
+func (g *grantDetails) metricLabels() []string {
+	account, bucket, _, _ := azureutils.GetBucketLocation(g.bucketID)
+	return []string{g.access.Name, g.access.Namespace, account, bucket}
+}
+
+// updateExpiryMetric exposes the expiry of the SAS last issued for the grant
+func (g *grantDetails) updateExpiryMetric() {
+	if g.expiry.IsZero() {
+		metrics.SASExpiry.DeleteLabelValues(g.metricLabels()...)
+		return
+	}
+	metrics.SASExpiry.WithLabelValues(g.metricLabels()...).Set(float64(g.expiry.Unix()))
+}
+
+// storageAccountRef identifies the storage account that signs the SAS of a bucket
+type storageAccountRef struct {
+	subsID        string
+	resourceGroup string
+	name          string
+}
+
+// grantTracker keeps the SAS grants of the BucketAccesses, keyed by namespace and name
+type grantTracker struct {
+	lock   sync.Mutex
+	grants map[k8stypes.NamespacedName]*grantDetails
+}
+
+func newGrantTracker() *grantTracker {
+	return &grantTracker{grants: map[k8stypes.NamespacedName]*grantDetails{}}
+}
+
+// track records the grant with the credentials just issued for it
+func (g *grantTracker) track(grant *grantDetails, secrets map[string]string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.remove(grant.access)
+	grant.setSecrets(secrets)
+	grant.updateExpiryMetric()
+	g.grants[grant.access] = grant
+}
+
+// restore records a grant read back from the cluster, unless its BucketAccess is tracked meanwhile
+func (g *grantTracker) restore(grant *grantDetails) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if _, ok := g.grants[grant.access]; ok {
+		return
+	}
+	grant.issued = time.Now()
+	grant.updateExpiryMetric()
+	g.grants[grant.access] = grant
+}
+
+// resolve moves the grant of the AccountId tracked without a namespace to the BucketAccess that
+// turned out to hold it, and returns whether there was one
+func (g *grantTracker) resolve(access k8stypes.NamespacedName, accountID string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	unresolved := k8stypes.NamespacedName{Name: access.Name}
+	grant, ok := g.grants[unresolved]
+	if !ok || grant.accountID != accountID {
+		return false
+	}
+	g.remove(unresolved)
+	grant.access = access
+	grant.updateExpiryMetric()
+	g.grants[access] = grant
+	return true
+}
+
+// untrack forgets the grants of a revoked AccountId. BucketAccesses of the same name and bucket in
+// several namespaces share it, those still granted are tracked again on the next sync.
+func (g *grantTracker) untrack(accountID string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for access, grant := range g.grants {
+		if grant.accountID == accountID {
+			g.remove(access)
+		}
+	}
+}
+
+// remove forgets a grant and its metrics, the caller holds the lock
+func (g *grantTracker) remove(access k8stypes.NamespacedName) {
+	if grant, ok := g.grants[access]; ok {
+		metrics.SASExpiry.DeleteLabelValues(grant.metricLabels()...)
+		delete(g.grants, access)
+	}
+}
+
+// untrackBucket forgets the grants of a deleted bucket
+func (g *grantTracker) untrackBucket(bucketID string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for access, grant := range g.grants {
+		if grant.bucketID == bucketID {
+			g.remove(access)
+		}
+	}
+}
+
+// prune forgets the grants issued before since whose BucketAccess is not among accesses. Grants
+// without a namespace are kept, the sidecar may not have recorded their AccountId yet.
+func (g *grantTracker) prune(accesses map[k8stypes.NamespacedName]string, since time.Time) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for access, grant := range g.grants {
+		if _, ok := accesses[access]; !ok && access.Namespace != "" && grant.issued.Before(since) {
+			g.remove(access)
+		}
+	}
+}
+
+// isTracked returns whether the BucketAccess has a tracked grant
+func (g *grantTracker) isTracked(access k8stypes.NamespacedName) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	_, ok := g.grants[access]
+	return ok
+}
+
+// forAccount returns the grants whose SAS are signed by the storage account
+func (g *grantTracker) forAccount(account storageAccountRef) map[k8stypes.NamespacedName]*grantDetails {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	grants := map[k8stypes.NamespacedName]*grantDetails{}
+	for access, grant := range g.grants {
+		if ref, err := getStorageAccountRef(grant.bucketID); err == nil && ref == account {
+			grants[access] = grant
+		}
+	}
+	return grants
+}
+
+// setSecrets records the credentials reissued for a grant, unless it was revoked meanwhile
+func (g *grantTracker) setSecrets(access k8stypes.NamespacedName, secrets map[string]string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if grant, ok := g.grants[access]; ok {
+		grant.setSecrets(secrets)
+		grant.updateExpiryMetric()
+	}
+}
+
+// expiring returns the grants whose SAS expire within renewBefore, or within half of their lifetime
+// when that is shorter so a SAS valid for less than renewBefore is not reissued on every check
+func (g *grantTracker) expiring(now time.Time, renewBefore time.Duration) map[k8stypes.NamespacedName]*grantDetails {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	grants := map[k8stypes.NamespacedName]*grantDetails{}
+	for access, grant := range g.grants {
+		if grant.expiry.IsZero() {
+			continue
+		}
+		threshold := renewBefore
+		if half := grant.expiry.Sub(grant.issued) / 2; half < threshold {
+			threshold = half
+		}
+		if grant.expiry.Sub(now) <= threshold {
+			grants[access] = grant
+		}
+	}
+	return grants
+}
+
+// bucketIDs returns the BucketIDs of all tracked grants
+func (g *grantTracker) bucketIDs() []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	ids := make([]string, 0, len(g.grants))
+	for _, grant := range g.grants {
+		ids = append(ids, grant.bucketID)
+	}
+	return ids
+}
+
+// unresolved returns the number of grants of the storage account tracked without a namespace
+func (g *grantTracker) unresolved(account storageAccountRef) int {
+	count := 0
+	for access := range g.forAccount(account) {
+		if access.Namespace == "" {
+			count++
+		}
+	}
+	return count
+}
+
+// syncGrants brings the tracked grants in line with the BucketAccesses of the cluster, so the SAS
+// granted before the driver started are renewed and reissued on key rotation too. Grants whose
+// BucketAccess is gone are forgotten. It returns by storage account the number of BucketAccesses
+// that hold a SAS the driver could not track.
+func (pr *provisioner) syncGrants(ctx context.Context) (map[string]int, error) {
+	untracked := map[string]int{}
+	if pr.accesses == nil {
+		return untracked, nil
+	}
+	listed := time.Now()
+	accesses, err := pr.accesses.ListAccountIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pr.grants.prune(accesses, listed)
+
+	for access, accountID := range accesses {
+		if !strings.HasPrefix(accountID, azureutils.SASAccountIDPrefix) || pr.grants.isTracked(access) {
+			continue
+		}
+		if pr.grants.resolve(access, accountID) {
+			continue
+		}
+		grant, err := pr.accesses.GetGrant(ctx, access)
+		if err != nil {
+			klog.Errorf("Could not track the SAS of BucketAccess %s: %v", access, err)
+			untracked[getSASStorageAccount(accountID)]++
+			continue
+		}
+		klog.Infof("Tracking the SAS of BucketAccess %s granted before the driver started", access)
+		pr.grants.restore(grant)
+	}
+	return untracked, nil
+}
+
+// getStorageAccountRef returns the storage account of a BucketID
+func getStorageAccountRef(bucketID string) (storageAccountRef, error) {
+	id, err := types.DecodeToBucketID(bucketID)
+	if err != nil {
+		return storageAccountRef{}, err
+	}
+	account, _, _, err := azureutils.GetBucketLocation(bucketID)
+	if err != nil {
+		return storageAccountRef{}, err
+	}
+	return storageAccountRef{subsID: id.SubID, resourceGroup: id.ResourceGroup, name: account}, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/events"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// runKeyRotation rotates the keys of every storage account the driver knows of each interval, until ctx is done
+func (pr *provisioner) runKeyRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pr.rotateKeys(ctx)
+	}
+}
+
+// rotateKeys rotates the keys of the storage accounts of the buckets created since the driver started
+// and of the SAS granted to the BucketAccesses of the cluster. Only the storage accounts the driver
+// created are rotated, the keys of an account supplied by the user may be in use outside the cluster.
+// A storage account that signed a SAS the driver could not track is skipped, regenerating its key
+// would revoke that SAS. A failure is logged and the other storage accounts are still rotated.
+func (pr *provisioner) rotateKeys(ctx context.Context) {
+	untracked, err := pr.syncGrants(ctx)
+	if err != nil {
+		klog.Errorf("Not rotating keys, the SAS granted to BucketAccesses could not be listed: %v", err)
+		return
+	}
+	for _, account := range pr.getKnownStorageAccounts() {
+		if count := untracked[account.name]; count > 0 {
+			klog.Errorf("Not rotating the keys of storage account %s, %d BucketAccesses hold SAS signed by it that the driver could not track", account.name, count)
+			continue
+		}
+		managed, err := azureutils.IsManagedAccount(ctx, account.subsID, account.resourceGroup, account.name, pr.cloud)
+		if err != nil {
+			klog.Errorf("Error reading the tags of storage account %s: %v", account.name, err)
+			continue
+		}
+		if !managed {
+			klog.V(2).Infof("Not rotating the keys of storage account %s, it was not created by the driver", account.name)
+			continue
+		}
+		if err := pr.rotateAccountKey(ctx, account); err != nil {
+			klog.Errorf("Error rotating the keys of storage account %s: %v", account.name, err)
+		}
+	}
+}
+
+// getKnownStorageAccounts returns the storage accounts of the known buckets and grants, sorted by name
+func (pr *provisioner) getKnownStorageAccounts() []storageAccountRef {
+	pr.bucketsLock.RLock()
+	bucketIDs := make([]string, 0, len(pr.bucketIDToNameMap))
+	for bucketID := range pr.bucketIDToNameMap {
+		bucketIDs = append(bucketIDs, bucketID)
+	}
+	pr.bucketsLock.RUnlock()
+	bucketIDs = append(bucketIDs, pr.grants.bucketIDs()...)
+
+	seen := map[storageAccountRef]bool{}
+	accounts := []storageAccountRef{}
+	for _, bucketID := range bucketIDs {
+		account, err := getStorageAccountRef(bucketID)
+		if err != nil || seen[account] {
+			continue
+		}
+		seen[account] = true
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].name < accounts[j].name })
+	return accounts
+}
+
+// rotateAccountKey switches the storage account to its other key, reissues the SAS of its grants and
+// regenerates the key they were signed with. Grants are held off meanwhile so none is signed with the
+// key that is about to be regenerated.
+func (pr *provisioner) rotateAccountKey(ctx context.Context, account storageAccountRef) error {
+	pr.rotationLock.Lock()
+	defer pr.rotationLock.Unlock()
+
+	// the Secret of a BucketAccess whose namespace is not known yet cannot be updated
+	if count := pr.grants.unresolved(account); count > 0 && pr.accesses != nil {
+		return fmt.Errorf("not regenerating a key, %d BucketAccesses granted a SAS signed by the storage account are not found yet", count)
+	}
+
+	next, err := azureutils.RotateAccountKey(ctx, account.subsID, account.resourceGroup, account.name, pr.cloud, func(ctx context.Context) error {
+		return pr.regrantAccount(ctx, account)
+	})
+	if err != nil {
+		return err
+	}
+	klog.Infof("Storage account %s now signs SAS with %s", account.name, next)
+	return nil
+}
+
+// regrantAccount reissues the SAS of every grant of the storage account and hands them to the workloads
+func (pr *provisioner) regrantAccount(ctx context.Context, account storageAccountRef) error {
+	for _, grant := range pr.grants.forAccount(account) {
+		if err := pr.reissueGrant(ctx, grant, "Reissuing credentials for key rotation"); err != nil {
+			return fmt.Errorf("could not reissue the SAS of BucketAccess %s: %v", grant.access, err)
+		}
+		pr.events.BucketAccess(grant.access.Namespace, grant.access.Name, v1.EventTypeNormal, events.ReasonCredentialsRotated,
+			fmt.Sprintf("Reissued the SAS before the key of storage account %s that signed it is regenerated", account.name))
+	}
+	return nil
+}
+
+// reissueGrant issues a new SAS for the grant and writes it to the credentials of the workloads.
+// The SAS is audited like any other grant, and a failure is recorded as a warning on the BucketAccess.
+func (pr *provisioner) reissueGrant(ctx context.Context, grant *grantDetails, operation string) error {
+	_, secrets, err := azureutils.CreateBucketAccess(ctx, grant.bucketID, grant.access.Name, grant.parameters, pr.cloud)
+	if err == nil && pr.accesses != nil {
+		err = pr.accesses.UpdateCredentials(ctx, grant.access, secrets)
+	}
+	pr.auditReissue(grant, secrets, operation, err)
+	if err != nil {
+		err = azureutils.ToGRPCError(err)
+		pr.events.BucketAccessFailed(grant.access.Namespace, grant.access.Name, operation, err)
+		return err
+	}
+	pr.grants.setSecrets(grant.access, secrets)
+	return nil
+}
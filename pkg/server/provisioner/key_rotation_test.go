@@ -0,0 +1,293 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/testing/fakeazure"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+// fakeBucketAccesses stands in for the BucketAccesses of the cluster and records the credentials written
+type fakeBucketAccesses struct {
+	err error
+	// resolveErr fails Resolve, which otherwise finds every BucketAccess in the namespace app
+	resolveErr error
+	// updated are the BucketAccesses whose credentials were written, current the last written
+	updated []k8stypes.NamespacedName
+	current map[string]string
+	// accountIDs are the BucketAccesses of the cluster
+	accountIDs map[k8stypes.NamespacedName]string
+	// grants are read back by GetGrant, other BucketAccesses cannot be read
+	grants map[k8stypes.NamespacedName]*grantDetails
+}
+
+func (f *fakeBucketAccesses) Resolve(_ context.Context, name, _, _ string) (k8stypes.NamespacedName, error) {
+	return k8stypes.NamespacedName{Namespace: "app", Name: name}, f.resolveErr
+}
+
+func (f *fakeBucketAccesses) ListAccountIDs(_ context.Context) (map[k8stypes.NamespacedName]string, error) {
+	return f.accountIDs, nil
+}
+
+func (f *fakeBucketAccesses) GetGrant(_ context.Context, access k8stypes.NamespacedName) (*grantDetails, error) {
+	if grant, ok := f.grants[access]; ok {
+		copied := *grant
+		return &copied, nil
+	}
+	return nil, errors.New("class not found")
+}
+
+func (f *fakeBucketAccesses) UpdateCredentials(_ context.Context, access k8stypes.NamespacedName, current map[string]string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.updated = append(f.updated, access)
+	f.current = current
+	return nil
+}
+
+// grantContainerAccess creates a container bucket and grants read access to it with a SAS
+func grantContainerAccess(t *testing.T, pr *provisioner) (*spec.DriverGrantBucketAccessResponse, string) {
+	ctx := context.Background()
+	createFakeAzureAccount(t, pr, "fakeaccount")
+	createResp, err := pr.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{
+		Name: "bucket",
+		Parameters: map[string]string{
+			constant.BucketUnitTypeField:     constant.Container.String(),
+			constant.StorageAccountNameField: "fakeaccount",
+			constant.ResourceGroupField:      fakeazure.ResourceGroup,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating bucket: %v", err)
+	}
+	grantResp, err := pr.DriverGrantBucketAccess(ctx, &spec.DriverGrantBucketAccessRequest{
+		BucketId:           createResp.BucketId,
+		Name:               "access",
+		AuthenticationType: spec.AuthenticationType_Key,
+		Parameters: map[string]string{
+			constant.EnableReadField: "true",
+			constant.EnableListField: "true",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error granting access: %v", err)
+	}
+	return grantResp, createResp.BucketId
+}
+
+// listWithSAS lists the blobs of the container with the secrets of a grant and returns the status code
+func listWithSAS(t *testing.T, s *fakeazure.Server, secrets map[string]string) int {
+	resp, err := s.HTTPClient().Get(secrets[constant.Endpoint] + secrets[constant.ContainerName] + "?restype=container&comp=list&" + secrets[constant.SASToken])
+	if err != nil {
+		t.Fatalf("unexpected error listing with the SAS: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRotateKeys(t *testing.T) {
+	azureutils.SetKeyRotation(true)
+	defer azureutils.SetKeyRotation(false)
+	pr, s := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{}
+	pr.accesses = accesses
+
+	grantResp, bucketID := grantContainerAccess(t, pr)
+	access := k8stypes.NamespacedName{Namespace: "app", Name: "access"}
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{access: grantResp.AccountId}
+	oldSecrets := grantResp.Credentials[constant.CredentialType].Secrets
+	if code := listWithSAS(t, s, oldSecrets); code != http.StatusOK {
+		t.Fatalf("expected the SAS to work before rotation, got %d", code)
+	}
+
+	pr.rotateKeys(context.Background())
+
+	if !reflect.DeepEqual(accesses.updated, []k8stypes.NamespacedName{access}) || accesses.current == nil {
+		t.Fatalf("expected the reissued SAS to be written for %v, got %v", access, accesses.updated)
+	}
+	if code := listWithSAS(t, s, oldSecrets); code != http.StatusForbidden {
+		t.Errorf("expected the old SAS to stop working once its key is regenerated, got %d", code)
+	}
+	if code := listWithSAS(t, s, accesses.current); code != http.StatusOK {
+		t.Errorf("expected the reissued SAS to work, got %d", code)
+	}
+	grants := pr.grants.forAccount(storageAccountRef{subsID: fakeazure.SubscriptionID, resourceGroup: fakeazure.ResourceGroup, name: "fakeaccount"})
+	if grant, ok := grants[access]; !ok || !reflect.DeepEqual(grant.secrets, accesses.current) {
+		t.Errorf("expected the grant to track the reissued SAS, got %v", grants)
+	}
+
+	// revoked grants are not reissued anymore
+	if _, err := pr.DriverRevokeBucketAccess(context.Background(), &spec.DriverRevokeBucketAccessRequest{
+		BucketId:  bucketID,
+		AccountId: grantResp.AccountId,
+	}); err != nil {
+		t.Fatalf("unexpected error revoking access: %v", err)
+	}
+	if ids := pr.grants.bucketIDs(); len(ids) != 0 {
+		t.Errorf("expected no grants after revoke, got %v", ids)
+	}
+}
+
+func TestRotateKeysUpdateFails(t *testing.T) {
+	azureutils.SetKeyRotation(true)
+	defer azureutils.SetKeyRotation(false)
+	pr, s := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{err: errors.New("secret not found")}
+	pr.accesses = accesses
+
+	grantResp, _ := grantContainerAccess(t, pr)
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{{Namespace: "app", Name: "access"}: grantResp.AccountId}
+	before, _ := s.AccountKeys("fakeaccount")
+
+	pr.rotateKeys(context.Background())
+
+	// the workloads still hold the old SAS, so the key it is signed with must be kept
+	if after, _ := s.AccountKeys("fakeaccount"); !reflect.DeepEqual(before, after) {
+		t.Errorf("expected no key to be regenerated, keys went from %v to %v", before, after)
+	}
+	if code := listWithSAS(t, s, grantResp.Credentials[constant.CredentialType].Secrets); code != http.StatusOK {
+		t.Errorf("expected the granted SAS to keep working, got %d", code)
+	}
+}
+
+func TestRotateKeysRestoredGrants(t *testing.T) {
+	azureutils.SetKeyRotation(true)
+	defer azureutils.SetKeyRotation(false)
+	pr, s := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{}
+	pr.accesses = accesses
+
+	grantResp, bucketID := grantContainerAccess(t, pr)
+	access := k8stypes.NamespacedName{Namespace: "app", Name: "access"}
+	// a BucketAccess granted before the driver restarted, whose class cannot be read yet
+	restarted := k8stypes.NamespacedName{Namespace: "app", Name: "before-restart"}
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{
+		access:    grantResp.AccountId,
+		restarted: azureutils.SASAccountIDPrefix + "fakeaccount/bucket/before-restart",
+		{Namespace: "app", Name: "other-account"}: azureutils.SASAccountIDPrefix + "otheraccount/bucket/other-account",
+		{Namespace: "app", Name: "iam"}:           azureutils.IAMAccountIDPrefix + "principal-id/fakeaccount/fs/iam",
+	}
+	before, _ := s.AccountKeys("fakeaccount")
+
+	pr.rotateKeys(context.Background())
+
+	if after, _ := s.AccountKeys("fakeaccount"); !reflect.DeepEqual(before, after) {
+		t.Errorf("expected no key to be regenerated, keys went from %v to %v", before, after)
+	}
+	if len(accesses.updated) != 0 {
+		t.Errorf("expected no SAS to be reissued, got %v", accesses.updated)
+	}
+
+	// once the grant can be read back it is reissued with the others
+	accesses.grants = map[k8stypes.NamespacedName]*grantDetails{restarted: {
+		access:     restarted,
+		accountID:  accesses.accountIDs[restarted],
+		bucketID:   bucketID,
+		parameters: map[string]string{constant.EnableReadField: "true"},
+	}}
+	pr.rotateKeys(context.Background())
+
+	if after, _ := s.AccountKeys("fakeaccount"); reflect.DeepEqual(before, after) {
+		t.Errorf("expected a key to be regenerated")
+	}
+	updated := map[k8stypes.NamespacedName]bool{}
+	for _, access := range accesses.updated {
+		updated[access] = true
+	}
+	if !reflect.DeepEqual(updated, map[k8stypes.NamespacedName]bool{access: true, restarted: true}) {
+		t.Errorf("expected the SAS of both BucketAccesses to be reissued, got %v", accesses.updated)
+	}
+}
+
+func TestRotateKeysUnresolvedGrant(t *testing.T) {
+	azureutils.SetKeyRotation(true)
+	defer azureutils.SetKeyRotation(false)
+	pr, s := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{resolveErr: errors.New("found 2 BucketAccesses named access for the bucket")}
+	pr.accesses = accesses
+
+	grantResp, _ := grantContainerAccess(t, pr)
+	before, _ := s.AccountKeys("fakeaccount")
+
+	// the sidecar did not record the AccountId yet
+	access := k8stypes.NamespacedName{Namespace: "app", Name: "access"}
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{access: ""}
+	pr.rotateKeys(context.Background())
+	if after, _ := s.AccountKeys("fakeaccount"); !reflect.DeepEqual(before, after) {
+		t.Errorf("expected no key to be regenerated, keys went from %v to %v", before, after)
+	}
+
+	accesses.accountIDs[access] = grantResp.AccountId
+	pr.rotateKeys(context.Background())
+	if after, _ := s.AccountKeys("fakeaccount"); reflect.DeepEqual(before, after) {
+		t.Errorf("expected a key to be regenerated")
+	}
+	if !reflect.DeepEqual(accesses.updated, []k8stypes.NamespacedName{access}) {
+		t.Errorf("expected the SAS to be written for %v, got %v", access, accesses.updated)
+	}
+}
+
+func TestRotateKeysUserAccount(t *testing.T) {
+	azureutils.SetKeyRotation(true)
+	defer azureutils.SetKeyRotation(false)
+	ctx := context.Background()
+	pr, s := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{}
+	pr.accesses = accesses
+
+	// a storage account the user created and named in the BucketClass
+	s.CreateAccount(fakeazure.SubscriptionID, fakeazure.ResourceGroup, "useraccount")
+	createResp, err := pr.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{
+		Name: "bucket",
+		Parameters: map[string]string{
+			constant.BucketUnitTypeField:     constant.Container.String(),
+			constant.StorageAccountNameField: "useraccount",
+			constant.ResourceGroupField:      fakeazure.ResourceGroup,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating bucket: %v", err)
+	}
+	grantResp, err := pr.DriverGrantBucketAccess(ctx, &spec.DriverGrantBucketAccessRequest{
+		BucketId:           createResp.BucketId,
+		Name:               "access",
+		AuthenticationType: spec.AuthenticationType_Key,
+		Parameters:         map[string]string{constant.EnableListField: "true"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error granting access: %v", err)
+	}
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{{Namespace: "app", Name: "access"}: grantResp.AccountId}
+	before, _ := s.AccountKeys("useraccount")
+
+	pr.rotateKeys(ctx)
+
+	if after, _ := s.AccountKeys("useraccount"); !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the keys of a user storage account to be kept, keys went from %v to %v", before, after)
+	}
+	if len(accesses.updated) != 0 {
+		t.Errorf("expected no SAS to be reissued, got %v", accesses.updated)
+	}
+}
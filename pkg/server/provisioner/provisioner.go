@@ -16,18 +16,31 @@ package provisionerserver
 import (
 	"context"
 	"fmt"
+	"github.com/Azure/azure-cosi-driver/pkg/audit"
 	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
 	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/driver"
+	"github.com/Azure/azure-cosi-driver/pkg/events"
 	"reflect"
+	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 	spec "sigs.k8s.io/container-object-storage-interface-spec"
 )
 
+// overridable for testing
+var (
+	createBucket = azureutils.CreateBucket
+	deleteBucket = azureutils.DeleteBucket
+)
+
 type bucketDetails struct {
 	bucketID   string
 	parameters map[string]string
@@ -39,32 +52,100 @@ type provisioner struct {
 	bucketsLock       sync.RWMutex
 	nameToBucketMap   map[string]*bucketDetails
 	bucketIDToNameMap map[string]string
-	cloud             *azure.Cloud
+	// bucketNameLocks and bucketIDLocks serialise operations on the same bucket
+	bucketNameLocks *bucketLocks
+	bucketIDLocks   *bucketLocks
+	cloud           *azure.Cloud
+	// auditLogger records every credential granted and revoked, nil disables auditing
+	auditLogger *audit.Logger
+	// events records Kubernetes Events on Buckets and BucketAccesses
+	events *events.Recorder
+	// grants are the SAS of the BucketAccesses, reissued when a key is rotated or before they expire
+	grants *grantTracker
+	// rotationLock is held for writing while a key is rotated and for reading while a SAS is signed
+	rotationLock sync.RWMutex
+	// accesses reads the BucketAccesses of the cluster and hands them reissued SAS, nil trusts the
+	// tracked grants to be all there are and leaves reissued SAS to the caller
+	accesses bucketAccesses
 }
 
 var _ spec.ProvisionerServer = &provisioner{}
 
-func NewProvisionerServer(
-	kubeconfig,
-	cloudConfigSecretName,
-	cloudConfigSecretNamespace string) (spec.ProvisionerServer, error) {
-	kubeClient, err := azureutils.GetKubeClient(kubeconfig)
+// Options configures the ProvisionerServer, zero intervals disable the background tasks
+type Options struct {
+	// Kubeconfig is the path of the kubeconfig, empty uses the in-cluster config
+	Kubeconfig                 string
+	CloudConfigSecretName      string
+	CloudConfigSecretNamespace string
+	// AuditLogger records every credential granted and revoked, nil disables auditing
+	AuditLogger *audit.Logger
+	// KeyRotationInterval is how often the keys of the storage accounts the driver created are rotated
+	KeyRotationInterval time.Duration
+	// SASRenewalInterval is how often the expiry of the tracked SAS is checked
+	SASRenewalInterval time.Duration
+	// SASRenewBefore is how long before its expiry a SAS is reissued
+	SASRenewBefore time.Duration
+	// UsageCollectionInterval is how often the usage of the known buckets is collected
+	UsageCollectionInterval time.Duration
+	// UsageAnnotations also records the collected usage as annotations on each Bucket
+	UsageAnnotations bool
+	// Leading is closed once the replica leads, the background tasks only start then. Nil leads at once.
+	Leading <-chan struct{}
+}
+
+func NewProvisionerServer(opts Options) (spec.ProvisionerServer, error) {
+	kubeClient, err := azureutils.GetKubeClient(opts.Kubeconfig)
 	if err != nil {
 		return nil, err
 	}
 	klog.Infof("Kubeclient : %+v", kubeClient)
+	dynamicClient, err := azureutils.GetDynamicClient(opts.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
 
-	azCloud, err := azureutils.GetAzureCloudProvider(kubeClient, cloudConfigSecretName, cloudConfigSecretNamespace)
+	azCloud, err := azureutils.GetAzureCloudProvider(kubeClient, opts.CloudConfigSecretName, opts.CloudConfigSecretNamespace)
 	if err != nil {
 		return nil, err
 	}
 
-	return &provisioner{
+	pr := &provisioner{
 		nameToBucketMap:   make(map[string]*bucketDetails),
 		bucketsLock:       sync.RWMutex{},
 		bucketIDToNameMap: make(map[string]string),
+		bucketNameLocks:   newBucketLocks(),
+		bucketIDLocks:     newBucketLocks(),
 		cloud:             azCloud,
-	}, nil
+		auditLogger:       opts.AuditLogger,
+		events:            events.NewRecorder(kubeClient, dynamicClient, driver.DriverName),
+		grants:            newGrantTracker(),
+		accesses:          newClusterBucketAccesses(kubeClient, dynamicClient),
+	}
+	if opts.KeyRotationInterval > 0 {
+		// every replica signs SAS with the key the leader rotates to
+		azureutils.SetKeyRotation(true)
+	}
+	go func() {
+		// followers would rotate keys and reissue SAS behind the back of the leader
+		if opts.Leading != nil {
+			<-opts.Leading
+		}
+		if opts.KeyRotationInterval > 0 || opts.SASRenewalInterval > 0 {
+			if _, err := pr.syncGrants(context.Background()); err != nil {
+				klog.Errorf("Error tracking the SAS granted before the driver started: %v", err)
+			}
+		}
+		if opts.KeyRotationInterval > 0 {
+			go pr.runKeyRotation(context.Background(), opts.KeyRotationInterval)
+		}
+		if opts.SASRenewalInterval > 0 {
+			go pr.runSASRenewal(context.Background(), opts.SASRenewalInterval, opts.SASRenewBefore)
+		}
+		if opts.UsageCollectionInterval > 0 {
+			go pr.runUsageCollection(context.Background(), opts.UsageCollectionInterval, newUsageCollector(dynamicClient, opts.UsageAnnotations))
+		}
+	}()
+	return pr, nil
 }
 
 func (pr *provisioner) DriverCreateBucket(
@@ -77,6 +158,11 @@ func (pr *provisioner) DriverCreateBucket(
 		return nil, status.Error(codes.InvalidArgument, "Parameters missing. Cannot initialize Azure bucket.")
 	}
 
+	if !pr.bucketNameLocks.TryAcquire(bucketName) {
+		return nil, status.Error(codes.Aborted, fmt.Sprintf("An operation for bucket %s is already in progress", bucketName))
+	}
+	defer pr.bucketNameLocks.Release(bucketName)
+
 	// Check if a bucket with these set of values exist in the namesToBucketMap
 	pr.bucketsLock.RLock()
 	currBucket, exists := pr.nameToBucketMap[bucketName]
@@ -96,19 +182,22 @@ func (pr *provisioner) DriverCreateBucket(
 		return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("Bucket %s exists with different parameters", bucketName))
 	}
 
-	bucketID, err := azureutils.CreateBucket(ctx, bucketName, parameters, pr.cloud)
+	bucketID, err := createBucket(ctx, bucketName, parameters, pr.cloud)
 	if err != nil {
+		err = azureutils.ToGRPCError(err)
+		pr.events.BucketFailed(bucketName, "Creating bucket", err)
 		return nil, err
 	}
+	pr.events.Bucket(bucketName, v1.EventTypeNormal, events.ReasonBucketCreated, fmt.Sprintf("Created %s", describeBucket(bucketID)))
 
 	// Insert the bucket into the namesToBucketMap
-	pr.bucketsLock.RLock()
+	pr.bucketsLock.Lock()
 	pr.nameToBucketMap[bucketName] = &bucketDetails{
 		bucketID:   bucketID,
 		parameters: parameters,
 	}
 	pr.bucketIDToNameMap[bucketID] = bucketName
-	pr.bucketsLock.RUnlock()
+	pr.bucketsLock.Unlock()
 
 	klog.Infof("DriverCreateBucket :: Bucket id :: %s", bucketID)
 
@@ -122,24 +211,58 @@ func (pr *provisioner) DriverDeleteBucket(
 	req *spec.DriverDeleteBucketRequest) (*spec.DriverDeleteBucketResponse, error) {
 	//determine if the bucket is an account or a blob container
 	bucketID := req.BucketId
-	err := azureutils.DeleteBucket(ctx, bucketID, pr.cloud)
+	if !pr.bucketIDLocks.TryAcquire(bucketID) {
+		return nil, status.Error(codes.Aborted, fmt.Sprintf("An operation for bucket id %s is already in progress", bucketID))
+	}
+	defer pr.bucketIDLocks.Release(bucketID)
+
+	// A create for the same name must not race with the delete either
+	pr.bucketsLock.RLock()
+	bucketName, known := pr.bucketIDToNameMap[bucketID]
+	pr.bucketsLock.RUnlock()
+	if known {
+		if !pr.bucketNameLocks.TryAcquire(bucketName) {
+			return nil, status.Error(codes.Aborted, fmt.Sprintf("An operation for bucket %s is already in progress", bucketName))
+		}
+		defer pr.bucketNameLocks.Release(bucketName)
+	}
+
+	err := deleteBucket(ctx, bucketID, pr.cloud)
 	if err != nil {
+		err = azureutils.ToGRPCError(err)
+		pr.events.BucketFailed(bucketName, "Deleting bucket", err)
 		return nil, err
 	}
+	pr.events.Bucket(bucketName, v1.EventTypeNormal, events.ReasonBucketDeleted, fmt.Sprintf("Deleted %s", describeBucket(bucketID)))
 
 	klog.Infof("DriverDeleteBucket :: Bucket id :: %s", bucketID)
-	if bucketName, ok := pr.bucketIDToNameMap[bucketID]; ok {
+	if known {
 		// Remove from the namesToBucketMap
-		pr.bucketsLock.RLock()
+		pr.bucketsLock.Lock()
 		delete(pr.nameToBucketMap, bucketName)
 		delete(pr.bucketIDToNameMap, bucketID)
-		pr.bucketsLock.RUnlock()
+		pr.bucketsLock.Unlock()
 	}
+	pr.grants.untrackBucket(bucketID)
 
 	return &spec.DriverDeleteBucketResponse{}, nil
 }
 
 func (pr *provisioner) DriverGrantBucketAccess(
+	ctx context.Context,
+	req *spec.DriverGrantBucketAccessRequest) (*spec.DriverGrantBucketAccessResponse, error) {
+	resp, err := pr.grantBucketAccess(ctx, req)
+	pr.auditGrant(req, resp, err)
+	if err != nil {
+		pr.events.BucketAccessFailed("", req.GetName(), "Granting access", err)
+	} else {
+		pr.events.BucketAccess("", req.GetName(), v1.EventTypeNormal, events.ReasonAccessGranted,
+			fmt.Sprintf("Granted %s access to %s", req.GetAuthenticationType(), describeBucket(req.GetBucketId())))
+	}
+	return resp, err
+}
+
+func (pr *provisioner) grantBucketAccess(
 	ctx context.Context,
 	req *spec.DriverGrantBucketAccessRequest) (*spec.DriverGrantBucketAccessResponse, error) {
 	bucketID := req.GetBucketId()
@@ -152,29 +275,129 @@ func (pr *provisioner) DriverGrantBucketAccess(
 		return nil, status.Error(codes.InvalidArgument, "AuthenticationType not provided in GrantBucketAccess request.")
 	}
 
-	var token string
-	var err error
-
 	klog.Infof("DriverGrantBucketAccess :: Bucket id :: %s", bucketID)
 	if req.AuthenticationType == spec.AuthenticationType_IAM {
-		return nil, status.Error(codes.Unimplemented, "AuthenticationType IAM not implemented.")
-	} else if req.AuthenticationType == spec.AuthenticationType_Key {
-		token, _, err = azureutils.CreateBucketSASURL(ctx, bucketID, parameters, pr.cloud)
+		accountID, endpoint, err := azureutils.GrantBucketIAMAccess(ctx, bucketID, req.GetName(), parameters, pr.cloud)
 		if err != nil {
-			return nil, err
+			return nil, azureutils.ToGRPCError(err)
 		}
+		return &spec.DriverGrantBucketAccessResponse{
+			AccountId: accountID,
+			Credentials: map[string]*spec.CredentialDetails{constant.CredentialType: {
+				Secrets: map[string]string{constant.Endpoint: endpoint},
+			}},
+		}, nil
+	}
+
+	// a key rotation must not regenerate the key the SAS is signed with before the grant is tracked
+	pr.rotationLock.RLock()
+	defer pr.rotationLock.RUnlock()
+	accountID, secrets, err := azureutils.CreateBucketAccess(ctx, bucketID, req.GetName(), parameters, pr.cloud)
+	if err != nil {
+		return nil, azureutils.ToGRPCError(err)
 	}
+	pr.grants.track(&grantDetails{
+		access:     pr.resolveBucketAccess(ctx, req.GetName(), bucketID, accountID),
+		accountID:  accountID,
+		bucketID:   bucketID,
+		parameters: parameters,
+	}, secrets)
 
 	return &spec.DriverGrantBucketAccessResponse{
-		AccountId: req.GetName(),
+		AccountId: accountID,
 		Credentials: map[string]*spec.CredentialDetails{constant.CredentialType: {
-			Secrets: map[string]string{constant.AccessToken: token},
+			Secrets: secrets,
 		}},
 	}, nil
 }
 
+// resolveBucketAccess returns the namespace and name of the BucketAccess being granted. COSI only
+// passes the name, when the namespace cannot be told yet it is left empty for the next sync.
+func (pr *provisioner) resolveBucketAccess(ctx context.Context, name, bucketID, accountID string) k8stypes.NamespacedName {
+	if pr.accesses == nil {
+		return k8stypes.NamespacedName{Name: name}
+	}
+	access, err := pr.accesses.Resolve(ctx, name, bucketID, accountID)
+	if err != nil {
+		klog.Warningf("Could not find the namespace of BucketAccess %s, looking again once it is granted: %v", name, err)
+		return k8stypes.NamespacedName{Name: name}
+	}
+	return access
+}
+
 func (pr *provisioner) DriverRevokeBucketAccess(
 	ctx context.Context,
 	req *spec.DriverRevokeBucketAccessRequest) (*spec.DriverRevokeBucketAccessResponse, error) {
+	klog.Infof("DriverRevokeBucketAccess :: Bucket id :: %s", req.GetBucketId())
+	accessName := getAccessName(req.GetAccountId())
+	if err := pr.revokeBucketAccess(ctx, req.GetBucketId(), req.GetAccountId()); err != nil {
+		err = azureutils.ToGRPCError(err)
+		pr.auditRevoke(req, err)
+		pr.events.BucketAccessFailed("", accessName, "Revoking access", err)
+		return nil, err
+	}
+	pr.auditRevoke(req, nil)
+	pr.grants.untrack(req.GetAccountId())
+	pr.events.BucketAccess("", accessName, v1.EventTypeNormal, events.ReasonAccessRevoked,
+		fmt.Sprintf("Revoked access to %s", describeBucket(req.GetBucketId())))
 	return &spec.DriverRevokeBucketAccessResponse{}, nil
 }
+
+// revokeBucketAccess leaves the ACL entries of an IAM grant in place while another BucketAccess
+// granted the same principal the same filesystem
+func (pr *provisioner) revokeBucketAccess(ctx context.Context, bucketID, accountID string) error {
+	if scope := azureutils.GetIAMGrantScope(accountID); scope != "" && pr.accesses != nil {
+		accountIDs, err := pr.accesses.ListAccountIDs(ctx)
+		if err != nil {
+			return status.Error(codes.Unavailable, fmt.Sprintf("could not check the other grants of %s: %v", accountID, err))
+		}
+		for access, other := range accountIDs {
+			if other != accountID && azureutils.GetIAMGrantScope(other) == scope {
+				klog.Infof("Keeping the ACL entries of %s, BucketAccess %s still uses them", accountID, access)
+				return nil
+			}
+		}
+	}
+	return azureutils.RevokeBucketAccess(ctx, bucketID, accountID, pr.cloud)
+}
+
+// describeBucket names the Azure resource behind a BucketID for event messages
+func describeBucket(bucketID string) string {
+	account, bucket, directory, err := azureutils.GetBucketLocation(bucketID)
+	if err != nil {
+		return "bucket"
+	}
+	if bucket == "" {
+		return fmt.Sprintf("storage account %s", account)
+	}
+	if directory != "" {
+		return fmt.Sprintf("%s/%s in storage account %s", bucket, directory, account)
+	}
+	return fmt.Sprintf("%s in storage account %s", bucket, account)
+}
+
+// getSASStorageAccount returns the name of the storage account that signed the SAS of an AccountId
+func getSASStorageAccount(accountID string) string {
+	if !strings.HasPrefix(accountID, azureutils.SASAccountIDPrefix) {
+		return ""
+	}
+	accountID = strings.TrimPrefix(accountID, azureutils.SASAccountIDPrefix)
+	// an AccountId without a storage account only names the BucketAccess
+	if i := strings.Index(accountID, "/"); i >= 0 {
+		return accountID[:i]
+	}
+	return ""
+}
+
+// getAccessName returns the BucketAccess name of a SAS or IAM AccountId, see azureutils.CreateBucketAccess
+// and azureutils.GrantBucketIAMAccess. IAM AccountIds that are a bare principal do not name the BucketAccess.
+func getAccessName(accountID string) string {
+	switch {
+	case strings.HasPrefix(accountID, azureutils.SASAccountIDPrefix):
+		accountID = strings.TrimPrefix(accountID, azureutils.SASAccountIDPrefix)
+	case strings.HasPrefix(accountID, azureutils.IAMAccountIDPrefix) && strings.Contains(accountID, "/"):
+	default:
+		return ""
+	}
+	return accountID[strings.LastIndex(accountID, "/")+1:]
+}
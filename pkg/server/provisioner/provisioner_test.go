@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/config"
 	"github.com/Azure/azure-cosi-driver/pkg/constant"
 	"github.com/Azure/azure-cosi-driver/pkg/types"
 	"net/http"
@@ -80,7 +82,10 @@ func newFakeProvisioner(ctrl *gomock.Controller) spec.ProvisionerServer {
 		nameToBucketMap:   make(map[string]*bucketDetails),
 		bucketsLock:       sync.RWMutex{},
 		bucketIDToNameMap: make(map[string]string),
+		bucketNameLocks:   newBucketLocks(),
+		bucketIDLocks:     newBucketLocks(),
 		cloud:             cloud,
+		grants:            newGrantTracker(),
 	}
 }
 
@@ -135,6 +140,23 @@ func TestDriverCreateBucket(t *testing.T) {
 	}
 }
 
+func TestDriverCreateBucketPolicyViolation(t *testing.T) {
+	if err := azureutils.SetPolicy(&config.Policy{RequiredTags: []string{"costcenter"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = azureutils.SetPolicy(nil) }()
+
+	ctrl := gomock.NewController(t)
+	pr := newFakeProvisioner(ctrl)
+	_, err := pr.DriverCreateBucket(context.Background(), &spec.DriverCreateBucketRequest{
+		Name:       constant.ValidContainer,
+		Parameters: map[string]string{},
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected a policy violation to be PermissionDenied, got %v", err)
+	}
+}
+
 func TestDriverDeleteBucket(t *testing.T) {
 	tests := []struct {
 		testName    string
@@ -157,7 +179,7 @@ func TestDriverDeleteBucket(t *testing.T) {
 				ResourceGroup: constant.ValidResourceGroup,
 				URL:           constant.ValidContainerURL,
 			},
-			expectedErr: fmt.Errorf("Error deleting container %s in storage account %s : %v", constant.ValidContainer, constant.ValidAccount, fmt.Errorf("Delete \"https://validaccount.blob.core.windows.net/validcontainer?restype=container\": dial tcp: lookup validaccount.blob.core.windows.net: no such host")),
+			expectedErr: status.Error(codes.Unavailable, fmt.Sprintf("Error deleting container %s in storage account %s : %v", constant.ValidContainer, constant.ValidAccount, fmt.Errorf("Delete \"https://validaccount.blob.core.windows.net/validcontainer?restype=container\": dial tcp: lookup validaccount.blob.core.windows.net: no such host"))),
 		},
 	}
 
@@ -180,11 +202,12 @@ func TestDriverDeleteBucket(t *testing.T) {
 
 func TestDriverGrantBucketAccess(t *testing.T) {
 	tests := []struct {
-		testName    string
-		url         string
-		authType    spec.AuthenticationType
-		params      map[string]string
-		expectedErr error
+		testName          string
+		url               string
+		authType          spec.AuthenticationType
+		params            map[string]string
+		expectedAccountID string
+		expectedErr       error
 	}{
 		{
 			testName:    "No Parameters",
@@ -203,11 +226,12 @@ func TestDriverGrantBucketAccess(t *testing.T) {
 			expectedErr: status.Error(codes.Unimplemented, "AuthenticationType IAM not implemented."),
 		},
 		{
-			testName:    "Key Auth Type",
-			authType:    spec.AuthenticationType_Key,
-			url:         constant.ValidAccountURL,
-			params:      map[string]string{},
-			expectedErr: nil,
+			testName:          "Key Auth Type",
+			authType:          spec.AuthenticationType_Key,
+			url:               constant.ValidAccountURL,
+			params:            map[string]string{},
+			expectedAccountID: "sas:validaccount/access",
+			expectedErr:       nil,
 		},
 	}
 
@@ -225,6 +249,7 @@ func TestDriverGrantBucketAccess(t *testing.T) {
 
 		resp, err := pr.DriverGrantBucketAccess(context.Background(), &spec.DriverGrantBucketAccessRequest{
 			BucketId:           id,
+			Name:               "access",
 			AuthenticationType: test.authType,
 			Parameters:         test.params,
 		})
@@ -234,5 +259,31 @@ func TestDriverGrantBucketAccess(t *testing.T) {
 		if err == nil && reflect.DeepEqual(nil, resp) {
 			t.Errorf("\nTestCase: %s\nresponse is nil", test.testName)
 		}
+		if err == nil && resp.AccountId != test.expectedAccountID {
+			t.Errorf("\nTestCase: %s\nexpected account ID: %v\nactual account ID: %v", test.testName, test.expectedAccountID, resp.AccountId)
+		}
+		if err == nil && resp.Credentials[constant.CredentialType].Secrets[constant.SASToken] == "" {
+			t.Errorf("\nTestCase: %s\nSAS token missing from credentials", test.testName)
+		}
+	}
+}
+
+func TestGetAccessName(t *testing.T) {
+	tests := []struct {
+		testName     string
+		accountID    string
+		expectedName string
+	}{
+		{testName: "Container SAS", accountID: "sas:validaccount/validcontainer/access", expectedName: "access"},
+		{testName: "Account SAS", accountID: "sas:validaccount/access", expectedName: "access"},
+		{testName: "Name only", accountID: "sas:access", expectedName: "access"},
+		{testName: "IAM", accountID: "iam:00000000-0000-0000-0000-000000000000/validaccount/fs/access", expectedName: "access"},
+		{testName: "IAM principal", accountID: "00000000-0000-0000-0000-000000000000", expectedName: ""},
+	}
+	for _, test := range tests {
+		name := getAccessName(test.accountID)
+		if name != test.expectedName {
+			t.Errorf("\nTestCase: %s\nexpected: %v\nactual: %v", test.testName, test.expectedName, name)
+		}
 	}
 }
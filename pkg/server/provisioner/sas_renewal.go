@@ -0,0 +1,74 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/events"
+	"github.com/Azure/azure-cosi-driver/pkg/metrics"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultSASRenewBefore is how long before its expiry a SAS is reissued
+	DefaultSASRenewBefore = 24 * time.Hour
+)
+
+// runSASRenewal reissues the SAS that expire within renewBefore each interval, until ctx is done
+func (pr *provisioner) runSASRenewal(ctx context.Context, interval, renewBefore time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pr.renewExpiringSAS(ctx, time.Now(), renewBefore)
+	}
+}
+
+// renewExpiringSAS reissues the SAS of the grants that expire within renewBefore of now, including
+// those granted before the driver started. A SAS that cannot be reissued is logged and tried again on
+// the next check, until it expires.
+func (pr *provisioner) renewExpiringSAS(ctx context.Context, now time.Time, renewBefore time.Duration) {
+	if _, err := pr.syncGrants(ctx); err != nil {
+		klog.Errorf("Error listing the SAS granted to BucketAccesses, only renewing those already tracked: %v", err)
+	}
+
+	// a SAS must not be signed with a key that is being regenerated
+	pr.rotationLock.RLock()
+	defer pr.rotationLock.RUnlock()
+
+	for access, grant := range pr.grants.expiring(now, renewBefore) {
+		if access.Namespace == "" && pr.accesses != nil {
+			klog.V(4).Infof("Not renewing the SAS of BucketAccess %s until it is found", access.Name)
+			continue
+		}
+		expiry := grant.expiry
+		if err := pr.reissueGrant(ctx, grant, "Renewing credentials"); err != nil {
+			metrics.SASRenewals.WithLabelValues(metrics.ResultFailure).Inc()
+			klog.Errorf("Error renewing the SAS of BucketAccess %s that expires at %s: %v", access, expiry.Format(time.RFC3339), err)
+			continue
+		}
+		metrics.SASRenewals.WithLabelValues(metrics.ResultSuccess).Inc()
+		pr.events.BucketAccess(access.Namespace, access.Name, v1.EventTypeNormal, events.ReasonCredentialsRenewed,
+			fmt.Sprintf("Reissued the SAS that expires at %s", expiry.Format(time.RFC3339)))
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/constant"
+	"github.com/Azure/azure-cosi-driver/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+func TestExpiringGrants(t *testing.T) {
+	issued := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newGrantTracker()
+	g.grants = map[k8stypes.NamespacedName]*grantDetails{
+		{Name: "week"}:    {issued: issued, expiry: issued.Add(7 * 24 * time.Hour)},
+		{Name: "hour"}:    {issued: issued, expiry: issued.Add(time.Hour)},
+		{Name: "unknown"}: {issued: issued},
+	}
+
+	tests := []struct {
+		testName string
+		now      time.Time
+		expected []string
+	}{
+		{
+			testName: "just issued",
+			now:      issued,
+			expected: []string{},
+		},
+		{
+			testName: "short SAS renewed at half of its lifetime",
+			now:      issued.Add(30 * time.Minute),
+			expected: []string{"hour"},
+		},
+		{
+			testName: "long SAS renewed a day before it expires",
+			now:      issued.Add(6 * 24 * time.Hour),
+			expected: []string{"hour", "week"},
+		},
+	}
+	for _, test := range tests {
+		grants := g.expiring(test.now, DefaultSASRenewBefore)
+		actual := []string{}
+		for _, name := range []string{"hour", "unknown", "week"} {
+			if _, ok := grants[k8stypes.NamespacedName{Name: name}]; ok {
+				actual = append(actual, name)
+			}
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("\nTestCase: %s\nExpected: %v\nActual: %v", test.testName, test.expected, actual)
+		}
+	}
+}
+
+func TestRenewExpiringSAS(t *testing.T) {
+	pr, s := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{}
+	pr.accesses = accesses
+
+	grantResp, _ := grantContainerAccess(t, pr)
+	access := k8stypes.NamespacedName{Namespace: "app", Name: "access"}
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{access: grantResp.AccountId}
+	oldSecrets := grantResp.Credentials[constant.CredentialType].Secrets
+	labels := []string{"access", "app", "fakeaccount", "bucket"}
+	expiry, err := time.Parse(time.RFC3339, oldSecrets[constant.ExpiryTimestamp])
+	if err != nil {
+		t.Fatalf("unexpected error parsing the expiry of the SAS: %v", err)
+	}
+	if value := testutil.ToFloat64(metrics.SASExpiry.WithLabelValues(labels...)); value != float64(expiry.Unix()) {
+		t.Errorf("expected the expiry metric to be %d, got %v", expiry.Unix(), value)
+	}
+
+	successes := testutil.ToFloat64(metrics.SASRenewals.WithLabelValues(metrics.ResultSuccess))
+	pr.renewExpiringSAS(context.Background(), time.Now(), DefaultSASRenewBefore)
+	if len(accesses.updated) != 0 {
+		t.Fatalf("expected a SAS valid for a week not to be renewed yet")
+	}
+
+	pr.renewExpiringSAS(context.Background(), expiry.Add(-time.Hour), DefaultSASRenewBefore)
+	if !reflect.DeepEqual(accesses.updated, []k8stypes.NamespacedName{access}) || accesses.current == nil {
+		t.Fatalf("expected the renewed SAS to be written for %v, got %v", access, accesses.updated)
+	}
+	if code := listWithSAS(t, s, accesses.current); code != http.StatusOK {
+		t.Errorf("expected the renewed SAS to work, got %d", code)
+	}
+	if value := testutil.ToFloat64(metrics.SASRenewals.WithLabelValues(metrics.ResultSuccess)); value != successes+1 {
+		t.Errorf("expected one more successful renewal, got %v after %v", value, successes)
+	}
+
+	// a renewal that cannot reach the workloads is counted and the previous SAS stays tracked
+	accesses.err = errors.New("secret not found")
+	failures := testutil.ToFloat64(metrics.SASRenewals.WithLabelValues(metrics.ResultFailure))
+	renewed := accesses.current
+	pr.renewExpiringSAS(context.Background(), expiry.Add(-time.Hour), DefaultSASRenewBefore)
+	if value := testutil.ToFloat64(metrics.SASRenewals.WithLabelValues(metrics.ResultFailure)); value != failures+1 {
+		t.Errorf("expected one more failed renewal, got %v after %v", value, failures)
+	}
+	grants := pr.grants.expiring(expiry.Add(-time.Hour), DefaultSASRenewBefore)
+	if grant, ok := grants[access]; !ok || !reflect.DeepEqual(grant.secrets, renewed) {
+		t.Errorf("expected the grant to keep the last SAS handed out, got %v", grants)
+	}
+
+	pr.grants.untrack(grantResp.AccountId)
+	if metrics.SASExpiry.DeleteLabelValues(labels...) {
+		t.Errorf("expected no expiry metric after the grant is untracked")
+	}
+}
+
+func TestRenewRestoredSAS(t *testing.T) {
+	pr, s := newFakeAzureProvisioner(t)
+	accesses := &fakeBucketAccesses{}
+	pr.accesses = accesses
+	grantResp, bucketID := grantContainerAccess(t, pr)
+
+	// the driver restarts, the BucketAccess and the expiry in its Secret are all that is left
+	pr.grants = newGrantTracker()
+	access := k8stypes.NamespacedName{Namespace: "app", Name: "access"}
+	expiry := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{access: grantResp.AccountId}
+	accesses.grants = map[k8stypes.NamespacedName]*grantDetails{access: {
+		access:     access,
+		accountID:  grantResp.AccountId,
+		bucketID:   bucketID,
+		parameters: map[string]string{constant.EnableReadField: "true", constant.EnableListField: "true"},
+		expiry:     expiry,
+	}}
+	if _, err := pr.syncGrants(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	labels := []string{"access", "app", "fakeaccount", "bucket"}
+	if value := testutil.ToFloat64(metrics.SASExpiry.WithLabelValues(labels...)); value != float64(expiry.Unix()) {
+		t.Errorf("expected the expiry metric of the restored grant to be %d, got %v", expiry.Unix(), value)
+	}
+
+	pr.renewExpiringSAS(context.Background(), expiry.Add(-30*time.Minute), DefaultSASRenewBefore)
+	if !reflect.DeepEqual(accesses.updated, []k8stypes.NamespacedName{access}) {
+		t.Fatalf("expected the restored SAS to be renewed, got %v", accesses.updated)
+	}
+	if code := listWithSAS(t, s, accesses.current); code != http.StatusOK {
+		t.Errorf("expected the renewed SAS to work, got %d", code)
+	}
+
+	// the BucketAccess is deleted while another replica leads
+	accesses.accountIDs = map[k8stypes.NamespacedName]string{}
+	if _, err := pr.syncGrants(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := pr.grants.bucketIDs(); len(ids) != 0 {
+		t.Errorf("expected the grant of a deleted BucketAccess to be forgotten, got %v", ids)
+	}
+	if metrics.SASExpiry.DeleteLabelValues(labels...) {
+		t.Errorf("expected no expiry metric after the grant is forgotten")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/driver"
+	"github.com/Azure/azure-cosi-driver/pkg/events"
+	"github.com/Azure/azure-cosi-driver/pkg/metrics"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog"
+)
+
+// Annotations the usage collector sets on Buckets
+const (
+	AnnotationBlobCount        = driver.DriverName + "/blob-count"
+	AnnotationBytes            = driver.DriverName + "/bytes"
+	AnnotationUsageCollectedAt = driver.DriverName + "/usage-collected-at"
+	// AnnotationTierBytesPrefix is followed by the lowercase access tier, as in bytes-hot
+	AnnotationTierBytesPrefix = driver.DriverName + "/bytes-"
+)
+
+// overridable for testing
+var getBucketUsage = azureutils.GetBucketUsage
+
+// usageCollector publishes the usage of the buckets the driver knows of
+type usageCollector struct {
+	// client looks up Buckets for the namespace of their BucketClaim and annotates them, nil skips both
+	client   dynamic.Interface
+	annotate bool
+	// published maps the buckets whose usage is published to the namespace it is published under
+	published map[string]string
+}
+
+func newUsageCollector(client dynamic.Interface, annotate bool) *usageCollector {
+	return &usageCollector{client: client, annotate: annotate, published: map[string]string{}}
+}
+
+// runUsageCollection collects the usage of the known buckets each interval, until ctx is done
+func (pr *provisioner) runUsageCollection(ctx context.Context, interval time.Duration, c *usageCollector) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pr.collectUsage(ctx, c)
+	}
+}
+
+// collectUsage publishes the usage of every bucket created since the driver started and removes
+// the usage of deleted buckets. A bucket whose usage cannot be read keeps its last published usage.
+func (pr *provisioner) collectUsage(ctx context.Context, c *usageCollector) {
+	pr.bucketsLock.RLock()
+	buckets := make(map[string]string, len(pr.nameToBucketMap))
+	for name, details := range pr.nameToBucketMap {
+		buckets[name] = details.bucketID
+	}
+	pr.bucketsLock.RUnlock()
+
+	published := map[string]string{}
+	for name, bucketID := range buckets {
+		usage, err := getBucketUsage(ctx, bucketID, pr.cloud)
+		if status.Code(err) == codes.Unimplemented {
+			klog.V(4).Infof("Not collecting the usage of bucket %s: %v", name, err)
+			continue
+		}
+		if err != nil {
+			klog.Errorf("Error collecting the usage of bucket %s: %v", name, err)
+			if namespace, ok := c.published[name]; ok {
+				published[name] = namespace
+			}
+			continue
+		}
+
+		bucket := c.getBucket(ctx, name)
+		namespace := ""
+		if bucket != nil {
+			namespace, _, _ = unstructured.NestedString(bucket.Object, "spec", "bucketClaim", "namespace")
+		}
+		if previous, ok := c.published[name]; ok && previous != namespace {
+			metrics.DeleteBucketUsage(name, previous)
+		}
+		metrics.SetBucketUsage(name, namespace, usage.BlobCount, usage.Bytes, usage.BytesPerTier)
+		published[name] = namespace
+
+		if c.annotate && bucket != nil {
+			if err := c.annotateBucket(ctx, bucket, usage, time.Now()); err != nil {
+				klog.Errorf("Error annotating bucket %s with its usage: %v", name, err)
+			}
+		}
+	}
+
+	for name, namespace := range c.published {
+		if _, ok := published[name]; !ok {
+			metrics.DeleteBucketUsage(name, namespace)
+		}
+	}
+	c.published = published
+}
+
+// getBucket returns the Bucket object, or nil when it cannot be read
+func (c *usageCollector) getBucket(ctx context.Context, name string) *unstructured.Unstructured {
+	if c.client == nil {
+		return nil
+	}
+	bucket, err := c.client.Resource(events.BucketGVR).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).Infof("Could not get Bucket %s: %v", name, err)
+		return nil
+	}
+	return bucket
+}
+
+// annotateBucket sets the usage annotations on the Bucket and removes those of tiers that emptied
+func (c *usageCollector) annotateBucket(ctx context.Context, bucket *unstructured.Unstructured, usage *azureutils.BucketUsage, now time.Time) error {
+	annotations := map[string]interface{}{
+		AnnotationBlobCount:        strconv.FormatInt(usage.BlobCount, 10),
+		AnnotationBytes:            strconv.FormatInt(usage.Bytes, 10),
+		AnnotationUsageCollectedAt: now.UTC().Format(time.RFC3339),
+	}
+	for key := range bucket.GetAnnotations() {
+		if strings.HasPrefix(key, AnnotationTierBytesPrefix) {
+			// a merge patch removes keys set to null
+			annotations[key] = nil
+		}
+	}
+	for tier, bytes := range usage.BytesPerTier {
+		annotations[AnnotationTierBytesPrefix+strings.ToLower(tier)] = strconv.FormatInt(bytes, 10)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}})
+	if err != nil {
+		return fmt.Errorf("could not encode the annotations: %v", err)
+	}
+	_, err = c.client.Resource(events.BucketGVR).Patch(ctx, bucket.GetName(), k8stypes.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
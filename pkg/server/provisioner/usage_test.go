@@ -0,0 +1,119 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisionerserver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-cosi-driver/pkg/azureutils"
+	"github.com/Azure/azure-cosi-driver/pkg/events"
+	"github.com/Azure/azure-cosi-driver/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+func newTestBucket(name, claimNamespace string, annotations map[string]string) *unstructured.Unstructured {
+	bucket := &unstructured.Unstructured{}
+	bucket.SetAPIVersion("objectstorage.k8s.io/v1alpha1")
+	bucket.SetKind("Bucket")
+	bucket.SetName(name)
+	bucket.SetAnnotations(annotations)
+	_ = unstructured.SetNestedField(bucket.Object, claimNamespace, "spec", "bucketClaim", "namespace")
+	return bucket
+}
+
+func TestCollectUsage(t *testing.T) {
+	ctx := context.Background()
+	usages := map[string]*azureutils.BucketUsage{
+		"id-team-a": {BlobCount: 3, Bytes: 130, BytesPerTier: map[string]int64{"Hot": 30, "Cool": 100}},
+	}
+	errs := map[string]error{
+		"id-share":  status.Error(codes.Unimplemented, "fileshare"),
+		"id-broken": errors.New("listing failed"),
+	}
+	getBucketUsage = func(_ context.Context, bucketID string, _ *azure.Cloud) (*azureutils.BucketUsage, error) {
+		return usages[bucketID], errs[bucketID]
+	}
+	defer func() { getBucketUsage = azureutils.GetBucketUsage }()
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		events.BucketGVR: "BucketList",
+	}, newTestBucket("team-a", "team-a-ns", map[string]string{AnnotationTierBytesPrefix + "archive": "5", "other": "kept"}))
+
+	pr := &provisioner{nameToBucketMap: map[string]*bucketDetails{
+		"team-a": {bucketID: "id-team-a"},
+		"share":  {bucketID: "id-share"},
+		"broken": {bucketID: "id-broken"},
+	}}
+	c := newUsageCollector(client, true)
+	pr.collectUsage(ctx, c)
+
+	if value := testutil.ToFloat64(metrics.BucketBytes.WithLabelValues("team-a", "team-a-ns")); value != 130 {
+		t.Errorf("expected 130 bytes for team-a, got %v", value)
+	}
+	if value := testutil.ToFloat64(metrics.BucketBlobs.WithLabelValues("team-a", "team-a-ns")); value != 3 {
+		t.Errorf("expected 3 blobs for team-a, got %v", value)
+	}
+	if value := testutil.ToFloat64(metrics.BucketTierBytes.WithLabelValues("team-a", "team-a-ns", "Cool")); value != 100 {
+		t.Errorf("expected 100 cool bytes for team-a, got %v", value)
+	}
+	if !reflect.DeepEqual(c.published, map[string]string{"team-a": "team-a-ns"}) {
+		t.Errorf("expected only team-a to be published, got %v", c.published)
+	}
+
+	bucket, err := client.Resource(events.BucketGVR).Get(ctx, "team-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	annotations := bucket.GetAnnotations()
+	delete(annotations, AnnotationUsageCollectedAt)
+	expected := map[string]string{
+		"other":                            "kept",
+		AnnotationBlobCount:                "3",
+		AnnotationBytes:                    "130",
+		AnnotationTierBytesPrefix + "hot":  "30",
+		AnnotationTierBytesPrefix + "cool": "100",
+	}
+	if !reflect.DeepEqual(annotations, expected) {
+		t.Errorf("expected annotations %v, got %v", expected, annotations)
+	}
+
+	// a bucket whose usage cannot be read keeps its last usage
+	errs["id-team-a"] = errors.New("metrics unavailable")
+	pr.collectUsage(ctx, c)
+	if value := testutil.ToFloat64(metrics.BucketBytes.WithLabelValues("team-a", "team-a-ns")); value != 130 {
+		t.Errorf("expected team-a to keep 130 bytes, got %v", value)
+	}
+
+	// a deleted bucket is no longer published
+	delete(pr.nameToBucketMap, "team-a")
+	pr.collectUsage(ctx, c)
+	if metrics.BucketBytes.DeleteLabelValues("team-a", "team-a-ns") || metrics.BucketTierBytes.DeleteLabelValues("team-a", "team-a-ns", "Hot") {
+		t.Errorf("expected the usage of a deleted bucket to be removed")
+	}
+	if len(c.published) != 0 {
+		t.Errorf("expected no published buckets, got %v", c.published)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance checks a COSI driver through its gRPC endpoint against the behaviour the
+// COSI spec and the COSI sidecar expect of every driver: idempotent creates, AlreadyExists for
+// conflicting parameters, deletes of deleted buckets that succeed, grants that can be revoked and
+// InvalidArgument for malformed requests. The cases only depend on the spec, other drivers can
+// run them by describing their bucket and access classes in a Driver.
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	spec "sigs.k8s.io/container-object-storage-interface-spec"
+)
+
+// callTimeout bounds every call of the suite
+const callTimeout = 30 * time.Second
+
+// Driver describes the driver under test
+type Driver struct {
+	// Endpoint is the gRPC endpoint of the driver, unix://<path> or tcp://<host:port>
+	Endpoint string
+	// Name is the name DriverGetInfo must return
+	Name string
+	// BucketParameters are the BucketClass parameters of the buckets the suite creates
+	BucketParameters map[string]string
+	// ConflictingBucketParameters are valid BucketClass parameters that differ from BucketParameters
+	ConflictingBucketParameters map[string]string
+	// InvalidBucketParameters are BucketClass parameters the driver must reject
+	InvalidBucketParameters map[string]string
+	// AccessParameters are the BucketAccessClass parameters of the grants the suite makes
+	AccessParameters map[string]string
+	// AuthenticationType is the authentication type of the grants, Key when unset
+	AuthenticationType spec.AuthenticationType
+	// CheckCredentials, if set, checks the credentials of a grant beyond them being present
+	CheckCredentials func(t *testing.T, credentials map[string]*spec.CredentialDetails)
+}
+
+// Clients are the gRPC clients of the driver under test
+type Clients struct {
+	Identity    spec.IdentityClient
+	Provisioner spec.ProvisionerClient
+}
+
+// TestCase is a behaviour every COSI driver must have
+type TestCase struct {
+	Name string
+	Run  func(ctx context.Context, t *testing.T, c *Clients, d *Driver)
+}
+
+// TestCases are the cases Run runs, in order
+var TestCases = []TestCase{
+	{Name: "GetInfo returns the driver name", Run: testGetInfo},
+	{Name: "CreateBucket is idempotent", Run: testCreateBucketIdempotent},
+	{Name: "CreateBucket with conflicting parameters returns AlreadyExists", Run: testCreateBucketConflict},
+	{Name: "CreateBucket without parameters returns InvalidArgument", Run: testCreateBucketMissingParameters},
+	{Name: "CreateBucket with invalid parameters returns InvalidArgument", Run: testCreateBucketInvalidParameters},
+	{Name: "DeleteBucket of a deleted bucket succeeds", Run: testDeleteBucketNotFound},
+	{Name: "DeleteBucket with a malformed BucketId returns InvalidArgument", Run: testDeleteBucketMalformedID},
+	{Name: "GrantBucketAccess and RevokeBucketAccess", Run: testGrantAndRevoke},
+	{Name: "GrantBucketAccess is idempotent", Run: testGrantIdempotent},
+	{Name: "GrantBucketAccess without an authentication type returns InvalidArgument", Run: testGrantMissingAuthenticationType},
+	{Name: "GrantBucketAccess without parameters returns InvalidArgument", Run: testGrantMissingParameters},
+	{Name: "GrantBucketAccess with a malformed BucketId returns InvalidArgument", Run: testGrantMalformedID},
+}
+
+// Run connects to the driver and runs every TestCase as a subtest
+func Run(t *testing.T, d Driver) {
+	conn, err := grpc.Dial(d.Endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("could not connect to %s: %v", d.Endpoint, err)
+	}
+	defer conn.Close()
+	if d.AuthenticationType == spec.AuthenticationType_UnknownAuthenticationType {
+		d.AuthenticationType = spec.AuthenticationType_Key
+	}
+
+	c := &Clients{
+		Identity:    spec.NewIdentityClient(conn),
+		Provisioner: spec.NewProvisionerClient(conn),
+	}
+	for _, tc := range TestCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+			defer cancel()
+			tc.Run(ctx, t, c, &d)
+		})
+	}
+}
+
+// bucketCount makes the bucket names of a run unique
+var bucketCount int32
+
+// newBucketName returns a name valid as a container, file share or storage account name prefix
+func newBucketName() string {
+	return fmt.Sprintf("conformance%d", atomic.AddInt32(&bucketCount, 1))
+}
+
+// createBucket creates a bucket with the BucketParameters of the driver and deletes it when the test ends
+func createBucket(ctx context.Context, t *testing.T, c *Clients, d *Driver, name string) string {
+	resp, err := c.Provisioner.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{Name: name, Parameters: d.BucketParameters})
+	if err != nil {
+		t.Fatalf("DriverCreateBucket %s: unexpected error: %v", name, err)
+	}
+	if resp.BucketId == "" {
+		t.Fatalf("DriverCreateBucket %s: empty BucketId", name)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		defer cancel()
+		if _, err := c.Provisioner.DriverDeleteBucket(ctx, &spec.DriverDeleteBucketRequest{BucketId: resp.BucketId}); err != nil {
+			t.Errorf("DriverDeleteBucket %s: unexpected error: %v", name, err)
+		}
+	})
+	return resp.BucketId
+}
+
+func grantRequest(d *Driver, bucketID, name string) *spec.DriverGrantBucketAccessRequest {
+	return &spec.DriverGrantBucketAccessRequest{
+		BucketId:           bucketID,
+		Name:               name,
+		AuthenticationType: d.AuthenticationType,
+		Parameters:         d.AccessParameters,
+	}
+}
+
+// expectCode fails the test unless err is a status error with the code
+func expectCode(t *testing.T, call string, err error, code codes.Code) {
+	t.Helper()
+	if s, _ := status.FromError(err); s.Code() != code {
+		t.Errorf("%s: expected code %s, got %v", call, code, err)
+	}
+}
+
+func testGetInfo(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	resp, err := c.Identity.DriverGetInfo(ctx, &spec.DriverGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("DriverGetInfo: unexpected error: %v", err)
+	}
+	if resp.Name != d.Name {
+		t.Errorf("DriverGetInfo: expected name %s, got %s", d.Name, resp.Name)
+	}
+}
+
+func testCreateBucketIdempotent(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	name := newBucketName()
+	bucketID := createBucket(ctx, t, c, d, name)
+	resp, err := c.Provisioner.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{Name: name, Parameters: d.BucketParameters})
+	if err != nil {
+		t.Fatalf("second DriverCreateBucket: unexpected error: %v", err)
+	}
+	if resp.BucketId != bucketID {
+		t.Errorf("second DriverCreateBucket: expected BucketId %s, got %s", bucketID, resp.BucketId)
+	}
+}
+
+func testCreateBucketConflict(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	name := newBucketName()
+	createBucket(ctx, t, c, d, name)
+	_, err := c.Provisioner.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{Name: name, Parameters: d.ConflictingBucketParameters})
+	expectCode(t, "DriverCreateBucket", err, codes.AlreadyExists)
+}
+
+func testCreateBucketMissingParameters(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	_, err := c.Provisioner.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{Name: newBucketName()})
+	expectCode(t, "DriverCreateBucket", err, codes.InvalidArgument)
+}
+
+func testCreateBucketInvalidParameters(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	_, err := c.Provisioner.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{Name: newBucketName(), Parameters: d.InvalidBucketParameters})
+	expectCode(t, "DriverCreateBucket", err, codes.InvalidArgument)
+}
+
+func testDeleteBucketNotFound(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	resp, err := c.Provisioner.DriverCreateBucket(ctx, &spec.DriverCreateBucketRequest{Name: newBucketName(), Parameters: d.BucketParameters})
+	if err != nil {
+		t.Fatalf("DriverCreateBucket: unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Provisioner.DriverDeleteBucket(ctx, &spec.DriverDeleteBucketRequest{BucketId: resp.BucketId}); err != nil {
+			t.Errorf("DriverDeleteBucket #%d: unexpected error: %v", i+1, err)
+		}
+	}
+}
+
+func testDeleteBucketMalformedID(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	_, err := c.Provisioner.DriverDeleteBucket(ctx, &spec.DriverDeleteBucketRequest{BucketId: "not a bucket id"})
+	expectCode(t, "DriverDeleteBucket", err, codes.InvalidArgument)
+}
+
+func testGrantAndRevoke(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	bucketID := createBucket(ctx, t, c, d, newBucketName())
+	resp, err := c.Provisioner.DriverGrantBucketAccess(ctx, grantRequest(d, bucketID, "access"))
+	if err != nil {
+		t.Fatalf("DriverGrantBucketAccess: unexpected error: %v", err)
+	}
+	if resp.AccountId == "" {
+		t.Errorf("DriverGrantBucketAccess: empty AccountId")
+	}
+	if len(resp.Credentials) == 0 {
+		t.Errorf("DriverGrantBucketAccess: no credentials")
+	}
+	if d.CheckCredentials != nil {
+		d.CheckCredentials(t, resp.Credentials)
+	}
+
+	// the sidecar retries revokes until they succeed, so a revoke must be repeatable
+	for i := 0; i < 2; i++ {
+		if _, err := c.Provisioner.DriverRevokeBucketAccess(ctx, &spec.DriverRevokeBucketAccessRequest{
+			BucketId:  bucketID,
+			AccountId: resp.AccountId,
+		}); err != nil {
+			t.Errorf("DriverRevokeBucketAccess #%d: unexpected error: %v", i+1, err)
+		}
+	}
+}
+
+func testGrantIdempotent(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	bucketID := createBucket(ctx, t, c, d, newBucketName())
+	first, err := c.Provisioner.DriverGrantBucketAccess(ctx, grantRequest(d, bucketID, "access"))
+	if err != nil {
+		t.Fatalf("DriverGrantBucketAccess: unexpected error: %v", err)
+	}
+	second, err := c.Provisioner.DriverGrantBucketAccess(ctx, grantRequest(d, bucketID, "access"))
+	if err != nil {
+		t.Fatalf("second DriverGrantBucketAccess: unexpected error: %v", err)
+	}
+	// the AccountId identifies the grant to revoke, a retried grant must not orphan the first one
+	if first.AccountId != second.AccountId {
+		t.Errorf("second DriverGrantBucketAccess: expected AccountId %s, got %s", first.AccountId, second.AccountId)
+	}
+}
+
+func testGrantMissingAuthenticationType(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	bucketID := createBucket(ctx, t, c, d, newBucketName())
+	req := grantRequest(d, bucketID, "access")
+	req.AuthenticationType = spec.AuthenticationType_UnknownAuthenticationType
+	_, err := c.Provisioner.DriverGrantBucketAccess(ctx, req)
+	expectCode(t, "DriverGrantBucketAccess", err, codes.InvalidArgument)
+}
+
+func testGrantMissingParameters(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	bucketID := createBucket(ctx, t, c, d, newBucketName())
+	req := grantRequest(d, bucketID, "access")
+	req.Parameters = nil
+	_, err := c.Provisioner.DriverGrantBucketAccess(ctx, req)
+	expectCode(t, "DriverGrantBucketAccess", err, codes.InvalidArgument)
+}
+
+func testGrantMalformedID(ctx context.Context, t *testing.T, c *Clients, d *Driver) {
+	_, err := c.Provisioner.DriverGrantBucketAccess(ctx, grantRequest(d, "not a bucket id", "access"))
+	expectCode(t, "DriverGrantBucketAccess", err, codes.InvalidArgument)
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakeazure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// armAccountRE matches /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Storage/storageAccounts[/<name>[/<action>]]
+	armAccountRE = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Storage/storageAccounts(?:/([^/]+)(?:/([^/]+))?)?/?$`)
+	// checkNameAvailabilityRE matches /subscriptions/<sub>/providers/Microsoft.Storage/checkNameAvailability
+	checkNameAvailabilityRE = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/providers/Microsoft\.Storage/checkNameAvailability/?$`)
+	// metricsRE matches /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Storage/storageAccounts/<name>[/blobServices/default]/providers/Microsoft.Insights/metrics
+	metricsRE = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Storage/storageAccounts/([^/]+)(/blobServices/default)?/providers/Microsoft\.Insights/metrics/?$`)
+	// tokenRE matches the AAD token endpoint, /<tenant>/oauth2/token
+	tokenRE = regexp.MustCompile(`^/[^/]+/oauth2/token/?$`)
+	// accountNameRE is the rule Azure has for storage account names
+	accountNameRE = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+)
+
+func (s *Server) serveARM(w http.ResponseWriter, r *http.Request) {
+	if tokenRE.MatchString(r.URL.Path) && r.Method == http.MethodPost {
+		s.issueToken(w)
+		return
+	}
+	if checkNameAvailabilityRE.MatchString(r.URL.Path) && r.Method == http.MethodPost {
+		s.checkNameAvailability(w, r)
+		return
+	}
+	if matches := metricsRE.FindStringSubmatch(r.URL.Path); matches != nil && r.Method == http.MethodGet {
+		s.listMetrics(w, r, matches[1], matches[2], matches[3], matches[4] != "")
+		return
+	}
+	matches := armAccountRE.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		writeARMError(w, http.StatusNotFound, "InvalidResourceType", "The fake does not serve %s", r.URL.Path)
+		return
+	}
+	subsID, resourceGroup, name, action := matches[1], matches[2], matches[3], strings.ToLower(matches[4])
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		s.listAccounts(w, subsID, resourceGroup)
+	case action == "" && r.Method == http.MethodPut:
+		s.createAccount(w, r, subsID, resourceGroup, name)
+	case action == "" && r.Method == http.MethodGet:
+		if a := s.getAccount(w, subsID, resourceGroup, name); a != nil {
+			writeJSON(w, http.StatusOK, a.resource)
+		}
+	case action == "" && r.Method == http.MethodPatch:
+		s.updateAccount(w, r, subsID, resourceGroup, name)
+	case action == "" && r.Method == http.MethodDelete:
+		if _, ok := s.accounts[name]; !ok || !s.inResourceGroup(name, subsID, resourceGroup) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		delete(s.accounts, name)
+		w.WriteHeader(http.StatusOK)
+	case action == "listkeys" && r.Method == http.MethodPost:
+		if a := s.getAccount(w, subsID, resourceGroup, name); a != nil {
+			writeJSON(w, http.StatusOK, a.listKeys())
+		}
+	case action == "regeneratekey" && r.Method == http.MethodPost:
+		s.regenerateKey(w, r, subsID, resourceGroup, name)
+	default:
+		writeARMError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "%s is not supported on %s", r.Method, r.URL.Path)
+	}
+}
+
+func (s *Server) inResourceGroup(name, subsID, resourceGroup string) bool {
+	a := s.accounts[name]
+	return strings.EqualFold(a.subscriptionID, subsID) && strings.EqualFold(a.resourceGroup, resourceGroup)
+}
+
+// getAccount returns the account, or writes ResourceNotFound and returns nil
+func (s *Server) getAccount(w http.ResponseWriter, subsID, resourceGroup, name string) *account {
+	a, ok := s.accounts[name]
+	if !ok || !s.inResourceGroup(name, subsID, resourceGroup) {
+		writeARMError(w, http.StatusNotFound, "ResourceNotFound", "The Resource 'Microsoft.Storage/storageAccounts/%s' under resource group '%s' was not found.", name, resourceGroup)
+		return nil
+	}
+	return a
+}
+
+func (s *Server) listAccounts(w http.ResponseWriter, subsID, resourceGroup string) {
+	accounts := []interface{}{}
+	for name, a := range s.accounts {
+		if s.inResourceGroup(name, subsID, resourceGroup) {
+			accounts = append(accounts, a.resource)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": accounts})
+}
+
+// issueToken answers client credential requests with a token the fake does not check
+func (s *Server) issueToken(w http.ResponseWriter) {
+	now := s.now()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token_type":   "Bearer",
+		"access_token": newETag(),
+		"expires_in":   "3600",
+		"expires_on":   strconv.FormatInt(now.Add(time.Hour).Unix(), 10),
+		"not_before":   strconv.FormatInt(now.Unix(), 10),
+		"resource":     s.ResourceManagerEndpoint(),
+	})
+}
+
+// listMetrics serves the UsedCapacity metric of a storage account, the bytes of its blobs, and the
+// BlobCount and BlobCapacity metrics of its blob service, split by access tier when filtered on Tier
+func (s *Server) listMetrics(w http.ResponseWriter, r *http.Request, subsID, resourceGroup, name string, blobService bool) {
+	supported := map[string]bool{"usedcapacity": !blobService, "blobcount": blobService, "blobcapacity": blobService}
+	metricNames := strings.Split(r.URL.Query().Get("metricnames"), ",")
+	for _, metric := range metricNames {
+		if !supported[strings.ToLower(metric)] {
+			writeARMError(w, http.StatusBadRequest, "BadRequest", "The fake does not serve the %q metric of %s", metric, r.URL.Path)
+			return
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.getAccount(w, subsID, resourceGroup, name)
+	if a == nil {
+		return
+	}
+	byTier := strings.Contains(strings.ToLower(r.URL.Query().Get("$filter")), "tier eq")
+	timeStamp := s.now().Truncate(time.Hour).Format(time.RFC3339)
+	value := []interface{}{}
+	for _, metric := range metricNames {
+		series := []interface{}{}
+		for tier, usage := range a.blobUsage(byTier) {
+			sample := usage.bytes
+			if strings.EqualFold(metric, "BlobCount") {
+				sample = usage.blobs
+			}
+			metadata := []interface{}{}
+			if byTier {
+				metadata = append(metadata, map[string]interface{}{"name": map[string]interface{}{"value": "tier"}, "value": tier})
+			}
+			series = append(series, map[string]interface{}{
+				"metadatavalues": metadata,
+				"data":           []interface{}{map[string]interface{}{"timeStamp": timeStamp, "average": sample}},
+			})
+		}
+		value = append(value, map[string]interface{}{
+			"id":         r.URL.Path,
+			"type":       "Microsoft.Insights/metrics",
+			"name":       map[string]interface{}{"value": metric, "localizedValue": metric},
+			"timeseries": series,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"timespan": r.URL.Query().Get("timespan"),
+		"interval": "PT1H",
+		"value":    value,
+	})
+}
+
+func (s *Server) checkNameAvailability(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeARMError(w, http.StatusBadRequest, "InvalidRequestContent", "%v", err)
+		return
+	}
+	if !strings.EqualFold(body.Type, "Microsoft.Storage/storageAccounts") {
+		writeARMError(w, http.StatusBadRequest, "InvalidResourceType", "type must be Microsoft.Storage/storageAccounts, got %q", body.Type)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := map[string]interface{}{"nameAvailable": true}
+	if !accountNameRE.MatchString(body.Name) {
+		result = map[string]interface{}{
+			"nameAvailable": false,
+			"reason":        "AccountNameInvalid",
+			"message":       fmt.Sprintf("%s is not a valid storage account name.", body.Name),
+		}
+	} else if _, taken := s.accounts[body.Name]; taken {
+		result = map[string]interface{}{
+			"nameAvailable": false,
+			"reason":        "AlreadyExists",
+			"message":       fmt.Sprintf("The storage account named %s is already taken.", body.Name),
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) createAccount(w http.ResponseWriter, r *http.Request, subsID, resourceGroup, name string) {
+	if !accountNameRE.MatchString(name) {
+		writeARMError(w, http.StatusBadRequest, "AccountNameInvalid", "%s is not a valid storage account name.", name)
+		return
+	}
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeARMError(w, http.StatusBadRequest, "InvalidRequestContent", "%v", err)
+		return
+	}
+	a, exists := s.accounts[name]
+	if exists && !s.inResourceGroup(name, subsID, resourceGroup) {
+		writeARMError(w, http.StatusConflict, "StorageAccountAlreadyTaken", "The storage account named %s is already taken.", name)
+		return
+	}
+	if !exists {
+		a = s.addAccount(subsID, resourceGroup, name)
+	}
+	// a second PUT replaces the settings of the account but keeps its keys and data
+	a.setResource(subsID, resourceGroup, name, body)
+	writeJSON(w, http.StatusOK, a.resource)
+}
+
+func (s *Server) addAccount(subsID, resourceGroup, name string) *account {
+	a := &account{
+		subscriptionID: subsID,
+		resourceGroup:  resourceGroup,
+		keys:           [2]string{newKey(), newKey()},
+		containers:     map[string]*blobContainer{},
+	}
+	s.accounts[name] = a
+	return a
+}
+
+func (a *account) setResource(subsID, resourceGroup, name string, body map[string]interface{}) {
+	body["id"] = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s", subsID, resourceGroup, name)
+	body["name"] = name
+	body["type"] = "Microsoft.Storage/storageAccounts"
+	properties, _ := body["properties"].(map[string]interface{})
+	if properties == nil {
+		properties = map[string]interface{}{}
+		body["properties"] = properties
+	}
+	properties["provisioningState"] = "Succeeded"
+	properties["primaryEndpoints"] = map[string]interface{}{
+		"blob": fmt.Sprintf("https://%s.blob.core.windows.net/", name),
+		"dfs":  fmt.Sprintf("https://%s.dfs.core.windows.net/", name),
+		"file": fmt.Sprintf("https://%s.file.core.windows.net/", name),
+	}
+	a.resource = body
+}
+
+func (s *Server) updateAccount(w http.ResponseWriter, r *http.Request, subsID, resourceGroup, name string) {
+	a := s.getAccount(w, subsID, resourceGroup, name)
+	if a == nil {
+		return
+	}
+	patch := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeARMError(w, http.StatusBadRequest, "InvalidRequestContent", "%v", err)
+		return
+	}
+	// ARM replaces the tags of a resource with those of an update instead of merging them
+	if tags, ok := patch["tags"]; ok {
+		a.resource["tags"] = tags
+		delete(patch, "tags")
+	}
+	mergePatch(a.resource, patch)
+	writeJSON(w, http.StatusOK, a.resource)
+}
+
+func (s *Server) regenerateKey(w http.ResponseWriter, r *http.Request, subsID, resourceGroup, name string) {
+	a := s.getAccount(w, subsID, resourceGroup, name)
+	if a == nil {
+		return
+	}
+	body := struct {
+		KeyName string `json:"keyName"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeARMError(w, http.StatusBadRequest, "InvalidRequestContent", "%v", err)
+		return
+	}
+	switch body.KeyName {
+	case "key1":
+		a.keys[0] = newKey()
+	case "key2":
+		a.keys[1] = newKey()
+	default:
+		writeARMError(w, http.StatusBadRequest, "InvalidValuesForRequestParameters", "keyName must be key1 or key2, got %q", body.KeyName)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.listKeys())
+}
+
+func (a *account) listKeys() map[string]interface{} {
+	return map[string]interface{}{
+		"keys": []interface{}{
+			map[string]interface{}{"keyName": "key1", "value": a.keys[0], "permissions": "FULL"},
+			map[string]interface{}{"keyName": "key2", "value": a.keys[1], "permissions": "FULL"},
+		},
+	}
+}
+
+// mergePatch applies a JSON merge patch, an object updates the fields it sets and null removes one
+func mergePatch(target, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(target, k)
+			continue
+		}
+		patchObject, isObject := v.(map[string]interface{})
+		targetObject, hasObject := target[k].(map[string]interface{})
+		if isObject && hasObject {
+			mergePatch(targetObject, patchObject)
+			continue
+		}
+		target[k] = v
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeARMError(w http.ResponseWriter, status int, code, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": fmt.Sprintf(format, args...)},
+	})
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakeazure
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/preview/monitor/mgmt/2021-07-01-preview/insights"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2021-09-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// newTestAccount starts a fake with the storage account name and returns its first key
+func newTestAccount(t *testing.T, name string) (*Server, string) {
+	s := NewServer()
+	t.Cleanup(s.Close)
+	parameters := storage.AccountCreateParameters{
+		Sku:      &storage.Sku{Name: storage.SkuNameStandardLRS},
+		Kind:     storage.KindStorageV2,
+		Location: to.StringPtr(Location),
+	}
+	if rerr := s.Cloud().StorageAccountClient.Create(context.Background(), SubscriptionID, ResourceGroup, name, parameters); rerr != nil {
+		t.Fatalf("unexpected error creating account: %v", rerr.Error())
+	}
+	keys, _ := s.AccountKeys(name)
+	return s, keys[0]
+}
+
+func TestStorageAccounts(t *testing.T) {
+	ctx := context.Background()
+	s, key := newTestAccount(t, "account")
+	client := s.Cloud().StorageAccountClient
+
+	account, rerr := client.GetProperties(ctx, SubscriptionID, ResourceGroup, "account")
+	if rerr != nil {
+		t.Fatalf("unexpected error: %v", rerr.Error())
+	}
+	if to.String(account.Name) != "account" || account.Sku.Name != storage.SkuNameStandardLRS || account.ProvisioningState != storage.ProvisioningStateSucceeded {
+		t.Errorf("unexpected account %+v", account)
+	}
+	if blob := to.String(account.PrimaryEndpoints.Blob); blob != "https://account.blob.core.windows.net/" {
+		t.Errorf("unexpected blob endpoint %s", blob)
+	}
+
+	keys, rerr := client.ListKeys(ctx, SubscriptionID, ResourceGroup, "account")
+	if rerr != nil || len(*keys.Keys) != 2 || to.String((*keys.Keys)[0].Value) != key {
+		t.Errorf("unexpected keys %v: %v", keys.Keys, rerr)
+	}
+
+	update := storage.AccountUpdateParameters{Tags: map[string]*string{"owner": to.StringPtr("team")}}
+	if rerr := client.Update(ctx, SubscriptionID, ResourceGroup, "account", update); rerr != nil {
+		t.Errorf("unexpected error: %v", rerr.Error())
+	}
+	accounts, rerr := client.ListByResourceGroup(ctx, SubscriptionID, ResourceGroup)
+	if rerr != nil || len(accounts) != 1 || to.String(accounts[0].Tags["owner"]) != "team" || accounts[0].Sku == nil {
+		t.Errorf("unexpected accounts %+v: %v", accounts, rerr)
+	}
+	update = storage.AccountUpdateParameters{Tags: map[string]*string{"env": to.StringPtr("dev")}}
+	if rerr := client.Update(ctx, SubscriptionID, ResourceGroup, "account", update); rerr != nil {
+		t.Errorf("unexpected error: %v", rerr.Error())
+	}
+	if account, _ := client.GetProperties(ctx, SubscriptionID, ResourceGroup, "account"); !reflect.DeepEqual(account.Tags, update.Tags) {
+		t.Errorf("expected an update to replace the tags, got %v", account.Tags)
+	}
+	if accounts, _ := client.ListByResourceGroup(ctx, SubscriptionID, "other-rg"); len(accounts) != 0 {
+		t.Errorf("expected no accounts in another resource group, got %d", len(accounts))
+	}
+
+	if rerr := client.Delete(ctx, SubscriptionID, ResourceGroup, "account"); rerr != nil {
+		t.Errorf("unexpected error: %v", rerr.Error())
+	}
+	if _, rerr := client.GetProperties(ctx, SubscriptionID, ResourceGroup, "account"); rerr == nil || rerr.HTTPStatusCode != http.StatusNotFound {
+		t.Errorf("expected the deleted account to be not found, got %v", rerr)
+	}
+	if rerr := client.Delete(ctx, SubscriptionID, ResourceGroup, "account"); rerr != nil {
+		t.Errorf("expected deleting a missing account to succeed, got %v", rerr.Error())
+	}
+}
+
+func TestCreateStorageAccountErrors(t *testing.T) {
+	s, _ := newTestAccount(t, "account")
+	client := s.Cloud().StorageAccountClient
+	tests := []struct {
+		testName       string
+		resourceGroup  string
+		name           string
+		expectedStatus int
+	}{
+		{
+			testName:       "Invalid name",
+			resourceGroup:  ResourceGroup,
+			name:           "Invalid_Name",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			testName:       "Name taken in another resource group",
+			resourceGroup:  "other-rg",
+			name:           "account",
+			expectedStatus: http.StatusConflict,
+		},
+	}
+	for _, test := range tests {
+		rerr := client.Create(context.Background(), SubscriptionID, test.resourceGroup, test.name, storage.AccountCreateParameters{Location: to.StringPtr(Location)})
+		if rerr == nil || rerr.HTTPStatusCode != test.expectedStatus {
+			t.Errorf("\nTestCase: %s\nExpected Status: %d\nActual Error: %v", test.testName, test.expectedStatus, rerr)
+		}
+	}
+}
+
+func TestRegenerateKey(t *testing.T) {
+	s, key := newTestAccount(t, "account")
+	url := s.ResourceManagerEndpoint() + "subscriptions/" + SubscriptionID + "/resourceGroups/" + ResourceGroup +
+		"/providers/Microsoft.Storage/storageAccounts/account/regenerateKey?api-version=2021-09-01"
+	resp, err := http.Post(url, "application/json", strings.NewReader(`{"keyName": "key1"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	keys, _ := s.AccountKeys("account")
+	if resp.StatusCode != http.StatusOK || keys[0] == key {
+		t.Errorf("expected key1 to be regenerated, got status %d", resp.StatusCode)
+	}
+}
+
+func TestCheckNameAvailability(t *testing.T) {
+	s, _ := newTestAccount(t, "account")
+	client := storage.NewAccountsClientWithBaseURI(s.ResourceManagerEndpoint(), SubscriptionID)
+
+	tests := []struct {
+		name           string
+		expectedReason storage.Reason
+	}{
+		{name: "otheraccount"},
+		{name: "account", expectedReason: storage.ReasonAlreadyExists},
+		{name: "Invalid-Name", expectedReason: storage.ReasonAccountNameInvalid},
+	}
+	for _, test := range tests {
+		result, err := client.CheckNameAvailability(context.Background(), storage.AccountCheckNameAvailabilityParameters{
+			Name: to.StringPtr(test.name),
+			Type: to.StringPtr("Microsoft.Storage/storageAccounts"),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error checking %s: %v", test.name, err)
+		}
+		if to.Bool(result.NameAvailable) != (test.expectedReason == "") || result.Reason != test.expectedReason {
+			t.Errorf("unexpected availability of %s: %v %s", test.name, to.Bool(result.NameAvailable), result.Reason)
+		}
+	}
+}
+
+func TestUsedCapacity(t *testing.T) {
+	s, _ := newTestAccount(t, "account")
+	s.lock.Lock()
+	s.accounts["account"].containers["container"] = &blobContainer{blobs: map[string]*blockBlob{}}
+	s.lock.Unlock()
+	if !s.PutBlob("account", "container", "blob", make([]byte, 100)) {
+		t.Fatalf("could not add a blob")
+	}
+
+	client := insights.NewMetricsClientWithBaseURI(s.ResourceManagerEndpoint(), SubscriptionID)
+	resourceID := "subscriptions/" + SubscriptionID + "/resourceGroups/" + ResourceGroup + "/providers/Microsoft.Storage/storageAccounts/account"
+	resp, err := client.List(context.Background(), resourceID, "", nil, "UsedCapacity", "Average", nil, "", "", insights.ResultTypeData, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := *(*(*resp.Value)[0].Timeseries)[0].Data
+	if len(data) != 1 || to.Float64(data[0].Average) != 100 {
+		t.Errorf("expected a used capacity of 100 bytes, got %+v", data)
+	}
+}
+
+func TestBlobServiceMetrics(t *testing.T) {
+	s, _ := newTestAccount(t, "account")
+	s.lock.Lock()
+	s.accounts["account"].containers["container"] = &blobContainer{blobs: map[string]*blockBlob{}}
+	s.lock.Unlock()
+	for name, size := range map[string]int{"hot1": 10, "hot2": 20, "cool": 100} {
+		if !s.PutBlob("account", "container", name, make([]byte, size)) {
+			t.Fatalf("could not add blob %s", name)
+		}
+	}
+	if !s.SetBlobTier("account", "container", "cool", "Cool") {
+		t.Fatalf("could not set the tier of blob cool")
+	}
+
+	client := insights.NewMetricsClientWithBaseURI(s.ResourceManagerEndpoint(), SubscriptionID)
+	resourceID := "subscriptions/" + SubscriptionID + "/resourceGroups/" + ResourceGroup + "/providers/Microsoft.Storage/storageAccounts/account/blobServices/default"
+	resp, err := client.List(context.Background(), resourceID, "", nil, "BlobCount,BlobCapacity", "Average", nil, "", "Tier eq '*'", insights.ResultTypeData, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual := map[string]float64{}
+	for _, metric := range *resp.Value {
+		for _, series := range *metric.Timeseries {
+			tier := to.String((*series.Metadatavalues)[0].Value)
+			actual[to.String(metric.Name.Value)+"/"+tier] = to.Float64((*series.Data)[0].Average)
+		}
+	}
+	expected := map[string]float64{"BlobCount/Hot": 2, "BlobCount/Cool": 1, "BlobCapacity/Hot": 30, "BlobCapacity/Cool": 100}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakeazure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operation describes what a data plane request needs to be authorized
+type operation struct {
+	// resourceType is the account SAS resource type of the request, s, c or o
+	resourceType string
+	container    string
+	// blob is the blob, or the prefix of the blobs, the request is about
+	blob string
+	// permissions are the SAS permissions, any of which allows the request
+	permissions string
+	// serviceSAS is whether a container or blob SAS can authorize the request
+	serviceSAS bool
+	// bearerOnly is set for requests that need an Azure AD token
+	bearerOnly bool
+}
+
+// authError is the reason a request was refused
+type authError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *authError) Error() string {
+	return e.msg
+}
+
+func newAuthError(status int, code, format string, args ...interface{}) *authError {
+	return &authError{status: status, code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// authorize checks the SharedKey, SAS or bearer authorization of a request
+func (s *Server) authorize(r *http.Request, accountName string, a *account, op operation) *authError {
+	header := r.Header.Get("Authorization")
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(header, "Bearer "):
+		return nil
+	case op.bearerOnly:
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "Only authentication scheme Bearer is supported")
+	case strings.HasPrefix(header, "SharedKey "):
+		return checkSharedKey(r, accountName, a, strings.TrimPrefix(header, "SharedKey "))
+	case query.Get("sig") != "":
+		return s.checkSAS(r, accountName, a, op)
+	}
+	return newAuthError(http.StatusUnauthorized, "NoAuthenticationInformation", "Server failed to authenticate the request. Please refer to the information in the www-authenticate header.")
+}
+
+func checkSharedKey(r *http.Request, accountName string, a *account, credential string) *authError {
+	name, signature, found := strings.Cut(credential, ":")
+	if !found || name != accountName {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "The SharedKey credential is not for account %s", accountName)
+	}
+	if !signedByAccountKey(a, sharedKeyStringToSign(r, accountName), signature) {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "The MAC signature found in the HTTP request is not the same as any computed signature.")
+	}
+	return nil
+}
+
+// sharedKeyStringToSign is the string a SharedKey signature signs
+// https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func sharedKeyStringToSign(r *http.Request, accountName string) string {
+	contentLength := r.Header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	date := r.Header.Get("Date")
+	if r.Header.Get("x-ms-date") != "" {
+		date = ""
+	}
+	return strings.Join([]string{
+		r.Method,
+		r.Header.Get("Content-Encoding"),
+		r.Header.Get("Content-Language"),
+		contentLength,
+		r.Header.Get("Content-MD5"),
+		r.Header.Get("Content-Type"),
+		date,
+		r.Header.Get("If-Modified-Since"),
+		r.Header.Get("If-Match"),
+		r.Header.Get("If-None-Match"),
+		r.Header.Get("If-Unmodified-Since"),
+		r.Header.Get("Range"),
+		canonicalizedHeaders(r.Header),
+		canonicalizedResource(r.URL, accountName),
+	}, "\n")
+}
+
+func canonicalizedHeaders(headers http.Header) string {
+	names := []string{}
+	values := map[string]string{}
+	for k, v := range headers {
+		name := strings.ToLower(strings.TrimSpace(k))
+		if strings.HasPrefix(name, "x-ms-") {
+			names = append(names, name)
+			values[name] = strings.Join(v, ",")
+		}
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, name+":"+values[name])
+	}
+	return strings.Join(lines, "\n")
+}
+
+func canonicalizedResource(u *url.URL, accountName string) string {
+	resource := "/" + accountName + u.EscapedPath()
+	if u.Path == "" {
+		resource += "/"
+	}
+	query := u.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	return resource
+}
+
+// signedByAccountKey checks a signature against both keys of the account
+func signedByAccountKey(a *account, stringToSign, signature string) bool {
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	for _, key := range a.keys {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			continue
+		}
+		mac := hmac.New(sha256.New, decoded)
+		mac.Write([]byte(stringToSign))
+		if hmac.Equal(mac.Sum(nil), expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSAS checks the signature, lifetime, source IP, scope and permissions of an account or service SAS
+// https://learn.microsoft.com/en-us/rest/api/storageservices/create-account-sas
+// https://learn.microsoft.com/en-us/rest/api/storageservices/create-service-sas
+func (s *Server) checkSAS(r *http.Request, accountName string, a *account, op operation) *authError {
+	q := r.URL.Query()
+	if q.Get("skoid") != "" {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "User delegation SAS is not supported by the fake")
+	}
+	if err := s.checkSASTimes(q.Get("st"), q.Get("se")); err != nil {
+		return err
+	}
+	if err := checkSASIP(q.Get("sip"), r.RemoteAddr); err != nil {
+		return err
+	}
+
+	var stringToSign string
+	if q.Get("ss") != "" || q.Get("srt") != "" {
+		if !strings.Contains(q.Get("ss"), "b") {
+			return newAuthError(http.StatusForbidden, "AuthorizationServiceMismatch", "This request is not authorized to perform this operation using this service.")
+		}
+		if !strings.Contains(q.Get("srt"), op.resourceType) {
+			return newAuthError(http.StatusForbidden, "AuthorizationResourceTypeMismatch", "This request is not authorized to perform this operation using this resource type.")
+		}
+		stringToSign = strings.Join([]string{
+			accountName, q.Get("sp"), q.Get("ss"), q.Get("srt"), q.Get("st"), q.Get("se"), q.Get("sip"), q.Get("spr"), q.Get("sv"), "",
+		}, "\n")
+	} else {
+		resource, err := serviceSASResource(q, accountName, op)
+		if err != nil {
+			return err
+		}
+		stringToSign = strings.Join([]string{
+			q.Get("sp"), q.Get("st"), q.Get("se"), resource, q.Get("si"), q.Get("sip"), q.Get("spr"), q.Get("sv"), q.Get("sr"),
+			"", q.Get("rscc"), q.Get("rscd"), q.Get("rsce"), q.Get("rscl"), q.Get("rsct"),
+		}, "\n")
+	}
+	if !signedByAccountKey(a, stringToSign, q.Get("sig")) {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "Signature did not match. String to sign used was %s", strings.ReplaceAll(stringToSign, "\n", `\n`))
+	}
+	if !strings.ContainsAny(q.Get("sp"), op.permissions) {
+		return newAuthError(http.StatusForbidden, "AuthorizationPermissionMismatch", "This request is not authorized to perform this operation using this permission.")
+	}
+	return nil
+}
+
+func (s *Server) checkSASTimes(start, expiry string) *authError {
+	now := s.now()
+	if expiry == "" {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "Signed expiry time is required")
+	}
+	se, err := parseSASTime(expiry)
+	if err != nil {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "Invalid signed expiry time %s", expiry)
+	}
+	if !now.Before(se) {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "Signed expiry time [%s] must be after signed start time [%s]", expiry, now.UTC().Format(time.RFC1123))
+	}
+	if start == "" {
+		return nil
+	}
+	st, err := parseSASTime(start)
+	if err != nil {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "Invalid signed start time %s", start)
+	}
+	if now.Before(st) {
+		return newAuthError(http.StatusForbidden, "AuthenticationFailed", "Signed start time [%s] is in the future", start)
+	}
+	return nil
+}
+
+func parseSASTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s", value)
+}
+
+// checkSASIP checks the source address against sip, a single address or a start-end range
+func checkSASIP(sip, remoteAddr string) *authError {
+	if sip == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	startValue, endValue, isRange := strings.Cut(sip, "-")
+	start := net.ParseIP(startValue)
+	end := start
+	if isRange {
+		end = net.ParseIP(endValue)
+	}
+	if ip == nil || start == nil || end == nil ||
+		bytes.Compare(ip.To16(), start.To16()) < 0 || bytes.Compare(ip.To16(), end.To16()) > 0 {
+		return newAuthError(http.StatusForbidden, "AuthorizationSourceIPMismatch", "This request is not authorized to perform this operation using this source IP %s.", host)
+	}
+	return nil
+}
+
+// serviceSASResource checks that a container, directory or blob SAS covers the request and
+// returns the canonicalized resource it signs
+func serviceSASResource(q url.Values, accountName string, op operation) (string, *authError) {
+	mismatch := newAuthError(http.StatusForbidden, "AuthorizationResourceTypeMismatch", "This request is not authorized to perform this operation using this resource type.")
+	if !op.serviceSAS || op.container == "" {
+		return "", mismatch
+	}
+	// the container of a service SAS is the one of the request URL
+	resource := "/blob/" + accountName + "/" + op.container
+	switch q.Get("sr") {
+	case "c":
+		return resource, nil
+	case "b":
+		if op.blob == "" {
+			return "", mismatch
+		}
+		return resource + "/" + op.blob, nil
+	case "d":
+		depth, err := strconv.Atoi(q.Get("sdd"))
+		if err != nil || depth < 1 {
+			return "", newAuthError(http.StatusForbidden, "AuthenticationFailed", "Invalid signed directory depth %s", q.Get("sdd"))
+		}
+		segments := strings.Split(op.blob, "/")
+		if len(segments) <= depth && !(len(segments) == depth && op.resourceType == "c") {
+			// a directory SAS covers the blobs below the directory, and listing the directory itself
+			return "", mismatch
+		}
+		return resource + "/" + strings.Join(segments[:depth], "/"), nil
+	}
+	return "", newAuthError(http.StatusForbidden, "AuthenticationFailed", "Signed resource %s is not supported by the fake", q.Get("sr"))
+}
@@ -0,0 +1,225 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakeazure
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// errorCode returns the storage error code of a failed request, or "" if it succeeded
+func errorCode(err error) string {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.ErrorCode
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestSharedKey(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestAccount(t, "account")
+	keys, _ := s.AccountKeys("account")
+	if _, err := newTestServiceClient(t, s, keys[1]).CreateContainer(ctx, "container", nil); err != nil {
+		t.Errorf("expected the secondary key to be accepted, got %v", err)
+	}
+	if _, err := newTestServiceClient(t, s, newKey()).CreateContainer(ctx, "other", nil); errorCode(err) != "AuthenticationFailed" {
+		t.Errorf("expected AuthenticationFailed with another key, got %v", err)
+	}
+	anonymous, _ := container.NewClientWithNoCredential(testServiceURL+"container", &container.ClientOptions{ClientOptions: s.clientOptions()})
+	if _, err := anonymous.GetProperties(ctx, nil); errorCode(err) != "NoAuthenticationInformation" {
+		t.Errorf("expected NoAuthenticationInformation, got %v", err)
+	}
+}
+
+func TestServiceSAS(t *testing.T) {
+	ctx := context.Background()
+	s, key := newTestAccount(t, "account")
+	if _, err := newTestServiceClient(t, s, key).CreateContainer(ctx, "container", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cred, _ := service.NewSharedKeyCredential("account", key)
+	otherCred, _ := service.NewSharedKeyCredential("account", newKey())
+	now := time.Now().UTC()
+	tests := []struct {
+		testName     string
+		values       sas.BlobSignatureValues
+		cred         *sas.SharedKeyCredential
+		blob         string
+		expectedCode string
+	}{
+		{
+			testName: "Container SAS",
+			values:   sas.BlobSignatureValues{ContainerName: "container", Permissions: "rcw", ExpiryTime: now.Add(time.Hour)},
+			blob:     "blob",
+		},
+		{
+			testName:     "Missing permission",
+			values:       sas.BlobSignatureValues{ContainerName: "container", Permissions: "rl", ExpiryTime: now.Add(time.Hour)},
+			blob:         "blob",
+			expectedCode: "AuthorizationPermissionMismatch",
+		},
+		{
+			testName:     "Expired",
+			values:       sas.BlobSignatureValues{ContainerName: "container", Permissions: "rcw", ExpiryTime: now.Add(-time.Minute)},
+			blob:         "blob",
+			expectedCode: "AuthenticationFailed",
+		},
+		{
+			testName:     "Not yet valid",
+			values:       sas.BlobSignatureValues{ContainerName: "container", Permissions: "rcw", StartTime: now.Add(time.Hour), ExpiryTime: now.Add(2 * time.Hour)},
+			blob:         "blob",
+			expectedCode: "AuthenticationFailed",
+		},
+		{
+			testName:     "Other key",
+			values:       sas.BlobSignatureValues{ContainerName: "container", Permissions: "rcw", ExpiryTime: now.Add(time.Hour)},
+			cred:         otherCred,
+			blob:         "blob",
+			expectedCode: "AuthenticationFailed",
+		},
+		{
+			testName:     "Other container",
+			values:       sas.BlobSignatureValues{ContainerName: "other", Permissions: "rcw", ExpiryTime: now.Add(time.Hour)},
+			blob:         "blob",
+			expectedCode: "AuthenticationFailed",
+		},
+		{
+			testName: "Directory SAS",
+			values:   sas.BlobSignatureValues{ContainerName: "container", Directory: "dir/sub", Permissions: "rcw", ExpiryTime: now.Add(time.Hour)},
+			blob:     "dir/sub/blob",
+		},
+		{
+			testName:     "Outside the directory",
+			values:       sas.BlobSignatureValues{ContainerName: "container", Directory: "dir/sub", Permissions: "rcw", ExpiryTime: now.Add(time.Hour)},
+			blob:         "dir/other/blob",
+			expectedCode: "AuthenticationFailed",
+		},
+		{
+			testName: "Source IP allowed",
+			values: sas.BlobSignatureValues{ContainerName: "container", Permissions: "rcw", ExpiryTime: now.Add(time.Hour),
+				IPRange: sas.IPRange{Start: net.ParseIP("127.0.0.0"), End: net.ParseIP("127.255.255.255")}},
+			blob: "blob",
+		},
+		{
+			testName: "Source IP denied",
+			values: sas.BlobSignatureValues{ContainerName: "container", Permissions: "rcw", ExpiryTime: now.Add(time.Hour),
+				IPRange: sas.IPRange{Start: net.ParseIP("10.0.0.1")}},
+			blob:         "blob",
+			expectedCode: "AuthorizationSourceIPMismatch",
+		},
+	}
+	for _, test := range tests {
+		if test.cred == nil {
+			test.cred = cred
+		}
+		query, err := test.values.SignWithSharedKey(test.cred)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		blobURL := testServiceURL + "container/" + test.blob + "?" + query.Encode()
+		client, _ := blockblob.NewClientWithNoCredential(blobURL, &blockblob.ClientOptions{ClientOptions: s.clientOptions()})
+		_, err = client.Upload(ctx, streaming.NopCloser(bytes.NewReader([]byte("data"))), nil)
+		if code := errorCode(err); code != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %q\nActual Error: %v", test.testName, test.expectedCode, err)
+		}
+	}
+}
+
+func TestServiceSASCannotManageContainers(t *testing.T) {
+	s, key := newTestAccount(t, "account")
+	cred, _ := service.NewSharedKeyCredential("account", key)
+	query, _ := sas.BlobSignatureValues{ContainerName: "container", Permissions: "racwdl", ExpiryTime: time.Now().Add(time.Hour)}.SignWithSharedKey(cred)
+	client, _ := container.NewClientWithNoCredential(testServiceURL+"container?"+query.Encode(), &container.ClientOptions{ClientOptions: s.clientOptions()})
+	if _, err := client.Create(context.Background(), nil); errorCode(err) != "AuthorizationResourceTypeMismatch" {
+		t.Errorf("expected AuthorizationResourceTypeMismatch, got %v", err)
+	}
+}
+
+func TestAccountSAS(t *testing.T) {
+	ctx := context.Background()
+	s, key := newTestAccount(t, "account")
+	cred, _ := service.NewSharedKeyCredential("account", key)
+	expiry := time.Now().Add(time.Hour)
+	tests := []struct {
+		testName     string
+		values       sas.AccountSignatureValues
+		expectedCode string
+	}{
+		{
+			testName: "Create container",
+			values:   sas.AccountSignatureValues{Services: "b", ResourceTypes: "sco", Permissions: "c", ExpiryTime: expiry},
+		},
+		{
+			testName:     "Object resource type only",
+			values:       sas.AccountSignatureValues{Services: "b", ResourceTypes: "o", Permissions: "c", ExpiryTime: expiry},
+			expectedCode: "AuthorizationResourceTypeMismatch",
+		},
+		{
+			testName:     "Other service",
+			values:       sas.AccountSignatureValues{Services: "f", ResourceTypes: "sco", Permissions: "c", ExpiryTime: expiry},
+			expectedCode: "AuthorizationServiceMismatch",
+		},
+		{
+			testName:     "Read only",
+			values:       sas.AccountSignatureValues{Services: "b", ResourceTypes: "sco", Permissions: "rl", ExpiryTime: expiry},
+			expectedCode: "AuthorizationPermissionMismatch",
+		},
+	}
+	for i, test := range tests {
+		query, err := test.values.SignWithSharedKey(cred)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client, _ := service.NewClientWithNoCredential(testServiceURL+"?"+query.Encode(), &service.ClientOptions{ClientOptions: s.clientOptions()})
+		_, err = client.CreateContainer(ctx, "container"+strings.Repeat("x", i), nil)
+		if code := errorCode(err); code != test.expectedCode {
+			t.Errorf("\nTestCase: %s\nExpected Code: %q\nActual Error: %v", test.testName, test.expectedCode, err)
+		}
+	}
+}
+
+func TestUnknownAccountAndService(t *testing.T) {
+	s, _ := newTestAccount(t, "account")
+	client := s.HTTPClient()
+	for url, expected := range map[string]int{
+		"https://missing.blob.core.windows.net/?comp=list": http.StatusNotFound,
+		"https://account.dfs.core.windows.net/filesystem":  http.StatusNotImplemented,
+	} {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("\nURL: %s\nExpected Status: %d\nActual Status: %d", url, expected, resp.StatusCode)
+		}
+	}
+}
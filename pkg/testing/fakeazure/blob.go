@@ -0,0 +1,476 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakeazure
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultServiceProperties are the blob service properties of a new account
+const defaultServiceProperties = `<?xml version="1.0" encoding="utf-8"?><StorageServiceProperties><Cors /></StorageServiceProperties>`
+
+// metadataPrefix starts the headers holding container and blob metadata
+const metadataPrefix = "x-ms-meta-"
+
+func (s *Server) serveData(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	accountName, service, _ := strings.Cut(host, ".")
+	service, _, _ = strings.Cut(service, ".")
+	if service == "privatelink" {
+		// <account>.privatelink.blob.<suffix> is the same account through a private endpoint
+		service = strings.Split(host, ".")[2]
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.accounts[accountName]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "ResourceNotFound", "The storage account %s does not exist.", accountName)
+		return
+	}
+	if service != "blob" {
+		writeStorageError(w, http.StatusNotImplemented, "NotImplemented", "The fake does not emulate the %s service.", service)
+		return
+	}
+
+	w.Header().Set("x-ms-request-id", newETag())
+	w.Header().Set("x-ms-version", r.Header.Get("x-ms-version"))
+	w.Header().Set("Date", s.now().UTC().Format(http.TimeFormat))
+
+	containerName, blobName, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	restype, comp := query.Get("restype"), query.Get("comp")
+	var op operation
+	var handler func()
+	switch {
+	case containerName == "" && comp == "list" && r.Method == http.MethodGet:
+		op = operation{resourceType: "s", permissions: "l"}
+		handler = func() { s.listContainers(w, r, a) }
+	case containerName == "" && restype == "service" && comp == "properties" && r.Method == http.MethodGet:
+		op = operation{resourceType: "s", permissions: "r"}
+		handler = func() { writeXMLBody(w, http.StatusOK, a.serviceProperties) }
+	case containerName == "" && restype == "service" && comp == "properties" && r.Method == http.MethodPut:
+		op = operation{resourceType: "s", permissions: "w"}
+		handler = func() { s.setServiceProperties(w, r, a) }
+	case containerName == "" && restype == "service" && comp == "userdelegationkey" && r.Method == http.MethodPost:
+		op = operation{bearerOnly: true}
+		handler = func() { s.getUserDelegationKey(w, r) }
+	case blobName == "" && restype == "container" && comp == "" && r.Method == http.MethodPut:
+		op = operation{resourceType: "c", container: containerName, permissions: "cw"}
+		handler = func() { s.createContainer(w, r, a, containerName) }
+	case blobName == "" && restype == "container" && comp == "" && r.Method == http.MethodDelete:
+		op = operation{resourceType: "c", container: containerName, permissions: "d"}
+		handler = func() { s.deleteContainer(w, a, containerName) }
+	case blobName == "" && restype == "container" && comp == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		op = operation{resourceType: "c", container: containerName, permissions: "r", serviceSAS: true}
+		handler = func() { s.getContainerProperties(w, a, containerName) }
+	case blobName == "" && restype == "container" && comp == "list" && r.Method == http.MethodGet:
+		op = operation{resourceType: "c", container: containerName, blob: query.Get("prefix"), permissions: "l", serviceSAS: true}
+		handler = func() { s.listBlobs(w, r, a, containerName) }
+	case blobName != "" && comp == "" && r.Method == http.MethodPut:
+		op = operation{resourceType: "o", container: containerName, blob: blobName, permissions: "cw", serviceSAS: true}
+		handler = func() { s.putBlob(w, r, a, containerName, blobName) }
+	case blobName != "" && comp == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		op = operation{resourceType: "o", container: containerName, blob: blobName, permissions: "r", serviceSAS: true}
+		handler = func() { s.getBlob(w, r, a, containerName, blobName) }
+	case blobName != "" && comp == "" && r.Method == http.MethodDelete:
+		op = operation{resourceType: "o", container: containerName, blob: blobName, permissions: "d", serviceSAS: true}
+		handler = func() { s.deleteBlob(w, a, containerName, blobName) }
+	default:
+		writeStorageError(w, http.StatusNotImplemented, "NotImplemented", "The fake does not emulate %s %s.", r.Method, r.URL.RequestURI())
+		return
+	}
+	if err := s.authorize(r, accountName, a, op); err != nil {
+		writeStorageError(w, err.status, err.code, "%s", err.msg)
+		return
+	}
+	handler()
+}
+
+func (s *Server) setServiceProperties(w http.ResponseWriter, r *http.Request, a *account) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeStorageError(w, http.StatusBadRequest, "InvalidInput", "%v", err)
+		return
+	}
+	if err := xml.Unmarshal(body, &struct {
+		XMLName xml.Name `xml:"StorageServiceProperties"`
+	}{}); err != nil {
+		writeStorageError(w, http.StatusBadRequest, "InvalidXmlDocument", "%v", err)
+		return
+	}
+	a.serviceProperties = body
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// userDelegationKey is the response of Get User Delegation Key
+type userDelegationKey struct {
+	XMLName       xml.Name `xml:"UserDelegationKey"`
+	SignedOid     string   `xml:"SignedOid"`
+	SignedTid     string   `xml:"SignedTid"`
+	SignedStart   string   `xml:"SignedStart"`
+	SignedExpiry  string   `xml:"SignedExpiry"`
+	SignedService string   `xml:"SignedService"`
+	SignedVersion string   `xml:"SignedVersion"`
+	Value         string   `xml:"Value"`
+}
+
+func (s *Server) getUserDelegationKey(w http.ResponseWriter, r *http.Request) {
+	info := struct {
+		Start  string `xml:"Start"`
+		Expiry string `xml:"Expiry"`
+	}{}
+	if err := xml.NewDecoder(r.Body).Decode(&info); err != nil || info.Expiry == "" {
+		writeStorageError(w, http.StatusBadRequest, "InvalidXmlDocument", "KeyInfo with an Expiry is required")
+		return
+	}
+	start := info.Start
+	if start == "" {
+		start = s.now().UTC().Format(time.RFC3339)
+	}
+	key, _ := xml.Marshal(userDelegationKey{
+		SignedOid:     "00000000-0000-0000-0000-000000000000",
+		SignedTid:     "00000000-0000-0000-0000-000000000000",
+		SignedStart:   start,
+		SignedExpiry:  info.Expiry,
+		SignedService: "b",
+		SignedVersion: r.Header.Get("x-ms-version"),
+		Value:         newKey(),
+	})
+	writeXMLBody(w, http.StatusOK, key)
+}
+
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request, a *account, name string) {
+	if _, ok := a.containers[name]; ok {
+		writeStorageError(w, http.StatusConflict, "ContainerAlreadyExists", "The specified container already exists.")
+		return
+	}
+	c := &blobContainer{
+		metadata:     getMetadata(r.Header),
+		publicAccess: r.Header.Get("x-ms-blob-public-access"),
+		lastModified: s.now().UTC(),
+		etag:         newETag(),
+		blobs:        map[string]*blockBlob{},
+	}
+	a.containers[name] = c
+	setLastModified(w, c.lastModified, c.etag)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) deleteContainer(w http.ResponseWriter, a *account, name string) {
+	if _, ok := a.containers[name]; !ok {
+		writeStorageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+	delete(a.containers, name)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) getContainerProperties(w http.ResponseWriter, a *account, name string) {
+	c, ok := a.containers[name]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+	setMetadata(w.Header(), c.metadata)
+	if c.publicAccess != "" {
+		w.Header().Set("x-ms-blob-public-access", c.publicAccess)
+	}
+	setLastModified(w, c.lastModified, c.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) putBlob(w http.ResponseWriter, r *http.Request, a *account, containerName, blobName string) {
+	c, ok := a.containers[containerName]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+	if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+		writeStorageError(w, http.StatusBadRequest, "InvalidHeaderValue", "Only x-ms-blob-type BlockBlob is supported by the fake.")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeStorageError(w, http.StatusBadRequest, "InvalidInput", "%v", err)
+		return
+	}
+	tier := r.Header.Get("x-ms-access-tier")
+	if tier == "" {
+		tier = defaultAccessTier
+	}
+	b := &blockBlob{
+		data:         data,
+		accessTier:   tier,
+		contentType:  r.Header.Get("x-ms-blob-content-type"),
+		metadata:     getMetadata(r.Header),
+		lastModified: s.now().UTC(),
+		etag:         newETag(),
+	}
+	c.blobs[blobName] = b
+	setLastModified(w, b.lastModified, b.etag)
+	w.Header().Set("x-ms-request-server-encrypted", "true")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) getBlob(w http.ResponseWriter, r *http.Request, a *account, containerName, blobName string) {
+	c, ok := a.containers[containerName]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+	b, ok := c.blobs[blobName]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		return
+	}
+	data, status := b.data, http.StatusOK
+	if rangeHeader := r.Header.Get("x-ms-range"); rangeHeader != "" || r.Header.Get("Range") != "" {
+		if rangeHeader == "" {
+			rangeHeader = r.Header.Get("Range")
+		}
+		start, end, ok := parseRange(rangeHeader, len(b.data))
+		if !ok {
+			writeStorageError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The range specified is invalid for the current size of the resource.")
+			return
+		}
+		data, status = b.data[start:end+1], http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(b.data)))
+	}
+	setMetadata(w.Header(), b.metadata)
+	setLastModified(w, b.lastModified, b.etag)
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if b.contentType != "" {
+		w.Header().Set("Content-Type", b.contentType)
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+func (s *Server) deleteBlob(w http.ResponseWriter, a *account, containerName, blobName string) {
+	c, ok := a.containers[containerName]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+	if _, ok := c.blobs[blobName]; !ok {
+		writeStorageError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		return
+	}
+	delete(c.blobs, blobName)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// metadataXML marshals metadata as one element per key
+type metadataXML map[string]string
+
+func (m metadataXML) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(m) {
+		if err := e.EncodeElement(m[k], xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+type itemProperties struct {
+	LastModified  string `xml:"Last-Modified"`
+	Etag          string `xml:"Etag"`
+	ContentLength *int   `xml:"Content-Length,omitempty"`
+	ContentType   string `xml:"Content-Type,omitempty"`
+	BlobType      string `xml:"BlobType,omitempty"`
+	AccessTier    string `xml:"AccessTier,omitempty"`
+	PublicAccess  string `xml:"PublicAccess,omitempty"`
+}
+
+type listItem struct {
+	Name       string         `xml:"Name"`
+	Properties itemProperties `xml:"Properties"`
+	Metadata   metadataXML    `xml:"Metadata,omitempty"`
+}
+
+type listContainersResult struct {
+	XMLName         xml.Name   `xml:"EnumerationResults"`
+	ServiceEndpoint string     `xml:"ServiceEndpoint,attr"`
+	Prefix          string     `xml:"Prefix"`
+	Containers      []listItem `xml:"Containers>Container"`
+	NextMarker      string     `xml:"NextMarker"`
+}
+
+type listBlobsResult struct {
+	XMLName         xml.Name   `xml:"EnumerationResults"`
+	ServiceEndpoint string     `xml:"ServiceEndpoint,attr"`
+	ContainerName   string     `xml:"ContainerName,attr"`
+	Prefix          string     `xml:"Prefix"`
+	Blobs           []listItem `xml:"Blobs>Blob"`
+	NextMarker      string     `xml:"NextMarker"`
+}
+
+// listContainers lists every matching container in one page
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request, a *account) {
+	prefix := r.URL.Query().Get("prefix")
+	withMetadata := strings.Contains(r.URL.Query().Get("include"), "metadata")
+	result := listContainersResult{ServiceEndpoint: fmt.Sprintf("https://%s/", r.Host), Prefix: prefix}
+	for _, name := range sortedContainerNames(a.containers) {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		c := a.containers[name]
+		item := listItem{
+			Name:       name,
+			Properties: itemProperties{LastModified: c.lastModified.Format(http.TimeFormat), Etag: c.etag, PublicAccess: c.publicAccess},
+		}
+		if withMetadata {
+			item.Metadata = c.metadata
+		}
+		result.Containers = append(result.Containers, item)
+	}
+	writeXML(w, result)
+}
+
+// listBlobs lists every matching blob in one page
+func (s *Server) listBlobs(w http.ResponseWriter, r *http.Request, a *account, containerName string) {
+	c, ok := a.containers[containerName]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	withMetadata := strings.Contains(r.URL.Query().Get("include"), "metadata")
+	result := listBlobsResult{ServiceEndpoint: fmt.Sprintf("https://%s/", r.Host), ContainerName: containerName, Prefix: prefix}
+	names := make([]string, 0, len(c.blobs))
+	for name := range c.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b := c.blobs[name]
+		length := len(b.data)
+		item := listItem{
+			Name: name,
+			Properties: itemProperties{
+				LastModified:  b.lastModified.Format(http.TimeFormat),
+				Etag:          b.etag,
+				ContentLength: &length,
+				ContentType:   b.contentType,
+				BlobType:      "BlockBlob",
+				AccessTier:    b.accessTier,
+			},
+		}
+		if withMetadata {
+			item.Metadata = b.metadata
+		}
+		result.Blobs = append(result.Blobs, item)
+	}
+	writeXML(w, result)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		writeStorageError(w, http.StatusInternalServerError, "InternalError", "%v", err)
+		return
+	}
+	writeXMLBody(w, http.StatusOK, append([]byte(xml.Header), body...))
+}
+
+func writeXMLBody(w http.ResponseWriter, status int, body []byte) {
+	if len(body) == 0 {
+		body = []byte(defaultServiceProperties)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func getMetadata(headers http.Header) map[string]string {
+	metadata := map[string]string{}
+	for k, v := range headers {
+		if name := strings.ToLower(k); strings.HasPrefix(name, metadataPrefix) && len(v) > 0 {
+			metadata[strings.TrimPrefix(name, metadataPrefix)] = v[0]
+		}
+	}
+	return metadata
+}
+
+func setMetadata(headers http.Header, metadata map[string]string) {
+	for k, v := range metadata {
+		headers.Set(metadataPrefix+k, v)
+	}
+}
+
+func setLastModified(w http.ResponseWriter, lastModified time.Time, etag string) {
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("ETag", etag)
+}
+
+// parseRange parses bytes=<start>-[<end>] and returns the inclusive bounds
+func parseRange(value string, size int) (int, int, bool) {
+	startValue, endValue, ok := strings.Cut(strings.TrimPrefix(value, "bytes="), "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.Atoi(startValue)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if endValue != "" {
+		if end, err = strconv.Atoi(endValue); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedContainerNames(containers map[string]*blobContainer) []string {
+	names := make([]string, 0, len(containers))
+	for name := range containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakeazure
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const testServiceURL = "https://account.blob.core.windows.net/"
+
+func newTestServiceClient(t *testing.T, s *Server, key string) *service.Client {
+	cred, err := service.NewSharedKeyCredential("account", key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client, err := service.NewClientWithSharedKeyCredential(testServiceURL, cred, &service.ClientOptions{ClientOptions: s.clientOptions()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return client
+}
+
+// clientOptions sends requests to the fake without retrying failures
+func (s *Server) clientOptions() azcore.ClientOptions {
+	return azcore.ClientOptions{Transport: s.HTTPClient(), Retry: policy.RetryOptions{MaxRetries: -1}}
+}
+
+func TestContainersAndBlobs(t *testing.T) {
+	ctx := context.Background()
+	s, key := newTestAccount(t, "account")
+	client := newTestServiceClient(t, s, key)
+
+	metadata := map[string]string{"owner": "team"}
+	if _, err := client.CreateContainer(ctx, "container", &service.CreateContainerOptions{Metadata: metadata}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.CreateContainer(ctx, "container", nil); !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		t.Errorf("expected ContainerAlreadyExists, got %v", err)
+	}
+	containerClient := client.NewContainerClient("container")
+	props, err := containerClient.GetProperties(ctx, nil)
+	if err != nil || props.Metadata["Owner"] != "team" {
+		t.Errorf("unexpected container properties %v: %v", props.Metadata, err)
+	}
+
+	pager := client.NewListContainersPager(&service.ListContainersOptions{Include: service.ListContainersInclude{Metadata: true}})
+	page, err := pager.NextPage(ctx)
+	if err != nil || len(page.ContainerItems) != 1 || to.String(page.ContainerItems[0].Name) != "container" || to.String(page.ContainerItems[0].Metadata["owner"]) != "team" {
+		t.Errorf("unexpected containers %+v: %v", page.ContainerItems, err)
+	}
+
+	blobClient := containerClient.NewBlockBlobClient("dir/blob.txt")
+	if _, err := blobClient.Upload(ctx, streaming.NopCloser(bytes.NewReader([]byte("hello"))), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	download, err := blobClient.DownloadStream(ctx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := io.ReadAll(download.Body)
+	download.Body.Close()
+	if string(data) != "hello" {
+		t.Errorf("unexpected blob content %q", data)
+	}
+	blobs, err := containerClient.NewListBlobsFlatPager(nil).NextPage(ctx)
+	if err != nil || len(blobs.Segment.BlobItems) != 1 || to.String(blobs.Segment.BlobItems[0].Name) != "dir/blob.txt" {
+		t.Errorf("unexpected blobs %+v: %v", blobs.Segment, err)
+	}
+	if _, err := blobClient.Delete(ctx, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := blobClient.DownloadStream(ctx, nil); !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		t.Errorf("expected BlobNotFound, got %v", err)
+	}
+
+	if _, err := client.DeleteContainer(ctx, "container", nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := client.DeleteContainer(ctx, "container", nil); !bloberror.HasCode(err, bloberror.ContainerNotFound) {
+		t.Errorf("expected ContainerNotFound, got %v", err)
+	}
+	if containers := s.Containers("account"); len(containers) != 0 {
+		t.Errorf("expected no containers, got %v", containers)
+	}
+}
+
+func TestServiceProperties(t *testing.T) {
+	ctx := context.Background()
+	s, key := newTestAccount(t, "account")
+	client := newTestServiceClient(t, s, key)
+
+	props, err := client.GetProperties(ctx, nil)
+	if err != nil || len(props.Cors) != 0 {
+		t.Fatalf("unexpected properties %+v: %v", props.Cors, err)
+	}
+	cors := []*service.CorsRule{{
+		AllowedOrigins:  to.StringPtr("https://example.com"),
+		AllowedMethods:  to.StringPtr("GET"),
+		AllowedHeaders:  to.StringPtr("*"),
+		ExposedHeaders:  to.StringPtr("*"),
+		MaxAgeInSeconds: to.Int32Ptr(60),
+	}}
+	if _, err := client.SetProperties(ctx, &service.SetPropertiesOptions{Cors: cors}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	props, err = client.GetProperties(ctx, nil)
+	if err != nil || len(props.Cors) != 1 || to.String(props.Cors[0].AllowedOrigins) != "https://example.com" {
+		t.Errorf("unexpected properties %+v: %v", props.Cors, err)
+	}
+}
+
+type testTokenCredential struct{}
+
+func (testTokenCredential) GetToken(ctx context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestUserDelegationKey(t *testing.T) {
+	ctx := context.Background()
+	s, key := newTestAccount(t, "account")
+	now := time.Now().UTC()
+	info := service.KeyInfo{
+		Start:  to.StringPtr(now.Format(sas.TimeFormat)),
+		Expiry: to.StringPtr(now.Add(time.Hour).Format(sas.TimeFormat)),
+	}
+
+	client, err := service.NewClient(testServiceURL, testTokenCredential{}, &service.ClientOptions{ClientOptions: s.clientOptions()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.GetUserDelegationCredential(ctx, info, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = newTestServiceClient(t, s, key).GetUserDelegationCredential(ctx, info, nil)
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a SharedKey request to be forbidden, got %v", err)
+	}
+}
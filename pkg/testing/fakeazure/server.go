@@ -0,0 +1,339 @@
+// Copyright 2021 The Kubernetes Authors.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fakeazure is an in-process fake of the Azure Storage APIs the driver calls, for tests.
+// It serves the ARM storage account and key endpoints over HTTP, and the blob container, blob and
+// service endpoints of every account over HTTPS, checking SharedKey and SAS authorization the way
+// Azure does. Bearer tokens are accepted without checks, there is no RBAC. Data Lake (dfs) and
+// Azure Files requests are answered with NotImplemented.
+package fakeazure
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	azclients "sigs.k8s.io/cloud-provider-azure/pkg/azureclients"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/storageaccountclient"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// Defaults of the cloud returned by Server.Cloud
+const (
+	SubscriptionID = "00000000-0000-0000-0000-000000000000"
+	ResourceGroup  = "fake-rg"
+	Location       = "eastus"
+	TenantID       = "00000000-0000-0000-0000-000000000001"
+	ClientID       = "00000000-0000-0000-0000-000000000002"
+)
+
+// defaultAccessTier is the tier of blobs uploaded without x-ms-access-tier, the default of a StorageV2 account
+const defaultAccessTier = "Hot"
+
+// Server emulates ARM and the blob service of every storage account created through it
+type Server struct {
+	arm  *httptest.Server
+	data *httptest.Server
+
+	lock     sync.Mutex
+	accounts map[string]*account
+	// now is the time SAS start and expiry times are checked against
+	now func() time.Time
+}
+
+// account is a storage account, whose name is unique across subscriptions as in Azure
+type account struct {
+	subscriptionID string
+	resourceGroup  string
+	// resource is the ARM representation of the account, as JSON
+	resource          map[string]interface{}
+	keys              [2]string
+	containers        map[string]*blobContainer
+	serviceProperties []byte
+}
+
+// blobUsage is the number and bytes of blobs, as reported by the capacity metrics
+type blobUsage struct {
+	blobs int64
+	bytes int64
+}
+
+// blobUsage adds up the blobs of the account, per access tier when byTier is set and under "" otherwise.
+// Like Azure, an account without blobs reports zero.
+func (a *account) blobUsage(byTier bool) map[string]*blobUsage {
+	usage := map[string]*blobUsage{}
+	for _, c := range a.containers {
+		for _, b := range c.blobs {
+			tier := ""
+			if byTier {
+				tier = b.accessTier
+			}
+			if usage[tier] == nil {
+				usage[tier] = &blobUsage{}
+			}
+			usage[tier].blobs++
+			usage[tier].bytes += int64(len(b.data))
+		}
+	}
+	if len(usage) == 0 {
+		tier := ""
+		if byTier {
+			tier = defaultAccessTier
+		}
+		usage[tier] = &blobUsage{}
+	}
+	return usage
+}
+
+type blobContainer struct {
+	metadata     map[string]string
+	publicAccess string
+	lastModified time.Time
+	etag         string
+	blobs        map[string]*blockBlob
+}
+
+type blockBlob struct {
+	data         []byte
+	accessTier   string
+	contentType  string
+	metadata     map[string]string
+	lastModified time.Time
+	etag         string
+}
+
+// NewServer starts a fake with no storage accounts, stop it with Close
+func NewServer() *Server {
+	s := &Server{
+		accounts: map[string]*account{},
+		now:      time.Now,
+	}
+	s.arm = httptest.NewServer(http.HandlerFunc(s.serveARM))
+	s.data = httptest.NewTLSServer(http.HandlerFunc(s.serveData))
+	return s
+}
+
+// Close stops the fake
+func (s *Server) Close() {
+	s.arm.Close()
+	s.data.Close()
+}
+
+// SetNow replaces the clock SAS times are checked against
+func (s *Server) SetNow(now func() time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.now = now
+}
+
+// ResourceManagerEndpoint is the URL ARM clients must use
+func (s *Server) ResourceManagerEndpoint() string {
+	return s.arm.URL + "/"
+}
+
+// HTTPClient returns a client that sends the requests to every https host, such as
+// <account>.blob.core.windows.net, to the fake, leaving the URL and Host header untouched
+func (s *Server) HTTPClient() *http.Client {
+	addr := s.data.Listener.Addr().String()
+	dialer := &net.Dialer{}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			// the certificate of the fake cannot match the hosts of real storage accounts
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		},
+	}
+}
+
+// Cloud returns a cloud provider whose storage account client talks to the fake. Its service
+// principal gets tokens from the fake too, so ARM clients built from the cloud config work.
+func (s *Server) Cloud() *azure.Cloud {
+	az := &azure.Cloud{}
+	az.SubscriptionID = SubscriptionID
+	az.ResourceGroup = ResourceGroup
+	az.Location = Location
+	az.TenantID = TenantID
+	az.AADClientID = ClientID
+	az.AADClientSecret = "fake"
+	az.Environment.ActiveDirectoryEndpoint = s.ResourceManagerEndpoint()
+	az.Environment.ResourceManagerEndpoint = s.ResourceManagerEndpoint()
+	az.Environment.ServiceManagementEndpoint = s.ResourceManagerEndpoint()
+	az.StorageAccountClient = storageaccountclient.New(&azclients.ClientConfig{
+		SubscriptionID:          SubscriptionID,
+		ResourceManagerEndpoint: s.ResourceManagerEndpoint(),
+		Authorizer:              autorest.NullAuthorizer{},
+	})
+	return az
+}
+
+// CreateAccount adds a storage account, in a subscription and resource group that may differ from
+// the ones of Server.Cloud to stand for an account of another Azure customer
+func (s *Server) CreateAccount(subscriptionID, resourceGroup, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.addAccount(subscriptionID, resourceGroup, name).setResource(subscriptionID, resourceGroup, name, map[string]interface{}{"location": Location})
+}
+
+// AccountKeys returns the two keys of a storage account
+func (s *Server) AccountKeys(name string) ([]string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.accounts[name]
+	if !ok {
+		return nil, false
+	}
+	return []string{a.keys[0], a.keys[1]}, true
+}
+
+// Accounts returns the names of all storage accounts, sorted
+func (s *Server) Accounts() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := make([]string, 0, len(s.accounts))
+	for name := range s.accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Containers returns the names of the containers of a storage account, sorted
+func (s *Server) Containers(accountName string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.accounts[accountName]
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(a.containers))
+	for name := range a.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Blob returns the content of a blob
+func (s *Server) Blob(accountName, containerName, blobName string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.accounts[accountName]
+	if !ok {
+		return nil, false
+	}
+	c, ok := a.containers[containerName]
+	if !ok {
+		return nil, false
+	}
+	b, ok := c.blobs[blobName]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, b.data...), true
+}
+
+// PutBlob stores a blob in an existing container, as if a workload had uploaded it
+func (s *Server) PutBlob(accountName, containerName, blobName string, data []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.accounts[accountName]
+	if !ok {
+		return false
+	}
+	c, ok := a.containers[containerName]
+	if !ok {
+		return false
+	}
+	c.blobs[blobName] = &blockBlob{
+		data:         append([]byte{}, data...),
+		accessTier:   defaultAccessTier,
+		metadata:     map[string]string{},
+		lastModified: s.now().UTC(),
+		etag:         newETag(),
+	}
+	return true
+}
+
+// SetBlobTier sets the access tier of a blob, it returns false when the blob does not exist
+func (s *Server) SetBlobTier(accountName, containerName, blobName, tier string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.accounts[accountName]
+	if !ok {
+		return false
+	}
+	c, ok := a.containers[containerName]
+	if !ok {
+		return false
+	}
+	b, ok := c.blobs[blobName]
+	if !ok {
+		return false
+	}
+	b.accessTier = tier
+	return true
+}
+
+// ServiceProperties returns the blob service properties last set on a storage account, as XML
+func (s *Server) ServiceProperties(accountName string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.accounts[accountName]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, a.serviceProperties...), true
+}
+
+func newKey() string {
+	key := make([]byte, 64)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newETag() string {
+	tag := make([]byte, 8)
+	if _, err := rand.Read(tag); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("\"0x%X\"", tag)
+}
+
+// storageError is the body of a failed data plane request
+type storageError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeStorageError(w http.ResponseWriter, status int, code, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	body, _ := xml.Marshal(storageError{Code: code, Message: fmt.Sprintf(format, args...)})
+	_, _ = w.Write(append([]byte(xml.Header), body...))
+}
@@ -11,6 +11,16 @@ type BucketID struct {
 	SubID         string `json:"subscriptionID"`
 	ResourceGroup string `json:"resourceGroup"`
 	URL           string `json:"url"`
+	// CORSRule is the key of the blob service CORS rule added for this bucket, if any
+	CORSRule string `json:"corsRule,omitempty"`
+	// PrivateLink is set when the account is reached through a blob private endpoint
+	PrivateLink bool `json:"privateLink,omitempty"`
+	// UnitType is set for bucket unit types that cannot be told apart by their URL alone
+	UnitType string `json:"unitType,omitempty"`
+	// Directory is the root directory of a filesystem bucket, relative to the filesystem
+	Directory string `json:"directory,omitempty"`
+	// Protocol is the enabled protocol of a fileshare bucket
+	Protocol string `json:"protocol,omitempty"`
 }
 
 // Marshals bucketID struct into json bytes, then encodes into base64
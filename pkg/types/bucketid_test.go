@@ -0,0 +1,75 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzDecodeToBucketID(f *testing.F) {
+	for _, id := range []BucketID{
+		{SubID: "subid", ResourceGroup: "rg", URL: "https://account.blob.core.windows.net/container"},
+		{SubID: "subid", ResourceGroup: "rg", URL: "https://account.dfs.core.windows.net/filesystem", UnitType: "filesystem", Directory: "dir"},
+		{SubID: "subid", ResourceGroup: "rg", URL: "https://account.file.core.windows.net/share", UnitType: "fileshare", Protocol: "SMB", PrivateLink: true},
+	} {
+		encoded, err := id.Encode()
+		if err != nil {
+			f.Fatalf("unexpected error: %v", err)
+		}
+		f.Add(encoded)
+	}
+	f.Add("")
+	f.Add("bnVsbA==")
+	f.Add("not base64")
+	f.Fuzz(func(t *testing.T, encoded string) {
+		id, err := DecodeToBucketID(encoded)
+		if err != nil {
+			return
+		}
+		// decode -> encode -> decode must be stable
+		again, err := id.Encode()
+		if err != nil {
+			t.Fatalf("could not encode %+v: %v", id, err)
+		}
+		decoded, err := DecodeToBucketID(again)
+		if err != nil {
+			t.Fatalf("could not decode %q: %v", again, err)
+		}
+		if !reflect.DeepEqual(decoded, id) {
+			t.Errorf("round trip of %q: expected %+v, got %+v", encoded, id, decoded)
+		}
+	})
+}
+
+func FuzzBucketIDRoundTrip(f *testing.F) {
+	f.Add("subid", "rg", "https://account.blob.core.windows.net/container", "", false, "", "", "")
+	f.Add("subid", "rg", "https://account.dfs.core.windows.net/filesystem", "rule", true, "filesystem", "dir/sub", "")
+	f.Fuzz(func(t *testing.T, subID, resourceGroup, url, corsRule string, privateLink bool, unitType, directory, protocol string) {
+		id := &BucketID{
+			SubID:         subID,
+			ResourceGroup: resourceGroup,
+			URL:           url,
+			CORSRule:      corsRule,
+			PrivateLink:   privateLink,
+			UnitType:      unitType,
+			Directory:     directory,
+			Protocol:      protocol,
+		}
+		for _, s := range []string{subID, resourceGroup, url, corsRule, unitType, directory, protocol} {
+			if !utf8.ValidString(s) {
+				t.Skip("JSON cannot represent invalid UTF-8")
+			}
+		}
+		encoded, err := id.Encode()
+		if err != nil {
+			t.Fatalf("could not encode %+v: %v", id, err)
+		}
+		decoded, err := DecodeToBucketID(encoded)
+		if err != nil {
+			t.Fatalf("could not decode %q: %v", encoded, err)
+		}
+		if !reflect.DeepEqual(decoded, id) {
+			t.Errorf("round trip: expected %+v, got %+v", id, decoded)
+		}
+	})
+}